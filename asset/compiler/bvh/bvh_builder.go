@@ -58,6 +58,14 @@ type splitScore struct {
 	score                 float32
 }
 
+// Check if this split is ordered before another split with the same score.
+func (s *splitScore) before(other *splitScore) bool {
+	if s.axis != other.axis {
+		return s.axis < other.axis
+	}
+	return s.splitPoint < other.splitPoint
+}
+
 type stats struct {
 	partitionedItems int
 	totalItems       int
@@ -180,10 +188,12 @@ func (b *builder) partition(workList []BoundedVolume, depth int) uint32 {
 		}
 	}
 
-	// Process all scores and pick the best split
+	// Process all scores and pick the best split. Scores are received in
+	// random order so ties are broken using the split axis and point to
+	// ensure that the generated tree does not change between builds.
 	for ; pendingScores > 0; pendingScores-- {
 		candidate := <-b.scoreChan
-		if candidate.score < bestScore {
+		if candidate.score < bestScore || (candidate.score == bestScore && bestSplit != nil && candidate.before(bestSplit)) {
 			bestScore = candidate.score
 			bestSplit = &candidate
 		}
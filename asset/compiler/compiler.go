@@ -198,8 +198,10 @@ func (sc *sceneCompiler) partitionGeometry() error {
 	// appropriate transformation matrix.
 	sc.optimizedScene.EmissivePrimitives = make([]scene.EmissivePrimitive, 0)
 	for _, mi := range sc.optimizedScene.MeshInstanceList {
-		for emissiveIndex, meshIndex := range emissiveIndexToMeshIndexMap {
-			if mi.MeshIndex != meshIndex {
+		// Visit emissives in index order so the compiled scene does not
+		// depend on the map iteration order.
+		for emissiveIndex := range meshEmissivePrimitives {
+			if mi.MeshIndex != emissiveIndexToMeshIndexMap[emissiveIndex] {
 				continue
 			}
 
@@ -236,6 +238,11 @@ func (sc *sceneCompiler) setupCamera() error {
 	sc.optimizedScene.Camera.Position = sc.parsedScene.Camera.Eye
 	sc.optimizedScene.Camera.LookAt = sc.parsedScene.Camera.Look
 	sc.optimizedScene.Camera.Up = sc.parsedScene.Camera.Up
+	sc.optimizedScene.Camera.ApertureRadius = sc.parsedScene.Camera.Aperture
+	sc.optimizedScene.Camera.FocusDistance = sc.parsedScene.Camera.Focus
+	sc.optimizedScene.Camera.Type = sc.parsedScene.Camera.Type
+	sc.optimizedScene.Camera.OrthoHeight = sc.parsedScene.Camera.OrthoHeight
+	sc.optimizedScene.Camera.FisheyeFOV = sc.parsedScene.Camera.FisheyeFOV
 
 	return nil
 }
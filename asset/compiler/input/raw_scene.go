@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/types"
 )
 
@@ -134,6 +135,16 @@ type Camera struct {
 	Eye  types.Vec3
 	Look types.Vec3
 	Up   types.Vec3
+
+	// Thin-lens aperture radius and focus distance. A zero focus distance
+	// enables autofocus.
+	Aperture float32
+	Focus    float32
+
+	// The projection type and the orthographic and fisheye camera attributes.
+	Type        scene.CameraType
+	OrthoHeight float32
+	FisheyeFOV  float32
 }
 
 // The scene contains all elements that are processed and optimized by the scene compiler.
@@ -152,10 +163,12 @@ func NewScene() *Scene {
 		MeshInstances: make([]*MeshInstance, 0),
 		Materials:     make([]*Material, 0),
 		Camera: &Camera{
-			FOV:  45.0,
-			Eye:  types.Vec3{0, 0, 0},
-			Look: types.Vec3{0, 0, -1},
-			Up:   types.Vec3{0, 1, 0},
+			FOV:         45.0,
+			Eye:         types.Vec3{0, 0, 0},
+			Look:        types.Vec3{0, 0, -1},
+			Up:          types.Vec3{0, 1, 0},
+			OrthoHeight: scene.DefaultOrthoHeight,
+			FisheyeFOV:  scene.DefaultFisheyeFOV,
 		},
 	}
 }
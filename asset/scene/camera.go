@@ -1,11 +1,16 @@
 package scene
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/achilleasa/polaris/types"
 )
 
+var (
+	ErrUnknownCameraType = errors.New("scene: unknown camera type")
+)
+
 // Constants for the directions that cameras can move.
 type CameraDirection uint8
 
@@ -18,6 +23,69 @@ const (
 	Backward
 )
 
+// The projection models supported by the scene camera.
+type CameraType uint8
+
+const (
+	// Perspective projection. If the camera specifies a non-zero aperture
+	// radius, a thin-lens model is used to simulate depth of field.
+	PerspectiveCamera CameraType = iota
+
+	// Orthographic projection using parallel rays along the view direction.
+	OrthographicCamera
+
+	// Equirectangular projection covering the full sphere around the camera;
+	// suitable for 360 degree panoramas.
+	EquirectangularCamera
+
+	// Equidistant fisheye projection mapping the configured field of view to
+	// the largest circle that fits the frame.
+	FisheyeCamera
+)
+
+// The list of supported camera types.
+var CameraTypes = []CameraType{PerspectiveCamera, OrthographicCamera, EquirectangularCamera, FisheyeCamera}
+
+// Get the camera type name.
+func (ct CameraType) String() string {
+	switch ct {
+	case PerspectiveCamera:
+		return "perspective"
+	case OrthographicCamera:
+		return "orthographic"
+	case EquirectangularCamera:
+		return "equirectangular"
+	case FisheyeCamera:
+		return "fisheye"
+	}
+
+	return fmt.Sprintf("camera(%d)", uint8(ct))
+}
+
+// Lookup a camera type by its name.
+func ParseCameraType(name string) (CameraType, error) {
+	for _, ct := range CameraTypes {
+		if ct.String() == name {
+			return ct, nil
+		}
+	}
+
+	return 0, ErrUnknownCameraType
+}
+
+// Default camera type attributes.
+const (
+	// The height of the orthographic view volume in world units.
+	DefaultOrthoHeight float32 = 2
+
+	// The fisheye field of view in degrees.
+	DefaultFisheyeFOV float32 = 180
+)
+
+// The focus distance used by thin-lens cameras when autofocus does not find
+// any geometry at the frame center.
+const AutofocusFallbackDistance float32 = 1000
+
 // Stores the ray directions at the for corners of our camera frustrum. It is
 // used as a shortcut for generating per pixel rays via interpolation of the
 // corner rays. While we don't care about the W coordinate we use Vec4 since
@@ -34,6 +102,15 @@ func (fr Frustrum) String() string {
 	)
 }
 
+// Get the unit-length view direction and the right and up vectors of the
+// view plane spanned by the frustrum corner rays.
+func (fr Frustrum) Axes() (forward, right, up types.Vec3) {
+	forward = fr[0].Vec3().Add(fr[1].Vec3()).Add(fr[2].Vec3()).Add(fr[3].Vec3()).Normalize()
+	right = fr[1].Vec3().Sub(fr[0].Vec3()).Normalize()
+	up = fr[0].Vec3().Sub(fr[2].Vec3()).Normalize()
+	return forward, right, up
+}
+
 // The camera type controls the scene camera.
 type Camera struct {
 	Position types.Vec3
@@ -49,18 +126,38 @@ type Camera struct {
 	// Camera FOV
 	FOV float32
 
+	// The camera projection type.
+	Type CameraType
+
+	// The height of the view volume in world units for orthographic cameras.
+	// The view volume width is calculated using the frame aspect ratio.
+	OrthoHeight float32
+
+	// The field of view in degrees for fisheye cameras.
+	FisheyeFOV float32
+
+	// Thin-lens attributes. The aperture radius controls the amount of depth
+	// of field blur; a zero radius models a pinhole camera. Points whose
+	// distance along the view direction equals the focus distance are in
+	// focus. If the focus distance is zero, thin-lens cameras automatically
+	// focus on the closest surface at the frame center.
+	ApertureRadius float32
+	FocusDistance  float32
+
 	// Adjust the frustrum so that Y is inverted
 	InvertY bool
 }
 
 func NewCamera(fov float32) *Camera {
 	return &Camera{
-		ViewMat:  types.Ident4(),
-		ProjMat:  types.Ident4(),
-		Position: types.Vec3{0, 0, 0},
-		LookAt:   types.Vec3{0, 0, -1},
-		Up:       types.Vec3{0, 1, 0},
-		FOV:      fov,
+		ViewMat:     types.Ident4(),
+		ProjMat:     types.Ident4(),
+		Position:    types.Vec3{0, 0, 0},
+		LookAt:      types.Vec3{0, 0, -1},
+		Up:          types.Vec3{0, 1, 0},
+		FOV:         fov,
+		OrthoHeight: DefaultOrthoHeight,
+		FisheyeFOV:  DefaultFisheyeFOV,
 	}
 }
 
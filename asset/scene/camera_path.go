@@ -0,0 +1,130 @@
+package scene
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrNoKeyframes           = errors.New("camera path: no keyframes defined")
+	ErrUnsortedKeyframes     = errors.New("camera path: keyframes must be sorted by time")
+	ErrInvalidKeyframeLookAt = errors.New("camera path: keyframe eye and look positions must not be the same")
+)
+
+// A camera keyframe.
+type CameraKeyframe struct {
+	// The keyframe time in seconds.
+	Time float32 `json:"time"`
+
+	// The camera eye position, target and up vector.
+	Eye    types.Vec3 `json:"eye"`
+	LookAt types.Vec3 `json:"look"`
+	Up     types.Vec3 `json:"up"`
+
+	// Camera FOV. It uses the same units as the camera_fov scene directive.
+	FOV float32 `json:"fov"`
+}
+
+// A camera path defined by a set of keyframes. Camera positions are linearly
+// interpolated between keyframes while camera orientations are interpolated
+// using quaternion slerp.
+type CameraPath struct {
+	Keyframes []CameraKeyframe
+
+	// Orientation quaternions and look distances for each keyframe.
+	orientations []types.Quat
+	lookDists    []float32
+}
+
+// Parse a JSON-encoded list of keyframes and create a new camera path.
+func ReadCameraPath(r io.Reader) (*CameraPath, error) {
+	var keyframes []CameraKeyframe
+	err := json.NewDecoder(r).Decode(&keyframes)
+	if err != nil {
+		return nil, fmt.Errorf("camera path: could not parse keyframes: %v", err)
+	}
+
+	return NewCameraPath(keyframes)
+}
+
+// Create a new camera path from a list of keyframes sorted by time.
+func NewCameraPath(keyframes []CameraKeyframe) (*CameraPath, error) {
+	if len(keyframes) == 0 {
+		return nil, ErrNoKeyframes
+	}
+
+	p := &CameraPath{
+		Keyframes:    keyframes,
+		orientations: make([]types.Quat, len(keyframes)),
+		lookDists:    make([]float32, len(keyframes)),
+	}
+
+	for index, kf := range keyframes {
+		if index > 0 && kf.Time < keyframes[index-1].Time {
+			return nil, ErrUnsortedKeyframes
+		}
+
+		lookDir := kf.LookAt.Sub(kf.Eye)
+		p.lookDists[index] = lookDir.Len()
+		if p.lookDists[index] == 0 {
+			return nil, ErrInvalidKeyframeLookAt
+		}
+
+		up := kf.Up
+		if up.Len() == 0 {
+			up = types.Vec3{0, 1, 0}
+		}
+		p.orientations[index] = types.QuatLookAtV(lookDir, up)
+	}
+
+	return p, nil
+}
+
+// Get the time of the first keyframe.
+func (p *CameraPath) StartTime() float32 {
+	return p.Keyframes[0].Time
+}
+
+// Get the time of the last keyframe.
+func (p *CameraPath) EndTime() float32 {
+	return p.Keyframes[len(p.Keyframes)-1].Time
+}
+
+// Position the camera at the interpolated path location for time t. Times
+// outside the keyframe range are clamped to the first/last keyframe. The
+// caller is responsible for calling SetupProjection on the camera to apply
+// the updated FOV.
+func (p *CameraPath) Apply(camera *Camera, t float32) {
+	// Find the keyframe pair that contains t
+	next := 0
+	for next < len(p.Keyframes) && p.Keyframes[next].Time <= t {
+		next++
+	}
+
+	var from, to int
+	var amount float32
+	switch {
+	case next == 0:
+		from, to = 0, 0
+	case next == len(p.Keyframes):
+		from, to = next-1, next-1
+	default:
+		from, to = next-1, next
+		amount = (t - p.Keyframes[from].Time) / (p.Keyframes[to].Time - p.Keyframes[from].Time)
+	}
+
+	kf0, kf1 := &p.Keyframes[from], &p.Keyframes[to]
+	orientation := types.QuatSlerp(p.orientations[from], p.orientations[to], amount)
+	lookDist := p.lookDists[from] + (p.lookDists[to]-p.lookDists[from])*amount
+
+	camera.Position = kf0.Eye.Add(kf1.Eye.Sub(kf0.Eye).Mul(amount))
+	camera.LookAt = camera.Position.Add(orientation.Rotate(types.Vec3{0, 0, -1}).Mul(lookDist))
+	camera.Up = orientation.Rotate(types.Vec3{0, 1, 0})
+	camera.FOV = kf0.FOV + (kf1.FOV-kf0.FOV)*amount
+	camera.Pitch = 0
+	camera.Yaw = 0
+}
@@ -0,0 +1,83 @@
+package scene
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestReadCameraPath(t *testing.T) {
+	specs := []struct {
+		payload string
+		expErr  string
+	}{
+		{`[]`, ErrNoKeyframes.Error()},
+		{`[{"time": 1, "eye": [0, 0, 0], "look": [0, 0, -1]}, {"time": 0, "eye": [0, 0, 0], "look": [0, 0, -1]}]`, ErrUnsortedKeyframes.Error()},
+		{`[{"time": 0, "eye": [1, 1, 1], "look": [1, 1, 1]}]`, ErrInvalidKeyframeLookAt.Error()},
+		{`{"time": 0}`, "camera path: could not parse keyframes"},
+		{`[{"time": 0, "eye": [0, 0, 0], "look": [0, 0, -1], "up": [0, 1, 0], "fov": 45}]`, ""},
+	}
+
+	for specIndex, spec := range specs {
+		_, err := ReadCameraPath(strings.NewReader(spec.payload))
+		if spec.expErr == "" {
+			if err != nil {
+				t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.HasPrefix(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error %q; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestCameraPathApply(t *testing.T) {
+	path, err := NewCameraPath([]CameraKeyframe{
+		{Time: 0, Eye: types.Vec3{0, 0, 0}, LookAt: types.Vec3{0, 0, -2}, Up: types.Vec3{0, 1, 0}, FOV: 40},
+		{Time: 2, Eye: types.Vec3{4, 0, 0}, LookAt: types.Vec3{2, 0, 0}, Up: types.Vec3{0, 1, 0}, FOV: 60},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		time      float32
+		expEye    types.Vec3
+		expLookAt types.Vec3
+		expFOV    float32
+	}{
+		// Clamp to first keyframe
+		{-1, types.Vec3{0, 0, 0}, types.Vec3{0, 0, -2}, 40},
+		{0, types.Vec3{0, 0, 0}, types.Vec3{0, 0, -2}, 40},
+		// Halfway through, the orientation should be rotated by 45 degrees
+		{1, types.Vec3{2, 0, 0}, types.Vec3{2 - 1.4142135, 0, -1.4142135}, 50},
+		{2, types.Vec3{4, 0, 0}, types.Vec3{2, 0, 0}, 60},
+		// Clamp to last keyframe
+		{3, types.Vec3{4, 0, 0}, types.Vec3{2, 0, 0}, 60},
+	}
+
+	camera := NewCamera(0)
+	for specIndex, spec := range specs {
+		path.Apply(camera, spec.time)
+
+		if !approxEqual(camera.Position, spec.expEye) {
+			t.Errorf("[spec %d] expected camera eye to be %v; got %v", specIndex, spec.expEye, camera.Position)
+		}
+		if !approxEqual(camera.LookAt, spec.expLookAt) {
+			t.Errorf("[spec %d] expected camera look at to be %v; got %v", specIndex, spec.expLookAt, camera.LookAt)
+		}
+		if !approxEqual(camera.Up, types.Vec3{0, 1, 0}) {
+			t.Errorf("[spec %d] expected camera up to be [0, 1, 0]; got %v", specIndex, camera.Up)
+		}
+		if camera.FOV != spec.expFOV {
+			t.Errorf("[spec %d] expected camera FOV to be %f; got %f", specIndex, spec.expFOV, camera.FOV)
+		}
+	}
+}
+
+func approxEqual(v1, v2 types.Vec3) bool {
+	return v1.Sub(v2).Len() < 1e-4
+}
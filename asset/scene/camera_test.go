@@ -0,0 +1,51 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestParseCameraType(t *testing.T) {
+	for _, ct := range CameraTypes {
+		parsed, err := ParseCameraType(ct.String())
+		if err != nil {
+			t.Errorf("unexpected error parsing camera type %q: %v", ct.String(), err)
+			continue
+		}
+		if parsed != ct {
+			t.Errorf("expected parsed camera type to be %d; got %d", ct, parsed)
+		}
+	}
+
+	_, err := ParseCameraType("cylindrical")
+	if err != ErrUnknownCameraType {
+		t.Fatalf("expected to get ErrUnknownCameraType; got %v", err)
+	}
+}
+
+func TestFrustrumAxes(t *testing.T) {
+	specs := []struct {
+		invertY bool
+		expUp   types.Vec3
+	}{
+		{false, types.Vec3{0, 1, 0}},
+		{true, types.Vec3{0, -1, 0}},
+	}
+
+	for specIndex, spec := range specs {
+		camera := NewCamera(1)
+		camera.Position = types.Vec3{1, 2, 3}
+		camera.LookAt = types.Vec3{1, 2, 0}
+		camera.InvertY = spec.invertY
+		camera.SetupProjection(1.5)
+
+		forward, right, up := camera.Frustrum.Axes()
+		expAxes := []types.Vec3{{0, 0, -1}, {1, 0, 0}, spec.expUp}
+		for axisIndex, axis := range []types.Vec3{forward, right, up} {
+			if axis.Sub(expAxes[axisIndex]).Len() > 1e-4 {
+				t.Errorf("[spec %d] expected axis %d to be %v; got %v", specIndex, axisIndex, expAxes[axisIndex], axis)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package procedural
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Materials used by the generated cornell box scene.
+var cornellBoxMaterials = []string{
+	`diffuse(reflectance: {0.75, 0.75, 0.75})`,
+	`diffuse(reflectance: {0.75, 0.15, 0.15})`,
+	`diffuse(reflectance: {0.15, 0.75, 0.15})`,
+	`emissive(radiance: {1, 0.9, 0.8}, scale: 15)`,
+	`diffuse(reflectance: {0.2, 0.4, 0.8})`,
+	`roughConductor(specularity: {0.9, 0.9, 0.9}, intIOR: "gold", roughness: 0.2)`,
+	`dielectric(specularity: {1, 1, 1}, transmittance: {1, 1, 1}, intIOR: 1.5, extIOR: "air")`,
+}
+
+// Material indices.
+const (
+	whiteMaterial = iota
+	redMaterial
+	greenMaterial
+	lightMaterial
+	firstSphereMaterial
+)
+
+// Generate a cornell box scene lit by an area light that contains a grid of
+// sphereGrid x sphereGrid spheres. Each sphere is tessellated into
+// sphereSegments segments along each axis. The generated scene does not
+// depend on any external assets so it can be used for benchmarking.
+func CornellBox(sphereGrid, sphereSegments int) (*scene.Scene, error) {
+	if sphereGrid < 0 {
+		return nil, fmt.Errorf("procedural: invalid sphere grid size %d", sphereGrid)
+	} else if sphereSegments < 3 {
+		return nil, fmt.Errorf("procedural: spheres require at least 3 segments; got %d", sphereSegments)
+	}
+
+	sc := input.NewScene()
+	for index, expr := range cornellBoxMaterials {
+		sc.Materials = append(sc.Materials, &input.Material{
+			Name:       fmt.Sprintf("material%d", index),
+			Expression: expr,
+			Used:       true,
+		})
+	}
+
+	// The box spans [-1, 1] along the X and Z axes and [0, 2] along the Y axis
+	box := input.NewMesh("box")
+	addQuad(box, whiteMaterial, types.Vec3{-1, 0, 1}, types.Vec3{1, 0, 1}, types.Vec3{1, 0, -1}, types.Vec3{-1, 0, -1})
+	addQuad(box, whiteMaterial, types.Vec3{-1, 2, -1}, types.Vec3{1, 2, -1}, types.Vec3{1, 2, 1}, types.Vec3{-1, 2, 1})
+	addQuad(box, whiteMaterial, types.Vec3{-1, 0, -1}, types.Vec3{1, 0, -1}, types.Vec3{1, 2, -1}, types.Vec3{-1, 2, -1})
+	addQuad(box, redMaterial, types.Vec3{-1, 0, 1}, types.Vec3{-1, 0, -1}, types.Vec3{-1, 2, -1}, types.Vec3{-1, 2, 1})
+	addQuad(box, greenMaterial, types.Vec3{1, 0, -1}, types.Vec3{1, 0, 1}, types.Vec3{1, 2, 1}, types.Vec3{1, 2, -1})
+	sc.Meshes = append(sc.Meshes, box)
+
+	light := input.NewMesh("light")
+	addQuad(light, lightMaterial, types.Vec3{-0.25, 1.99, -0.25}, types.Vec3{0.25, 1.99, -0.25}, types.Vec3{0.25, 1.99, 0.25}, types.Vec3{-0.25, 1.99, 0.25})
+	sc.Meshes = append(sc.Meshes, light)
+
+	// Arrange the spheres in a grid on the box floor
+	if sphereGrid > 0 {
+		cellSize := float32(1.6) / float32(sphereGrid)
+		radius := cellSize * 0.4
+		for row := 0; row < sphereGrid; row++ {
+			for col := 0; col < sphereGrid; col++ {
+				center := types.Vec3{
+					-0.8 + (float32(col)+0.5)*cellSize,
+					radius,
+					-0.8 + (float32(row)+0.5)*cellSize,
+				}
+				sphere := input.NewMesh(fmt.Sprintf("sphere%d", row*sphereGrid+col))
+				matIndex := firstSphereMaterial + (row+col)%(len(cornellBoxMaterials)-firstSphereMaterial)
+				addSphere(sphere, matIndex, center, radius, sphereSegments)
+				sc.Meshes = append(sc.Meshes, sphere)
+			}
+		}
+	}
+
+	for meshIndex, mesh := range sc.Meshes {
+		bbox := mesh.BBox()
+		inst := &input.MeshInstance{
+			MeshIndex: uint32(meshIndex),
+			Transform: types.Ident4(),
+		}
+		inst.SetBBox(bbox)
+		inst.SetCenter(bbox[0].Add(bbox[1]).Mul(0.5))
+		sc.MeshInstances = append(sc.MeshInstances, inst)
+	}
+
+	sc.Camera.FOV = 45
+	sc.Camera.Eye = types.Vec3{0, 1, 3.4}
+	sc.Camera.Look = types.Vec3{0, 1, 0}
+	sc.Camera.Up = types.Vec3{0, 1, 0}
+
+	return compiler.Compile(sc)
+}
+
+// Add a quad with counter-clockwise vertices to a mesh.
+func addQuad(mesh *input.Mesh, matIndex int, v0, v1, v2, v3 types.Vec3) {
+	normal := v1.Sub(v0).Cross(v2.Sub(v0)).Normalize()
+	uvs := [4]types.Vec2{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	verts := [4]types.Vec3{v0, v1, v2, v3}
+	for _, indices := range [][3]int{{0, 1, 2}, {0, 2, 3}} {
+		addTriangle(
+			mesh,
+			matIndex,
+			[3]types.Vec3{verts[indices[0]], verts[indices[1]], verts[indices[2]]},
+			[3]types.Vec3{normal, normal, normal},
+			[3]types.Vec2{uvs[indices[0]], uvs[indices[1]], uvs[indices[2]]},
+		)
+	}
+}
+
+// Add a UV sphere to a mesh.
+func addSphere(mesh *input.Mesh, matIndex int, center types.Vec3, radius float32, segments int) {
+	point := func(ring, segment int) (types.Vec3, types.Vec3, types.Vec2) {
+		theta := math.Pi * float64(ring) / float64(segments)
+		phi := 2.0 * math.Pi * float64(segment) / float64(segments)
+		normal := types.Vec3{
+			float32(math.Sin(theta) * math.Cos(phi)),
+			float32(math.Cos(theta)),
+			float32(math.Sin(theta) * math.Sin(phi)),
+		}
+		uv := types.Vec2{float32(segment) / float32(segments), float32(ring) / float32(segments)}
+		return center.Add(normal.Mul(radius)), normal, uv
+	}
+
+	for ring := 0; ring < segments; ring++ {
+		for segment := 0; segment < segments; segment++ {
+			p0, n0, uv0 := point(ring, segment)
+			p1, n1, uv1 := point(ring, segment+1)
+			p2, n2, uv2 := point(ring+1, segment+1)
+			p3, n3, uv3 := point(ring+1, segment)
+
+			// Skip the degenerate triangles at the sphere poles
+			if ring != 0 {
+				addTriangle(mesh, matIndex, [3]types.Vec3{p0, p1, p3}, [3]types.Vec3{n0, n1, n3}, [3]types.Vec2{uv0, uv1, uv3})
+			}
+			if ring != segments-1 {
+				addTriangle(mesh, matIndex, [3]types.Vec3{p1, p2, p3}, [3]types.Vec3{n1, n2, n3}, [3]types.Vec2{uv1, uv2, uv3})
+			}
+		}
+	}
+}
+
+// Add a triangle primitive to a mesh.
+func addTriangle(mesh *input.Mesh, matIndex int, verts, normals [3]types.Vec3, uvs [3]types.Vec2) {
+	prim := &input.Primitive{
+		Vertices:      verts,
+		Normals:       normals,
+		UVs:           uvs,
+		MaterialIndex: matIndex,
+	}
+	prim.SetBBox([2]types.Vec3{
+		types.MinVec3(verts[0], types.MinVec3(verts[1], verts[2])),
+		types.MaxVec3(verts[0], types.MaxVec3(verts[1], verts[2])),
+	})
+	prim.SetCenter(verts[0].Add(verts[1]).Add(verts[2]).Mul(1.0 / 3.0))
+	mesh.Primitives = append(mesh.Primitives, prim)
+	mesh.MarkBBoxDirty()
+}
@@ -0,0 +1,40 @@
+package procedural
+
+import "testing"
+
+func TestCornellBox(t *testing.T) {
+	sc, err := CornellBox(2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sc.EmissivePrimitives) != 2 {
+		t.Fatalf("expected scene to contain 2 emissive primitives; got %d", len(sc.EmissivePrimitives))
+	}
+	if sc.Camera == nil {
+		t.Fatal("expected scene to define a camera")
+	}
+
+	// Box (10) + light (2) + 4 spheres with 8 segments (2 * 8 * (8 - 1) each)
+	expPrimitives := 10 + 2 + 4*2*8*7
+	if len(sc.MaterialIndex) != expPrimitives {
+		t.Fatalf("expected scene to contain %d primitives; got %d", expPrimitives, len(sc.MaterialIndex))
+	}
+}
+
+func TestCornellBoxErrors(t *testing.T) {
+	specs := []struct {
+		grid     int
+		segments int
+	}{
+		{-1, 8},
+		{1, 2},
+	}
+
+	for specIndex, spec := range specs {
+		_, err := CornellBox(spec.grid, spec.segments)
+		if err == nil {
+			t.Errorf("[spec %d] expected to get an error", specIndex)
+		}
+	}
+}
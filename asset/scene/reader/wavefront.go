@@ -423,6 +423,34 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
+		case "camera_aperture":
+			r.rawScene.Camera.Aperture, err = parseNonNegativeFloat32(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_focus":
+			r.rawScene.Camera.Focus, err = parseNonNegativeFloat32(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_type":
+			r.rawScene.Camera.Type, err = parseCameraType(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_ortho_height":
+			r.rawScene.Camera.OrthoHeight, err = parsePositiveFloat32(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_fisheye_fov":
+			r.rawScene.Camera.FisheyeFOV, err = parsePositiveFloat32(lineTokens)
+			if err == nil && r.rawScene.Camera.FisheyeFOV > 360 {
+				err = fmt.Errorf(`unsupported value for "%s"; expected a value in the (0, 360] range; got %g`, lineTokens[0], r.rawScene.Camera.FisheyeFOV)
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
 		case "instance":
 			instance, err := r.parseMeshInstance(lineTokens)
 			if err != nil {
@@ -795,6 +823,42 @@ func parseFloat32(lineTokens []string) (float32, error) {
 	return float32(val), nil
 }
 
+// Parse a float32 row whose value must not be negative.
+func parseNonNegativeFloat32(lineTokens []string) (float32, error) {
+	val, err := parseFloat32(lineTokens)
+	if err == nil && val < 0 {
+		err = fmt.Errorf(`unsupported value for "%s"; expected a non-negative value; got %g`, lineTokens[0], val)
+	}
+	return val, err
+}
+
+// Parse a float32 row whose value must be greater than zero.
+func parsePositiveFloat32(lineTokens []string) (float32, error) {
+	val, err := parseFloat32(lineTokens)
+	if err == nil && val <= 0 {
+		err = fmt.Errorf(`unsupported value for "%s"; expected a positive value; got %g`, lineTokens[0], val)
+	}
+	return val, err
+}
+
+// Parse a camera type row.
+func parseCameraType(lineTokens []string) (scene.CameraType, error) {
+	if len(lineTokens) != 2 {
+		return 0, fmt.Errorf(`unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
+	}
+
+	cameraType, err := scene.ParseCameraType(lineTokens[1])
+	if err != nil {
+		supported := make([]string, 0, len(scene.CameraTypes))
+		for _, ct := range scene.CameraTypes {
+			supported = append(supported, ct.String())
+		}
+		return 0, fmt.Errorf(`unsupported value for "%s"; expected one of: %s; got %q`, lineTokens[0], strings.Join(supported, ", "), lineTokens[1])
+	}
+
+	return cameraType, nil
+}
+
 // Parse a Vec3 row.
 func parseVec3(lineTokens []string) (types.Vec3, error) {
 	if len(lineTokens) < 4 {
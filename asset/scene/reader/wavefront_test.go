@@ -1,14 +1,12 @@
 package reader
 
 import (
-	"image"
-	"image/png"
-	"net/http"
-	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/types"
 )
 
@@ -96,7 +94,7 @@ func TestSelectFaceCoordinate(t *testing.T) {
 	}
 
 	for idx, s := range specs {
-		v, err := selectFaceCoordIndex(s.in, s.listLen)
+		v, err := selectFaceCoordIndex(s.in, s.listLen, 0)
 		if s.expError != "" && (err == nil || err.Error() != s.expError) {
 			t.Fatalf("[spec %d] expected error %s; got %v", idx, s.expError, err)
 		} else if v != s.out {
@@ -127,10 +125,10 @@ f 1/1/1 2/2/2 -1/-1/-1
 	r.Read(res)
 
 	expMeshInstances := 1
-	if len(r.sceneGraph.MeshInstances) != expMeshInstances {
-		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.sceneGraph.MeshInstances))
+	if len(r.rawScene.MeshInstances) != expMeshInstances {
+		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.rawScene.MeshInstances))
 	}
-	inst0 := r.sceneGraph.MeshInstances[0]
+	inst0 := r.rawScene.MeshInstances[0]
 	if inst0.MeshIndex != 0 {
 		t.Fatalf("expected mesh instance to point to mesh at index 0; got %d", inst0.MeshIndex)
 	}
@@ -182,8 +180,8 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 	r.Read(res)
 
 	expMeshInstances := 3
-	if len(r.sceneGraph.MeshInstances) != expMeshInstances {
-		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.sceneGraph.MeshInstances))
+	if len(r.rawScene.MeshInstances) != expMeshInstances {
+		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.rawScene.MeshInstances))
 	}
 
 	type spec struct {
@@ -198,7 +196,7 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 		{2, types.Vec3{0, 1, 0}, types.Vec3{0, 0, 20}},
 	}
 	for idx, s := range specs {
-		inst := r.sceneGraph.MeshInstances[s.instance]
+		inst := r.rawScene.MeshInstances[s.instance]
 		out := inst.Transform.Mul4x1(s.in.Vec4(1.0)).Vec3()
 		if !types.ApproxEqual(out, s.expOut, 1e-3) {
 			t.Fatalf("[spec %d] expected transformed point with instance %d matrix to be %v; got %v", idx, s.instance, s.expOut, out)
@@ -209,7 +207,7 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 		[2]types.Vec3{types.Vec3{1, 0, 1}, types.Vec3{2, 1, 1}},
 	}
 	for meshIndex, expBBox := range expBBoxes {
-		bbox := r.sceneGraph.MeshInstances[meshIndex].BBox()
+		bbox := r.rawScene.MeshInstances[meshIndex].BBox()
 		if !types.ApproxEqual(bbox[0], expBBox[0], 1e-3) {
 			t.Fatalf("[mesh inst. %d] expected bbox min to be %v; got %v", meshIndex, expBBox[0], bbox[0])
 		}
@@ -244,11 +242,11 @@ f 1/1/1 2/2/2 -1/-1/-1
 	}
 
 	expMeshes := 1
-	if len(r.sceneGraph.Meshes) != expMeshes {
-		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.sceneGraph.Meshes))
+	if len(r.rawScene.Meshes) != expMeshes {
+		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.rawScene.Meshes))
 	}
 
-	mesh0 := r.sceneGraph.Meshes[0]
+	mesh0 := r.rawScene.Meshes[0]
 	expName := "testObj"
 	if mesh0.Name != expName {
 		t.Fatalf("expected mesh[0] name to be '%s'; got %s", expName, mesh0.Name)
@@ -260,8 +258,8 @@ f 1/1/1 2/2/2 -1/-1/-1
 	}
 
 	expMaterials := 1
-	if len(r.sceneGraph.Materials) != expMaterials {
-		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.sceneGraph.Materials))
+	if len(r.materials) != expMaterials {
+		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.materials))
 	}
 
 	expPoints := []types.Vec3{
@@ -332,11 +330,11 @@ f 1 2 3 4
 	}
 
 	expMeshes := 1
-	if len(r.sceneGraph.Meshes) != expMeshes {
-		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.sceneGraph.Meshes))
+	if len(r.rawScene.Meshes) != expMeshes {
+		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.rawScene.Meshes))
 	}
 
-	mesh0 := r.sceneGraph.Meshes[0]
+	mesh0 := r.rawScene.Meshes[0]
 	expName := "testObj"
 	if mesh0.Name != expName {
 		t.Fatalf("expected mesh[0] name to be '%s'; got %s", expName, mesh0.Name)
@@ -348,8 +346,8 @@ f 1 2 3 4
 	}
 
 	expMaterials := 1
-	if len(r.sceneGraph.Materials) != expMaterials {
-		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.sceneGraph.Materials))
+	if len(r.materials) != expMaterials {
+		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.materials))
 	}
 
 	expPoints := []types.Vec3{
@@ -409,6 +407,88 @@ f 1 2 3 4
 		t.Fatalf("[prim 1] expected bbox max to be %v; got %v", expBBox[1], bbox[1])
 	}
 }
+
+func TestParseCameraLens(t *testing.T) {
+	type spec struct {
+		payload     string
+		expAperture float32
+		expFocus    float32
+		expError    string
+	}
+	specs := []spec{
+		{"camera_fov 30", 0, 0, ""},
+		{"camera_aperture 0.25\ncamera_focus 4.5", 0.25, 4.5, ""},
+		{"camera_aperture 0\ncamera_focus 0", 0, 0, ""},
+		{"camera_aperture -0.1", 0, 0, `[embedded: 1] error: unsupported value for "camera_aperture"; expected a non-negative value; got -0.1`},
+		{"camera_focus -2", 0, 0, `[embedded: 1] error: unsupported value for "camera_focus"; expected a non-negative value; got -2`},
+		{"camera_aperture", 0, 0, `[embedded: 1] error: unsupported syntax for "camera_aperture"; expected 1 argument; got 0`},
+		{"camera_aperture 0.1\ncamera_focus far", 0, 0, `[embedded: 2] error: strconv.ParseFloat: parsing "far": invalid syntax`},
+	}
+
+	for idx, s := range specs {
+		r := newWavefrontReader()
+		err := r.parse(mockResource(s.payload))
+		if s.expError != "" {
+			if err == nil || err.Error() != s.expError {
+				t.Fatalf("[spec %d] expected error %s; got %v", idx, s.expError, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("[spec %d] unexpected error: %v", idx, err)
+		}
+
+		camera := r.rawScene.Camera
+		if camera.Aperture != s.expAperture || camera.Focus != s.expFocus {
+			t.Fatalf("[spec %d] expected camera aperture and focus to be %f, %f; got %f, %f", idx, s.expAperture, s.expFocus, camera.Aperture, camera.Focus)
+		}
+	}
+}
+
+func TestParseCameraType(t *testing.T) {
+	type spec struct {
+		payload        string
+		expType        scene.CameraType
+		expOrthoHeight float32
+		expFisheyeFOV  float32
+		expError       string
+	}
+	specs := []spec{
+		{"camera_fov 30", scene.PerspectiveCamera, scene.DefaultOrthoHeight, scene.DefaultFisheyeFOV, ""},
+		{"camera_type perspective", scene.PerspectiveCamera, scene.DefaultOrthoHeight, scene.DefaultFisheyeFOV, ""},
+		{"camera_type orthographic\ncamera_ortho_height 5", scene.OrthographicCamera, 5, scene.DefaultFisheyeFOV, ""},
+		{"camera_type equirectangular", scene.EquirectangularCamera, scene.DefaultOrthoHeight, scene.DefaultFisheyeFOV, ""},
+		{"camera_type fisheye\ncamera_fisheye_fov 360", scene.FisheyeCamera, scene.DefaultOrthoHeight, 360, ""},
+		{"camera_type cylindrical", 0, 0, 0, `[embedded: 1] error: unsupported value for "camera_type"; expected one of: perspective, orthographic, equirectangular, fisheye; got "cylindrical"`},
+		{"camera_type", 0, 0, 0, `[embedded: 1] error: unsupported syntax for "camera_type"; expected 1 argument; got 0`},
+		{"camera_type fisheye orthographic", 0, 0, 0, `[embedded: 1] error: unsupported syntax for "camera_type"; expected 1 argument; got 2`},
+		{"camera_ortho_height 0", 0, 0, 0, `[embedded: 1] error: unsupported value for "camera_ortho_height"; expected a positive value; got 0`},
+		{"camera_ortho_height -1", 0, 0, 0, `[embedded: 1] error: unsupported value for "camera_ortho_height"; expected a positive value; got -1`},
+		{"camera_fisheye_fov 0", 0, 0, 0, `[embedded: 1] error: unsupported value for "camera_fisheye_fov"; expected a positive value; got 0`},
+		{"camera_type fisheye\ncamera_fisheye_fov 400", 0, 0, 0, `[embedded: 2] error: unsupported value for "camera_fisheye_fov"; expected a value in the (0, 360] range; got 400`},
+	}
+
+	for idx, s := range specs {
+		r := newWavefrontReader()
+		err := r.parse(mockResource(s.payload))
+		if s.expError != "" {
+			if err == nil || err.Error() != s.expError {
+				t.Fatalf("[spec %d] expected error %s; got %v", idx, s.expError, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("[spec %d] unexpected error: %v", idx, err)
+		}
+
+		camera := r.rawScene.Camera
+		if camera.Type != s.expType {
+			t.Fatalf("[spec %d] expected camera type to be %s; got %s", idx, s.expType, camera.Type)
+		}
+		if camera.OrthoHeight != s.expOrthoHeight || camera.FisheyeFOV != s.expFisheyeFOV {
+			t.Fatalf("[spec %d] expected camera ortho height and fisheye fov to be %f, %f; got %f, %f", idx, s.expOrthoHeight, s.expFisheyeFOV, camera.OrthoHeight, camera.FisheyeFOV)
+		}
+	}
+}
+
 func TestMaterialLoaderMissingNewMaterialCommand(t *testing.T) {
 	payload := `Kd 1.0 1.0 1.0`
 	res := mockResource(payload)
@@ -453,8 +533,7 @@ func TestMaterialLoaderSuccess(t *testing.T) {
 	Kd 1.0 1.0 1.0
 	Ks 0.1 0.2 0.3
 	Ke 0.4    0.5 0.6
-	Ni 2.5
-	Nr 0`
+	Ni 2.5`
 	res := mockResource(payload)
 	r := newWavefrontReader()
 	err := r.parseMaterials(res)
@@ -462,12 +541,12 @@ func TestMaterialLoaderSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	matLen := len(r.sceneGraph.Materials)
+	matLen := len(r.materials)
 	if matLen != 1 {
 		t.Fatalf("expected to parse 1 material; got %d", matLen)
 	}
 
-	mat := r.sceneGraph.Materials[0]
+	mat := r.materials[0]
 	if mat.Name != "foo" {
 		t.Fatalf("expected material name to be 'foo'; got %s", mat.Name)
 	}
@@ -488,74 +567,17 @@ func TestMaterialLoaderSuccess(t *testing.T) {
 	if mat.Ni != expScalar {
 		t.Fatalf("expected Ni to be %f; got %f", expScalar, mat.Ni)
 	}
-	expScalar = 0
-	if mat.Nr != expScalar {
-		t.Fatalf("expected Nr to be %f; got %f", expScalar, mat.Nr)
-	}
-
 }
 
 func TestMaterialLoaderWithTextures(t *testing.T) {
-	serverFn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		png.Encode(w, image.NewRGBA64(image.Rect(0, 0, 1, 1)))
-	})
-	server := httptest.NewServer(serverFn)
-	defer server.Close()
-
-	payload := `
-newmtl foo
-map_Kd SERVER/kd.png
-map_Ks SERVER/ks.png
-map_Ke SERVER/ke.png
-map_bump SERVER/bump.png
-map_Ni SERVER/ni.png
-map_Nr SERVER/nr.png
-`
-	res := mockResource(strings.Replace(payload, "SERVER", server.URL, -1))
-	r := newWavefrontReader()
-	err := r.parseMaterials(res)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if len(r.sceneGraph.Materials) != 1 {
-		t.Fatalf("expected to parse 1 material; got %d", len(r.sceneGraph.Materials))
-	}
-
-	expTexCount := 6
-	if len(r.sceneGraph.Textures) != expTexCount {
-		t.Fatalf("expected to load %d textures; got %d", expTexCount, len(r.sceneGraph.Textures))
-	}
-
-	texIndices := 0
-	mat := r.sceneGraph.Materials[0]
-	if mat.KdTex != -1 {
-		texIndices++
-	}
-	if mat.KsTex != -1 {
-		texIndices++
-	}
-	if mat.KeTex != -1 {
-		texIndices++
-	}
-	if mat.NiTex != -1 {
-		texIndices++
-	}
-	if mat.NrTex != -1 {
-		texIndices++
-	}
-	if mat.NormalTex != -1 {
-		texIndices++
-	}
-	if texIndices != expTexCount {
-		t.Fatalf("expected %d texture indices to be assigned to mat0; got %d", expTexCount, texIndices)
-	}
-}
-
-func TestMaterialLoaderWithMissingTextures(t *testing.T) {
 	payload := `
 newmtl foo
-map_Kd invalid.png
+map_Kd kd.png
+map_Ks ks.png
+map_Ke ke.png
+map_Tf tf.png
+map_bump bump.png
+map_normal normal.png
 `
 	res := mockResource(payload)
 	r := newWavefrontReader()
@@ -564,13 +586,15 @@ map_Kd invalid.png
 		t.Fatal(err)
 	}
 
-	if len(r.sceneGraph.Textures) != 0 {
-		t.Fatalf("expected texture list to be empty; got %d items", len(r.sceneGraph.Textures))
+	if len(r.materials) != 1 {
+		t.Fatalf("expected to parse 1 material; got %d", len(r.materials))
 	}
 
-	mat := r.sceneGraph.Materials[0]
-	if mat.KdTex != -1 {
-		t.Fatalf("expected kdTex to be -1 for missing texture; got %d", mat.KdTex)
+	mat := r.materials[0]
+	expTextures := []string{"kd.png", "ks.png", "ke.png", "tf.png", "bump.png", "normal.png"}
+	textures := []string{mat.KdTex, mat.KsTex, mat.KeTex, mat.TfTex, mat.BumpTex, mat.NormalTex}
+	if !reflect.DeepEqual(textures, expTextures) {
+		t.Fatalf("expected material textures to be %v; got %v", expTextures, textures)
 	}
 }
 
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/procedural"
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli"
+)
+
+const (
+	// The name reported for the built-in benchmark scene.
+	builtinBenchScene = "builtin:cornell-box"
+
+	// The sphere grid size and sphere tessellation for the built-in benchmark scene.
+	benchSphereGrid     = 3
+	benchSphereSegments = 16
+)
+
+// A benchmark report.
+type benchReport struct {
+	Scene        string
+	Options      renderer.Options
+	WarmupFrames int
+	Frames       int
+	Devices      []benchDeviceReport
+	Total        benchTotalReport
+}
+
+// Benchmark results for a single device. Times are accumulated over all
+// measured frames and ray rates are calculated using the device render time.
+type benchDeviceReport struct {
+	Id                  string
+	Type                string
+	Failed              bool
+	Error               string `json:",omitempty"`
+	Blocks              uint32
+	PrimaryRays         uint64
+	IndirectRays        uint64
+	OcclusionRays       uint64
+	PrimaryRaysPerSec   float64
+	IndirectRaysPerSec  float64
+	OcclusionRaysPerSec float64
+	RaysPerSec          float64
+	RenderTime          time.Duration
+	UpdateTime          time.Duration
+	PrimaryRayTime      time.Duration
+	IntegratorTime      time.Duration
+	MergeTime           time.Duration
+	KernelTimes         map[string]time.Duration
+
+	// The accumulated kernel times indexed by kernel type.
+	kernelTimes tracer.KernelTimes
+}
+
+// Benchmark totals for all devices. Ray rates are calculated using the
+// total render time for all measured frames.
+type benchTotalReport struct {
+	Rays         uint64
+	RaysPerSec   float64
+	RenderTime   time.Duration
+	AvgFrameTime time.Duration
+}
+
+// Render a scene for a number of frames and report the ray throughput of
+// each device.
+func RunBenchmark(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	format := ctx.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid output format %q; supported formats: table, json", format)
+	}
+
+	numFrames := ctx.Int("frames")
+	if numFrames <= 0 {
+		return errors.New("frames must be greater than 0")
+	}
+	warmupFrames := ctx.Int("warmup")
+	if warmupFrames < 0 {
+		return errors.New("warmup must not be negative")
+	}
+
+	// Use fixed options and disable RR so that each frame traces the same workload
+	opts := renderer.Options{
+		FrameW:          uint32(ctx.Int("width")),
+		FrameH:          uint32(ctx.Int("height")),
+		SamplesPerPixel: uint32(ctx.Int("spp")),
+		Exposure:        1.0,
+		NumBounces:      uint32(ctx.Int("num-bounces")),
+		Seed:            uint32(ctx.Int("seed")),
+		CountRays:       true,
+		//
+		BlackListedDevices: ctx.StringSlice("blacklist"),
+		ForcePrimaryDevice: ctx.String("force-primary"),
+		UseCpuTracer:       ctx.Bool("cpu"),
+		RemoteWorkers:      ctx.StringSlice("worker"),
+		RemoteTimeout:      ctx.Duration("worker-timeout"),
+		CpuPipeline:        cpu.DefaultPipeline(),
+	}
+	opts.MinBouncesForRR = opts.NumBounces + 1
+
+	// Load scene or generate the built-in scene
+	var sc *scene.Scene
+	var err error
+	sceneName := builtinBenchScene
+	switch ctx.NArg() {
+	case 0:
+		sc, err = procedural.CornellBox(benchSphereGrid, benchSphereSegments)
+	case 1:
+		sceneName = ctx.Args().First()
+		sc, err = reader.ReadScene(sceneName)
+	default:
+		return errors.New("expected at most one scene file argument")
+	}
+	if err != nil {
+		return err
+	}
+
+	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
+
+	// Create renderer
+	scheduler, err := parseScheduler(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := renderer.NewDefault(sc, scheduler, opencl.DefaultPipeline(opencl.NoDebug), opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	report := benchReport{
+		Scene:        sceneName,
+		Options:      opts,
+		WarmupFrames: warmupFrames,
+		Frames:       numFrames,
+	}
+	deviceIndex := make(map[string]int)
+
+	for frame := 0; frame < warmupFrames+numFrames; frame++ {
+		err = r.Render()
+		if err != nil {
+			return err
+		}
+
+		stats := r.Stats()
+		if frame < warmupFrames {
+			logger.Noticef("rendered warmup frame %d/%d in %s", frame+1, warmupFrames, stats.RenderTime)
+			continue
+		}
+		logger.Noticef("rendered frame %d/%d in %s", frame-warmupFrames+1, numFrames, stats.RenderTime)
+
+		report.Total.RenderTime += stats.RenderTime
+		for _, stat := range stats.Tracers {
+			index, exists := deviceIndex[stat.Id]
+			if !exists {
+				index = len(report.Devices)
+				deviceIndex[stat.Id] = index
+				report.Devices = append(report.Devices, benchDeviceReport{
+					Id:   stat.Id,
+					Type: deviceType(stat.Flags),
+				})
+			}
+
+			dev := &report.Devices[index]
+			if stat.Dead {
+				dev.Failed = true
+				dev.Error = fmt.Sprint(stat.Err)
+			}
+			dev.Blocks += stat.NumBlocks
+			dev.PrimaryRays += stat.PrimaryRays
+			dev.IndirectRays += stat.IndirectRays
+			dev.OcclusionRays += stat.OcclusionRays
+			dev.RenderTime += stat.RenderTime
+			dev.UpdateTime += stat.UpdateTime
+			dev.PrimaryRayTime += stat.PrimaryRayTime
+			dev.IntegratorTime += stat.IntegratorTime
+			dev.MergeTime += stat.MergeTime
+			dev.kernelTimes.Add(stat.KernelTimes)
+		}
+	}
+
+	for index := range report.Devices {
+		dev := &report.Devices[index]
+		dev.PrimaryRaysPerSec = raysPerSec(dev.PrimaryRays, dev.RenderTime)
+		dev.IndirectRaysPerSec = raysPerSec(dev.IndirectRays, dev.RenderTime)
+		dev.OcclusionRaysPerSec = raysPerSec(dev.OcclusionRays, dev.RenderTime)
+		dev.RaysPerSec = raysPerSec(dev.PrimaryRays+dev.IndirectRays+dev.OcclusionRays, dev.RenderTime)
+		dev.KernelTimes = make(map[string]time.Duration)
+		for kernel, elapsed := range dev.kernelTimes {
+			dev.KernelTimes[tracer.Kernel(kernel).String()] = elapsed
+		}
+		report.Total.Rays += dev.PrimaryRays + dev.IndirectRays + dev.OcclusionRays
+	}
+	report.Total.RaysPerSec = raysPerSec(report.Total.Rays, report.Total.RenderTime)
+	report.Total.AvgFrameTime = report.Total.RenderTime / time.Duration(numFrames)
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	displayBenchReport(report)
+	return nil
+}
+
+// Calculate the number of rays per second.
+func raysPerSec(rays uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(rays) / elapsed.Seconds()
+}
+
+// Format a ray rate using the most appropriate unit.
+func fmtRaysPerSec(rate float64) string {
+	switch {
+	case rate >= 1e9:
+		return fmt.Sprintf("%.2f Grays/s", rate/1e9)
+	case rate >= 1e6:
+		return fmt.Sprintf("%.2f Mrays/s", rate/1e6)
+	case rate >= 1e3:
+		return fmt.Sprintf("%.2f Krays/s", rate/1e3)
+	}
+	return fmt.Sprintf("%.0f rays/s", rate)
+}
+
+func displayBenchReport(report benchReport) {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Device", "Status", "Blocks", "Primary", "Indirect", "Occlusion", "Total", "Update", "Primary rays", "Integrator", "Merge", "Render time"})
+	for _, dev := range report.Devices {
+		status := "ok"
+		if dev.Failed {
+			status = fmt.Sprintf("failed: %s", dev.Error)
+		}
+		table.Append([]string{
+			dev.Id,
+			status,
+			fmt.Sprintf("%d", dev.Blocks),
+			fmtRaysPerSec(dev.PrimaryRaysPerSec),
+			fmtRaysPerSec(dev.IndirectRaysPerSec),
+			fmtRaysPerSec(dev.OcclusionRaysPerSec),
+			fmtRaysPerSec(dev.RaysPerSec),
+			fmt.Sprintf("%s", dev.UpdateTime),
+			fmt.Sprintf("%s", dev.PrimaryRayTime),
+			fmt.Sprintf("%s", dev.IntegratorTime),
+			fmt.Sprintf("%s", dev.MergeTime),
+			fmt.Sprintf("%s", dev.RenderTime),
+		})
+	}
+	table.SetFooter([]string{"", "", "", "", "", "TOTAL", fmtRaysPerSec(report.Total.RaysPerSec), "", "", "", "AVG FRAME", fmt.Sprintf("%s", report.Total.AvgFrameTime)})
+
+	table.Render()
+
+	// Append a table with the kernel time breakdown for each device
+	header := []string{"Device"}
+	for kernel := tracer.Kernel(0); kernel < tracer.NumKernels; kernel++ {
+		header = append(header, kernel.String())
+	}
+	kernelTable := tablewriter.NewWriter(&buf)
+	kernelTable.SetAutoFormatHeaders(false)
+	kernelTable.SetAutoWrapText(false)
+	kernelTable.SetHeader(header)
+	for _, dev := range report.Devices {
+		row := []string{dev.Id}
+		for _, elapsed := range dev.kernelTimes {
+			row = append(row, fmt.Sprintf("%s", elapsed))
+		}
+		kernelTable.Append(row)
+	}
+	kernelTable.Render()
+
+	logger.Noticef(
+		"benchmark results for %s (%dx%d, %d spp, %d bounces, %d frames)\n%s",
+		report.Scene,
+		report.Options.FrameW, report.Options.FrameH,
+		report.Options.SamplesPerPixel, report.Options.NumBounces,
+		report.Frames,
+		buf.String(),
+	)
+}
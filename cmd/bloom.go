@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/urfave/cli"
+)
+
+// Populate the bloom params for the default post-process pipeline from the
+// CLI flags. Returns nil if bloom is not enabled.
+func parseBloomParams(ctx *cli.Context) (*tracer.BloomParams, error) {
+	if !ctx.Bool("bloom") {
+		return nil, nil
+	}
+
+	params, err := newBloomParams(ctx.Float64("bloom-threshold"), ctx.Float64("bloom-radius"), ctx.Float64("bloom-intensity"))
+	if err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+// Create and validate the bloom params.
+func newBloomParams(threshold, radius, intensity float64) (tracer.BloomParams, error) {
+	if threshold < 0 {
+		return tracer.BloomParams{}, fmt.Errorf("bloom threshold must not be negative; got %g", threshold)
+	} else if radius < 1 {
+		return tracer.BloomParams{}, fmt.Errorf("bloom radius must be at least 1 pixel; got %g", radius)
+	} else if intensity < 0 {
+		return tracer.BloomParams{}, fmt.Errorf("bloom intensity must not be negative; got %g", intensity)
+	}
+
+	return tracer.BloomParams{
+		Threshold: float32(threshold),
+		Radius:    float32(radius),
+		Intensity: float32(intensity),
+	}, nil
+}
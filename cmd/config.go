@@ -0,0 +1,475 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// The pipeline section of a render configuration file.
+type pipelineConfig struct {
+	// The primary ray generator stage.
+	Camera string `json:"camera"`
+
+	// The integrator stage.
+	Integrator string `json:"integrator"`
+
+	// The list of post-process stages. If specified, it replaces the
+	// default post-process stages.
+	PostProcess []stageConfig `json:"post-process"`
+}
+
+// A configurable pipeline stage.
+type stageConfig struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// A function that creates the opencl and cpu variants of a post-process stage.
+type postProcessStageFactory func(params map[string]interface{}) (opencl.PipelineStage, cpu.PipelineStage, error)
+
+// Post-process stages that can be referenced by render configuration files.
+var postProcessStages = map[string]postProcessStageFactory{
+	"tonemap-reinhard":   tonemapStageFactory(tracer.ReinhardTonemap, "white-point", "gamma"),
+	"tonemap-aces":       tonemapStageFactory(tracer.ACESTonemap, "gamma"),
+	"tonemap-uncharted2": tonemapStageFactory(tracer.Uncharted2Tonemap, "white-point", "gamma"),
+	"tonemap-linear":     tonemapStageFactory(tracer.LinearTonemap, "white-point", "gamma"),
+	"denoise":            denoiseStageFactory,
+	"bloom":              bloomStageFactory,
+}
+
+// Create a denoising stage. Unspecified parameters are set to their default values.
+func denoiseStageFactory(params map[string]interface{}) (opencl.PipelineStage, cpu.PipelineStage, error) {
+	const stageType = "denoise"
+	paramNames := []string{"iterations", "color", "normal", "depth", "albedo"}
+	if err := validateStageParams(stageType, params, paramNames...); err != nil {
+		return nil, nil, err
+	}
+
+	defaults := tracer.DefaultDenoiseParams()
+	defValues := []float64{
+		float64(defaults.Iterations),
+		float64(defaults.ColorSigma),
+		float64(defaults.NormalSigma),
+		float64(defaults.DepthSigma),
+		float64(defaults.AlbedoSigma),
+	}
+	values := make([]float64, len(paramNames))
+	for index, name := range paramNames {
+		var err error
+		if values[index], err = floatStageParam(stageType, params, name, defValues[index]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	denoise, err := newDenoiseParams(values[0], values[1], values[2], values[3], values[4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: invalid parameters for post-process stage %q: %v", stageType, err)
+	}
+	return opencl.Denoise(denoise), cpu.Denoise(denoise), nil
+}
+
+// Create a factory for a tone-mapping stage that uses the specified operator
+// and accepts the specified parameters.
+func tonemapStageFactory(op tracer.TonemapOperator, paramNames ...string) postProcessStageFactory {
+	stageType := "tonemap-" + op.String()
+	return func(params map[string]interface{}) (opencl.PipelineStage, cpu.PipelineStage, error) {
+		if err := validateStageParams(stageType, params, paramNames...); err != nil {
+			return nil, nil, err
+		}
+
+		whitePoint, err := floatStageParam(stageType, params, "white-point", 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		gamma, err := floatStageParam(stageType, params, "gamma", float64(tracer.DefaultGamma))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tonemap, err := newTonemapParams(op.String(), whitePoint, gamma)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: invalid parameters for post-process stage %q: %v", stageType, err)
+		}
+		return opencl.Tonemap(tonemap), cpu.Tonemap(tonemap), nil
+	}
+}
+
+// Create a bloom stage. Unspecified parameters are set to their default values.
+func bloomStageFactory(params map[string]interface{}) (opencl.PipelineStage, cpu.PipelineStage, error) {
+	const stageType = "bloom"
+	if err := validateStageParams(stageType, params, "threshold", "radius", "intensity"); err != nil {
+		return nil, nil, err
+	}
+
+	defaults := tracer.DefaultBloomParams()
+	threshold, err := floatStageParam(stageType, params, "threshold", float64(defaults.Threshold))
+	if err != nil {
+		return nil, nil, err
+	}
+	radius, err := floatStageParam(stageType, params, "radius", float64(defaults.Radius))
+	if err != nil {
+		return nil, nil, err
+	}
+	intensity, err := floatStageParam(stageType, params, "intensity", float64(defaults.Intensity))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bloom, err := newBloomParams(threshold, radius, intensity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: invalid parameters for post-process stage %q: %v", stageType, err)
+	}
+	return opencl.Bloom(bloom), cpu.Bloom(bloom), nil
+}
+
+// Load the render configuration file specified by the config flag. Each
+// configuration key (except pipeline) corresponds to a flag of the running
+// command. Values from the file are only applied to flags that were not
+// explicitly set on the command line. Returns the pipeline section of the file
+// or nil if no configuration file was specified.
+func loadRenderConfig(ctx *cli.Context) (*pipelineConfig, error) {
+	configFile := ctx.String("config")
+	if configFile == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON so both formats are parsed as YAML and
+	// then converted to JSON which is used for decoding the config values.
+	ext := strings.ToLower(filepath.Ext(configFile))
+	if ext == ".yaml" || ext == ".yml" {
+		var doc interface{}
+		if err = yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("config: could not parse %q: %v", configFile, err)
+		}
+
+		doc, err = yamlToJSON(doc)
+		if err == nil {
+			data, err = json.Marshal(doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("config: could not parse %q: %v", configFile, err)
+		}
+	}
+
+	var values map[string]json.RawMessage
+	if err = json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("config: could not parse %q: %v", configFile, err)
+	}
+
+	// Map flag names and aliases to the list of names for each flag
+	flagNames := make(map[string][]string)
+	flagsByName := make(map[string]cli.Flag)
+	for _, flag := range ctx.Command.Flags {
+		var names []string
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+
+		if names[0] == "config" || names[0] == "help" {
+			continue
+		}
+
+		for _, name := range names {
+			flagNames[name] = names
+			flagsByName[name] = flag
+		}
+	}
+
+	var pc *pipelineConfig
+	for key, value := range values {
+		if key == "pipeline" {
+			pc = &pipelineConfig{}
+			dec := json.NewDecoder(bytes.NewReader(value))
+			dec.DisallowUnknownFields()
+			if err = dec.Decode(pc); err != nil {
+				return nil, fmt.Errorf("config: invalid pipeline definition: %v", err)
+			}
+			continue
+		}
+
+		names, known := flagNames[key]
+		if !known {
+			return nil, fmt.Errorf("config: unknown key %q; supported keys: %s", key, strings.Join(supportedConfigKeys(flagNames), ", "))
+		}
+
+		// Command-line flags override values from the config file
+		if isAnySet(ctx, names) {
+			continue
+		}
+
+		if err = applyConfigValue(ctx, flagsByName[key], names, key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return pc, nil
+}
+
+// Set the flag value from a config value. Lists are only supported for flags
+// that can be specified multiple times.
+//
+// Aliases of scalar flags have their own values so the value is set for all
+// aliases. Slice flags share their value between aliases so the value is only
+// set once.
+func applyConfigValue(ctx *cli.Context, flag cli.Flag, names []string, key string, value json.RawMessage) error {
+	if _, isSlice := flag.(cli.StringSliceFlag); isSlice {
+		names = names[:1]
+	}
+
+	var list []interface{}
+	if json.Unmarshal(value, &list) != nil {
+		list = []interface{}{value}
+	}
+
+	for _, item := range list {
+		var strValue string
+		switch v := item.(type) {
+		case json.RawMessage:
+			if json.Unmarshal(v, &strValue) != nil {
+				strValue = string(v)
+			}
+		case string:
+			strValue = v
+		default:
+			strValue = fmt.Sprint(v)
+		}
+
+		for _, name := range names {
+			if err := ctx.Set(name, strValue); err != nil {
+				return fmt.Errorf("config: invalid value %s for key %q: %v", string(value), key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check if any of the flag names was set on the command line.
+func isAnySet(ctx *cli.Context, names []string) bool {
+	for _, name := range names {
+		if ctx.IsSet(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get a sorted list of supported configuration keys.
+func supportedConfigKeys(flagNames map[string][]string) []string {
+	keys := []string{"pipeline"}
+	for name, names := range flagNames {
+		if name == names[0] {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Convert the map[interface{}]interface{} values generated by the YAML parser
+// into map[string]interface{} values that can be encoded as JSON.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	var err error
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for key, item := range val {
+			strKey, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported non-string key %v", key)
+			}
+			if out[strKey], err = yamlToJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []interface{}:
+		for index, item := range val {
+			if val[index], err = yamlToJSON(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// The parameters for the default post-process stages.
+type postProcessParams struct {
+	tonemap tracer.TonemapParams
+
+	// Optional filters that are applied prior to tone-mapping.
+	denoise *tracer.DenoiseParams
+	bloom   *tracer.BloomParams
+}
+
+// Populate the params for the default post-process stages from the CLI flags.
+func parsePostProcessParams(ctx *cli.Context) (postProcessParams, error) {
+	var pp postProcessParams
+	var err error
+	if pp.tonemap, err = parseTonemapParams(ctx); err != nil {
+		return pp, err
+	}
+	if pp.denoise, err = parseDenoiseParams(ctx); err != nil {
+		return pp, err
+	}
+	pp.bloom, err = parseBloomParams(ctx)
+	return pp, err
+}
+
+// Create the opencl and cpu rendering pipelines. If a pipeline configuration
+// is specified, it is used to select the pipeline stages; otherwise the
+// default pipelines are returned. Unless the configuration selects a camera,
+// the tracers select the primary ray generator using the scene camera. The
+// default post-process stages denoise the frame, add bloom and apply
+// tone-mapping using the specified params.
+func newPipelines(pc *pipelineConfig, pp postProcessParams) (*opencl.Pipeline, *cpu.Pipeline, error) {
+	pipeline := opencl.DefaultPipeline(opencl.NoDebug)
+	pipeline.PostProcess = nil
+	cpuPipeline := cpu.DefaultPipeline()
+	cpuPipeline.PostProcess = nil
+	if pp.denoise != nil {
+		pipeline.PostProcess = append(pipeline.PostProcess, opencl.Denoise(*pp.denoise))
+		cpuPipeline.PostProcess = append(cpuPipeline.PostProcess, cpu.Denoise(*pp.denoise))
+	}
+	if pp.bloom != nil {
+		pipeline.PostProcess = append(pipeline.PostProcess, opencl.Bloom(*pp.bloom))
+		cpuPipeline.PostProcess = append(cpuPipeline.PostProcess, cpu.Bloom(*pp.bloom))
+	}
+	pipeline.PostProcess = append(pipeline.PostProcess, opencl.Tonemap(pp.tonemap))
+	cpuPipeline.PostProcess = append(cpuPipeline.PostProcess, cpu.Tonemap(pp.tonemap))
+
+	var cameraType string
+	if pc != nil {
+		cameraType = pc.Camera
+	}
+
+	// The default camera stages select the camera model using the
+	// camera data supplied to the tracers.
+	switch cameraType {
+	case "":
+	case "perspective":
+		pipeline.PrimaryRayGenerator = opencl.PerspectiveCamera()
+		cpuPipeline.PrimaryRayGenerator = cpu.PerspectiveCamera()
+	case "thin-lens":
+		pipeline.PrimaryRayGenerator = opencl.ThinLensCamera()
+		cpuPipeline.PrimaryRayGenerator = cpu.ThinLensCamera()
+	case "orthographic":
+		pipeline.PrimaryRayGenerator = opencl.OrthographicCamera()
+		cpuPipeline.PrimaryRayGenerator = cpu.OrthographicCamera()
+	case "equirectangular":
+		pipeline.PrimaryRayGenerator = opencl.EquirectangularCamera()
+		cpuPipeline.PrimaryRayGenerator = cpu.EquirectangularCamera()
+	case "fisheye":
+		pipeline.PrimaryRayGenerator = opencl.FisheyeCamera()
+		cpuPipeline.PrimaryRayGenerator = cpu.FisheyeCamera()
+	default:
+		return nil, nil, fmt.Errorf("config: unsupported camera %q; supported cameras: perspective, thin-lens, orthographic, equirectangular, fisheye", cameraType)
+	}
+
+	if pc == nil {
+		return pipeline, cpuPipeline, nil
+	}
+
+	switch pc.Integrator {
+	case "", "montecarlo":
+	default:
+		return nil, nil, fmt.Errorf("config: unsupported integrator %q; supported integrators: montecarlo", pc.Integrator)
+	}
+
+	if pc.PostProcess == nil {
+		return pipeline, cpuPipeline, nil
+	}
+
+	pipeline.PostProcess = nil
+	cpuPipeline.PostProcess = nil
+	for _, stage := range pc.PostProcess {
+		factory, exists := postProcessStages[stage.Type]
+		if !exists {
+			supported := make([]string, 0, len(postProcessStages))
+			for name := range postProcessStages {
+				supported = append(supported, name)
+			}
+			sort.Strings(supported)
+			return nil, nil, fmt.Errorf("config: unsupported post-process stage %q; supported stages: %s", stage.Type, strings.Join(supported, ", "))
+		}
+
+		clStage, cpuStage, err := factory(stage.Params)
+		if err != nil {
+			return nil, nil, err
+		}
+		pipeline.PostProcess = append(pipeline.PostProcess, clStage)
+		cpuPipeline.PostProcess = append(cpuPipeline.PostProcess, cpuStage)
+	}
+
+	return pipeline, cpuPipeline, nil
+}
+
+// Check whether the post-process stages selected by the pipeline configuration
+// (or the default post-process stages if the configuration does not specify
+// any) denoise the frame. Denoising requires AOV capture to be enabled.
+func usesDenoising(pc *pipelineConfig, denoise *tracer.DenoiseParams) bool {
+	if pc == nil || pc.PostProcess == nil {
+		return denoise != nil
+	}
+
+	for _, stage := range pc.PostProcess {
+		if stage.Type == "denoise" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the value of a numeric post-process stage parameter or defValue if the
+// parameter is not specified.
+func floatStageParam(stageType string, params map[string]interface{}, name string, defValue float64) (float64, error) {
+	value, exists := params[name]
+	if !exists {
+		return defValue, nil
+	}
+
+	v, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("config: expected parameter %q for post-process stage %q to be a number; got %v", name, stageType, value)
+	}
+	return v, nil
+}
+
+// Ensure that a post-process stage configuration only contains supported parameters.
+func validateStageParams(stageType string, params map[string]interface{}, supported ...string) error {
+	for name := range params {
+		known := false
+		for _, supportedName := range supported {
+			if name == supportedName {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			if len(supported) == 0 {
+				return fmt.Errorf("config: post-process stage %q does not accept any parameters; got %q", stageType, name)
+			}
+			return fmt.Errorf("config: unknown parameter %q for post-process stage %q; supported parameters: %s", name, stageType, strings.Join(supported, ", "))
+		}
+	}
+
+	return nil
+}
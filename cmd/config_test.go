@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+)
+
+func TestLoadRenderConfig(t *testing.T) {
+	specs := []struct {
+		file string
+		data string
+		args []string
+
+		expErr      string
+		expWidth    int
+		expSpp      int
+		expBounces  int
+		expExposure float64
+		expWorkers  []string
+		expPipeline *pipelineConfig
+	}{
+		// No config file
+		{
+			expWidth:    512,
+			expSpp:      4,
+			expBounces:  5,
+			expExposure: 1.2,
+		},
+		// YAML config
+		{
+			file:        "config.yaml",
+			data:        "width: 64\nspp: 16\nnb: 2\nexposure: 0.5\nworker:\n  - 10.0.0.1:1234\n  - 10.0.0.2:1234\n",
+			expWidth:    64,
+			expSpp:      16,
+			expBounces:  2,
+			expExposure: 0.5,
+			expWorkers:  []string{"10.0.0.1:1234", "10.0.0.2:1234"},
+		},
+		// JSON config
+		{
+			file:        "config.json",
+			data:        `{"width": 64, "spp": 16, "num-bounces": 2, "exposure": 0.5, "w": "10.0.0.1:1234"}`,
+			expWidth:    64,
+			expSpp:      16,
+			expBounces:  2,
+			expExposure: 0.5,
+			expWorkers:  []string{"10.0.0.1:1234"},
+		},
+		// Command-line flags and their aliases override config values
+		{
+			file:        "config.yaml",
+			data:        "width: 64\nspp: 16\nnum-bounces: 2\nworker: 10.0.0.1:1234\n",
+			args:        []string{"-spp", "8", "-nb", "3", "-w", "10.0.0.2:1234"},
+			expWidth:    64,
+			expSpp:      8,
+			expBounces:  3,
+			expExposure: 1.2,
+			expWorkers:  []string{"10.0.0.2:1234"},
+		},
+		// Pipeline section
+		{
+			file:        "config.yml",
+			data:        "spp: 16\npipeline:\n  camera: orthographic\n  post-process:\n    - type: bloom\n      params:\n        radius: 4\n    - type: tonemap-aces\n",
+			expWidth:    512,
+			expSpp:      16,
+			expBounces:  5,
+			expExposure: 1.2,
+			expPipeline: &pipelineConfig{
+				Camera: "orthographic",
+				PostProcess: []stageConfig{
+					{Type: "bloom", Params: map[string]interface{}{"radius": float64(4)}},
+					{Type: "tonemap-aces"},
+				},
+			},
+		},
+		// Errors
+		{
+			file:   "config.yaml",
+			data:   "width: 64\nsamples: 16\n",
+			expErr: `config: unknown key "samples"`,
+		},
+		{
+			file:   "config.json",
+			data:   `{"pipeline": {"camera": "orthographic", "denoiser": "bilateral"}}`,
+			expErr: "config: invalid pipeline definition",
+		},
+		{
+			file:   "config.json",
+			data:   `{"spp": "many"}`,
+			expErr: `config: invalid value "many" for key "spp"`,
+		},
+		{
+			file:   "config.json",
+			data:   `{"spp": 16`,
+			expErr: "config: could not parse",
+		},
+		{
+			file:   "config.yaml",
+			data:   "spp: [16\n",
+			expErr: "config: could not parse",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "polaris-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for specIndex, spec := range specs {
+		args := spec.args
+		if spec.file != "" {
+			configFile := filepath.Join(dir, spec.file)
+			err = ioutil.WriteFile(configFile, []byte(spec.data), os.ModePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args = append([]string{"-config", configFile}, args...)
+		}
+
+		var pc *pipelineConfig
+		var width, spp, bounces int
+		var exposure float64
+		var workers []string
+		err = runConfigCommand(args, func(ctx *cli.Context) error {
+			var err error
+			if pc, err = loadRenderConfig(ctx); err != nil {
+				return err
+			}
+			width, spp, bounces = ctx.Int("width"), ctx.Int("spp"), ctx.Int("nb")
+			exposure, workers = ctx.Float64("exposure"), ctx.StringSlice("w")
+			return nil
+		})
+
+		if spec.expErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), spec.expErr) {
+				t.Errorf("[spec %d] expected error starting with %q; got %v", specIndex, spec.expErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if width != spec.expWidth || spp != spec.expSpp || bounces != spec.expBounces || exposure != spec.expExposure {
+			t.Errorf("[spec %d] expected width, spp, bounces and exposure to be %d, %d, %d, %v; got %d, %d, %d, %v", specIndex, spec.expWidth, spec.expSpp, spec.expBounces, spec.expExposure, width, spp, bounces, exposure)
+		}
+		if len(workers) != 0 || len(spec.expWorkers) != 0 {
+			if !reflect.DeepEqual(workers, spec.expWorkers) {
+				t.Errorf("[spec %d] expected workers to be %v; got %v", specIndex, spec.expWorkers, workers)
+			}
+		}
+		if !reflect.DeepEqual(pc, spec.expPipeline) {
+			t.Errorf("[spec %d] expected pipeline config to be %+v; got %+v", specIndex, spec.expPipeline, pc)
+		}
+	}
+}
+
+func TestNewPipelines(t *testing.T) {
+	pp := postProcessParams{tonemap: tracer.TonemapParams{Operator: tracer.ReinhardTonemap}}
+	denoise := tracer.DefaultDenoiseParams()
+	bloom := tracer.DefaultBloomParams()
+	ppWithFilters := postProcessParams{tonemap: pp.tonemap, denoise: &denoise, bloom: &bloom}
+
+	specs := []struct {
+		pc *pipelineConfig
+		pp postProcessParams
+
+		expErr         string
+		expCamera      opencl.PipelineStage
+		expCpuCamera   cpu.PipelineStage
+		expPostProcess int
+	}{
+		// Default pipelines
+		{nil, pp, "", opencl.DefaultPipeline(opencl.NoDebug).PrimaryRayGenerator, cpu.DefaultPipeline().PrimaryRayGenerator, 1},
+		{nil, ppWithFilters, "", opencl.DefaultPipeline(opencl.NoDebug).PrimaryRayGenerator, cpu.DefaultPipeline().PrimaryRayGenerator, 3},
+		{&pipelineConfig{Integrator: "montecarlo"}, ppWithFilters, "", opencl.DefaultPipeline(opencl.NoDebug).PrimaryRayGenerator, cpu.DefaultPipeline().PrimaryRayGenerator, 3},
+		// Explicit cameras
+		{&pipelineConfig{Camera: "perspective"}, pp, "", opencl.PerspectiveCamera(), cpu.PerspectiveCamera(), 1},
+		{&pipelineConfig{Camera: "thin-lens"}, pp, "", opencl.ThinLensCamera(), cpu.ThinLensCamera(), 1},
+		{&pipelineConfig{Camera: "orthographic"}, pp, "", opencl.OrthographicCamera(), cpu.OrthographicCamera(), 1},
+		{&pipelineConfig{Camera: "equirectangular"}, pp, "", opencl.EquirectangularCamera(), cpu.EquirectangularCamera(), 1},
+		{&pipelineConfig{Camera: "fisheye"}, pp, "", opencl.FisheyeCamera(), cpu.FisheyeCamera(), 1},
+		// Post-process stages replace the default stages
+		{&pipelineConfig{PostProcess: []stageConfig{}}, ppWithFilters, "", nil, nil, 0},
+		{&pipelineConfig{PostProcess: []stageConfig{{Type: "denoise"}, {Type: "tonemap-linear", Params: map[string]interface{}{"gamma": 1.0}}}}, pp, "", nil, nil, 2},
+		// Errors
+		{&pipelineConfig{Camera: "cylindrical"}, pp, `config: unsupported camera "cylindrical"`, nil, nil, 0},
+		{&pipelineConfig{Integrator: "bdpt"}, pp, `config: unsupported integrator "bdpt"`, nil, nil, 0},
+		{&pipelineConfig{PostProcess: []stageConfig{{Type: "sharpen"}}}, pp, `config: unsupported post-process stage "sharpen"`, nil, nil, 0},
+		{&pipelineConfig{PostProcess: []stageConfig{{Type: "bloom", Params: map[string]interface{}{"size": 4.0}}}}, pp, `config: unknown parameter "size"`, nil, nil, 0},
+	}
+
+	for specIndex, spec := range specs {
+		pipeline, cpuPipeline, err := newPipelines(spec.pc, spec.pp)
+		if spec.expErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), spec.expErr) {
+				t.Errorf("[spec %d] expected error starting with %q; got %v", specIndex, spec.expErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if spec.expCamera != nil && !sameStage(pipeline.PrimaryRayGenerator, spec.expCamera) {
+			t.Errorf("[spec %d] opencl pipeline uses an unexpected primary ray generator", specIndex)
+		}
+		if spec.expCpuCamera != nil && !sameStage(cpuPipeline.PrimaryRayGenerator, spec.expCpuCamera) {
+			t.Errorf("[spec %d] cpu pipeline uses an unexpected primary ray generator", specIndex)
+		}
+		if len(pipeline.PostProcess) != spec.expPostProcess || len(cpuPipeline.PostProcess) != spec.expPostProcess {
+			t.Errorf("[spec %d] expected pipelines to have %d post-process stages; got %d (opencl), %d (cpu)", specIndex, spec.expPostProcess, len(pipeline.PostProcess), len(cpuPipeline.PostProcess))
+		}
+	}
+}
+
+// Run a command that accepts a subset of the render flags and invokes action.
+func runConfigCommand(args []string, action func(ctx *cli.Context) error) error {
+	app := cli.NewApp()
+	app.Writer = ioutil.Discard
+	app.ErrWriter = ioutil.Discard
+	app.Commands = []cli.Command{
+		{
+			Name: "render",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "width", Value: 512},
+				cli.IntFlag{Name: "spp", Value: 4},
+				cli.IntFlag{Name: "num-bounces, nb", Value: 5},
+				cli.StringSliceFlag{Name: "worker, w"},
+				cli.Float64Flag{Name: "exposure", Value: 1.2},
+				cli.StringFlag{Name: "config"},
+			},
+			Action: action,
+		},
+	}
+
+	return app.Run(append([]string{"polaris", "render"}, args...))
+}
+
+// Check whether two pipeline stages were created by the same stage constructor.
+func sameStage(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
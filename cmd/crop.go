@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// Parse a crop region specification in "x,y,w,h" format.
+func parseCrop(spec string) (tracer.Tile, error) {
+	tokens := strings.Split(spec, ",")
+	if len(tokens) != 4 {
+		return tracer.Tile{}, fmt.Errorf("invalid crop region %q; expected x,y,w,h", spec)
+	}
+
+	var values [4]uint32
+	for index, token := range tokens {
+		value, err := strconv.ParseUint(strings.TrimSpace(token), 10, 32)
+		if err != nil {
+			return tracer.Tile{}, fmt.Errorf("invalid crop region %q; expected x,y,w,h", spec)
+		}
+		values[index] = uint32(value)
+	}
+
+	return tracer.Tile{X: values[0], Y: values[1], W: values[2], H: values[3]}, nil
+}
+
+// Composite the cropped image stored in imgFile over a previously rendered
+// frame and overwrite imgFile with the full composited frame.
+func compositeCrop(imgFile, baseFile string, crop tracer.Tile, frameW, frameH uint32) error {
+	base, err := readPNG(baseFile)
+	if err != nil {
+		return err
+	}
+	if base.Bounds().Dx() != int(frameW) || base.Bounds().Dy() != int(frameH) {
+		return fmt.Errorf("crop base image %q must be %dx%d; got %dx%d", baseFile, frameW, frameH, base.Bounds().Dx(), base.Bounds().Dy())
+	}
+
+	cropped, err := readPNG(imgFile)
+	if err != nil {
+		return err
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+	draw.Draw(out, out.Bounds(), base, base.Bounds().Min, draw.Src)
+	cropRect := image.Rect(int(crop.X), int(crop.Y), int(crop.X+crop.W), int(crop.Y+crop.H))
+	draw.Draw(out, cropRect, cropped, cropped.Bounds().Min, draw.Src)
+
+	f, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, out)
+}
+
+// Read a PNG image from a file.
+func readPNG(imgFile string) (image.Image, error) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return png.Decode(f)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/urfave/cli"
+)
+
+// Populate the denoising params for the default post-process pipeline from
+// the CLI flags. Returns nil if denoising is not enabled.
+func parseDenoiseParams(ctx *cli.Context) (*tracer.DenoiseParams, error) {
+	if !ctx.Bool("denoise") {
+		return nil, nil
+	}
+
+	params, err := newDenoiseParams(
+		float64(ctx.Int("denoise-iterations")),
+		ctx.Float64("denoise-color"),
+		ctx.Float64("denoise-normal"),
+		ctx.Float64("denoise-depth"),
+		ctx.Float64("denoise-albedo"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+// Create and validate the denoising params. A zero sigma disables the
+// corresponding edge-stopping weight.
+func newDenoiseParams(iterations, colorSigma, normalSigma, depthSigma, albedoSigma float64) (tracer.DenoiseParams, error) {
+	if iterations < 1 || iterations != float64(uint32(iterations)) {
+		return tracer.DenoiseParams{}, fmt.Errorf("denoise iterations must be an integer greater than 0; got %g", iterations)
+	}
+
+	sigmas := []struct {
+		name  string
+		value float64
+	}{
+		{"color", colorSigma},
+		{"normal", normalSigma},
+		{"depth", depthSigma},
+		{"albedo", albedoSigma},
+	}
+	for _, sigma := range sigmas {
+		if sigma.value < 0 {
+			return tracer.DenoiseParams{}, fmt.Errorf("denoise %s sigma must not be negative; got %g", sigma.name, sigma.value)
+		}
+	}
+
+	return tracer.DenoiseParams{
+		Iterations:  uint32(iterations),
+		ColorSigma:  float32(colorSigma),
+		NormalSigma: float32(normalSigma),
+		DepthSigma:  float32(depthSigma),
+		AlbedoSigma: float32(albedoSigma),
+	}, nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/urfave/cli"
+)
+
+// Merge the accumulator files written by separate renders of the same frame
+// and save the result using the post-process stages of the cpu pipeline.
+func MergeAccumulators(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	pc, err := loadRenderConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ctx.NArg() == 0 {
+		return errors.New("missing accumulator file arguments")
+	}
+
+	var merged *renderer.Checkpoint
+	seeds := make(map[uint32]string)
+	for _, accFile := range ctx.Args() {
+		cp, err := renderer.ReadCheckpoint(accFile)
+		if err != nil {
+			return err
+		}
+
+		if otherFile, exists := seeds[cp.Seed]; exists {
+			logger.Warningf("%q and %q were rendered using the same seed; their samples are correlated", otherFile, accFile)
+		}
+		seeds[cp.Seed] = accFile
+
+		if merged == nil {
+			merged = cp
+		} else if err = merged.Merge(cp); err != nil {
+			return err
+		}
+		logger.Infof("merged %d spp from %q", cp.AccumulatedSamples, accFile)
+	}
+
+	if merged.AccumulatedSamples == 0 {
+		return errors.New("the accumulator files do not contain any samples")
+	}
+
+	// Load the merged accumulator into a cpu tracer and run its post-process
+	// stages to tonemap and save the frame.
+	out := ctx.String("out")
+	tonemap, err := parseTonemapParams(ctx)
+	if err != nil {
+		return err
+	}
+	if usesDenoising(pc, nil) {
+		return errors.New("accumulator files do not contain the AOVs required for denoising")
+	}
+	_, cpuPipeline, err := newPipelines(pc, postProcessParams{tonemap: tonemap})
+	if err != nil {
+		return err
+	}
+	_, saveStage := saveFrameStages(out)
+	cpuPipeline.PostProcess = append(cpuPipeline.PostProcess, saveStage)
+
+	tr, err := cpu.NewTracer("merge", 0, cpuPipeline)
+	if err != nil {
+		return err
+	}
+	err = tr.Init()
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	_, err = tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{merged.FrameW, merged.FrameH})
+	if err != nil {
+		return err
+	}
+	_, err = tr.UpdateState(tracer.Synchronous, tracer.SceneData, &scene.Scene{})
+	if err != nil {
+		return err
+	}
+
+	region := merged.Region()
+	blockReq := &tracer.BlockRequest{
+		FrameW:          merged.FrameW,
+		FrameH:          merged.FrameH,
+		BlockX:          region.X,
+		BlockY:          region.Y,
+		BlockW:          region.W,
+		BlockH:          region.H,
+		SamplesPerPixel: merged.AccumulatedSamples,
+		Exposure:        float32(ctx.Float64("exposure")),
+	}
+	err = tr.(tracer.FrameAccumulatorReadWriter).WriteFrameAccumulator(blockReq, merged.Accumulator)
+	if err != nil {
+		return err
+	}
+	_, err = tr.SyncFramebuffer(blockReq)
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("merged %d accumulator files with a total of %d spp into %q", ctx.NArg(), merged.AccumulatedSamples, out)
+	return nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/achilleasa/polaris/renderer"
+)
+
+// The pass size for progressive renders without a sample or snapshot limit.
+const defaultPassSamples uint32 = 16
+
+// The settings for rendering a frame progressively.
+type progressiveRender struct {
+	// The target number of samples per pixel. If zero, the frame is
+	// rendered until the time limit expires.
+	samplesPerPixel uint32
+
+	// The number of samples per pixel rendered by each pass.
+	passSamples uint32
+
+	// If set, a message is logged each time the output file is updated.
+	logSnapshots bool
+	out          string
+
+	// If non-zero, rendering stops once the time limit expires.
+	timeLimit time.Duration
+
+	// If set, the frame accumulator contents are written to this file
+	// after any pass that completes at least checkpointInterval after the
+	// last checkpoint and once rendering stops. Passes that are interrupted
+	// by a signal or the time limit are not included in the checkpoint.
+	checkpointFile     string
+	checkpointInterval time.Duration
+	sceneHash          string
+	opts               renderer.Options
+
+	// The number of samples per pixel restored from a checkpoint.
+	resumedSamples uint32
+}
+
+// Render the frame by accumulating passes of passSamples samples per pixel.
+// The post-process stages run after each pass so the output image is updated
+// while the frame converges. Rendering stops once the frame has accumulated
+// samplesPerPixel samples or the time limit expires.
+func (pr *progressiveRender) run(ctx context.Context, r renderer.Renderer) error {
+	start := time.Now()
+	if pr.timeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pr.timeLimit)
+		defer cancel()
+	}
+
+	samples := pr.resumedSamples
+
+	// The accumulator contents after the last fully rendered pass. Checkpoints
+	// are only created from fully rendered passes so that resumed renders
+	// match uninterrupted renders.
+	var cp *renderer.Checkpoint
+	var cpWritten bool
+	lastCheckpoint := time.Now()
+	for (pr.samplesPerPixel == 0 || samples < pr.samplesPerPixel) && ctx.Err() == nil {
+		pass := pr.passSamples
+		if pr.samplesPerPixel != 0 && pass > pr.samplesPerPixel-samples {
+			pass = pr.samplesPerPixel - samples
+		}
+
+		err := r.RenderPass(ctx, samples, pass)
+		if err != nil && err != ctx.Err() {
+			return err
+		}
+
+		// If the primary tracer changed, the pass was rendered from scratch
+		if r.Stats().PrimaryChanged {
+			samples = 0
+		}
+		samples += r.Stats().RenderedSamples
+
+		if r.Stats().Cancelled {
+			break
+		}
+
+		if pr.checkpointFile != "" {
+			accumulator, err := r.ReadFrameAccumulator()
+			if err != nil {
+				return err
+			}
+			cp, cpWritten = renderer.NewCheckpoint(pr.sceneHash, pr.opts, samples, accumulator), false
+
+			if time.Since(lastCheckpoint) >= pr.checkpointInterval {
+				if err = pr.writeCheckpoint(cp); err != nil {
+					return err
+				}
+				cpWritten, lastCheckpoint = true, time.Now()
+			}
+		}
+
+		if pr.logSnapshots && (pr.samplesPerPixel == 0 || samples < pr.samplesPerPixel) {
+			logger.Noticef("saved snapshot with %d spp to %q (elapsed time: %s)", samples, pr.out, time.Since(start))
+		}
+	}
+
+	if cp != nil && !cpWritten {
+		if err := pr.writeCheckpoint(cp); err != nil {
+			return err
+		}
+	}
+
+	switch ctx.Err() {
+	case context.Canceled:
+		return context.Canceled
+	case context.DeadlineExceeded:
+		logger.Noticef("time limit reached; rendered %d spp in %s", samples, time.Since(start))
+	default:
+		logger.Noticef("rendered %d spp in %s", samples, time.Since(start))
+	}
+	return nil
+}
+
+// Write a checkpoint to the checkpoint file.
+func (pr *progressiveRender) writeCheckpoint(cp *renderer.Checkpoint) error {
+	err := cp.Write(pr.checkpointFile)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("wrote checkpoint with %d spp to %q", cp.AccumulatedSamples, pr.checkpointFile)
+	return nil
+}
@@ -2,13 +2,19 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
+	"strings"
 
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/hdr"
 	"github.com/achilleasa/polaris/renderer"
 	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
 	"github.com/achilleasa/polaris/tracer/opencl"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli"
@@ -23,10 +29,8 @@ const (
 	cameraMoveSpeed float32 = 0.05
 )
 
-// Render a still frame.
-func RenderFrame(ctx *cli.Context) error {
-	setupLogging(ctx)
-
+// Populate renderer options from the CLI flags shared by all render commands.
+func parseRenderOptions(ctx *cli.Context) renderer.Options {
 	opts := renderer.Options{
 		FrameW:          uint32(ctx.Int("width")),
 		FrameH:          uint32(ctx.Int("height")),
@@ -34,9 +38,13 @@ func RenderFrame(ctx *cli.Context) error {
 		Exposure:        float32(ctx.Float64("exposure")),
 		NumBounces:      uint32(ctx.Int("num-bounces")),
 		MinBouncesForRR: uint32(ctx.Int("rr-bounces")),
+		Seed:            uint32(ctx.Int("seed")),
 		//
 		BlackListedDevices: ctx.StringSlice("blacklist"),
 		ForcePrimaryDevice: ctx.String("force-primary"),
+		UseCpuTracer:       ctx.Bool("cpu"),
+		RemoteWorkers:      ctx.StringSlice("worker"),
+		RemoteTimeout:      ctx.Duration("worker-timeout"),
 	}
 
 	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
@@ -44,6 +52,64 @@ func RenderFrame(ctx *cli.Context) error {
 		opts.MinBouncesForRR = opts.NumBounces + 1
 	}
 
+	return opts
+}
+
+// Render a still frame.
+func RenderFrame(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	pc, err := loadRenderConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := parseRenderOptions(ctx)
+
+	statsOut := ctx.String("stats-out")
+	if statsOut != "" {
+		if err := validateStatsOut(statsOut); err != nil {
+			return err
+		}
+	}
+
+	timeLimit := ctx.Duration("time-limit")
+	if timeLimit < 0 {
+		return errors.New("time-limit must not be negative")
+	}
+	saveEvery := ctx.Int("save-every")
+	if saveEvery < 0 {
+		return errors.New("save-every must not be negative")
+	}
+
+	pp, err := parsePostProcessParams(ctx)
+	if err != nil {
+		return err
+	}
+	opts.CaptureAOVs = usesDenoising(pc, pp.denoise)
+
+	checkpointFile := ctx.String("checkpoint")
+	resumeFile := ctx.String("resume")
+	if (checkpointFile != "" || resumeFile != "") && (len(ctx.StringSlice("aov")) != 0 || opts.CaptureAOVs) {
+		return errors.New("checkpoints do not support AOV outputs or denoising")
+	}
+
+	// Setup crop region
+	if cropSpec := ctx.String("crop"); cropSpec != "" {
+		opts.Crop, err = parseCrop(cropSpec)
+		if err != nil {
+			return err
+		}
+	}
+	cropBase := ctx.String("crop-base")
+	if cropBase != "" {
+		if opts.Crop == (tracer.Tile{}) {
+			return errors.New("the crop-base option requires a crop region")
+		} else if hdr.IsHDR(ctx.String("out")) {
+			return errors.New("the crop-base option is only supported for PNG output")
+		}
+	}
+
 	// Load scene
 	if ctx.NArg() != 1 {
 		return errors.New("missing scene file argument")
@@ -57,44 +123,308 @@ func RenderFrame(ctx *cli.Context) error {
 	// Update projection matrix
 	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
 
+	// Load the checkpoint to resume from
+	var sceneHash string
+	var checkpoint *renderer.Checkpoint
+	if checkpointFile != "" || resumeFile != "" {
+		sceneHash, err = renderer.SceneHash(sc)
+		if err != nil {
+			return err
+		}
+	}
+	if resumeFile != "" {
+		checkpoint, err = renderer.ReadCheckpoint(resumeFile)
+		if err != nil {
+			return err
+		}
+		err = checkpoint.Validate(sceneHash, opts)
+		if err != nil {
+			return err
+		}
+		if opts.SamplesPerPixel != 0 && checkpoint.AccumulatedSamples >= opts.SamplesPerPixel {
+			return fmt.Errorf("checkpoint %q already contains %d spp", resumeFile, checkpoint.AccumulatedSamples)
+		}
+	}
+
 	// Setup tracing pipeline
-	pipeline := opencl.DefaultPipeline(opencl.NoDebug)
-	pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveFrameBuffer(ctx.String("out")))
+	pipeline, cpuPipeline, err := newPipelines(pc, pp)
+	if err != nil {
+		return err
+	}
+	saveStage, cpuSaveStage := saveFrameStages(ctx.String("out"))
+	pipeline.PostProcess = append(pipeline.PostProcess, saveStage)
+	opts.CpuPipeline = cpuPipeline
+	opts.CpuPipeline.PostProcess = append(opts.CpuPipeline.PostProcess, cpuSaveStage)
+
+	// Setup AOV outputs
+	for _, aovSpec := range ctx.StringSlice("aov") {
+		aov, imgFile, err := parseAOVSpec(aovSpec)
+		if err != nil {
+			return err
+		}
+
+		opts.CaptureAOVs = true
+		pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveAOV(aov, imgFile))
+		opts.CpuPipeline.PostProcess = append(opts.CpuPipeline.PostProcess, cpu.SaveAOV(aov, imgFile))
+	}
 
 	// Create renderer
-	r, err := renderer.NewDefault(sc, tracer.NaiveScheduler(), pipeline, opts)
+	scheduler, err := parseScheduler(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := renderer.NewDefault(sc, scheduler, pipeline, opts)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
-	err = r.Render()
-	if err != nil {
+	// Stop rendering on SIGINT; the post-process stages still run so the
+	// partially rendered frame is saved.
+	renderCtx, cancel := interruptContext()
+	defer cancel()
+
+	if timeLimit > 0 || saveEvery > 0 || checkpointFile != "" || checkpoint != nil {
+		pr := &progressiveRender{
+			samplesPerPixel:    opts.SamplesPerPixel,
+			passSamples:        uint32(saveEvery),
+			logSnapshots:       saveEvery > 0,
+			out:                ctx.String("out"),
+			timeLimit:          timeLimit,
+			checkpointFile:     checkpointFile,
+			checkpointInterval: ctx.Duration("checkpoint-every"),
+			sceneHash:          sceneHash,
+			opts:               opts,
+		}
+
+		// Use smaller passes for checkpointed renders so checkpoints can
+		// be written while the frame converges.
+		if pr.passSamples == 0 && (opts.SamplesPerPixel == 0 || checkpointFile != "" || checkpoint != nil) {
+			pr.passSamples = defaultPassSamples
+		} else if pr.passSamples == 0 {
+			pr.passSamples = opts.SamplesPerPixel
+		}
+
+		if checkpoint != nil {
+			err = r.WriteFrameAccumulator(checkpoint.Accumulator)
+			if err != nil {
+				return err
+			}
+			pr.resumedSamples = checkpoint.AccumulatedSamples
+			logger.Noticef("resuming render from checkpoint %q with %d spp", resumeFile, checkpoint.AccumulatedSamples)
+		}
+
+		err = pr.run(renderCtx, r)
+	} else {
+		err = r.RenderContext(renderCtx)
+	}
+	if err != nil && err != context.Canceled {
 		return err
 	}
+	if err == context.Canceled {
+		logger.Warningf("rendering was interrupted; saved partially rendered frame to %q", ctx.String("out"))
+	}
+
+	if cropBase != "" {
+		err = compositeCrop(ctx.String("out"), cropBase, opts.Crop, opts.FrameW, opts.FrameH)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Display stats
 	displayFrameStats(r.Stats())
 
+	if statsOut != "" {
+		err = writeFrameStats(statsOut, ctx.Args().First(), opts, r.Stats())
+	}
+
 	return err
 }
 
+// Render an image sequence by moving the camera along a keyframed path.
+func RenderAnimation(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	pc, err := loadRenderConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := parseRenderOptions(ctx)
+
+	fps := ctx.Float64("fps")
+	if fps <= 0 {
+		return errors.New("fps must be greater than 0")
+	}
+
+	// Load camera path
+	keyframeFile := ctx.String("keyframes")
+	if keyframeFile == "" {
+		return errors.New("missing keyframe file argument")
+	}
+
+	f, err := os.Open(keyframeFile)
+	if err != nil {
+		return err
+	}
+	path, err := scene.ReadCameraPath(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// Load scene
+	if ctx.NArg() != 1 {
+		return errors.New("missing scene file argument")
+	}
+
+	sc, err := reader.ReadScene(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	// Position camera at the first keyframe
+	aspect := float32(opts.FrameW) / float32(opts.FrameH)
+	path.Apply(sc.Camera, path.StartTime())
+	sc.Camera.SetupProjection(aspect)
+
+	// Setup tracing pipeline
+	outPattern := ctx.String("out")
+	pp, err := parsePostProcessParams(ctx)
+	if err != nil {
+		return err
+	}
+	pipeline, cpuPipeline, err := newPipelines(pc, pp)
+	if err != nil {
+		return err
+	}
+	opts.CaptureAOVs = usesDenoising(pc, pp.denoise)
+	saveStage, cpuSaveStage := saveFrameSequenceStages(outPattern)
+	pipeline.PostProcess = append(pipeline.PostProcess, saveStage)
+	opts.CpuPipeline = cpuPipeline
+	opts.CpuPipeline.PostProcess = append(opts.CpuPipeline.PostProcess, cpuSaveStage)
+
+	// Create renderer
+	scheduler, err := parseScheduler(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := renderer.NewDefault(sc, scheduler, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	numFrames := int(float64(path.EndTime()-path.StartTime())*fps) + 1
+	logger.Noticef("rendering %d frames at %.2f fps", numFrames, fps)
+
+	// Stop rendering on SIGINT; the frame being rendered is still saved.
+	renderCtx, cancel := interruptContext()
+	defer cancel()
+
+	for frame := 0; frame < numFrames; frame++ {
+		if frame > 0 {
+			path.Apply(sc.Camera, path.StartTime()+float32(float64(frame)/fps))
+			sc.Camera.SetupProjection(aspect)
+			r.UpdateCamera(sc.Camera)
+		}
+
+		err = r.RenderContext(renderCtx)
+		if err == context.Canceled {
+			logger.Warningf("rendering was interrupted; saved partially rendered frame %d/%d (%s)", frame+1, numFrames, fmt.Sprintf(outPattern, frame))
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		logger.Noticef("rendered frame %d/%d (%s) in %s", frame+1, numFrames, fmt.Sprintf(outPattern, frame), r.Stats().RenderTime)
+	}
+
+	return nil
+}
+
+// Parse an AOV output specification in "type=filename" format.
+func parseAOVSpec(spec string) (tracer.AOV, string, error) {
+	tokens := strings.SplitN(spec, "=", 2)
+	if len(tokens) != 2 || tokens[1] == "" {
+		return 0, "", fmt.Errorf("invalid AOV output %q; expected type=filename", spec)
+	}
+
+	aov, err := tracer.ParseAOV(tokens[0])
+	if err != nil {
+		supported := make([]string, len(tracer.AOVTypes))
+		for index, aovType := range tracer.AOVTypes {
+			supported[index] = aovType.String()
+		}
+		return 0, "", fmt.Errorf("invalid AOV type %q; supported types: %s", tokens[0], strings.Join(supported, ", "))
+	}
+
+	return aov, tokens[1], nil
+}
+
+// Create the opencl and cpu pipeline stages for saving the rendered frame. If
+// the output file extension matches a HDR format (pfm, hdr or exr) the stages
+// save the linear frame accumulator contents; otherwise the tone-mapped frame
+// buffer is saved as a PNG image.
+func saveFrameStages(out string) (opencl.PipelineStage, cpu.PipelineStage) {
+	if hdr.IsHDR(out) {
+		return opencl.SaveHDRFrameBuffer(out), cpu.SaveHDRFrameBuffer(out)
+	}
+	return opencl.SaveFrameBuffer(out), cpu.SaveFrameBuffer(out)
+}
+
+// Create the opencl and cpu pipeline stages for saving a sequence of rendered
+// frames. The image format is selected in the same way as saveFrameStages.
+func saveFrameSequenceStages(outPattern string) (opencl.PipelineStage, cpu.PipelineStage) {
+	if hdr.IsHDR(outPattern) {
+		return opencl.SaveHDRFrameBufferSequence(outPattern), cpu.SaveHDRFrameBufferSequence(outPattern)
+	}
+	return opencl.SaveFrameBufferSequence(outPattern), cpu.SaveFrameBufferSequence(outPattern)
+}
+
+// Create the block scheduler selected by the scheduler option.
+func parseScheduler(ctx *cli.Context) (tracer.BlockScheduler, error) {
+	schedulerType := ctx.String("scheduler")
+	var scheduler tracer.BlockScheduler
+	switch schedulerType {
+	case "naive":
+		scheduler = tracer.NaiveScheduler()
+	case "perfect":
+		scheduler = tracer.PerfectScheduler()
+	case "work-stealing":
+		tileSize := uint32(ctx.Int("tile-size"))
+		scheduler = tracer.WorkStealingScheduler(tileSize, tileSize)
+	default:
+		return nil, fmt.Errorf("invalid scheduler algorithm %q; supported algorithms: naive, perfect, work-stealing", schedulerType)
+	}
+	logger.Noticef("using %q block scheduler", schedulerType)
+
+	return scheduler, nil
+}
+
 func displayFrameStats(stats renderer.FrameStats) {
 	var buf bytes.Buffer
 	table := tablewriter.NewWriter(&buf)
 	table.SetAutoFormatHeaders(false)
 	table.SetAutoWrapText(false)
-	table.SetHeader([]string{"Device", "Primary", "Block height", "% of frame", "Render time"})
+	table.SetHeader([]string{"Device", "Primary", "Status", "Blocks", "Last block", "% of frame", "Render time"})
 	for _, stat := range stats.Tracers {
+		status := "ok"
+		if stat.Dead {
+			status = fmt.Sprintf("failed: %v", stat.Err)
+		}
 		table.Append([]string{
 			stat.Id,
 			fmt.Sprintf("%t", stat.IsPrimary),
-			fmt.Sprintf("%d", stat.BlockH),
+			status,
+			fmt.Sprintf("%d", stat.NumBlocks),
+			fmt.Sprintf("%dx%d @ (%d, %d)", stat.BlockW, stat.BlockH, stat.BlockX, stat.BlockY),
 			fmt.Sprintf("%02.1f %%", stat.FramePercent),
 			fmt.Sprintf("%s", stat.RenderTime),
 		})
 	}
-	table.SetFooter([]string{"", "", "", "TOTAL", fmt.Sprintf("%s", stats.RenderTime)})
+	table.SetFooter([]string{"", "", "", "", "", "TOTAL", fmt.Sprintf("%s", stats.RenderTime)})
 
 	table.Render()
 	logger.Noticef("frame statistics\n%s", buf.String())
@@ -105,35 +435,25 @@ func RenderInteractive(ctx *cli.Context) error {
 	runtime.LockOSThread()
 	setupLogging(ctx)
 
-	opts := renderer.Options{
-		FrameW:          uint32(ctx.Int("width")),
-		FrameH:          uint32(ctx.Int("height")),
-		SamplesPerPixel: uint32(ctx.Int("spp")),
-		Exposure:        float32(ctx.Float64("exposure")),
-		NumBounces:      uint32(ctx.Int("num-bounces")),
-		MinBouncesForRR: uint32(ctx.Int("rr-bounces")),
-		//
-		BlackListedDevices: ctx.StringSlice("blacklist"),
-		ForcePrimaryDevice: ctx.String("force-primary"),
+	pc, err := loadRenderConfig(ctx)
+	if err != nil {
+		return err
 	}
 
-	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
-		logger.Notice("disabling RR for path elimination")
-		opts.MinBouncesForRR = opts.NumBounces + 1
+	opts := parseRenderOptions(ctx)
+
+	statsOut := ctx.String("stats-out")
+	if statsOut != "" {
+		if err := validateStatsOut(statsOut); err != nil {
+			return err
+		}
 	}
 
 	// Setup block scheduler
-	schedulerType := ctx.String("scheduler")
-	var scheduler tracer.BlockScheduler
-	switch schedulerType {
-	case "naive":
-		scheduler = tracer.NaiveScheduler()
-	case "perfect":
-		scheduler = tracer.PerfectScheduler()
-	default:
-		return fmt.Errorf("invalid scheduler algorithm %q; supported algorithms: naive, perfect", schedulerType)
+	scheduler, err := parseScheduler(ctx)
+	if err != nil {
+		return err
 	}
-	logger.Noticef("using %q block scheduler", schedulerType)
 
 	// Load scene
 	if ctx.NArg() != 1 {
@@ -151,7 +471,16 @@ func RenderInteractive(ctx *cli.Context) error {
 	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
 
 	// Setup tracing pipeline
-	pipeline := opencl.DefaultPipeline(opencl.NoDebug)
+	pp, err := parsePostProcessParams(ctx)
+	if err != nil {
+		return err
+	}
+	pipeline, cpuPipeline, err := newPipelines(pc, pp)
+	if err != nil {
+		return err
+	}
+	opts.CaptureAOVs = usesDenoising(pc, pp.denoise)
+	opts.CpuPipeline = cpuPipeline
 
 	// Create renderer
 	r, err := renderer.NewInteractive(sc, scheduler, pipeline, opts)
@@ -159,6 +488,18 @@ func RenderInteractive(ctx *cli.Context) error {
 		return err
 	}
 
-	// enter main loop
-	return r.Render()
+	// enter main loop; SIGINT closes the renderer as if the window was closed
+	renderCtx, cancel := interruptContext()
+	defer cancel()
+
+	err = r.RenderContext(renderCtx)
+	if err == context.Canceled {
+		err = nil
+	}
+	if err != nil || statsOut == "" {
+		return err
+	}
+
+	// Export the stats for the last rendered frame
+	return writeFrameStats(statsOut, ctx.Args().First(), opts, r.Stats())
 }
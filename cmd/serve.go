@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/server"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+)
+
+// Serve an HTTP API for submitting render jobs.
+func RunServer(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	// Validate the scheduler option before accepting any jobs
+	_, err := parseScheduler(ctx)
+	if err != nil {
+		return err
+	}
+
+	workDir := ctx.String("work-dir")
+	if workDir == "" {
+		workDir, err = ioutil.TempDir("", "polaris")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+	}
+
+	config := server.Config{
+		WorkDir:            workDir,
+		PassSamples:        uint32(ctx.Int("pass-spp")),
+		MaxQueuedJobs:      ctx.Int("max-queued-jobs"),
+		MaxSceneSize:       int64(ctx.Int("max-scene-mb")) << 20,
+		MaxFrameW:          uint32(ctx.Int("max-frame-w")),
+		MaxFrameH:          uint32(ctx.Int("max-frame-h")),
+		MaxSamplesPerPixel: uint32(ctx.Int("max-spp")),
+	}
+
+	srv, err := server.NewServer(ctx.String("listen"), config, func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error) {
+		// Jobs share the devices selected by the server options
+		opts.BlackListedDevices = ctx.StringSlice("blacklist")
+		opts.ForcePrimaryDevice = ctx.String("force-primary")
+		opts.UseCpuTracer = ctx.Bool("cpu")
+		opts.RemoteWorkers = ctx.StringSlice("worker")
+		opts.RemoteTimeout = ctx.Duration("worker-timeout")
+
+		if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
+			opts.MinBouncesForRR = opts.NumBounces + 1
+		}
+
+		saveStage, cpuSaveStage := saveFrameStages(imgFile)
+		pipeline := opencl.DefaultPipeline(opencl.NoDebug)
+		pipeline.PostProcess = append(pipeline.PostProcess, saveStage)
+		opts.CpuPipeline = cpu.DefaultPipeline()
+		opts.CpuPipeline.PostProcess = append(opts.CpuPipeline.PostProcess, cpuSaveStage)
+
+		// Schedulers keep state between frames so each job gets its own instance
+		scheduler, err := parseScheduler(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return renderer.NewDefault(sc, scheduler, pipeline, opts)
+	})
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	return srv.Serve()
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// Create a context that is cancelled when the process receives a SIGINT.
+// Once the context is cancelled, the signal handler is removed so that
+// pressing Ctrl-C again terminates the process immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	go func() {
+		defer signal.Stop(sigChan)
+
+		select {
+		case <-sigChan:
+			logger.Warning("interrupted; stopping after the current samples (press Ctrl-C again to exit immediately)")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
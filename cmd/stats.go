@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// A machine-readable frame statistics report.
+type frameStatsReport struct {
+	Scene   string
+	Options renderer.Options
+	Devices []deviceReport
+	Tracers []tracerStatsReport
+	Total   totalStatsReport
+}
+
+// A device used by the renderer.
+type deviceReport struct {
+	Id    string
+	Type  string
+	Speed uint32
+}
+
+// Frame statistics for a single tracer.
+type tracerStatsReport struct {
+	Id           string
+	Primary      bool
+	Failed       bool
+	Error        string `json:",omitempty"`
+	Blocks       uint32
+	BlockX       uint32
+	BlockY       uint32
+	BlockW       uint32
+	BlockH       uint32
+	FramePercent float32
+	RenderTime   time.Duration
+	UpdateTime   time.Duration
+	MergeTime    time.Duration
+}
+
+// Totals for all tracers. RenderTime is the total render time for the frame.
+type totalStatsReport struct {
+	Blocks       uint32
+	FramePercent float32
+	RenderTime   time.Duration
+	UpdateTime   time.Duration
+	MergeTime    time.Duration
+}
+
+// Ensure that the stats output file uses a supported format.
+func validateStatsOut(statsFile string) error {
+	switch strings.ToLower(filepath.Ext(statsFile)) {
+	case ".json", ".csv":
+		return nil
+	}
+	return fmt.Errorf("unsupported stats output file %q; use a .json or .csv extension", statsFile)
+}
+
+// Write frame statistics to a JSON or CSV file. The file format is selected
+// based on the file extension.
+func writeFrameStats(statsFile, sceneFile string, opts renderer.Options, stats renderer.FrameStats) error {
+	report := frameStatsReport{
+		Scene:   sceneFile,
+		Options: opts,
+		Total:   totalStatsReport{RenderTime: stats.RenderTime},
+	}
+
+	for _, stat := range stats.Tracers {
+		report.Devices = append(report.Devices, deviceReport{
+			Id:    stat.Id,
+			Type:  deviceType(stat.Flags),
+			Speed: stat.Speed,
+		})
+
+		tracerReport := tracerStatsReport{
+			Id:           stat.Id,
+			Primary:      stat.IsPrimary,
+			Failed:       stat.Dead,
+			Blocks:       stat.NumBlocks,
+			BlockX:       stat.BlockX,
+			BlockY:       stat.BlockY,
+			BlockW:       stat.BlockW,
+			BlockH:       stat.BlockH,
+			FramePercent: stat.FramePercent,
+			RenderTime:   stat.RenderTime,
+			UpdateTime:   stat.UpdateTime,
+			MergeTime:    stat.MergeTime,
+		}
+		if stat.Err != nil {
+			tracerReport.Error = stat.Err.Error()
+		}
+		report.Tracers = append(report.Tracers, tracerReport)
+
+		report.Total.Blocks += stat.NumBlocks
+		report.Total.FramePercent += stat.FramePercent
+		report.Total.UpdateTime += stat.UpdateTime
+		report.Total.MergeTime += stat.MergeTime
+	}
+
+	f, err := os.Create(statsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(statsFile)) == ".csv" {
+		return writeFrameStatsCSV(f, report)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// Write a CSV file with a row for each tracer followed by a row with the totals.
+// Each row also contains the scene name, the render options and the device details.
+func writeFrameStatsCSV(f *os.File, report frameStatsReport) error {
+	w := csv.NewWriter(f)
+	w.Write([]string{
+		"scene", "frame_w", "frame_h", "spp", "num_bounces", "rr_bounces", "exposure",
+		"device", "device_type", "device_speed", "primary", "failed", "error",
+		"blocks", "block_x", "block_y", "block_w", "block_h", "frame_percent",
+		"render_time_ns", "update_time_ns", "merge_time_ns",
+	})
+
+	// Prepend the scene and options columns to each row
+	opts := report.Options
+	optionColumns := []string{
+		report.Scene,
+		fmt.Sprint(opts.FrameW),
+		fmt.Sprint(opts.FrameH),
+		fmt.Sprint(opts.SamplesPerPixel),
+		fmt.Sprint(opts.NumBounces),
+		fmt.Sprint(opts.MinBouncesForRR),
+		fmt.Sprint(opts.Exposure),
+	}
+	row := func(columns ...string) []string {
+		return append(append([]string(nil), optionColumns...), columns...)
+	}
+
+	for index, stat := range report.Tracers {
+		device := report.Devices[index]
+		w.Write(row(
+			device.Id,
+			device.Type,
+			fmt.Sprint(device.Speed),
+			fmt.Sprint(stat.Primary),
+			fmt.Sprint(stat.Failed),
+			stat.Error,
+			fmt.Sprint(stat.Blocks),
+			fmt.Sprint(stat.BlockX),
+			fmt.Sprint(stat.BlockY),
+			fmt.Sprint(stat.BlockW),
+			fmt.Sprint(stat.BlockH),
+			fmt.Sprint(stat.FramePercent),
+			fmt.Sprint(stat.RenderTime.Nanoseconds()),
+			fmt.Sprint(stat.UpdateTime.Nanoseconds()),
+			fmt.Sprint(stat.MergeTime.Nanoseconds()),
+		))
+	}
+
+	total := report.Total
+	w.Write(row(
+		"TOTAL", "", "", "", "", "",
+		fmt.Sprint(total.Blocks),
+		"", "", "", "",
+		fmt.Sprint(total.FramePercent),
+		fmt.Sprint(total.RenderTime.Nanoseconds()),
+		fmt.Sprint(total.UpdateTime.Nanoseconds()),
+		fmt.Sprint(total.MergeTime.Nanoseconds()),
+	))
+
+	w.Flush()
+	return w.Error()
+}
+
+// Get a description of the device type based on the tracer flags.
+func deviceType(flags tracer.Flag) string {
+	switch {
+	case flags&tracer.Remote != 0:
+		return "remote"
+	case flags&tracer.CpuDevice != 0:
+		return "cpu"
+	default:
+		return "gpu"
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/urfave/cli"
+)
+
+// Populate the tone-mapping params for the default post-process pipeline
+// from the CLI flags.
+func parseTonemapParams(ctx *cli.Context) (tracer.TonemapParams, error) {
+	return newTonemapParams(ctx.String("tonemap"), ctx.Float64("white-point"), ctx.Float64("gamma"))
+}
+
+// Create and validate the tone-mapping params for the named operator. A zero
+// white point selects the operator's default white point.
+func newTonemapParams(opName string, whitePoint, gamma float64) (tracer.TonemapParams, error) {
+	op, err := tracer.ParseTonemapOperator(opName)
+	if err != nil {
+		supported := make([]string, 0, len(tracer.TonemapOperators))
+		for _, op := range tracer.TonemapOperators {
+			supported = append(supported, op.String())
+		}
+		return tracer.TonemapParams{}, fmt.Errorf("unsupported tonemap operator %q; supported operators: %s", opName, strings.Join(supported, ", "))
+	}
+
+	if whitePoint < 0 {
+		return tracer.TonemapParams{}, fmt.Errorf("white-point must not be negative; got %g", whitePoint)
+	} else if gamma <= 0 {
+		return tracer.TonemapParams{}, fmt.Errorf("gamma must be greater than 0; got %g", gamma)
+	}
+
+	return tracer.TonemapParams{
+		Operator:   op,
+		WhitePoint: float32(whitePoint),
+		Gamma:      float32(gamma),
+	}, nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/achilleasa/polaris/tracer/remote"
+	"github.com/urfave/cli"
+)
+
+// Serve a local tracer to remote renderers.
+func RunWorker(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	useCpuTracer := ctx.Bool("cpu")
+	blackList := ctx.StringSlice("blacklist")
+	if len(blackList) != 0 {
+		logger.Infof("blacklisted devices: %s", strings.Join(blackList, ", "))
+	}
+
+	srv, err := remote.NewServer(ctx.String("listen"), func() (tracer.Tracer, error) {
+		if !useCpuTracer {
+			tr, err := newWorkerOpenCLTracer(blackList)
+			if err == nil {
+				return tr, nil
+			}
+			logger.Noticef("could not init opencl device (%v); falling back to CPU tracer", err)
+		}
+
+		tr, err := cpu.NewTracer("CPU tracer", 0, cpu.DefaultPipeline())
+		if err == nil {
+			err = tr.Init()
+		}
+		return tr, err
+	})
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	return srv.Serve()
+}
+
+// Create and initialize an opencl tracer using the fastest available
+// device that is not blacklisted. GPU devices are preferred over CPU devices.
+func newWorkerOpenCLTracer(blackList []string) (tracer.Tracer, error) {
+	platforms, err := device.GetPlatformInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected *device.Device
+	for _, platformInfo := range platforms {
+		for _, dev := range platformInfo.Devices {
+			keep := true
+			for _, text := range blackList {
+				if text != "" && strings.Contains(dev.Name, text) {
+					keep = false
+					break
+				}
+			}
+
+			if !keep {
+				continue
+			}
+
+			if selected == nil ||
+				(selected.Type == device.CpuDevice && dev.Type != device.CpuDevice) ||
+				(selected.Type == dev.Type && dev.Speed > selected.Speed) {
+				selected = dev
+			}
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("no opencl devices available")
+	}
+
+	ctx, err := device.NewSharedContext([]*device.Device{selected})
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := opencl.NewTracer(selected.Name, selected, ctx, opencl.DefaultPipeline(opencl.NoDebug))
+	if err == nil {
+		err = tr.Init()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
@@ -0,0 +1,104 @@
+package hdr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	exrMagic          = 20000630
+	exrVersion        = 2
+	exrPixelTypeFloat = 2
+	exrNoCompression  = 0
+	exrIncreasingY    = 0
+)
+
+// Encode image as an uncompressed single-part scanline OpenEXR file with
+// 32-bit float R, G and B channels. Each chunk contains a single scanline.
+func encodeOpenEXR(w io.Writer, width, height uint32, pixels []types.Vec3) error {
+	var header bytes.Buffer
+
+	le := binary.LittleEndian
+	binary.Write(&header, le, uint32(exrMagic))
+	binary.Write(&header, le, uint32(exrVersion))
+
+	// Channels must be listed in alphabetical order
+	var chlist bytes.Buffer
+	for _, name := range []string{"B", "G", "R"} {
+		chlist.WriteString(name)
+		chlist.WriteByte(0)
+		binary.Write(&chlist, le, int32(exrPixelTypeFloat))
+		chlist.Write([]byte{0, 0, 0, 0}) // pLinear + reserved
+		binary.Write(&chlist, le, [2]int32{1, 1})
+	}
+	chlist.WriteByte(0)
+
+	window := [4]int32{0, 0, int32(width) - 1, int32(height) - 1}
+	writeExrAttribute(&header, "channels", "chlist", chlist.Bytes())
+	writeExrAttribute(&header, "compression", "compression", []byte{exrNoCompression})
+	writeExrAttribute(&header, "dataWindow", "box2i", window)
+	writeExrAttribute(&header, "displayWindow", "box2i", window)
+	writeExrAttribute(&header, "lineOrder", "lineOrder", []byte{exrIncreasingY})
+	writeExrAttribute(&header, "pixelAspectRatio", "float", float32(1.0))
+	writeExrAttribute(&header, "screenWindowCenter", "v2f", [2]float32{0, 0})
+	writeExrAttribute(&header, "screenWindowWidth", "float", float32(1.0))
+	header.WriteByte(0)
+
+	bw := bufio.NewWriter(w)
+	_, err := bw.Write(header.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// Write the scanline offset table
+	dataSize := width * 3 * 4
+	chunkSize := uint64(8 + dataSize)
+	chunkOffset := uint64(header.Len()) + uint64(height)*8
+	offsets := make([]uint64, height)
+	for y := range offsets {
+		offsets[y] = chunkOffset + uint64(y)*chunkSize
+	}
+	err = binary.Write(bw, le, offsets)
+	if err != nil {
+		return err
+	}
+
+	// Write scanlines; each scanline stores all pixels for each channel in turn
+	chunk := make([]byte, chunkSize)
+	le.PutUint32(chunk[4:], dataSize)
+	for y := 0; y < int(height); y++ {
+		le.PutUint32(chunk[0:], uint32(y))
+		row := pixels[y*int(width) : (y+1)*int(width)]
+		for chIndex, c := range []int{2, 1, 0} {
+			offset := 8 + chIndex*int(width)*4
+			for x, pixel := range row {
+				le.PutUint32(chunk[offset+x*4:], math.Float32bits(pixel[c]))
+			}
+		}
+
+		_, err = bw.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Append a header attribute. The attribute value is encoded in little-endian format.
+func writeExrAttribute(header *bytes.Buffer, name, attrType string, value interface{}) {
+	var valBuf bytes.Buffer
+	binary.Write(&valBuf, binary.LittleEndian, value)
+
+	header.WriteString(name)
+	header.WriteByte(0)
+	header.WriteString(attrType)
+	header.WriteByte(0)
+	binary.Write(header, binary.LittleEndian, int32(valBuf.Len()))
+	header.Write(valBuf.Bytes())
+}
@@ -0,0 +1,86 @@
+package hdr
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("hdr: unsupported image format")
+	ErrInvalidImageSize  = errors.New("hdr: pixel count does not match image dimensions")
+)
+
+// Supported HDR image formats.
+type Format uint8
+
+const (
+	// Portable float map (.pfm).
+	PFM Format = iota
+
+	// Radiance RGBE (.hdr).
+	Radiance
+
+	// Uncompressed single-part scanline OpenEXR with 32-bit float channels (.exr).
+	OpenEXR
+)
+
+// Detect the HDR image format from the extension of the supplied filename.
+func FormatFromFilename(filename string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pfm":
+		return PFM, nil
+	case ".hdr":
+		return Radiance, nil
+	case ".exr":
+		return OpenEXR, nil
+	}
+
+	return 0, ErrUnsupportedFormat
+}
+
+// Returns true if the filename extension matches one of the supported HDR formats.
+func IsHDR(filename string) bool {
+	_, err := FormatFromFilename(filename)
+	return err == nil
+}
+
+// Encode a linear RGB image. Pixels are stored in row-major order starting
+// from the top-left corner of the image.
+func Encode(w io.Writer, format Format, width, height uint32, pixels []types.Vec3) error {
+	if len(pixels) != int(width*height) {
+		return ErrInvalidImageSize
+	}
+
+	switch format {
+	case PFM:
+		return encodePFM(w, width, height, pixels)
+	case Radiance:
+		return encodeRadiance(w, width, height, pixels)
+	case OpenEXR:
+		return encodeOpenEXR(w, width, height, pixels)
+	}
+
+	return ErrUnsupportedFormat
+}
+
+// Write a linear RGB image to a file. The image format is selected based
+// on the filename extension.
+func WriteFile(filename string, width, height uint32, pixels []types.Vec3) error {
+	format, err := FormatFromFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return Encode(f, format, width, height, pixels)
+}
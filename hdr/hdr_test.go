@@ -0,0 +1,258 @@
+package hdr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestFormatFromFilename(t *testing.T) {
+	specs := []struct {
+		filename  string
+		expFormat Format
+		expErr    error
+	}{
+		{"frame.pfm", PFM, nil},
+		{"out/frame.HDR", Radiance, nil},
+		{"frame-%04d.exr", OpenEXR, nil},
+		{"frame.png", 0, ErrUnsupportedFormat},
+		{"frame", 0, ErrUnsupportedFormat},
+	}
+
+	for specIndex, spec := range specs {
+		format, err := FormatFromFilename(spec.filename)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+		if format != spec.expFormat {
+			t.Errorf("[spec %d] expected format %d; got %d", specIndex, spec.expFormat, format)
+		}
+	}
+}
+
+func TestEncodeInvalidImageSize(t *testing.T) {
+	err := Encode(&bytes.Buffer{}, PFM, 2, 2, make([]types.Vec3, 3))
+	if err != ErrInvalidImageSize {
+		t.Fatalf("expected to get ErrInvalidImageSize; got %v", err)
+	}
+}
+
+func TestEncodePFM(t *testing.T) {
+	pixels := testImage(3, 2)
+
+	var buf bytes.Buffer
+	err := Encode(&buf, PFM, 3, 2, pixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expHeader := "PF\n3 2\n-1.0\n"
+	if !strings.HasPrefix(buf.String(), expHeader) {
+		t.Fatalf("expected PFM header %q; got %q", expHeader, buf.String()[:len(expHeader)])
+	}
+
+	data := buf.Bytes()[len(expHeader):]
+	if len(data) != 3*2*12 {
+		t.Fatalf("expected PFM data size to be %d; got %d", 3*2*12, len(data))
+	}
+
+	// PFM rows are stored bottom to top
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			expPixel := pixels[(1-y)*3+x]
+			for c := 0; c < 3; c++ {
+				v := math.Float32frombits(binary.LittleEndian.Uint32(data[(y*3+x)*12+c*4:]))
+				if v != expPixel[c] {
+					t.Fatalf("expected pixel (%d, %d) to be %v; got component %d = %f", x, y, expPixel, c, v)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeRadiance(t *testing.T) {
+	// Image widths below 8 pixels are stored without RLE
+	for _, width := range []uint32{4, 64} {
+		pixels := testImage(width, 3)
+
+		var buf bytes.Buffer
+		err := Encode(&buf, Radiance, width, 3, pixels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeRadiance(&buf, width, 3)
+		if err != nil {
+			t.Fatalf("[width %d] %v", width, err)
+		}
+
+		for index, pixel := range decoded {
+			// RGBE has 8 bits of mantissa precision
+			for c := 0; c < 3; c++ {
+				if diff := math.Abs(float64(pixel[c] - pixels[index][c])); diff > float64(pixels[index].MaxComponent())/128 {
+					t.Fatalf("[width %d] expected pixel %d to be %v; got %v", width, index, pixels[index], pixel)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeOpenEXR(t *testing.T) {
+	pixels := testImage(3, 2)
+
+	var buf bytes.Buffer
+	err := Encode(&buf, OpenEXR, 3, 2, pixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if magic := binary.LittleEndian.Uint32(data); magic != exrMagic {
+		t.Fatalf("expected EXR magic number %d; got %d", exrMagic, magic)
+	}
+
+	// Locate the end of the header and read the offset table
+	headerEnd := bytes.Index(data, []byte("screenWindowWidth\x00float\x00")) + 24 + 4 + 4
+	if data[headerEnd] != 0 {
+		t.Fatal("expected header to be terminated by a null byte")
+	}
+
+	offsetTable := headerEnd + 1
+	for y := 0; y < 2; y++ {
+		chunkOffset := binary.LittleEndian.Uint64(data[offsetTable+y*8:])
+		chunk := data[chunkOffset:]
+
+		if lineY := binary.LittleEndian.Uint32(chunk); lineY != uint32(y) {
+			t.Fatalf("expected chunk %d to contain scanline %d; got %d", y, y, lineY)
+		}
+		if dataSize := binary.LittleEndian.Uint32(chunk[4:]); dataSize != 3*3*4 {
+			t.Fatalf("expected chunk %d data size to be %d; got %d", y, 3*3*4, dataSize)
+		}
+
+		// Channels are stored in B, G, R order
+		for chIndex, c := range []int{2, 1, 0} {
+			for x := 0; x < 3; x++ {
+				v := math.Float32frombits(binary.LittleEndian.Uint32(chunk[8+(chIndex*3+x)*4:]))
+				if expV := pixels[y*3+x][c]; v != expV {
+					t.Fatalf("expected pixel (%d, %d) component %d to be %f; got %f", x, y, c, expV, v)
+				}
+			}
+		}
+	}
+
+	expLen := offsetTable + 2*8 + 2*(8+3*3*4)
+	if len(data) != expLen {
+		t.Fatalf("expected EXR file size to be %d; got %d", expLen, len(data))
+	}
+}
+
+// Generate a test image with a mix of constant areas and gradients so that
+// both RLE runs and literals are exercised.
+func testImage(width, height uint32) []types.Vec3 {
+	pixels := make([]types.Vec3, width*height)
+	for index := range pixels {
+		x := uint32(index) % width
+		if x < width/2 {
+			pixels[index] = types.Vec3{1.5, 0.25, 0}
+			continue
+		}
+		pixels[index] = types.Vec3{float32(index) * 0.5, 10.0, float32(x) / float32(width)}
+	}
+	return pixels
+}
+
+// A minimal Radiance decoder supporting flat and RLE scanlines.
+func decodeRadiance(r io.Reader, width, height uint32) ([]types.Vec3, error) {
+	br := bufio.NewReader(r)
+
+	// Skip header
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == "\n" {
+			break
+		}
+	}
+
+	resLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if expLine := fmt.Sprintf("-Y %d +X %d\n", height, width); resLine != expLine {
+		return nil, fmt.Errorf("expected resolution line %q; got %q", expLine, resLine)
+	}
+
+	pixels := make([]types.Vec3, 0, width*height)
+	scanline := make([]byte, width*4)
+	for y := uint32(0); y < height; y++ {
+		if width < rleMinScanlineWidth {
+			_, err = io.ReadFull(br, scanline)
+			if err != nil {
+				return nil, err
+			}
+			for x := uint32(0); x < width; x++ {
+				pixels = append(pixels, fromRGBE(scanline[x*4], scanline[x*4+1], scanline[x*4+2], scanline[x*4+3]))
+			}
+			continue
+		}
+
+		var marker [4]byte
+		_, err = io.ReadFull(br, marker[:])
+		if err != nil {
+			return nil, err
+		}
+		if marker[0] != 2 || marker[1] != 2 || uint32(marker[2])<<8|uint32(marker[3]) != width {
+			return nil, fmt.Errorf("invalid RLE scanline marker %v", marker)
+		}
+
+		for c := uint32(0); c < 4; c++ {
+			plane := scanline[c*width : (c+1)*width]
+			for x := 0; x < int(width); {
+				count, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				if count > 128 {
+					v, err := br.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					for i := 0; i < int(count-128); i++ {
+						plane[x] = v
+						x++
+					}
+					continue
+				}
+				_, err = io.ReadFull(br, plane[x:x+int(count)])
+				if err != nil {
+					return nil, err
+				}
+				x += int(count)
+			}
+		}
+
+		for x := uint32(0); x < width; x++ {
+			pixels = append(pixels, fromRGBE(scanline[x], scanline[width+x], scanline[2*width+x], scanline[3*width+x]))
+		}
+	}
+
+	return pixels, nil
+}
+
+func fromRGBE(r, g, b, e byte) types.Vec3 {
+	if e == 0 {
+		return types.Vec3{}
+	}
+	scale := float32(math.Ldexp(1.0, int(e)-(128+8)))
+	return types.Vec3{float32(r) * scale, float32(g) * scale, float32(b) * scale}
+}
@@ -0,0 +1,41 @@
+package hdr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Encode image as a little-endian color PFM. PFM stores scanlines from
+// bottom to top so rows are written in reverse order.
+func encodePFM(w io.Writer, width, height uint32, pixels []types.Vec3) error {
+	bw := bufio.NewWriter(w)
+
+	// A negative scale factor indicates little-endian data
+	_, err := fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", width, height)
+	if err != nil {
+		return err
+	}
+
+	row := make([]byte, width*12)
+	for y := int(height) - 1; y >= 0; y-- {
+		offset := 0
+		for _, pixel := range pixels[y*int(width) : (y+1)*int(width)] {
+			for c := 0; c < 3; c++ {
+				binary.LittleEndian.PutUint32(row[offset:], math.Float32bits(pixel[c]))
+				offset += 4
+			}
+		}
+
+		_, err = bw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,142 @@
+package hdr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	// Runs shorter than this value are stored as literal bytes.
+	rleMinRunLength = 4
+
+	// Scanlines outside this width range cannot be run-length encoded.
+	rleMinScanlineWidth = 8
+	rleMaxScanlineWidth = 0x7fff
+)
+
+// Encode image using the Radiance RGBE format. Scanlines are written from top
+// to bottom using the "new" run-length encoding whenever the image width allows it.
+func encodeRadiance(w io.Writer, width, height uint32, pixels []types.Vec3) error {
+	bw := bufio.NewWriter(w)
+
+	_, err := fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", height, width)
+	if err != nil {
+		return err
+	}
+
+	useRLE := width >= rleMinScanlineWidth && width <= rleMaxScanlineWidth
+	scanline := make([]byte, width*4)
+	for y := 0; y < int(height); y++ {
+		for x, pixel := range pixels[y*int(width) : (y+1)*int(width)] {
+			rgbe := toRGBE(pixel)
+			if useRLE {
+				// Store each component in a separate plane
+				for c := 0; c < 4; c++ {
+					scanline[c*int(width)+x] = rgbe[c]
+				}
+			} else {
+				copy(scanline[x*4:], rgbe[:])
+			}
+		}
+
+		if !useRLE {
+			_, err = bw.Write(scanline)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = bw.Write([]byte{2, 2, byte(width >> 8), byte(width & 0xff)})
+		if err != nil {
+			return err
+		}
+		for c := 0; c < 4; c++ {
+			err = writeRLE(bw, scanline[c*int(width):(c+1)*int(width)])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Convert a linear RGB value to its shared exponent RGBE representation.
+func toRGBE(v types.Vec3) [4]byte {
+	maxComp := v.MaxComponent()
+	if maxComp < 1e-32 {
+		return [4]byte{}
+	}
+
+	mantissa, exp := math.Frexp(float64(maxComp))
+	scale := float32(mantissa * 256.0 / float64(maxComp))
+
+	var rgbe [4]byte
+	for c := 0; c < 3; c++ {
+		if v[c] > 0 {
+			rgbe[c] = byte(v[c] * scale)
+		}
+	}
+	rgbe[3] = byte(exp + 128)
+	return rgbe
+}
+
+// Run-length encode a single component plane of a scanline.
+func writeRLE(w *bufio.Writer, data []byte) error {
+	var err error
+	cur := 0
+	for cur < len(data) {
+		// Find the start of the next run that is long enough to encode
+		begRun := cur
+		runCount, prevRunCount := 0, 0
+		for runCount < rleMinRunLength && begRun < len(data) {
+			begRun += runCount
+			prevRunCount = runCount
+			runCount = 1
+			for begRun+runCount < len(data) && runCount < 127 && data[begRun] == data[begRun+runCount] {
+				runCount++
+			}
+		}
+
+		// If a short run precedes the long run, encode it as a run
+		if prevRunCount > 1 && prevRunCount == begRun-cur {
+			_, err = w.Write([]byte{byte(128 + prevRunCount), data[cur]})
+			if err != nil {
+				return err
+			}
+			cur = begRun
+		}
+
+		// Write literal bytes until we reach the start of the run
+		for cur < begRun {
+			count := begRun - cur
+			if count > 128 {
+				count = 128
+			}
+			err = w.WriteByte(byte(count))
+			if err == nil {
+				_, err = w.Write(data[cur : cur+count])
+			}
+			if err != nil {
+				return err
+			}
+			cur += count
+		}
+
+		// Write the run
+		if runCount >= rleMinRunLength {
+			_, err = w.Write([]byte{byte(128 + runCount), data[begRun]})
+			if err != nil {
+				return err
+			}
+			cur += runCount
+		}
+	}
+
+	return nil
+}
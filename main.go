@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/achilleasa/polaris/cmd"
 	"github.com/urfave/cli"
@@ -62,6 +63,232 @@ func main() {
 			Usage:  "list available opencl devices",
 			Action: cmd.ListDevices,
 		},
+		{
+			Name:        "worker",
+			Usage:       "serve a local tracer to remote renderers",
+			Description: `Listen for connections from remote renderers and process their block requests using a local tracer.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: ":7890",
+					Usage: "the address to listen for connections",
+				},
+				cli.StringSliceFlag{
+					Name:  "blacklist, b",
+					Value: &cli.StringSlice{},
+					Usage: "blacklist opencl device whose names contain this value",
+				},
+				cli.BoolFlag{
+					Name:  "cpu",
+					Usage: "use the CPU tracer instead of the opencl devices",
+				},
+			},
+			Action: cmd.RunWorker,
+		},
+		{
+			Name:        "serve",
+			Usage:       "serve an HTTP API for submitting render jobs",
+			Description: `Accept render jobs over HTTP and process them one at a time using the local and remote tracers.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: ":8080",
+					Usage: "the address to listen for HTTP requests",
+				},
+				cli.StringFlag{
+					Name:  "work-dir",
+					Value: "",
+					Usage: "the directory for storing uploaded scenes and rendered images; defaults to a temporary directory",
+				},
+				cli.IntFlag{
+					Name:  "pass-spp",
+					Value: 4,
+					Usage: "the number of samples per pixel rendered between job progress updates",
+				},
+				cli.IntFlag{
+					Name:  "max-queued-jobs",
+					Value: 32,
+					Usage: "the max number of queued jobs",
+				},
+				cli.IntFlag{
+					Name:  "max-scene-mb",
+					Value: 256,
+					Usage: "the max size in MB of uploaded scenes",
+				},
+				cli.IntFlag{
+					Name:  "max-frame-w",
+					Value: 8192,
+					Usage: "the max frame width that can be requested by a job",
+				},
+				cli.IntFlag{
+					Name:  "max-frame-h",
+					Value: 8192,
+					Usage: "the max frame height that can be requested by a job",
+				},
+				cli.IntFlag{
+					Name:  "max-spp",
+					Value: 65536,
+					Usage: "the max samples per pixel that can be requested by a job",
+				},
+				cli.StringSliceFlag{
+					Name:  "blacklist, b",
+					Value: &cli.StringSlice{},
+					Usage: "blacklist opencl device whose names contain this value",
+				},
+				cli.StringFlag{
+					Name:  "force-primary",
+					Value: "",
+					Usage: "force a particular device name as the primary device",
+				},
+				cli.BoolFlag{
+					Name:  "cpu",
+					Usage: "use the CPU tracer instead of the opencl devices",
+				},
+				cli.StringSliceFlag{
+					Name:  "worker, w",
+					Value: &cli.StringSlice{},
+					Usage: "use the remote worker listening at this address (host:port)",
+				},
+				cli.DurationFlag{
+					Name:  "worker-timeout",
+					Value: time.Minute,
+					Usage: "the base timeout for remote worker requests; trace requests are allowed additional time depending on the block size and spp",
+				},
+				cli.StringFlag{
+					Name:  "scheduler",
+					Value: "naive",
+					Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, work-stealing",
+				},
+				cli.IntFlag{
+					Name:  "tile-size",
+					Value: 64,
+					Usage: "the tile width and height used by the work-stealing scheduler",
+				},
+			},
+			Action: cmd.RunServer,
+		},
+		{
+			Name:        "bench",
+			Usage:       "benchmark the ray throughput of the rendering devices",
+			Description: `Render a scene for a number of frames with fixed options and report the number of rays traced per second by each device. If no scene file is specified, a built-in procedural scene is used.`,
+			ArgsUsage:   "[scene_file.zip or scene_file.obj]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "frames",
+					Value: 10,
+					Usage: "number of measured frames",
+				},
+				cli.IntFlag{
+					Name:  "warmup",
+					Value: 1,
+					Usage: "number of frames to render before measuring",
+				},
+				cli.IntFlag{
+					Name:  "width",
+					Value: 512,
+					Usage: "frame width",
+				},
+				cli.IntFlag{
+					Name:  "height",
+					Value: 512,
+					Usage: "frame height",
+				},
+				cli.IntFlag{
+					Name:  "spp",
+					Value: 4,
+					Usage: "samples per pixel",
+				},
+				cli.IntFlag{
+					Name:  "num-bounces, nb",
+					Value: 5,
+					Usage: "number of indirect ray bounces",
+				},
+				cli.IntFlag{
+					Name:  "seed",
+					Value: 0,
+					Usage: "the seed for the random number generators",
+				},
+				cli.StringSliceFlag{
+					Name:  "blacklist, b",
+					Value: &cli.StringSlice{},
+					Usage: "blacklist opencl device whose names contain this value",
+				},
+				cli.StringFlag{
+					Name:  "force-primary",
+					Value: "",
+					Usage: "force a particular device name as the primary device",
+				},
+				cli.BoolFlag{
+					Name:  "cpu",
+					Usage: "use the CPU tracer instead of the opencl devices",
+				},
+				cli.StringSliceFlag{
+					Name:  "worker, w",
+					Value: &cli.StringSlice{},
+					Usage: "use the remote worker listening at this address (host:port)",
+				},
+				cli.DurationFlag{
+					Name:  "worker-timeout",
+					Value: time.Minute,
+					Usage: "the base timeout for remote worker requests; trace requests are allowed additional time depending on the block size and spp",
+				},
+				cli.StringFlag{
+					Name:  "scheduler",
+					Value: "naive",
+					Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, work-stealing",
+				},
+				cli.IntFlag{
+					Name:  "tile-size",
+					Value: 64,
+					Usage: "the tile width and height used by the work-stealing scheduler",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "table",
+					Usage: "output format for the benchmark results; supported formats: table, json",
+				},
+			},
+			Action: cmd.RunBenchmark,
+		},
+		{
+			Name:        "merge",
+			Usage:       "merge accumulator files from separate renders of the same frame",
+			Description: `Combine the accumulator files written by the checkpoint option of the render frame command. The samples of each file are weighted by their sample count. Each file should be rendered using a different seed. The merged frame is tonemapped and saved as a PNG image or, depending on the output file extension, as an HDR image.`,
+			ArgsUsage:   "accumulator_file [accumulator_file...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: "frame.png",
+					Usage: "image filename for the merged frame; use a .pfm, .hdr or .exr extension to save a linear HDR image",
+				},
+				cli.Float64Flag{
+					Name:  "exposure",
+					Value: 1.2,
+					Usage: "camera exposure for tone-mapping",
+				},
+				cli.StringFlag{
+					Name:  "tonemap",
+					Value: "reinhard",
+					Usage: "tone-mapping operator; supported operators: reinhard, aces, uncharted2, linear",
+				},
+				cli.Float64Flag{
+					Name:  "white-point",
+					Value: 0,
+					Usage: "the radiance value that is mapped to white by the reinhard, uncharted2 and linear operators (0 selects the operator default)",
+				},
+				cli.Float64Flag{
+					Name:  "gamma",
+					Value: 2.2,
+					Usage: "gamma value for encoding tone-mapped frames",
+				},
+				cli.StringFlag{
+					Name:  "config",
+					Value: "",
+					Usage: "load options and post-process pipeline stages from a .yaml or .json file; command-line flags override values from the file",
+				},
+			},
+			Action: cmd.MergeAccumulators,
+		},
 		{
 			Name:   "render",
 			Usage:  "render scene",
@@ -88,6 +315,31 @@ func main() {
 							Value: 16,
 							Usage: "samples per pixel",
 						},
+						cli.DurationFlag{
+							Name:  "time-limit",
+							Value: 0,
+							Usage: "stop rendering once this time budget (e.g. 90s, 10m) expires and save the frame; if spp is 0 the frame is rendered until the time limit expires",
+						},
+						cli.IntFlag{
+							Name:  "save-every",
+							Value: 0,
+							Usage: "render the frame in passes of this many samples per pixel and save a snapshot of the output image after each pass",
+						},
+						cli.StringFlag{
+							Name:  "checkpoint",
+							Value: "",
+							Usage: "periodically write the accumulated samples to this checkpoint file so that the render can be resumed",
+						},
+						cli.DurationFlag{
+							Name:  "checkpoint-every",
+							Value: 10 * time.Minute,
+							Usage: "the minimum time between checkpoint writes",
+						},
+						cli.StringFlag{
+							Name:  "resume",
+							Value: "",
+							Usage: "resume rendering from a checkpoint file; the scene and render options must match the ones used for creating the checkpoint",
+						},
 						cli.IntFlag{
 							Name:  "num-bounces, nb",
 							Value: 5,
@@ -103,6 +355,74 @@ func main() {
 							Value: 1.2,
 							Usage: "camera exposure for tone-mapping",
 						},
+						cli.StringFlag{
+							Name:  "tonemap",
+							Value: "reinhard",
+							Usage: "tone-mapping operator; supported operators: reinhard, aces, uncharted2, linear",
+						},
+						cli.Float64Flag{
+							Name:  "white-point",
+							Value: 0,
+							Usage: "the radiance value that is mapped to white by the reinhard, uncharted2 and linear operators (0 selects the operator default)",
+						},
+						cli.Float64Flag{
+							Name:  "gamma",
+							Value: 2.2,
+							Usage: "gamma value for encoding tone-mapped frames",
+						},
+						cli.BoolFlag{
+							Name:  "denoise",
+							Usage: "denoise frames using an edge-avoiding a-trous wavelet filter prior to tone-mapping",
+						},
+						cli.IntFlag{
+							Name:  "denoise-iterations",
+							Value: 5,
+							Usage: "number of denoising filter iterations",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-color",
+							Value: 0.8,
+							Usage: "edge-stopping sigma for radiance differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-normal",
+							Value: 0.3,
+							Usage: "edge-stopping sigma for normal differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-depth",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for relative depth differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-albedo",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for albedo differences (0 disables the weight)",
+						},
+						cli.BoolFlag{
+							Name:  "bloom",
+							Usage: "add a glow around pixels whose luminance exceeds the bloom threshold",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-threshold",
+							Value: 1.0,
+							Usage: "luminance above which pixels contribute to the bloom glow",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-radius",
+							Value: 32,
+							Usage: "approximate radius of the bloom glow in pixels",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-intensity",
+							Value: 0.1,
+							Usage: "scale factor for the bloom glow",
+						},
+						cli.IntFlag{
+							Name:  "seed",
+							Value: 0,
+							Usage: "the seed for the random number generators; renders with the same seed and devices produce the same output",
+						},
 						cli.StringSliceFlag{
 							Name:  "blacklist, b",
 							Value: &cli.StringSlice{},
@@ -113,14 +433,224 @@ func main() {
 							Value: "",
 							Usage: "force a particular device name as the primary device",
 						},
+						cli.BoolFlag{
+							Name:  "cpu",
+							Usage: "use the CPU tracer instead of the opencl devices",
+						},
+						cli.StringSliceFlag{
+							Name:  "worker, w",
+							Value: &cli.StringSlice{},
+							Usage: "use the remote worker listening at this address (host:port)",
+						},
+						cli.DurationFlag{
+							Name:  "worker-timeout",
+							Value: time.Minute,
+							Usage: "the base timeout for remote worker requests; trace requests are allowed additional time depending on the block size and spp",
+						},
 						cli.StringFlag{
 							Name:  "out, o",
 							Value: "frame.png",
-							Usage: "image filename for the rendered frame",
+							Usage: "image filename for the rendered frame; use a .pfm, .hdr or .exr extension to save a linear HDR image",
+						},
+						cli.StringSliceFlag{
+							Name:  "aov",
+							Value: &cli.StringSlice{},
+							Usage: "save an AOV (albedo, normal, depth, instance-id, material-id) to an image file using the type=filename format",
+						},
+						cli.StringFlag{
+							Name:  "crop",
+							Value: "",
+							Usage: "only render the frame region specified in x,y,w,h format; the output image contains the cropped region",
+						},
+						cli.StringFlag{
+							Name:  "crop-base",
+							Value: "",
+							Usage: "composite the cropped region over a previously rendered PNG frame and save the full frame",
+						},
+						cli.StringFlag{
+							Name:  "scheduler",
+							Value: "naive",
+							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, work-stealing",
+						},
+						cli.IntFlag{
+							Name:  "tile-size",
+							Value: 64,
+							Usage: "the tile width and height used by the work-stealing scheduler",
+						},
+						cli.StringFlag{
+							Name:  "stats-out",
+							Value: "",
+							Usage: "write frame statistics to a .json or .csv file",
+						},
+						cli.StringFlag{
+							Name:  "config",
+							Value: "",
+							Usage: "load render options and pipeline stages from a .yaml or .json file; command-line flags override values from the file",
 						},
 					},
 					Action: cmd.RenderFrame,
 				},
+				{
+					Name:        "animation",
+					Usage:       "render an image sequence using a keyframed camera path",
+					Description: `Render an image sequence by moving the camera along a path defined by a keyframe file.`,
+					ArgsUsage:   "scene_file.zip or scene_file.obj",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "width",
+							Value: 1024,
+							Usage: "frame width",
+						},
+						cli.IntFlag{
+							Name:  "height",
+							Value: 1024,
+							Usage: "frame height",
+						},
+						cli.IntFlag{
+							Name:  "spp",
+							Value: 16,
+							Usage: "samples per pixel",
+						},
+						cli.IntFlag{
+							Name:  "num-bounces, nb",
+							Value: 5,
+							Usage: "number of indirect ray bounces",
+						},
+						cli.IntFlag{
+							Name:  "rr-bounces, nr",
+							Value: 3,
+							Usage: "number of indirect ray bounces before applying RR (disabled if 0 or >= than num-bounces)",
+						},
+						cli.Float64Flag{
+							Name:  "exposure",
+							Value: 1.2,
+							Usage: "camera exposure for tone-mapping",
+						},
+						cli.StringFlag{
+							Name:  "tonemap",
+							Value: "reinhard",
+							Usage: "tone-mapping operator; supported operators: reinhard, aces, uncharted2, linear",
+						},
+						cli.Float64Flag{
+							Name:  "white-point",
+							Value: 0,
+							Usage: "the radiance value that is mapped to white by the reinhard, uncharted2 and linear operators (0 selects the operator default)",
+						},
+						cli.Float64Flag{
+							Name:  "gamma",
+							Value: 2.2,
+							Usage: "gamma value for encoding tone-mapped frames",
+						},
+						cli.BoolFlag{
+							Name:  "denoise",
+							Usage: "denoise frames using an edge-avoiding a-trous wavelet filter prior to tone-mapping",
+						},
+						cli.IntFlag{
+							Name:  "denoise-iterations",
+							Value: 5,
+							Usage: "number of denoising filter iterations",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-color",
+							Value: 0.8,
+							Usage: "edge-stopping sigma for radiance differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-normal",
+							Value: 0.3,
+							Usage: "edge-stopping sigma for normal differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-depth",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for relative depth differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-albedo",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for albedo differences (0 disables the weight)",
+						},
+						cli.BoolFlag{
+							Name:  "bloom",
+							Usage: "add a glow around pixels whose luminance exceeds the bloom threshold",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-threshold",
+							Value: 1.0,
+							Usage: "luminance above which pixels contribute to the bloom glow",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-radius",
+							Value: 32,
+							Usage: "approximate radius of the bloom glow in pixels",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-intensity",
+							Value: 0.1,
+							Usage: "scale factor for the bloom glow",
+						},
+						cli.IntFlag{
+							Name:  "seed",
+							Value: 0,
+							Usage: "the seed for the random number generators; renders with the same seed and devices produce the same output",
+						},
+						cli.StringSliceFlag{
+							Name:  "blacklist, b",
+							Value: &cli.StringSlice{},
+							Usage: "blacklist opencl device whose names contain this value",
+						},
+						cli.StringFlag{
+							Name:  "force-primary",
+							Value: "",
+							Usage: "force a particular device name as the primary device",
+						},
+						cli.BoolFlag{
+							Name:  "cpu",
+							Usage: "use the CPU tracer instead of the opencl devices",
+						},
+						cli.StringSliceFlag{
+							Name:  "worker, w",
+							Value: &cli.StringSlice{},
+							Usage: "use the remote worker listening at this address (host:port)",
+						},
+						cli.DurationFlag{
+							Name:  "worker-timeout",
+							Value: time.Minute,
+							Usage: "the base timeout for remote worker requests; trace requests are allowed additional time depending on the block size and spp",
+						},
+						cli.StringFlag{
+							Name:  "keyframes, k",
+							Value: "",
+							Usage: "a JSON file with the camera keyframes",
+						},
+						cli.Float64Flag{
+							Name:  "fps",
+							Value: 24,
+							Usage: "number of rendered frames per second of animation",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Value: "frame-%04d.png",
+							Usage: "image filename pattern for the rendered frames; use a .pfm, .hdr or .exr extension to save linear HDR images",
+						},
+						cli.StringFlag{
+							Name:  "scheduler",
+							Value: "naive",
+							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, work-stealing",
+						},
+						cli.IntFlag{
+							Name:  "tile-size",
+							Value: 64,
+							Usage: "the tile width and height used by the work-stealing scheduler",
+						},
+						cli.StringFlag{
+							Name:  "config",
+							Value: "",
+							Usage: "load render options and pipeline stages from a .yaml or .json file; command-line flags override values from the file",
+						},
+					},
+					Action: cmd.RenderAnimation,
+				},
 				{
 					Name:        "interactive",
 					Usage:       "render interactive view of the scene",
@@ -156,6 +686,74 @@ func main() {
 							Value: 1.2,
 							Usage: "camera exposure for tone-mapping",
 						},
+						cli.StringFlag{
+							Name:  "tonemap",
+							Value: "reinhard",
+							Usage: "tone-mapping operator; supported operators: reinhard, aces, uncharted2, linear",
+						},
+						cli.Float64Flag{
+							Name:  "white-point",
+							Value: 0,
+							Usage: "the radiance value that is mapped to white by the reinhard, uncharted2 and linear operators (0 selects the operator default)",
+						},
+						cli.Float64Flag{
+							Name:  "gamma",
+							Value: 2.2,
+							Usage: "gamma value for encoding tone-mapped frames",
+						},
+						cli.BoolFlag{
+							Name:  "denoise",
+							Usage: "denoise frames using an edge-avoiding a-trous wavelet filter prior to tone-mapping",
+						},
+						cli.IntFlag{
+							Name:  "denoise-iterations",
+							Value: 5,
+							Usage: "number of denoising filter iterations",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-color",
+							Value: 0.8,
+							Usage: "edge-stopping sigma for radiance differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-normal",
+							Value: 0.3,
+							Usage: "edge-stopping sigma for normal differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-depth",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for relative depth differences (0 disables the weight)",
+						},
+						cli.Float64Flag{
+							Name:  "denoise-albedo",
+							Value: 0.1,
+							Usage: "edge-stopping sigma for albedo differences (0 disables the weight)",
+						},
+						cli.BoolFlag{
+							Name:  "bloom",
+							Usage: "add a glow around pixels whose luminance exceeds the bloom threshold",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-threshold",
+							Value: 1.0,
+							Usage: "luminance above which pixels contribute to the bloom glow",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-radius",
+							Value: 32,
+							Usage: "approximate radius of the bloom glow in pixels",
+						},
+						cli.Float64Flag{
+							Name:  "bloom-intensity",
+							Value: 0.1,
+							Usage: "scale factor for the bloom glow",
+						},
+						cli.IntFlag{
+							Name:  "seed",
+							Value: 0,
+							Usage: "the seed for the random number generators; renders with the same seed and devices produce the same output",
+						},
 						cli.StringSliceFlag{
 							Name:  "blacklist, b",
 							Value: &cli.StringSlice{},
@@ -166,10 +764,39 @@ func main() {
 							Value: "",
 							Usage: "force a particular device name as the primary device",
 						},
+						cli.BoolFlag{
+							Name:  "cpu",
+							Usage: "use the CPU tracer instead of the opencl devices",
+						},
+						cli.StringSliceFlag{
+							Name:  "worker, w",
+							Value: &cli.StringSlice{},
+							Usage: "use the remote worker listening at this address (host:port)",
+						},
+						cli.DurationFlag{
+							Name:  "worker-timeout",
+							Value: time.Minute,
+							Usage: "the base timeout for remote worker requests; trace requests are allowed additional time depending on the block size and spp",
+						},
 						cli.StringFlag{
 							Name:  "scheduler",
 							Value: "perfect",
-							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect",
+							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, work-stealing",
+						},
+						cli.IntFlag{
+							Name:  "tile-size",
+							Value: 64,
+							Usage: "the tile width and height used by the work-stealing scheduler",
+						},
+						cli.StringFlag{
+							Name:  "stats-out",
+							Value: "",
+							Usage: "write frame statistics to a .json or .csv file",
+						},
+						cli.StringFlag{
+							Name:  "config",
+							Value: "",
+							Usage: "load render options and pipeline stages from a .yaml or .json file; command-line flags override values from the file",
 						},
 					},
 					Action: cmd.RenderInteractive,
@@ -0,0 +1,291 @@
+package renderer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The magic value and version of the accumulator file format.
+const (
+	accumulatorFileMagic   = "PACC"
+	accumulatorFileVersion = 1
+
+	// The maximum frame width and height accepted when reading an
+	// accumulator file.
+	maxAccumulatorFrameDim = 32768
+)
+
+// A checkpoint stores the frame accumulator contents of a partially rendered
+// frame together with the information required for resuming the render.
+//
+// Checkpoints are stored as accumulator files which use the following
+// little-endian binary format:
+//
+//	offset  size  description
+//	0       4     magic value "PACC"
+//	4       4     format version (1)
+//	8       4     frame width
+//	12      4     frame height
+//	16      16    crop region x, y, width and height (all zero if not cropped)
+//	32      4     number of bounces
+//	36      4     number of bounces before applying russian roulette
+//	40      4     seed
+//	44      4     number of accumulated samples per pixel
+//	48      64    hex-encoded SHA-256 scene hash
+//	112     12*N  accumulator contents for the N pixels of the rendered region
+//
+// The accumulator contents are stored in row-major order as float32 RGB
+// triplets. Each triplet contains the sum of the linear radiance samples for
+// a pixel; dividing it by the number of accumulated samples yields the
+// pixel radiance.
+type Checkpoint struct {
+	// A hash of the rendered scene as returned by SceneHash.
+	SceneHash string
+
+	// The render options that affect the accumulated samples.
+	FrameW          uint32
+	FrameH          uint32
+	Crop            tracer.Tile
+	NumBounces      uint32
+	MinBouncesForRR uint32
+	Seed            uint32
+
+	// The number of samples per pixel stored in the accumulator.
+	AccumulatedSamples uint32
+
+	// The frame accumulator contents for the rendered frame region.
+	Accumulator []types.Vec3
+}
+
+// The fixed-size header of an accumulator file.
+type accumulatorFileHeader struct {
+	Magic              [4]byte
+	Version            uint32
+	FrameW             uint32
+	FrameH             uint32
+	Crop               [4]uint32
+	NumBounces         uint32
+	MinBouncesForRR    uint32
+	Seed               uint32
+	AccumulatedSamples uint32
+	SceneHash          [64]byte
+}
+
+// Create a checkpoint for a frame rendered with the specified options.
+func NewCheckpoint(sceneHash string, opts Options, accumulatedSamples uint32, accumulator []types.Vec3) *Checkpoint {
+	return &Checkpoint{
+		SceneHash:          sceneHash,
+		FrameW:             opts.FrameW,
+		FrameH:             opts.FrameH,
+		Crop:               opts.Crop,
+		NumBounces:         opts.NumBounces,
+		MinBouncesForRR:    opts.MinBouncesForRR,
+		Seed:               opts.Seed,
+		AccumulatedSamples: accumulatedSamples,
+		Accumulator:        accumulator,
+	}
+}
+
+// Read a checkpoint from an accumulator file.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	var header accumulatorFileHeader
+	err = binary.Read(br, binary.LittleEndian, &header)
+	if err != nil || string(header.Magic[:]) != accumulatorFileMagic {
+		return nil, fmt.Errorf("renderer: %q is not an accumulator file", filename)
+	} else if header.Version != accumulatorFileVersion {
+		return nil, fmt.Errorf("renderer: unsupported accumulator file version %d", header.Version)
+	}
+
+	cp := &Checkpoint{
+		SceneHash:          strings.TrimRight(string(header.SceneHash[:]), "\x00"),
+		FrameW:             header.FrameW,
+		FrameH:             header.FrameH,
+		Crop:               tracer.Tile{X: header.Crop[0], Y: header.Crop[1], W: header.Crop[2], H: header.Crop[3]},
+		NumBounces:         header.NumBounces,
+		MinBouncesForRR:    header.MinBouncesForRR,
+		Seed:               header.Seed,
+		AccumulatedSamples: header.AccumulatedSamples,
+	}
+
+	// Validate the header before allocating any buffers so that corrupted
+	// files cannot trigger huge allocations.
+	crop := cp.Crop
+	if cp.FrameW == 0 || cp.FrameH == 0 || cp.FrameW > maxAccumulatorFrameDim || cp.FrameH > maxAccumulatorFrameDim {
+		return nil, fmt.Errorf("renderer: invalid frame dimensions %dx%d in accumulator file %q", cp.FrameW, cp.FrameH, filename)
+	} else if crop != (tracer.Tile{}) && (crop.W == 0 || crop.H == 0 || uint64(crop.X)+uint64(crop.W) > uint64(cp.FrameW) || uint64(crop.Y)+uint64(crop.H) > uint64(cp.FrameH)) {
+		return nil, fmt.Errorf("renderer: invalid crop region in accumulator file %q", filename)
+	}
+
+	region := cp.Region()
+	expSize := int64(binary.Size(header)) + 12*int64(region.W)*int64(region.H)
+	if info.Size() != expSize {
+		return nil, fmt.Errorf("renderer: expected accumulator file %q to contain %d bytes; got %d", filename, expSize, info.Size())
+	}
+
+	data := make([]float32, 3*region.W*region.H)
+	err = binary.Read(br, binary.LittleEndian, data)
+	if err != nil {
+		return nil, fmt.Errorf("renderer: could not read accumulator data from %q: %v", filename, err)
+	}
+
+	cp.Accumulator = make([]types.Vec3, region.W*region.H)
+	for index := range cp.Accumulator {
+		copy(cp.Accumulator[index][:], data[3*index:])
+	}
+
+	return cp, nil
+}
+
+// Write the checkpoint to an accumulator file. The checkpoint is written to a
+// temporary file which then replaces the target file so that a failure while
+// writing does not corrupt a previously written checkpoint.
+func (cp *Checkpoint) Write(filename string) error {
+	region := cp.Region()
+	if len(cp.Accumulator) != int(region.W*region.H) {
+		return fmt.Errorf("renderer: expected checkpoint to contain %d accumulator samples; got %d", region.W*region.H, len(cp.Accumulator))
+	}
+
+	header := accumulatorFileHeader{
+		Version:            accumulatorFileVersion,
+		FrameW:             cp.FrameW,
+		FrameH:             cp.FrameH,
+		Crop:               [4]uint32{cp.Crop.X, cp.Crop.Y, cp.Crop.W, cp.Crop.H},
+		NumBounces:         cp.NumBounces,
+		MinBouncesForRR:    cp.MinBouncesForRR,
+		Seed:               cp.Seed,
+		AccumulatedSamples: cp.AccumulatedSamples,
+	}
+	copy(header.Magic[:], accumulatorFileMagic)
+	copy(header.SceneHash[:], cp.SceneHash)
+
+	data := make([]float32, 0, 3*len(cp.Accumulator))
+	for _, sample := range cp.Accumulator {
+		data = append(data, sample[:]...)
+	}
+
+	f, err := os.Create(filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp"))
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	err = binary.Write(bw, binary.LittleEndian, &header)
+	if err == nil {
+		err = binary.Write(bw, binary.LittleEndian, data)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// Merge the samples of another checkpoint for the same scene and frame
+// region into this checkpoint. The checkpoints may have been rendered using
+// different seeds. As accumulators store the sum of all samples, the merged
+// pixel values are weighted by the number of samples in each checkpoint.
+func (cp *Checkpoint) Merge(other *Checkpoint) error {
+	if cp.SceneHash != other.SceneHash {
+		return ErrCheckpointSceneMismatch
+	}
+
+	opts := Options{
+		FrameW:          cp.FrameW,
+		FrameH:          cp.FrameH,
+		Crop:            cp.Crop,
+		NumBounces:      cp.NumBounces,
+		MinBouncesForRR: cp.MinBouncesForRR,
+		Seed:            other.Seed,
+	}
+	err := other.Validate(cp.SceneHash, opts)
+	if err != nil {
+		return err
+	}
+
+	for index, sample := range other.Accumulator {
+		cp.Accumulator[index] = cp.Accumulator[index].Add(sample)
+	}
+	cp.AccumulatedSamples += other.AccumulatedSamples
+	return nil
+}
+
+// Get the frame region covered by the checkpoint accumulator.
+func (cp *Checkpoint) Region() tracer.Tile {
+	opts := Options{FrameW: cp.FrameW, FrameH: cp.FrameH, Crop: cp.Crop}
+	return opts.frameRegion()
+}
+
+// Check that the checkpoint can be used for resuming a render of the given
+// scene using the specified options.
+func (cp *Checkpoint) Validate(sceneHash string, opts Options) error {
+	if cp.SceneHash != sceneHash {
+		return ErrCheckpointSceneMismatch
+	}
+
+	specs := []struct {
+		name   string
+		cp     interface{}
+		option interface{}
+	}{
+		{"frame width", cp.FrameW, opts.FrameW},
+		{"frame height", cp.FrameH, opts.FrameH},
+		{"crop region", cp.Crop, opts.Crop},
+		{"number of bounces", cp.NumBounces, opts.NumBounces},
+		{"bounces before applying RR", cp.MinBouncesForRR, opts.MinBouncesForRR},
+		{"seed", cp.Seed, opts.Seed},
+	}
+	for _, spec := range specs {
+		if spec.cp != spec.option {
+			return fmt.Errorf("renderer: checkpoint %s %v does not match the render option value %v", spec.name, spec.cp, spec.option)
+		}
+	}
+
+	region := opts.frameRegion()
+	if len(cp.Accumulator) != int(region.W*region.H) {
+		return fmt.Errorf("renderer: expected checkpoint to contain %d accumulator samples; got %d", region.W*region.H, len(cp.Accumulator))
+	}
+
+	return nil
+}
+
+// Calculate a hash of the scene contents. The hash is used for ensuring that
+// checkpoints are only resumed using the scene that was used for creating them.
+func SceneHash(sc *scene.Scene) (string, error) {
+	hash := sha256.New()
+	err := gob.NewEncoder(hash).Encode(sc)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,295 @@
+package renderer
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestCheckpointReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{FrameW: 4, FrameH: 2, NumBounces: 3, MinBouncesForRR: 2, Seed: 42, SamplesPerPixel: 64}
+	accumulator := make([]types.Vec3, 8)
+	for index := range accumulator {
+		accumulator[index] = types.Vec3{float32(index), 0.5, 2}
+	}
+
+	cp := NewCheckpoint("hash", opts, 16, accumulator)
+	cpFile := filepath.Join(dir, "frame.checkpoint")
+	err = cp.Write(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwriting an existing checkpoint should not leave temporary files behind
+	err = cp.Write(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected checkpoint dir to contain 1 file; got %d", len(files))
+	}
+
+	readCp, err := ReadCheckpoint(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readCp, cp) {
+		t.Fatalf("expected read checkpoint to be:\n%#v\ngot:\n%#v", cp, readCp)
+	}
+
+	_, err = ReadCheckpoint(filepath.Join(dir, "missing.checkpoint"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected to get a not-exists error; got %v", err)
+	}
+
+	// Cropped checkpoints only store the accumulator for the crop region
+	opts.Crop = tracer.Tile{X: 1, Y: 1, W: 2, H: 1}
+	cp = NewCheckpoint("hash", opts, 16, accumulator[:2])
+	err = cp.Write(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCp, err = ReadCheckpoint(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readCp, cp) {
+		t.Fatalf("expected read checkpoint to be:\n%#v\ngot:\n%#v", cp, readCp)
+	}
+
+	cp.Accumulator = accumulator
+	if err = cp.Write(cpFile); err == nil {
+		t.Fatal("expected writing a checkpoint with extra accumulator samples to fail")
+	}
+}
+
+func TestCheckpointFileFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{FrameW: 2, FrameH: 1, Crop: tracer.Tile{W: 2, H: 1}, NumBounces: 3, MinBouncesForRR: 2, Seed: 42}
+	cp := NewCheckpoint(strings.Repeat("ab", 32), opts, 16, []types.Vec3{{1, 2, 3}, {4, 5, 6}})
+	cpFile := filepath.Join(dir, "frame.pacc")
+	err = cp.Write(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(cpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 112+2*12 {
+		t.Fatalf("expected accumulator file to contain %d bytes; got %d", 112+2*12, len(data))
+	}
+
+	if string(data[0:4]) != "PACC" {
+		t.Fatalf("expected file to start with the PACC magic value; got %q", string(data[0:4]))
+	}
+	headerFields := []struct {
+		offset int
+		value  uint32
+	}{
+		{4, 1},
+		{8, 2},
+		{12, 1},
+		{16, 0},
+		{20, 0},
+		{24, 2},
+		{28, 1},
+		{32, 3},
+		{36, 2},
+		{40, 42},
+		{44, 16},
+	}
+	for specIndex, spec := range headerFields {
+		if got := binary.LittleEndian.Uint32(data[spec.offset:]); got != spec.value {
+			t.Errorf("[spec %d] expected header value at offset %d to be %d; got %d", specIndex, spec.offset, spec.value, got)
+		}
+	}
+	if string(data[48:112]) != cp.SceneHash {
+		t.Fatalf("expected scene hash at offset 48 to be %q; got %q", cp.SceneHash, string(data[48:112]))
+	}
+	for index := 0; index < 6; index++ {
+		got := math.Float32frombits(binary.LittleEndian.Uint32(data[112+4*index:]))
+		if got != float32(index+1) {
+			t.Errorf("expected accumulator value %d to be %f; got %f", index, float32(index+1), got)
+		}
+	}
+
+	// Reading a file that is not an accumulator file should fail
+	err = ioutil.WriteFile(cpFile, []byte("not an accumulator file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadCheckpoint(cpFile); err == nil {
+		t.Fatal("expected reading an invalid accumulator file to fail")
+	}
+
+	// Reading a truncated file should fail
+	err = ioutil.WriteFile(cpFile, data[:len(data)-4], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadCheckpoint(cpFile); err == nil {
+		t.Fatal("expected reading a truncated accumulator file to fail")
+	}
+
+	// Reading a file with trailing data should fail
+	err = ioutil.WriteFile(cpFile, append(append([]byte(nil), data...), 0, 0, 0, 0), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadCheckpoint(cpFile); err == nil {
+		t.Fatal("expected reading an accumulator file with trailing data to fail")
+	}
+
+	// Reading a file whose header contains invalid dimensions or does not
+	// match the file size should fail before allocating the accumulator
+	invalidHeaders := []struct {
+		fields map[int]uint32
+		expErr string
+	}{
+		{map[int]uint32{8: 0}, "invalid frame dimensions"},
+		{map[int]uint32{12: 0xffffffff}, "invalid frame dimensions"},
+		{map[int]uint32{8: 1}, "invalid crop region"},
+		{map[int]uint32{16: 0xffffffff}, "invalid crop region"},
+		{map[int]uint32{24: 0}, "invalid crop region"},
+		{map[int]uint32{8: 30000, 12: 30000, 24: 0, 28: 0}, "expected accumulator file"},
+	}
+	for specIndex, spec := range invalidHeaders {
+		corrupted := append([]byte(nil), data...)
+		for offset, value := range spec.fields {
+			binary.LittleEndian.PutUint32(corrupted[offset:], value)
+		}
+		err = ioutil.WriteFile(cpFile, corrupted, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ReadCheckpoint(cpFile); err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestCheckpointMerge(t *testing.T) {
+	opts := Options{FrameW: 2, FrameH: 1, NumBounces: 3, MinBouncesForRR: 2, Seed: 1}
+	cp := NewCheckpoint("hash", opts, 4, []types.Vec3{{4, 4, 4}, {0, 4, 8}})
+
+	otherOpts := opts
+	otherOpts.Seed = 2
+	other := NewCheckpoint("hash", otherOpts, 12, []types.Vec3{{24, 24, 24}, {12, 0, 0}})
+
+	err := cp.Merge(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.AccumulatedSamples != 16 {
+		t.Fatalf("expected merged checkpoint to contain 16 spp; got %d", cp.AccumulatedSamples)
+	}
+	expAccumulator := []types.Vec3{{28, 28, 28}, {12, 4, 8}}
+	if !reflect.DeepEqual(cp.Accumulator, expAccumulator) {
+		t.Fatalf("expected merged accumulator to be %v; got %v", expAccumulator, cp.Accumulator)
+	}
+
+	other.SceneHash = "other"
+	if err = cp.Merge(other); err != ErrCheckpointSceneMismatch {
+		t.Fatalf("expected to get ErrCheckpointSceneMismatch; got %v", err)
+	}
+
+	other.SceneHash = "hash"
+	other.NumBounces = 4
+	if err = cp.Merge(other); err == nil {
+		t.Fatal("expected merging checkpoints with a different number of bounces to fail")
+	}
+}
+
+func TestCheckpointValidate(t *testing.T) {
+	opts := Options{FrameW: 4, FrameH: 2, NumBounces: 3, MinBouncesForRR: 2, Seed: 42, SamplesPerPixel: 64}
+	cp := NewCheckpoint("hash", opts, 16, make([]types.Vec3, 8))
+
+	// The target samples and options that do not affect the accumulated
+	// samples may be changed when resuming
+	resumeOpts := opts
+	resumeOpts.SamplesPerPixel = 128
+	resumeOpts.Exposure = 2
+	resumeOpts.UseCpuTracer = true
+	if err := cp.Validate("hash", resumeOpts); err != nil {
+		t.Fatalf("expected checkpoint to be valid; got %v", err)
+	}
+
+	if err := cp.Validate("other", opts); err != ErrCheckpointSceneMismatch {
+		t.Fatalf("expected to get ErrCheckpointSceneMismatch; got %v", err)
+	}
+
+	specs := []func(opts *Options){
+		func(opts *Options) { opts.FrameW = 8 },
+		func(opts *Options) { opts.FrameH = 8 },
+		func(opts *Options) { opts.Crop = tracer.Tile{X: 1, W: 2, H: 2} },
+		func(opts *Options) { opts.NumBounces = 4 },
+		func(opts *Options) { opts.MinBouncesForRR = 4 },
+		func(opts *Options) { opts.Seed = 1 },
+	}
+	for specIndex, spec := range specs {
+		mismatchOpts := opts
+		spec(&mismatchOpts)
+		if err := cp.Validate("hash", mismatchOpts); err == nil {
+			t.Errorf("[spec %d] expected validation to fail", specIndex)
+		}
+	}
+
+	cp.Accumulator = cp.Accumulator[1:]
+	if err := cp.Validate("hash", opts); err == nil {
+		t.Fatal("expected validation to fail for a checkpoint with missing accumulator samples")
+	}
+}
+
+func TestSceneHash(t *testing.T) {
+	sc := &scene.Scene{
+		VertexList: []types.Vec4{{0, 1, 2, 0}},
+		Camera:     scene.NewCamera(45),
+	}
+
+	hash, err := SceneHash(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := SceneHash(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hash2 {
+		t.Fatalf("expected hashing the same scene to return the same hash; got %q and %q", hash, hash2)
+	}
+
+	sc.VertexList[0][1] = 2
+	hash2, err = SceneHash(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == hash2 {
+		t.Fatal("expected hashing a modified scene to return a different hash")
+	}
+}
@@ -1,8 +1,8 @@
 package renderer
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
@@ -10,10 +10,42 @@ import (
 	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/log"
 	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
 	"github.com/achilleasa/polaris/tracer/opencl"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/achilleasa/polaris/tracer/remote"
+	"github.com/achilleasa/polaris/types"
 )
 
+// A block request for a scheduled tile.
+type blockJob struct {
+	// The tracer that should process the job or -1 if the job is
+	// placed in the shared job queue.
+	trIndex int
+
+	tile     tracer.Tile
+	blockReq tracer.BlockRequest
+}
+
+// The outcome of processing a block job.
+type blockJobResult struct {
+	trIndex    int
+	tile       tracer.Tile
+	renderTime time.Duration
+	mergeTime  time.Duration
+
+	// Errors reported by the tracer that processed the block and by the
+	// primary tracer while merging the block output into its accumulator.
+	traceErr error
+	mergeErr error
+
+	// Set if the worker exited after reporting this result.
+	workerExited bool
+
+	// A copy of the tracer statistics after processing and merging the block.
+	traceStats tracer.Stats
+}
+
 type defaultRenderer struct {
 	logger log.Logger
 
@@ -25,17 +57,32 @@ type defaultRenderer struct {
 
 	// The list of registered tracers.
 	tracers         []tracer.Tracer
-	jobChans        []chan tracer.BlockRequest
-	jobCompleteChan chan error
+	jobChans        []chan blockJob
+	jobCompleteChan chan blockJobResult
+
+	// A job queue shared by all workers; used by schedulers that
+	// implement tracer.SharedQueueScheduler.
+	sharedJobChan chan blockJob
 
 	// The selected primary tracer.
 	primary int
 
+	// Tracers that failed and were removed from the tracer pool.
+	deadTracers []bool
+
 	// The scheduler for distributing blocks to the list of tracers.
 	scheduler tracer.BlockScheduler
 
-	// The block assignments generated by the scheduler
-	blockAssignments []uint32
+	// The tiles processed for the last frame, the index of the tracer
+	// that processed each tile and the number of samples it rendered.
+	tileAssignments []tracer.Tile
+	tileTracers     []int
+	tileSamples     []uint32
+
+	// The number of started frames. It is combined with the seed option
+	// so that each frame uses a different set of random numbers. Passes
+	// that accumulate samples on top of the current frame reuse its seed.
+	frameIndex uint32
 
 	// Renderer statistics.
 	stats FrameStats
@@ -49,6 +96,12 @@ func NewDefault(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *open
 		return nil, ErrCameraNotDefined
 	}
 
+	if crop := opts.Crop; crop != (tracer.Tile{}) {
+		if crop.W == 0 || crop.H == 0 || crop.X+crop.W > opts.FrameW || crop.Y+crop.H > opts.FrameH {
+			return nil, ErrInvalidCrop
+		}
+	}
+
 	r := &defaultRenderer{
 		logger:    log.New("renderer"),
 		scheduler: scheduler,
@@ -59,27 +112,43 @@ func NewDefault(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *open
 	if err != nil {
 		return nil, err
 	}
-	r.jobChans = make([]chan tracer.BlockRequest, len(r.tracers))
-	r.jobCompleteChan = make(chan error, 0)
+
+	r.start(sc)
+	return r, nil
+}
+
+// Select the primary tracer, upload the scene to the registered tracers and
+// start a worker for each tracer.
+func (r *defaultRenderer) start(sc *scene.Scene) {
+	// If no primary tracer selected, pick the local GPU with max estimated speed
+	r.deadTracers = make([]bool, len(r.tracers))
+	if r.primary == -1 {
+		r.selectPrimary()
+	}
+
+	r.stats.Tracers[r.primary].IsPrimary = true
+	r.logger.Noticef("selected %q as primary device", r.tracers[r.primary].Id())
+
+	r.jobChans = make([]chan blockJob, len(r.tracers))
+	r.jobCompleteChan = make(chan blockJobResult, 0)
+	r.sharedJobChan = make(chan blockJob, 0)
 
 	// Start workers
 	r.workerInitGroup.Add(len(r.tracers))
 	r.workerCloseGroup.Add(len(r.tracers))
 	for trIndex := 0; trIndex < len(r.tracers); trIndex++ {
 		// Queue state changes
-		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{opts.FrameW, opts.FrameH})
+		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{r.options.FrameW, r.options.FrameH})
 		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.SceneData, sc)
 		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.CameraData, sc.Camera)
 
 		// Start worker
-		r.jobChans[trIndex] = make(chan tracer.BlockRequest, 0)
-		go r.jobWorker(trIndex)
+		r.jobChans[trIndex] = make(chan blockJob, 0)
+		go r.jobWorker(trIndex, r.jobChans[trIndex])
 	}
 
 	// wait for all workers to start
 	r.workerInitGroup.Wait()
-
-	return r, nil
 }
 
 // Get last frame stats.
@@ -90,30 +159,95 @@ func (r *defaultRenderer) Stats() FrameStats {
 // Shutdown renderer and any attached tracers.
 func (r *defaultRenderer) Close() {
 	for _, ch := range r.jobChans {
-		close(ch)
+		if ch != nil {
+			close(ch)
+		}
 	}
 
 	r.workerCloseGroup.Wait()
 }
 
+// Queue a camera update for all attached tracers.
+func (r *defaultRenderer) UpdateCamera(camera *scene.Camera) {
+	for trIndex, tr := range r.tracers {
+		if !r.deadTracers[trIndex] {
+			tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+		}
+	}
+}
+
 // Render next frame.
 func (r *defaultRenderer) Render() error {
-	return r.renderFrame(0)
+	return r.renderFrame(context.Background(), 0, r.options.SamplesPerPixel)
+}
+
+// Render next frame and stop early if the context is cancelled.
+func (r *defaultRenderer) RenderContext(ctx context.Context) error {
+	return r.renderFrame(ctx, 0, r.options.SamplesPerPixel)
+}
+
+// Render an additional pass for the current frame and accumulate it on top
+// of the samples rendered by the previous passes.
+func (r *defaultRenderer) RenderPass(ctx context.Context, accumulatedSamples, samplesPerPixel uint32) error {
+	return r.renderFrame(ctx, accumulatedSamples, samplesPerPixel)
+}
+
+// Read the frame accumulator contents of the primary tracer.
+func (r *defaultRenderer) ReadFrameAccumulator() ([]types.Vec3, error) {
+	rw, isReadWriter := r.tracers[r.primary].(tracer.FrameAccumulatorReadWriter)
+	if !isReadWriter {
+		return nil, ErrAccumulatorAccessUnsupported
+	}
+
+	blockReq := r.frameRegionRequest()
+	return rw.ReadFrameAccumulator(&blockReq)
+}
+
+// Replace the frame accumulator contents of the primary tracer.
+func (r *defaultRenderer) WriteFrameAccumulator(samples []types.Vec3) error {
+	rw, isReadWriter := r.tracers[r.primary].(tracer.FrameAccumulatorReadWriter)
+	if !isReadWriter {
+		return ErrAccumulatorAccessUnsupported
+	}
+
+	blockReq := r.frameRegionRequest()
+	return rw.WriteFrameAccumulator(&blockReq, samples)
+}
+
+// Create a block request that covers the rendered frame region.
+func (r *defaultRenderer) frameRegionRequest() tracer.BlockRequest {
+	region := r.options.frameRegion()
+	return tracer.BlockRequest{
+		FrameW: r.options.FrameW,
+		FrameH: r.options.FrameH,
+		BlockX: region.X,
+		BlockY: region.Y,
+		BlockW: region.W,
+		BlockH: region.H,
+	}
 }
 
 // The actual frame implementation. This is intentionally split so it can be
-// used by the opengl renderer.
-func (r *defaultRenderer) renderFrame(accumulatedSamples uint32) error {
+// used by the opengl renderer. If the context is cancelled, the remaining
+// blocks are still scheduled but tracers stop after their current sample so
+// that the frame can be finalized with the samples rendered so far.
+func (r *defaultRenderer) renderFrame(ctx context.Context, accumulatedSamples, samplesPerPixel uint32) error {
+	if accumulatedSamples == 0 || r.frameIndex == 0 {
+		r.frameIndex++
+	}
+
 	var blockReq = tracer.BlockRequest{
 		FrameW:             r.options.FrameW,
 		FrameH:             r.options.FrameH,
-		BlockW:             r.options.FrameW,
-		SamplesPerPixel:    r.options.SamplesPerPixel,
+		SamplesPerPixel:    samplesPerPixel,
 		Exposure:           r.options.Exposure,
 		NumBounces:         r.options.NumBounces,
 		MinBouncesForRR:    r.options.MinBouncesForRR,
 		AccumulatedSamples: accumulatedSamples,
-		Seed:               rand.Uint32(),
+		Seed:               tracer.DeriveSeed(r.options.Seed, r.frameIndex-1),
+		CaptureAOVs:        r.options.CaptureAOVs,
+		CountRays:          r.options.CountRays,
+		Cancel:             ctx.Done(),
 	}
 
 	// If running in progressive mode we need to capture a single sample
@@ -122,81 +256,517 @@ func (r *defaultRenderer) renderFrame(accumulatedSamples uint32) error {
 	}
 
 	start := time.Now()
+	r.notify(&FrameStartEvent{
+		FrameW:             blockReq.FrameW,
+		FrameH:             blockReq.FrameH,
+		AccumulatedSamples: blockReq.AccumulatedSamples,
+		SamplesPerPixel:    blockReq.SamplesPerPixel,
+	})
+
+	r.stats.FailedTracers = nil
+	r.stats.PrimaryChanged = false
+	r.stats.Cancelled = false
+
+	// If the primary tracer fails, the frame accumulator contents are lost
+	// so we need to render the frame from scratch using the new primary.
+	for {
+		// Reset tracer stats
+		for trIndex := range r.stats.Tracers {
+			stat := &r.stats.Tracers[trIndex]
+			*stat = TracerStat{Id: stat.Id, Flags: stat.Flags, Speed: stat.Speed, IsPrimary: stat.IsPrimary, Dead: stat.Dead, Err: stat.Err}
+		}
+
+		primaryLost, err := r.processTiles(blockReq)
+		if err != nil {
+			return err
+		}
+
+		if !primaryLost {
+			break
+		}
+
+		blockReq.AccumulatedSamples = 0
+		r.stats.PrimaryChanged = true
+	}
+
+	// Post-process filters weigh the frame samples using the number of
+	// samples rendered for this frame.
+	r.stats.RenderedSamples = blockReq.SamplesPerPixel
+	if r.stats.Cancelled {
+		r.stats.RenderedSamples = r.equalizeTileSamples(blockReq)
+	}
+	blockReq.SamplesPerPixel = r.stats.RenderedSamples
 
-	// Schedule blocks and process them in parallel
-	r.blockAssignments = r.scheduler.Schedule(r.tracers, blockReq.FrameH)
-	for trIndex, blockH := range r.blockAssignments {
-		blockReq.BlockH = blockH
-		r.jobChans[trIndex] <- blockReq
+	// Run post-process filters on the primary tracer
+	region := r.options.frameRegion()
+	blockReq.BlockX, blockReq.BlockY = region.X, region.Y
+	blockReq.BlockW, blockReq.BlockH = region.W, region.H
+	primaryStats := r.tracers[r.primary].Stats()
+	kernelTimes := primaryStats.KernelTimes
+	r.tracers[r.primary].SyncFramebuffer(&blockReq)
+	for _, kernel := range []tracer.Kernel{tracer.FilterKernel, tracer.TonemapKernel} {
+		r.stats.Tracers[r.primary].KernelTimes[kernel] += primaryStats.KernelTimes[kernel] - kernelTimes[kernel]
+	}
 
-		r.stats.Tracers[trIndex].BlockH = blockH
-		r.stats.Tracers[trIndex].FramePercent = 100.0 * float32(blockH) / float32(blockReq.FrameH)
+	r.stats.RenderTime = time.Since(start)
 
-		blockReq.BlockY += blockH
+	accumulatedSamples = blockReq.AccumulatedSamples + blockReq.SamplesPerPixel
+	stats := r.stats
+	stats.Tracers = append([]TracerStat(nil), r.stats.Tracers...)
+	r.notify(&FrameEndEvent{AccumulatedSamples: accumulatedSamples, Stats: stats})
+	if milestone := sampleMilestone(blockReq.AccumulatedSamples, accumulatedSamples); milestone != 0 {
+		r.notify(&SampleMilestoneEvent{Milestone: milestone, AccumulatedSamples: accumulatedSamples})
 	}
 
-	var tot uint32 = 0
-	for _, bh := range r.blockAssignments {
-		tot += bh
+	return ctx.Err()
+}
+
+// Scale the primary tracer's frame output for tiles that rendered more samples
+// than the tile with the fewest rendered samples so that all tiles have the
+// same sample weight. This is required when a frame is cancelled as tracers
+// stop rendering their tiles at different samples. Returns the lowest number
+// of samples rendered for any tile.
+func (r *defaultRenderer) equalizeTileSamples(blockReq tracer.BlockRequest) uint32 {
+	minSamples := blockReq.SamplesPerPixel
+	for _, samples := range r.tileSamples {
+		if samples < minSamples {
+			minSamples = samples
+		}
+	}
+
+	scaler, isScaler := r.tracers[r.primary].(tracer.FrameOutputScaler)
+	if !isScaler {
+		r.logger.Warningf("primary tracer %q does not support scaling its frame output; cancelled frame blocks may not be weighted correctly", r.tracers[r.primary].Id())
+		return minSamples
 	}
 
-	// Wait for all tracers to finish
-	pending := len(r.tracers)
-	for pending != 0 {
-		err, ok := <-r.jobCompleteChan
-		if !ok {
-			err = ErrInterrupted
+	// The frame accumulator contains the samples from previous passes
+	// so tiles are scaled using their total sample count.
+	for tileIndex, samples := range r.tileSamples {
+		if samples == minSamples {
+			continue
 		}
 
-		if err != nil {
-			return err
+		tile := r.tileAssignments[tileIndex]
+		blockReq.BlockX, blockReq.BlockY = tile.X, tile.Y
+		blockReq.BlockW, blockReq.BlockH = tile.W, tile.H
+		scale := float32(blockReq.AccumulatedSamples+minSamples) / float32(blockReq.AccumulatedSamples+samples)
+		if _, err := scaler.ScaleFrameOutput(&blockReq, scale); err != nil {
+			r.logger.Warningf("could not scale frame output for tile %v: %v", tile, err)
+		}
+	}
+
+	return minSamples
+}
+
+// Schedule the frame tiles, process them in parallel and wait for all tracers
+// to finish. If a tracer fails, it is removed from the tracer pool and its
+// tiles are rebalanced across the surviving tracers. This method returns true
+// if the primary tracer failed and a new primary tracer was selected.
+func (r *defaultRenderer) processTiles(blockReq tracer.BlockRequest) (bool, error) {
+	r.tileAssignments = r.tileAssignments[:0]
+	r.tileTracers = r.tileTracers[:0]
+	r.tileSamples = r.tileSamples[:0]
+
+	queue := r.scheduleTiles(r.scheduler, r.options.frameRegion())
+	r.notifySchedule(queue, "")
+	inFlight := 0
+	primaryLost := false
+	primaryWorkerExited := false
+	for len(queue) != 0 || inFlight != 0 {
+		// A nil job channel blocks forever so we only wait for completed
+		// jobs once all tiles have been dispatched. If the primary tracer
+		// has failed we stop dispatching jobs and wait for the in-flight
+		// jobs to complete.
+		var jobChan chan blockJob
+		var job blockJob
+		if len(queue) != 0 && !primaryLost {
+			job = queue[0]
+			job.blockReq = blockReq
+			job.blockReq.BlockX, job.blockReq.BlockY = job.tile.X, job.tile.Y
+			job.blockReq.BlockW, job.blockReq.BlockH = job.tile.W, job.tile.H
+
+			if job.trIndex == -1 {
+				jobChan = r.sharedJobChan
+			} else {
+				jobChan = r.jobChans[job.trIndex]
+			}
+		} else if inFlight == 0 {
+			break
+		}
+
+		select {
+		case jobChan <- job:
+			queue = queue[1:]
+			inFlight++
+		case res, ok := <-r.jobCompleteChan:
+			if !ok {
+				return false, ErrInterrupted
+			}
+			inFlight--
+			if res.trIndex == r.primary && res.workerExited {
+				primaryWorkerExited = true
+			}
+
+			if res.traceErr == nil && res.mergeErr == nil {
+				r.collectJobStats(res)
+				if blockReq.Cancelled() && res.traceStats.RenderedSamples < blockReq.SamplesPerPixel {
+					r.stats.Cancelled = true
+				}
+				continue
+			}
+
+			// Merge failures are caused by the primary tracer; the
+			// tracer that rendered the block remains in the pool.
+			if res.traceErr == nil {
+				if !r.deadTracers[r.primary] {
+					r.markTracerDead(r.primary, res.mergeErr)
+				}
+				primaryLost = true
+				continue
+			}
+
+			if !r.deadTracers[res.trIndex] {
+				r.markTracerDead(res.trIndex, res.traceErr)
+			}
+			if res.trIndex == r.primary {
+				primaryLost = true
+				continue
+			}
+
+			// Rebalance the failed tile and any queued tiles assigned
+			// to the failed tracer across the surviving tracers.
+			rebalance := []tracer.Tile{res.tile}
+			pending := queue[:0]
+			for _, queuedJob := range queue {
+				if queuedJob.trIndex == res.trIndex {
+					rebalance = append(rebalance, queuedJob.tile)
+				} else {
+					pending = append(pending, queuedJob)
+				}
+			}
+			queue = pending
+			rebalanceScheduler := r.rebalanceScheduler()
+			var rescheduled []blockJob
+			for _, tile := range rebalance {
+				rescheduled = append(rescheduled, r.scheduleTiles(rebalanceScheduler, tile)...)
+			}
+			queue = append(queue, rescheduled...)
+			r.notifySchedule(rescheduled, r.tracers[res.trIndex].Id())
 		}
+	}
 
-		pending--
+	if !primaryLost {
+		return false, nil
 	}
 
-	// Run post-process filters on the primary tracer
-	blockReq.BlockY = 0
-	blockReq.BlockH = blockReq.FrameH
-	r.tracers[r.primary].SyncFramebuffer(&blockReq)
+	// Now that no jobs are in flight it is safe to close the failed primary.
+	// If the primary failed while merging the output of another tracer its
+	// worker is still running and needs to be stopped first.
+	if !primaryWorkerExited {
+		close(r.jobChans[r.primary])
+		r.jobChans[r.primary] = nil
+	}
+	r.tracers[r.primary].Close()
+	r.stats.Tracers[r.primary].IsPrimary = false
+	if !r.selectPrimary() {
+		for _, dead := range r.deadTracers {
+			if !dead {
+				return true, ErrPrimaryLost
+			}
+		}
+		return true, ErrNoTracers
+	}
+	r.stats.Tracers[r.primary].IsPrimary = true
+	r.logger.Noticef("promoted %q to primary device", r.tracers[r.primary].Id())
+	r.notify(&PrimaryChangedEvent{Tracer: r.tracers[r.primary].Id()})
 
-	r.stats.RenderTime = time.Since(start)
+	return true, nil
+}
 
-	// Collect stats
+// Split a frame region into tiles using the supplied block scheduler and create
+// a job for each tile. Unless the scheduler uses a shared queue, each tile is
+// assigned to the surviving tracer with the same index.
+func (r *defaultRenderer) scheduleTiles(scheduler tracer.BlockScheduler, region tracer.Tile) []blockJob {
+	liveTracers := make([]tracer.Tracer, 0, len(r.tracers))
+	liveIndices := make([]int, 0, len(r.tracers))
 	for trIndex, tr := range r.tracers {
-		r.stats.Tracers[trIndex].RenderTime = tr.Stats().RenderTime
+		if !r.deadTracers[trIndex] {
+			liveTracers = append(liveTracers, tr)
+			liveIndices = append(liveIndices, trIndex)
+		}
 	}
 
-	return nil
+	_, sharedQueue := scheduler.(tracer.SharedQueueScheduler)
+	tiles := scheduler.Schedule(liveTracers, region.W, region.H)
+	jobs := make([]blockJob, 0, len(tiles))
+	for tileIndex, tile := range tiles {
+		// Skip empty tiles
+		if tile.W == 0 || tile.H == 0 {
+			continue
+		}
+
+		tile.X += region.X
+		tile.Y += region.Y
+
+		trIndex := -1
+		if !sharedQueue {
+			trIndex = liveIndices[tileIndex]
+		}
+		jobs = append(jobs, blockJob{trIndex: trIndex, tile: tile})
+	}
+
+	return jobs
 }
 
-// A tracing job processor.
-func (r *defaultRenderer) jobWorker(trIndex int) {
-	r.workerInitGroup.Done()
-	defer func() {
+// Get a scheduler for rebalancing the tiles of a failed tracer. Schedulers
+// cache their assignments so a new instance is used to avoid overwriting the
+// assignment for the entire frame with the assignment for a single tile.
+func (r *defaultRenderer) rebalanceScheduler() tracer.BlockScheduler {
+	if sch, isShared := r.scheduler.(tracer.SharedQueueScheduler); isShared {
+		return tracer.WorkStealingScheduler(sch.TileSize())
+	}
+
+	return tracer.NaiveScheduler()
+}
+
+// Remove a failed tracer from the tracer pool. Tracers other than the primary
+// are closed immediately; the primary tracer is closed by processTiles once
+// no other tracer is merging its output into the primary's accumulator.
+func (r *defaultRenderer) markTracerDead(trIndex int, err error) {
+	r.deadTracers[trIndex] = true
+	r.stats.Tracers[trIndex].Dead = true
+	r.stats.Tracers[trIndex].Err = err
+	r.stats.FailedTracers = append(r.stats.FailedTracers, r.tracers[trIndex].Id())
+	r.logger.Warningf("device %q failed: %v; removing it from the device pool", r.tracers[trIndex].Id(), err)
+	r.notify(&TracerFailedEvent{Tracer: r.tracers[trIndex].Id(), Err: err})
+
+	if trIndex != r.primary {
 		r.tracers[trIndex].Close()
-		r.workerCloseGroup.Done()
-	}()
+	}
+}
 
+// Update the stats for the tracer that processed a block job.
+func (r *defaultRenderer) collectJobStats(res blockJobResult) {
+	r.tileAssignments = append(r.tileAssignments, res.tile)
+	r.tileTracers = append(r.tileTracers, res.trIndex)
+	r.tileSamples = append(r.tileSamples, res.traceStats.RenderedSamples)
+
+	stat := &r.stats.Tracers[res.trIndex]
+	stat.BlockX, stat.BlockY = res.tile.X, res.tile.Y
+	stat.BlockW, stat.BlockH = res.tile.W, res.tile.H
+	stat.NumBlocks++
+	region := r.options.frameRegion()
+	stat.FramePercent += 100.0 * float32(res.tile.W*res.tile.H) / float32(region.W*region.H)
+	stat.RenderTime += res.renderTime
+	stat.UpdateTime += res.traceStats.UpdateTime
+	stat.MergeTime += res.mergeTime
+	stat.PrimaryRayTime += res.traceStats.PrimaryRayTime
+	stat.IntegratorTime += res.traceStats.IntegratorTime
+	stat.PrimaryRays += res.traceStats.PrimaryRays
+	stat.IndirectRays += res.traceStats.IndirectRays
+	stat.OcclusionRays += res.traceStats.OcclusionRays
+	stat.KernelTimes.Add(res.traceStats.KernelTimes)
+
+	r.notify(&BlockCompleteEvent{
+		Tracer:     r.tracers[res.trIndex].Id(),
+		Tile:       res.tile,
+		RenderTime: res.renderTime,
+	})
+}
+
+// Notify observers about the tiles assigned by the scheduler.
+func (r *defaultRenderer) notifySchedule(jobs []blockJob, failedTracer string) {
+	if len(r.options.Observers) == 0 {
+		return
+	}
+
+	ev := &ScheduleEvent{
+		Tiles:        make([]tracer.Tile, len(jobs)),
+		FailedTracer: failedTracer,
+	}
+	for jobIndex, job := range jobs {
+		ev.Tiles[jobIndex] = job.tile
+		if job.trIndex != -1 {
+			ev.Tracers = append(ev.Tracers, r.tracers[job.trIndex].Id())
+		}
+	}
+	r.notify(ev)
+}
+
+// Send an event to all registered observers.
+func (r *defaultRenderer) notify(ev Event) {
+	for _, observer := range r.options.Observers {
+		observer.OnEvent(ev)
+	}
+}
+
+// A tracing job processor. If the worker tracer fails, the worker exits without
+// closing its tracer; the renderer is responsible for closing failed tracers.
+// Merge errors are reported separately as they are caused by the primary tracer.
+func (r *defaultRenderer) jobWorker(trIndex int, jobChan <-chan blockJob) {
+	r.workerInitGroup.Done()
+	defer r.workerCloseGroup.Done()
+
+	var job blockJob
+	var ok bool
 	for {
 		select {
-		case blockReq, ok := <-r.jobChans[trIndex]:
+		case job, ok = <-jobChan:
 			if !ok {
+				// Failed tracers are closed by the renderer
+				if !r.deadTracers[trIndex] {
+					r.tracers[trIndex].Close()
+				}
 				return
 			}
+		case job = <-r.sharedJobChan:
+		}
+
+		var mergeTime time.Duration
+		var mergeErr error
+		renderTime, traceErr := r.tracers[trIndex].Trace(&job.blockReq)
+		if traceErr == nil {
+			// Merge trace accumulator output for this block with primary tracer's frame accumulator
+			mergeTime, mergeErr = r.tracers[r.primary].MergeOutput(r.tracers[trIndex], &job.blockReq)
+		}
+		traceStats := *r.tracers[trIndex].Stats()
+		exit := traceErr != nil || (mergeErr != nil && trIndex == r.primary)
+		r.jobCompleteChan <- blockJobResult{
+			trIndex:      trIndex,
+			tile:         job.tile,
+			renderTime:   renderTime,
+			mergeTime:    mergeTime,
+			traceErr:     traceErr,
+			mergeErr:     mergeErr,
+			workerExited: exit,
+			traceStats:   traceStats,
+		}
+
+		if exit {
+			return
+		}
+	}
+}
+
+// Initialize the tracers used for rendering. Unless the CPU tracer is
+// explicitly requested, the renderer will try to use the available opencl
+// devices and fall back to the CPU tracer if none of them can be initialized.
+func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
+	r.tracers = make([]tracer.Tracer, 0)
+	r.stats.Tracers = make([]TracerStat, 0)
+	r.primary = -1
 
-			_, err := r.tracers[trIndex].Trace(&blockReq)
-			if err == nil {
-				// Merge trace accumulator output for this pass with primary tracer's frame accumulator
-				_, err = r.tracers[r.primary].MergeOutput(r.tracers[trIndex], &blockReq)
+	if !r.options.UseCpuTracer {
+		err := r.initOpenCLTracers(pipeline)
+		if err != nil {
+			r.logger.Warningf("could not init opencl devices: %v", err)
+		}
+	}
+
+	if len(r.tracers) == 0 {
+		if !r.options.UseCpuTracer {
+			r.logger.Notice("no opencl devices available; falling back to CPU tracer")
+		}
+
+		err := r.initCpuTracer()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.tracers) == 0 {
+		return ErrNoTracers
+	}
+
+	// Remote tracers are added after the local tracers so the fallback
+	// primary selection always picks a local tracer.
+	for _, addr := range r.options.RemoteWorkers {
+		err := r.initRemoteTracer(addr)
+		if err != nil {
+			r.logger.Warningf("could not connect to remote worker %q: %v", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// Select the local GPU with max estimated speed as the primary tracer. If no
+// GPU is available, the first available local tracer is selected instead.
+// Remote and failed tracers are never selected. Returns false if no suitable
+// tracer could be found.
+func (r *defaultRenderer) selectPrimary() bool {
+	r.primary = -1
+	var bestSpeed uint32 = 0
+	for trIndex, tr := range r.tracers {
+		if !r.deadTracers[trIndex] && ((tr.Flags() & (tracer.CpuDevice | tracer.Remote)) == 0) && tr.Speed() > bestSpeed {
+			bestSpeed = tr.Speed()
+			r.primary = trIndex
+		}
+	}
+
+	// If we still haven't found a primary device just select the first available
+	if r.primary == -1 {
+		for trIndex, tr := range r.tracers {
+			if !r.deadTracers[trIndex] && (tr.Flags()&tracer.Remote) == 0 {
+				r.primary = trIndex
+				break
 			}
-			r.jobCompleteChan <- err
 		}
 	}
+
+	return r.primary != -1
+}
+
+// Create and initialize a CPU tracer.
+func (r *defaultRenderer) initCpuTracer() error {
+	pipeline := r.options.CpuPipeline
+	if pipeline == nil {
+		pipeline = cpu.DefaultPipeline()
+	}
+
+	tr, err := cpu.NewTracer(fmt.Sprintf("CPU tracer (%d)", len(r.tracers)), 0, pipeline)
+	if err == nil {
+		err = tr.Init()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.logger.Noticef("using device %q", tr.Id())
+	r.addTracer(tr)
+	return nil
+}
+
+// Create and initialize a tracer connected to the remote worker at addr.
+func (r *defaultRenderer) initRemoteTracer(addr string) error {
+	tr, err := remote.NewTracer(fmt.Sprintf("%s (%d)", addr, len(r.tracers)), addr, r.options.RemoteTimeout)
+	if err == nil {
+		err = tr.Init()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.logger.Noticef("using remote device %q", tr.Id())
+	r.addTracer(tr)
+	return nil
+}
+
+// Append a tracer to the tracer list and init its statistics.
+func (r *defaultRenderer) addTracer(tr tracer.Tracer) {
+	r.tracers = append(r.tracers, tr)
+	r.stats.Tracers = append(r.stats.Tracers, TracerStat{
+		Id:    tr.Id(),
+		Flags: tr.Flags(),
+		Speed: tr.Speed(),
+	})
 }
 
 // Select and initialize opencl devices excluding the ones which match the blacklist entries.
-func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
+func (r *defaultRenderer) initOpenCLTracers(pipeline *opencl.Pipeline) error {
 	if len(r.options.BlackListedDevices) != 0 {
 		r.logger.Infof("blacklisted devices: %s", strings.Join(r.options.BlackListedDevices, ", "))
 	}
@@ -230,10 +800,6 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 	}
 
 	// Initialize all tracers using the shared context
-	r.tracers = make([]tracer.Tracer, 0)
-	r.stats.Tracers = make([]TracerStat, 0)
-	r.primary = -1
-
 	for _, device := range selectedDevices {
 		// Create and initialize tracer
 		tr, err := opencl.NewTracer(
@@ -253,40 +819,12 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 
 		// If no error occured add to list
 		r.logger.Noticef("using device %q", tr.Id())
-		r.tracers = append(r.tracers, tr)
+		r.addTracer(tr)
 
 		if r.options.ForcePrimaryDevice != "" && strings.Contains(device.Name, r.options.ForcePrimaryDevice) {
 			r.primary = len(r.tracers) - 1
 		}
-
-		// Init statistics
-		r.stats.Tracers = append(r.stats.Tracers, TracerStat{
-			Id: tr.Id(),
-		})
-	}
-
-	if len(r.tracers) == 0 {
-		return ErrNoTracers
-	}
-
-	// If no primary tracer selected, pick the GPU with max estimated speed
-	if r.primary == -1 {
-		var bestSpeed uint32 = 0
-		for trIndex, tr := range r.tracers {
-			if ((tr.Flags() & tracer.CpuDevice) == 0) && tr.Speed() > bestSpeed {
-				bestSpeed = tr.Speed()
-				r.primary = trIndex
-			}
-		}
 	}
 
-	// If we still haven't found a primary device just select the first available
-	if r.primary == -1 {
-		r.primary = 0
-	}
-
-	r.stats.Tracers[r.primary].IsPrimary = true
-	r.logger.Noticef("selected %q as primary device", r.tracers[r.primary].Id())
-
 	return nil
 }
@@ -0,0 +1,532 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestInvalidCrop(t *testing.T) {
+	sc := &scene.Scene{Camera: &scene.Camera{}}
+	crops := []tracer.Tile{
+		{X: 1, Y: 1},
+		{W: 10, H: 0},
+		{X: 5, W: 6, H: 4},
+		{Y: 8, W: 2, H: 1},
+	}
+
+	for index, crop := range crops {
+		opts := Options{FrameW: 10, FrameH: 8, Crop: crop}
+		_, err := NewDefault(sc, tracer.NaiveScheduler(), nil, opts)
+		if err != ErrInvalidCrop {
+			t.Errorf("[crop %d] expected to get ErrInvalidCrop; got %v", index, err)
+		}
+	}
+}
+
+func TestRendererTracerFailure(t *testing.T) {
+	schedulers := []tracer.BlockScheduler{
+		&recordingScheduler{BlockScheduler: tracer.NaiveScheduler()},
+		tracer.WorkStealingScheduler(4, 4),
+	}
+
+	for specIndex, scheduler := range schedulers {
+		gpu := newMockTracer("gpu", tracer.Local, 2)
+		cpu1 := newMockTracer("cpu-1", tracer.Local|tracer.CpuDevice, 1)
+		cpu2 := newMockTracer("cpu-2", tracer.Local|tracer.CpuDevice, 1)
+		cpu1.traceErr = errors.New("device lost")
+
+		r := newMockRenderer(scheduler, Options{FrameW: 12, FrameH: 4, SamplesPerPixel: 1}, gpu, cpu1, cpu2)
+		err := r.Render()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		stats := r.Stats()
+		if len(stats.FailedTracers) != 1 || stats.FailedTracers[0] != cpu1.id {
+			t.Fatalf("[spec %d] expected failed tracers to be [%s]; got %v", specIndex, cpu1.id, stats.FailedTracers)
+		}
+		if stats.PrimaryChanged || r.primary != 0 {
+			t.Fatalf("[spec %d] expected primary tracer not to change", specIndex)
+		}
+		if !cpu1.isClosed() || gpu.isClosed() || cpu2.isClosed() {
+			t.Fatalf("[spec %d] expected only the failed tracer to be closed", specIndex)
+		}
+
+		// The failed tile should be rebalanced across the surviving tracers
+		assertFrameCoverage(t, specIndex, gpu, 12, 4)
+		if cpu1.mergedBy(gpu) != 0 {
+			t.Fatalf("[spec %d] expected no blocks from the failed tracer to be merged", specIndex)
+		}
+
+		// Subsequent frames should only use the surviving tracers
+		gpu.resetMerged()
+		err = r.Render()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+		if failed := r.Stats().FailedTracers; len(failed) != 0 {
+			t.Fatalf("[spec %d] expected no tracer failures for the second frame; got %v", specIndex, failed)
+		}
+		assertFrameCoverage(t, specIndex, gpu, 12, 4)
+		r.Close()
+
+		// Rebalancing should not use the frame scheduler
+		if rec, ok := scheduler.(*recordingScheduler); ok {
+			for _, dims := range rec.calls {
+				if dims != [2]uint32{12, 4} {
+					t.Fatalf("[spec %d] expected frame scheduler to only schedule 12x4 frames; got %v", specIndex, dims)
+				}
+			}
+		}
+	}
+}
+
+func TestRendererPrimaryTraceFailure(t *testing.T) {
+	gpu := newMockTracer("gpu", tracer.Local, 2)
+	cpu1 := newMockTracer("cpu-1", tracer.Local|tracer.CpuDevice, 1)
+	cpu2 := newMockTracer("cpu-2", tracer.Local|tracer.CpuDevice, 1)
+	gpu.traceErr = errors.New("device lost")
+
+	r := newMockRenderer(tracer.NaiveScheduler(), Options{FrameW: 8, FrameH: 8, SamplesPerPixel: 1}, gpu, cpu1, cpu2)
+	defer r.Close()
+	err := r.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := r.Stats()
+	if len(stats.FailedTracers) != 1 || stats.FailedTracers[0] != gpu.id {
+		t.Fatalf("expected failed tracers to be [%s]; got %v", gpu.id, stats.FailedTracers)
+	}
+	if !stats.PrimaryChanged || r.primary != 1 || !stats.Tracers[1].IsPrimary || stats.Tracers[0].IsPrimary {
+		t.Fatalf("expected %q to be promoted to primary; got primary index %d", cpu1.id, r.primary)
+	}
+	if !gpu.isClosed() {
+		t.Fatal("expected failed primary tracer to be closed")
+	}
+
+	// The frame should be rendered from scratch using the new primary
+	assertFrameCoverage(t, 0, cpu1, 8, 8)
+}
+
+func TestRendererPrimaryMergeFailure(t *testing.T) {
+	gpu := newMockTracer("gpu", tracer.Local, 2)
+	cpu1 := newMockTracer("cpu-1", tracer.Local|tracer.CpuDevice, 1)
+	cpu2 := newMockTracer("cpu-2", tracer.Local|tracer.CpuDevice, 1)
+	gpu.mergeErr = errors.New("out of memory")
+
+	r := newMockRenderer(tracer.NaiveScheduler(), Options{FrameW: 8, FrameH: 8, SamplesPerPixel: 1}, gpu, cpu1, cpu2)
+	defer r.Close()
+	err := r.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merge failures should be blamed on the primary tracer
+	stats := r.Stats()
+	if len(stats.FailedTracers) != 1 || stats.FailedTracers[0] != gpu.id {
+		t.Fatalf("expected failed tracers to be [%s]; got %v", gpu.id, stats.FailedTracers)
+	}
+	for trIndex, stat := range stats.Tracers[1:] {
+		if stat.Dead || r.deadTracers[trIndex+1] {
+			t.Fatalf("expected tracer %q not to be marked as dead", stat.Id)
+		}
+	}
+	if !stats.PrimaryChanged || r.primary != 1 {
+		t.Fatalf("expected %q to be promoted to primary; got primary index %d", cpu1.id, r.primary)
+	}
+	if !gpu.isClosed() || cpu1.isClosed() || cpu2.isClosed() {
+		t.Fatal("expected only the failed primary tracer to be closed")
+	}
+	assertFrameCoverage(t, 0, cpu1, 8, 8)
+}
+
+func TestRendererFailoverAccumulator(t *testing.T) {
+	sc := environmentScene()
+	opts := Options{FrameW: 16, FrameH: 8, SamplesPerPixel: 2, NumBounces: 2, MinBouncesForRR: 3, Exposure: 1}
+
+	// Render the reference frame using a single-tracer renderer
+	ref := newTestRenderer(tracer.NaiveScheduler(), opts, sc, newCpuTracer(t, "ref"))
+	err := ref.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expFrame := append([]byte(nil), ref.tracers[ref.primary].(*cpu.Tracer).FrameBuffer()...)
+	ref.Close()
+	if expFrame[0] == 0 || expFrame[0] == 255 {
+		t.Fatalf("expected reference frame to be neither black nor saturated; got %v", expFrame[:4])
+	}
+
+	schedulers := []tracer.BlockScheduler{
+		tracer.NaiveScheduler(),
+		tracer.WorkStealingScheduler(4, 4),
+	}
+	for specIndex, scheduler := range schedulers {
+		failing := newMockTracer("remote", tracer.Local|tracer.CpuDevice, 4)
+		failing.traceErr = errors.New("connection reset")
+
+		r := newTestRenderer(scheduler, opts, sc, newCpuTracer(t, "cpu-1"), failing, newCpuTracer(t, "cpu-2"))
+		err := r.Render()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+		if failed := r.Stats().FailedTracers; len(failed) != 1 || failed[0] != failing.id {
+			t.Fatalf("[spec %d] expected failed tracers to be [%s]; got %v", specIndex, failing.id, failed)
+		}
+		assertFrameBuffer(t, specIndex, r, expFrame)
+
+		// The next frame should replace the previously merged samples
+		err = r.Render()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+		assertFrameBuffer(t, specIndex, r, expFrame)
+		r.Close()
+	}
+}
+
+func TestRendererCancelledFrame(t *testing.T) {
+	gpu := newMockTracer("gpu", tracer.Local, 2)
+	cpu1 := newMockTracer("cpu-1", tracer.Local|tracer.CpuDevice, 1)
+	gpu.cancelledSamples = 3
+	cpu1.cancelledSamples = 1
+
+	var frameEndSamples uint32
+	r := newMockRenderer(tracer.NaiveScheduler(), Options{FrameW: 8, FrameH: 8, SamplesPerPixel: 4}, gpu, cpu1)
+	r.options.Observers = []Observer{ObserverFunc(func(ev Event) {
+		if frameEnd, ok := ev.(*FrameEndEvent); ok {
+			frameEndSamples = frameEnd.AccumulatedSamples
+		}
+	})}
+	defer r.Close()
+
+	// Passes that are not cancelled should not be scaled
+	err := r.RenderPass(context.Background(), 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := r.Stats(); stats.Cancelled || stats.RenderedSamples != 4 || frameEndSamples != 4 {
+		t.Fatalf("expected frame to render 4 samples; got %d (cancelled: %t)", stats.RenderedSamples, stats.Cancelled)
+	}
+	if scaled := gpu.scaledBlocks(); len(scaled) != 0 {
+		t.Fatalf("expected no frame blocks to be scaled; got %v", scaled)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, accumulatedSamples := range []uint32{0, 4} {
+		gpu.resetScaled()
+		err = r.RenderPass(ctx, accumulatedSamples, 4)
+		if err != context.Canceled {
+			t.Fatalf("expected to get context.Canceled; got %v", err)
+		}
+
+		stats := r.Stats()
+		if !stats.Cancelled || stats.RenderedSamples != 1 || frameEndSamples != accumulatedSamples+1 {
+			t.Fatalf("expected cancelled frame to render 1 sample; got %d (cancelled: %t)", stats.RenderedSamples, stats.Cancelled)
+		}
+
+		// Only the blocks rendered by the gpu tracer should be scaled down
+		// to the sample weight of the cpu blocks.
+		expScale := float32(accumulatedSamples+1) / float32(accumulatedSamples+3)
+		var expScaled []tracer.Tile
+		for tileIndex, trIndex := range r.tileTracers {
+			if trIndex == 0 {
+				expScaled = append(expScaled, r.tileAssignments[tileIndex])
+			}
+		}
+		scaled := gpu.scaledBlocks()
+		if len(scaled) != len(expScaled) {
+			t.Fatalf("expected %d scaled blocks; got %d", len(expScaled), len(scaled))
+		}
+		for index, block := range scaled {
+			if block.Tile != expScaled[index] || block.scale != expScale {
+				t.Fatalf("expected block %v to be scaled by %f; got block %v scaled by %f", expScaled[index], expScale, block.Tile, block.scale)
+			}
+		}
+	}
+}
+
+// Create a renderer that uses the supplied mock tracers.
+func newMockRenderer(scheduler tracer.BlockScheduler, opts Options, tracers ...*mockTracer) *defaultRenderer {
+	trList := make([]tracer.Tracer, len(tracers))
+	for index, tr := range tracers {
+		trList[index] = tr
+	}
+	return newTestRenderer(scheduler, opts, &scene.Scene{Camera: &scene.Camera{}}, trList...)
+}
+
+// Create a renderer that uses the supplied tracers to render a scene.
+func newTestRenderer(scheduler tracer.BlockScheduler, opts Options, sc *scene.Scene, tracers ...tracer.Tracer) *defaultRenderer {
+	r := &defaultRenderer{
+		logger:    log.New("renderer"),
+		scheduler: scheduler,
+		options:   opts,
+		primary:   -1,
+	}
+	for _, tr := range tracers {
+		r.addTracer(tr)
+	}
+	r.start(sc)
+
+	return r
+}
+
+// Create and initialize a cpu tracer.
+func newCpuTracer(t *testing.T, id string) tracer.Tracer {
+	tr, err := cpu.NewTracer(id, 1, cpu.DefaultPipeline())
+	if err == nil {
+		err = tr.Init()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// A scene whose geometry lies behind the camera so that every primary ray
+// escapes to a uniform environment light. The rendered output does not
+// depend on the sampled paths and can be compared across renders.
+func environmentScene() *scene.Scene {
+	return &scene.Scene{
+		BvhNodeList: []scene.BvhNode{
+			{Min: types.Vec3{-1, -1, 1}, Max: types.Vec3{1, 1, 1}, LData: 0, RData: 0},
+			{Min: types.Vec3{-1, -1, 1}, Max: types.Vec3{1, 1, 1}, LData: 0, RData: 1},
+		},
+		MeshInstanceList: []scene.MeshInstance{
+			{MeshIndex: 0, BvhRoot: 1, Transform: types.Ident4()},
+		},
+		VertexList:    []types.Vec4{{-1, -1, 1, 1}, {1, -1, 1, 1}, {0, 1, 1, 1}},
+		NormalList:    []types.Vec4{{0, 0, -1, 0}, {0, 0, -1, 0}, {0, 0, -1, 0}},
+		UvList:        []types.Vec2{{0, 0}, {1, 0}, {0, 1}},
+		MaterialIndex: []uint32{0},
+		MaterialNodeList: []scene.MaterialNode{
+			{Union1: [4]int32{int32(material.BxdfDiffuse), 0, 0, -1}, Union2: types.Vec4{0.5, 0.5, 0.5, 0}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.BxdfEmissive), 0, 0, -1}, Union2: types.Vec4{0.1, 0.2, 0.3, 0}, Union4: types.Vec3{0, 0, 1}, Union5: [1]int32{-1}},
+		},
+		EmissivePrimitives:    []scene.EmissivePrimitive{{Type: scene.EnvironmentLight, MaterialNodeIndex: 1}},
+		SceneDiffuseMatIndex:  1,
+		SceneEmissiveMatIndex: 1,
+		Camera: &scene.Camera{
+			Frustrum: scene.Frustrum{
+				{-1, 1, -1, 0},
+				{1, 1, -1, 0},
+				{-1, -1, -1, 0},
+				{1, -1, -1, 0},
+			},
+		},
+	}
+}
+
+// Check that the frame buffer of the primary tracer matches expFrame.
+func assertFrameBuffer(t *testing.T, specIndex int, r *defaultRenderer, expFrame []byte) {
+	frame := r.tracers[r.primary].(*cpu.Tracer).FrameBuffer()
+	frameW := int(r.options.FrameW)
+	for offset := range expFrame {
+		if frame[offset] != expFrame[offset] {
+			pixelIndex := offset / 4
+			t.Fatalf("[spec %d] expected merged pixel (%d, %d) to be %v; got %v", specIndex, pixelIndex%frameW, pixelIndex/frameW, expFrame[pixelIndex*4:pixelIndex*4+4], frame[pixelIndex*4:pixelIndex*4+4])
+		}
+	}
+}
+
+// Check that the blocks merged into the primary tracer cover each frame pixel exactly once.
+func assertFrameCoverage(t *testing.T, specIndex int, primary *mockTracer, frameW, frameH uint32) {
+	coverage := make([]int, frameW*frameH)
+	for _, block := range primary.mergedBlocks() {
+		for y := block.Y; y < block.Y+block.H; y++ {
+			for x := block.X; x < block.X+block.W; x++ {
+				coverage[y*frameW+x]++
+			}
+		}
+	}
+
+	for pixel, count := range coverage {
+		if count != 1 {
+			t.Fatalf("[spec %d] expected pixel (%d, %d) to be merged once; got %d", specIndex, uint32(pixel)%frameW, uint32(pixel)/frameW, count)
+		}
+	}
+}
+
+// A block scheduler that records the frame dimensions passed to Schedule.
+type recordingScheduler struct {
+	tracer.BlockScheduler
+	calls [][2]uint32
+}
+
+func (sch *recordingScheduler) Schedule(tracers []tracer.Tracer, frameW, frameH uint32) []tracer.Tile {
+	sch.calls = append(sch.calls, [2]uint32{frameW, frameH})
+	return sch.BlockScheduler.Schedule(tracers, frameW, frameH)
+}
+
+type mergedBlock struct {
+	tracer.Tile
+	from *mockTracer
+}
+
+type scaledBlock struct {
+	tracer.Tile
+	scale float32
+}
+
+type mockTracer struct {
+	sync.Mutex
+
+	id    string
+	flags tracer.Flag
+	speed uint32
+	stats tracer.Stats
+
+	// Errors returned by Trace and by MergeOutput when merging the output
+	// of other tracers.
+	traceErr error
+	mergeErr error
+
+	// If non-zero, the number of samples rendered for cancelled block requests.
+	cancelledSamples uint32
+
+	merged []mergedBlock
+	scaled []scaledBlock
+	closed bool
+}
+
+func newMockTracer(id string, flags tracer.Flag, speed uint32) *mockTracer {
+	return &mockTracer{
+		id:    id,
+		flags: flags,
+		speed: speed,
+	}
+}
+
+func (mt *mockTracer) Id() string {
+	return mt.id
+}
+
+func (mt *mockTracer) Flags() tracer.Flag {
+	return mt.flags
+}
+
+func (mt *mockTracer) Speed() uint32 {
+	return mt.speed
+}
+
+func (mt *mockTracer) Init() error {
+	return nil
+}
+
+func (mt *mockTracer) Close() {
+	mt.Lock()
+	defer mt.Unlock()
+	mt.closed = true
+}
+
+func (mt *mockTracer) Stats() *tracer.Stats {
+	return &mt.stats
+}
+
+func (mt *mockTracer) UpdateState(_ tracer.UpdateMode, _ tracer.ChangeType, _ interface{}) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	if mt.traceErr != nil {
+		return 0, mt.traceErr
+	}
+
+	mt.stats.BlockW, mt.stats.BlockH = blockReq.BlockW, blockReq.BlockH
+	mt.stats.RenderTime = time.Millisecond
+	mt.stats.RenderedSamples = blockReq.SamplesPerPixel
+	if mt.cancelledSamples != 0 && blockReq.Cancelled() {
+		mt.stats.RenderedSamples = mt.cancelledSamples
+	}
+	return mt.stats.RenderTime, nil
+}
+
+func (mt *mockTracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	mt.Lock()
+	defer mt.Unlock()
+
+	if other != tracer.Tracer(mt) && mt.mergeErr != nil {
+		return 0, mt.mergeErr
+	}
+
+	mt.merged = append(mt.merged, mergedBlock{
+		Tile: tracer.Tile{X: blockReq.BlockX, Y: blockReq.BlockY, W: blockReq.BlockW, H: blockReq.BlockH},
+		from: other.(*mockTracer),
+	})
+	return 0, nil
+}
+
+func (mt *mockTracer) SyncFramebuffer(_ *tracer.BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) ScaleFrameOutput(blockReq *tracer.BlockRequest, scale float32) (time.Duration, error) {
+	mt.Lock()
+	defer mt.Unlock()
+
+	mt.scaled = append(mt.scaled, scaledBlock{
+		Tile:  tracer.Tile{X: blockReq.BlockX, Y: blockReq.BlockY, W: blockReq.BlockW, H: blockReq.BlockH},
+		scale: scale,
+	})
+	return 0, nil
+}
+
+func (mt *mockTracer) isClosed() bool {
+	mt.Lock()
+	defer mt.Unlock()
+	return mt.closed
+}
+
+func (mt *mockTracer) mergedBlocks() []tracer.Tile {
+	mt.Lock()
+	defer mt.Unlock()
+
+	blocks := make([]tracer.Tile, len(mt.merged))
+	for index, block := range mt.merged {
+		blocks[index] = block.Tile
+	}
+	return blocks
+}
+
+// Get the number of blocks from this tracer that were merged into primary.
+func (mt *mockTracer) mergedBy(primary *mockTracer) int {
+	primary.Lock()
+	defer primary.Unlock()
+
+	var count int
+	for _, block := range primary.merged {
+		if block.from == mt {
+			count++
+		}
+	}
+	return count
+}
+
+func (mt *mockTracer) resetMerged() {
+	mt.Lock()
+	defer mt.Unlock()
+	mt.merged = nil
+}
+
+func (mt *mockTracer) scaledBlocks() []scaledBlock {
+	mt.Lock()
+	defer mt.Unlock()
+	return append([]scaledBlock(nil), mt.scaled...)
+}
+
+func (mt *mockTracer) resetScaled() {
+	mt.Lock()
+	defer mt.Unlock()
+	mt.scaled = nil
+}
@@ -3,8 +3,12 @@ package renderer
 import "errors"
 
 var (
-	ErrNoTracers        = errors.New("renderer: no tracers attached")
-	ErrSceneNotDefined  = errors.New("renderer: no scene defined")
-	ErrCameraNotDefined = errors.New("renderer: no camera defined")
-	ErrInterrupted      = errors.New("renderer: interrupted while rendering")
+	ErrNoTracers                    = errors.New("renderer: no tracers attached")
+	ErrSceneNotDefined              = errors.New("renderer: no scene defined")
+	ErrCameraNotDefined             = errors.New("renderer: no camera defined")
+	ErrInterrupted                  = errors.New("renderer: interrupted while rendering")
+	ErrInvalidCrop                  = errors.New("renderer: crop region must be non-empty and inside the frame")
+	ErrPrimaryLost                  = errors.New("renderer: primary tracer failed and no local tracer is available to replace it")
+	ErrAccumulatorAccessUnsupported = errors.New("renderer: primary tracer does not support accessing its frame accumulator")
+	ErrCheckpointSceneMismatch      = errors.New("renderer: checkpoint was created for a different scene")
 )
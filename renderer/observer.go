@@ -0,0 +1,108 @@
+package renderer
+
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// The Observer interface is implemented by types that receive progress events
+// from a renderer. Observers are invoked synchronously from the goroutine that
+// renders the frame so they should return as quickly as possible.
+type Observer interface {
+	// Handle a render event. The event is one of the *Event types
+	// defined by this package.
+	OnEvent(Event)
+}
+
+// An adapter for using ordinary functions as observers.
+type ObserverFunc func(Event)
+
+// Invoke the wrapped function.
+func (f ObserverFunc) OnEvent(ev Event) {
+	f(ev)
+}
+
+// A render event.
+type Event interface{}
+
+// Emitted before the renderer starts processing a frame.
+type FrameStartEvent struct {
+	// Frame dimensions.
+	FrameW uint32
+	FrameH uint32
+
+	// The number of samples accumulated by previous passes and the number
+	// of samples per pixel that will be rendered for this frame.
+	AccumulatedSamples uint32
+	SamplesPerPixel    uint32
+}
+
+// Emitted once the renderer completes a frame.
+type FrameEndEvent struct {
+	// The total number of accumulated samples including this frame.
+	AccumulatedSamples uint32
+
+	// The frame statistics.
+	Stats FrameStats
+}
+
+// Emitted whenever the scheduler assigns tiles to the tracers.
+type ScheduleEvent struct {
+	// The scheduled tiles and the ids of the tracers that they are assigned
+	// to. If the scheduler uses a shared queue, Tracers is nil.
+	Tiles   []tracer.Tile
+	Tracers []string
+
+	// If the tiles were reassigned because a tracer failed, FailedTracer
+	// contains the id of the failed tracer.
+	FailedTracer string
+}
+
+// Emitted when a tracer completes a block.
+type BlockCompleteEvent struct {
+	// The id of the tracer that processed the block.
+	Tracer string
+
+	// The processed tile.
+	Tile tracer.Tile
+
+	// The time spent by the tracer rendering the block.
+	RenderTime time.Duration
+}
+
+// Emitted when a tracer fails and is removed from the tracer pool.
+type TracerFailedEvent struct {
+	// The id of the failed tracer and the reason for the failure.
+	Tracer string
+	Err    error
+}
+
+// Emitted when the primary tracer fails and the renderer selects a new one.
+type PrimaryChangedEvent struct {
+	// The id of the new primary tracer.
+	Tracer string
+}
+
+// Emitted when the number of accumulated samples per pixel reaches a power
+// of two (1, 2, 4, 8, ...).
+type SampleMilestoneEvent struct {
+	// The milestone that was reached and the actual number of accumulated
+	// samples per pixel.
+	Milestone          uint32
+	AccumulatedSamples uint32
+}
+
+// Get the largest power of two in the (from, to] range or 0 if the range does
+// not contain a power of two.
+func sampleMilestone(from, to uint32) uint32 {
+	var milestone uint32 = 1
+	for milestone <= to>>1 {
+		milestone <<= 1
+	}
+
+	if to == 0 || milestone <= from {
+		return 0
+	}
+	return milestone
+}
@@ -0,0 +1,112 @@
+package renderer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestSampleMilestone(t *testing.T) {
+	type spec struct {
+		from         uint32
+		to           uint32
+		expMilestone uint32
+	}
+	specs := []spec{
+		{0, 0, 0},
+		{0, 1, 1},
+		{1, 2, 2},
+		{2, 3, 0},
+		{3, 4, 4},
+		{0, 16, 16},
+		{16, 31, 0},
+		{10, 70, 64},
+		{0, 0xffffffff, 0x80000000},
+	}
+
+	for index, s := range specs {
+		milestone := sampleMilestone(s.from, s.to)
+		if milestone != s.expMilestone {
+			t.Fatalf("[spec %d] expected milestone for range (%d, %d] to be %d; got %d", index, s.from, s.to, s.expMilestone, milestone)
+		}
+	}
+}
+
+func TestObserverEventSequence(t *testing.T) {
+	type spec struct {
+		failedTracer   int
+		expEvents      []string
+		expBlockEvents int
+	}
+	specs := []spec{
+		// Failed tiles are rebalanced across the surviving tracers
+		{
+			1,
+			[]string{"FrameStart", "Schedule", "TracerFailed cpu-1", "Schedule cpu-1", "FrameEnd", "SampleMilestone"},
+			4,
+		},
+		// The frame is rendered from scratch once a new primary is selected
+		{
+			0,
+			[]string{"FrameStart", "Schedule", "TracerFailed gpu", "PrimaryChanged cpu-1", "Schedule", "FrameEnd", "SampleMilestone"},
+			2,
+		},
+	}
+
+	for specIndex, s := range specs {
+		tracers := []*mockTracer{
+			newMockTracer("gpu", tracer.Local, 2),
+			newMockTracer("cpu-1", tracer.Local|tracer.CpuDevice, 1),
+			newMockTracer("cpu-2", tracer.Local|tracer.CpuDevice, 1),
+		}
+		tracers[s.failedTracer].traceErr = errors.New("device lost")
+
+		// Record the event sequence along with the id of the failed tracer
+		// or the new primary tracer. Blocks rendered before the primary
+		// tracer changed are discarded so they are not counted.
+		var events []string
+		var blockEvents int
+		observer := ObserverFunc(func(ev Event) {
+			switch e := ev.(type) {
+			case *FrameStartEvent:
+				events = append(events, "FrameStart")
+			case *ScheduleEvent:
+				events = append(events, strings.TrimSpace("Schedule "+e.FailedTracer))
+			case *BlockCompleteEvent:
+				// Blocks are processed in parallel so we only check that
+				// they are reported while the frame is being rendered.
+				if len(events) < 2 || events[len(events)-1] == "FrameEnd" {
+					t.Errorf("[spec %d] unexpected BlockComplete event after %v", specIndex, events)
+				}
+				blockEvents++
+			case *TracerFailedEvent:
+				events = append(events, "TracerFailed "+e.Tracer)
+			case *PrimaryChangedEvent:
+				events = append(events, "PrimaryChanged "+e.Tracer)
+				blockEvents = 0
+			case *FrameEndEvent:
+				events = append(events, "FrameEnd")
+			case *SampleMilestoneEvent:
+				events = append(events, "SampleMilestone")
+			}
+		})
+
+		opts := Options{FrameW: 12, FrameH: 4, SamplesPerPixel: 1, Observers: []Observer{observer}}
+		r := newMockRenderer(tracer.NaiveScheduler(), opts, tracers...)
+		err := r.Render()
+		r.Close()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		if !reflect.DeepEqual(events, s.expEvents) {
+			t.Fatalf("[spec %d] expected event sequence to be %v; got %v", specIndex, s.expEvents, events)
+		}
+		if blockEvents != s.expBlockEvents {
+			t.Fatalf("[spec %d] expected %d BlockComplete events; got %d", specIndex, s.expBlockEvents, blockEvents)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package renderer
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
+	"time"
+	"unsafe"
 
 	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
 	"github.com/achilleasa/polaris/tracer/opencl"
 	"github.com/achilleasa/polaris/types"
 	"github.com/go-gl/gl/v2.1/gl"
@@ -23,6 +28,9 @@ const (
 
 	// Height in pixels for stacked series widgets
 	stackedSeriesHeight uint32 = 20
+
+	// The default window title
+	windowTitle = "polaris"
 )
 
 const (
@@ -51,12 +59,17 @@ type interactiveGLRenderer struct {
 	// Display options
 	showUI                bool
 	blockAssignmentSeries *stackedSeries
+	kernelTimeSeries      *stackedSeries
 }
 
 // Create a new interactive opengl renderer using the specified block scheduler and tracing pipeline.
 func NewInteractive(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *opencl.Pipeline, opts Options) (Renderer, error) {
 	// Add an extra pipeline step to copy framebuffer data to an opengl texture
 	pipeline.PostProcess = append(pipeline.PostProcess, opencl.CopyFrameBufferToOpenGLTexture())
+	if opts.CpuPipeline == nil {
+		opts.CpuPipeline = cpu.DefaultPipeline()
+	}
+	opts.CpuPipeline.PostProcess = append(opts.CpuPipeline.PostProcess, copyCpuFrameBufferToOpenGLTexture())
 
 	base, err := NewDefault(sc, scheduler, pipeline, opts)
 	if err != nil {
@@ -101,7 +114,7 @@ func (r *interactiveGLRenderer) initGL(opts Options) error {
 	glfw.WindowHint(glfw.Resizable, glfw.False)
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	r.window, err = glfw.CreateWindow(int(opts.FrameW), int(opts.FrameH), "polaris", nil, nil)
+	r.window, err = glfw.CreateWindow(int(opts.FrameW), int(opts.FrameH), windowTitle, nil, nil)
 	if err != nil {
 		return fmt.Errorf("could not create opengl window: %s", err.Error())
 	}
@@ -134,7 +147,15 @@ func (r *interactiveGLRenderer) initGL(opts Options) error {
 }
 
 func (r *interactiveGLRenderer) Render() error {
+	return r.RenderContext(context.Background())
+}
+
+// Render frames until the window is closed or the context is cancelled.
+func (r *interactiveGLRenderer) RenderContext(ctx context.Context) error {
 	for !r.window.ShouldClose() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		glfw.PollEvents()
 
 		// Render next frame
@@ -142,12 +163,13 @@ func (r *interactiveGLRenderer) Render() error {
 
 		// Render frame unless we have reached our target SPP
 		if r.options.SamplesPerPixel == 0 || (r.options.SamplesPerPixel != 0 && r.accumulatedSamples < r.defaultRenderer.options.SamplesPerPixel) {
-			err := r.renderFrame(r.accumulatedSamples)
-			if r.options.SamplesPerPixel == 0 {
-				r.accumulatedSamples++
-			} else {
-				r.accumulatedSamples += r.options.SamplesPerPixel
+			err := r.renderFrame(ctx, r.accumulatedSamples, r.options.SamplesPerPixel)
+
+			// If the primary tracer changed the frame was rendered from scratch
+			if r.stats.PrimaryChanged {
+				r.accumulatedSamples = 0
 			}
+			r.accumulatedSamples += r.stats.RenderedSamples
 			if err != nil {
 				r.Unlock()
 				return err
@@ -182,34 +204,61 @@ func (r *interactiveGLRenderer) initUI() error {
 
 	// Setup series
 	r.blockAssignmentSeries = makeStackedSeries(len(r.tracers), int(r.options.FrameW))
+	r.kernelTimeSeries = makeStackedSeries(int(tracer.NumKernels), int(r.options.FrameW))
 
 	return nil
 }
 
 func (r *interactiveGLRenderer) onBeforeShowUI() {
 	r.blockAssignmentSeries.Clear()
+	r.kernelTimeSeries.Clear()
+}
+
+func (r *interactiveGLRenderer) onAfterHideUI() {
+	r.window.SetTitle(windowTitle)
 }
 
 func (r *interactiveGLRenderer) renderUI() {
-	var y int32 = 1
-	var frameW int32 = int32(r.options.FrameW) - 1
 	gl.LineWidth(2.0)
-	for seriesIndex, blockH := range r.blockAssignments {
-		gl.Color3fv(&r.blockAssignmentSeries.colors[seriesIndex][0])
+	for tileIndex, tile := range r.tileAssignments {
+		x0, y0 := int32(tile.X)+1, int32(tile.Y)+1
+		x1, y1 := int32(tile.X+tile.W)-1, int32(tile.Y+tile.H)-1
+		gl.Color3fv(&r.blockAssignmentSeries.colors[r.tileTracers[tileIndex]][0])
 		gl.Begin(gl.LINE_LOOP)
-		gl.Vertex2i(0, y)
-		gl.Vertex2i(frameW, y)
-		gl.Vertex2i(frameW, y+int32(blockH))
-		gl.Vertex2i(0, y+int32(blockH))
+		gl.Vertex2i(x0, y0)
+		gl.Vertex2i(x1, y0)
+		gl.Vertex2i(x1, y1)
+		gl.Vertex2i(x0, y1)
 		gl.End()
-
-		y += int32(blockH)
 	}
 
-	for seriesIndex, blockH := range r.blockAssignments {
-		r.blockAssignmentSeries.Append(seriesIndex, float32(blockH))
+	for seriesIndex, stat := range r.stats.Tracers {
+		r.blockAssignmentSeries.Append(seriesIndex, stat.FramePercent)
 	}
 	r.blockAssignmentSeries.Render(r.options.FrameH-stackedSeriesHeight, stackedSeriesHeight)
+
+	// Display the kernel time breakdown for all tracers above the block
+	// assignments and list the kernel times in the window title.
+	var kernelTimes tracer.KernelTimes
+	for _, stat := range r.stats.Tracers {
+		kernelTimes.Add(stat.KernelTimes)
+	}
+	for kernel, elapsed := range kernelTimes {
+		r.kernelTimeSeries.Append(kernel, float32(elapsed.Seconds()))
+	}
+	r.kernelTimeSeries.Render(r.options.FrameH-2*stackedSeriesHeight-2, stackedSeriesHeight)
+	r.window.SetTitle(fmt.Sprintf("%s - %s", windowTitle, fmtKernelTimes(kernelTimes)))
+}
+
+// Format the non-zero kernel times as a comma-delimited list.
+func fmtKernelTimes(kernelTimes tracer.KernelTimes) string {
+	var entries []string
+	for kernel, elapsed := range kernelTimes {
+		if elapsed != 0 {
+			entries = append(entries, fmt.Sprintf("%s: %s", tracer.Kernel(kernel), elapsed))
+		}
+	}
+	return strings.Join(entries, ", ")
 }
 
 func (r *interactiveGLRenderer) onKeyEvent(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
@@ -233,6 +282,8 @@ func (r *interactiveGLRenderer) onKeyEvent(w *glfw.Window, key glfw.Key, scancod
 		r.showUI = !r.showUI
 		if r.showUI {
 			r.onBeforeShowUI()
+		} else {
+			r.onAfterHideUI()
 		}
 		return
 	default:
@@ -246,7 +297,7 @@ func (r *interactiveGLRenderer) onKeyEvent(w *glfw.Window, key glfw.Key, scancod
 		speedScaler = 2.0
 	}
 	r.camera.Move(moveDir, speedScaler*cameraMoveSpeed)
-	r.updateCamera()
+	r.UpdateCamera(r.camera)
 }
 
 func (r *interactiveGLRenderer) onMouseEvent(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mod glfw.ModifierKey) {
@@ -287,18 +338,17 @@ func (r *interactiveGLRenderer) onCursorPosEvent(w *glfw.Window, xPos, yPos floa
 		r.camera.Pitch = delta[1]
 		r.camera.Yaw = delta[0]
 		r.camera.Update()
-		r.updateCamera()
+		r.UpdateCamera(r.camera)
 	}
 }
 
-func (r *interactiveGLRenderer) updateCamera() {
+// Queue a camera update for all attached tracers and restart sample accumulation.
+func (r *interactiveGLRenderer) UpdateCamera(camera *scene.Camera) {
 	r.Lock()
 	defer r.Unlock()
 
-	for _, tr := range r.tracers {
-		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, r.camera)
-	}
-
+	r.defaultRenderer.UpdateCamera(camera)
+	r.camera = camera
 	r.accumulatedSamples = 0
 }
 
@@ -359,3 +409,13 @@ func (s *stackedSeries) Render(rY, rHeight uint32) {
 	}
 	gl.End()
 }
+
+// Copy the RGBA screen buffer of a cpu tracer to an opengl texture. This
+// function assumes that the caller has enabled the appropriate 2D texture target.
+func copyCpuFrameBufferToOpenGLTexture() cpu.PipelineStage {
+	return func(tr *cpu.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(blockReq.FrameW), int32(blockReq.FrameH), gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&tr.FrameBuffer()[0]))
+		return time.Since(start), nil
+	}
+}
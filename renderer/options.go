@@ -1,10 +1,21 @@
 package renderer
 
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+)
+
 type Options struct {
 	// Frame dims.
 	FrameW uint32
 	FrameH uint32
 
+	// If set, only the pixels inside this frame region are traced and
+	// post-processed.
+	Crop tracer.Tile
+
 	// Number of indirect bounces.
 	NumBounces uint32
 
@@ -17,7 +28,48 @@ type Options struct {
 	// Exposure for tonemapping.
 	Exposure float32
 
+	// The seed for the random number generators. All frame, sample and
+	// bounce seeds are derived from this value so rendering the same scene
+	// with the same options and devices produces the same output.
+	Seed uint32
+
+	// Capture AOVs for the first hit of each primary ray.
+	CaptureAOVs bool
+
+	// Count the number of rays traced by each tracer.
+	CountRays bool
+
 	// Device selection.
 	BlackListedDevices []string
 	ForcePrimaryDevice string
+
+	// Use the CPU tracer instead of the opencl tracers. The CPU tracer is
+	// also used as a fallback if no opencl devices are available.
+	UseCpuTracer bool
+
+	// The pipeline for the CPU tracer. If not specified, the renderer
+	// will use cpu.DefaultPipeline().
+	CpuPipeline *cpu.Pipeline `json:"-"`
+
+	// A list of remote worker addresses (host:port). Remote tracers
+	// are used in addition to the local tracers.
+	RemoteWorkers []string
+
+	// The base timeout for requests sent to remote workers. Trace requests
+	// are allowed additional time depending on the block size and the
+	// number of samples per pixel. If not specified, the renderer will use
+	// remote.DefaultRequestTimeout.
+	RemoteTimeout time.Duration
+
+	// Observers that receive render progress events.
+	Observers []Observer `json:"-"`
+}
+
+// Get the frame region to be rendered. This is either the crop region or
+// the entire frame.
+func (opts *Options) frameRegion() tracer.Tile {
+	if opts.Crop != (tracer.Tile{}) {
+		return opts.Crop
+	}
+	return tracer.Tile{W: opts.FrameW, H: opts.FrameH}
 }
@@ -1,9 +1,44 @@
 package renderer
 
+import (
+	"context"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
 type Renderer interface {
 	// Render frame.
 	Render() error
 
+	// Render frame and stop early if the context is cancelled. Once the
+	// context is cancelled, local tracers stop after their current sample
+	// and the frame is finalized by running the post-process stages on the
+	// samples rendered so far. In that case the context error is returned.
+	RenderContext(ctx context.Context) error
+
+	// Render an additional pass with the specified number of samples per
+	// pixel and accumulate it on top of the samples already rendered for
+	// the current frame. If the primary tracer fails while rendering the
+	// pass, the accumulated samples are discarded and FrameStats reports
+	// that the primary tracer changed. If the context is cancelled, the pass
+	// is finalized in the same way as RenderContext.
+	RenderPass(ctx context.Context, accumulatedSamples, samplesPerPixel uint32) error
+
+	// Read the frame accumulator contents of the primary tracer for the
+	// rendered frame region. Together with the number of accumulated samples
+	// they can be used to resume an interrupted render.
+	ReadFrameAccumulator() ([]types.Vec3, error)
+
+	// Replace the frame accumulator contents of the primary tracer for the
+	// rendered frame region. Subsequent passes accumulate samples on top
+	// of the written contents.
+	WriteFrameAccumulator([]types.Vec3) error
+
+	// Queue a camera update for all attached tracers. The update is
+	// applied before rendering the next frame.
+	UpdateCamera(*scene.Camera)
+
 	// Shutdown renderer and any attached tracer.
 	Close()
 
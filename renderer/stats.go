@@ -1,20 +1,60 @@
 package renderer
 
-import "time"
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+)
 
 type TracerStat struct {
 	// The tracer id.
 	Id string
 
+	// The tracer flags and its computation speed estimate.
+	Flags tracer.Flag
+	Speed uint32
+
 	// True if this is the primary tracer
 	IsPrimary bool
 
-	// The block height and the percentage of total frame area it represents.
+	// True if the tracer failed and was removed from the tracer pool. The
+	// error that caused the failure is stored in Err.
+	Dead bool
+	Err  error `json:"-"`
+
+	// The last processed block tile and the percentage of total frame
+	// area covered by all blocks processed by the tracer.
+	BlockX       uint32
+	BlockY       uint32
+	BlockW       uint32
 	BlockH       uint32
 	FramePercent float32
 
-	// Render time for assigned block
+	// The number of blocks processed by the tracer.
+	NumBlocks uint32
+
+	// Render time for all processed blocks
 	RenderTime time.Duration
+
+	// Time spent applying queued state changes and merging the output
+	// of the processed blocks into the primary tracer's frame accumulator.
+	UpdateTime time.Duration
+	MergeTime  time.Duration
+
+	// Time spent generating primary rays and running the integrator for
+	// all processed blocks.
+	PrimaryRayTime time.Duration
+	IntegratorTime time.Duration
+
+	// The execution time for each tracer kernel for all processed blocks.
+	// The tonemap time is only reported for the primary tracer.
+	KernelTimes tracer.KernelTimes
+
+	// The number of primary, indirect and occlusion rays traced for all
+	// processed blocks. Rays are only counted if the CountRays option is set.
+	PrimaryRays   uint64
+	IndirectRays  uint64
+	OcclusionRays uint64
 }
 
 type FrameStats struct {
@@ -23,4 +63,20 @@ type FrameStats struct {
 
 	// Total render time for entire frame.
 	RenderTime time.Duration
+
+	// The ids of the tracers that failed while rendering the frame.
+	FailedTracers []string
+
+	// True if the primary tracer failed while rendering the frame and
+	// a new primary tracer was selected.
+	PrimaryChanged bool
+
+	// True if rendering was cancelled and the frame was finalized using
+	// fewer samples than requested.
+	Cancelled bool
+
+	// The number of samples per pixel rendered for the frame. If the frame
+	// was cancelled this is the lowest number of samples rendered for any
+	// of the frame blocks.
+	RenderedSamples uint32
 }
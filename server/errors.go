@@ -0,0 +1,21 @@
+package server
+
+import "errors"
+
+var (
+	ErrJobNotFound       = errors.New("render server: no such job")
+	ErrSceneNotFound     = errors.New("render server: no such scene")
+	ErrSceneNotSpecified = errors.New("render server: job does not specify a scene")
+	ErrSceneTooLarge     = errors.New("render server: uploaded scene exceeds the server size limit")
+	ErrInvalidSceneFile  = errors.New("render server: scene file must be a relative path inside the server work directory")
+	ErrInvalidFrameSize  = errors.New("render server: frame width and height must be greater than 0")
+	ErrFrameTooLarge     = errors.New("render server: frame width or height exceeds the server limit")
+	ErrInvalidSamples    = errors.New("render server: samples per pixel must be greater than 0")
+	ErrTooManySamples    = errors.New("render server: samples per pixel exceed the server limit")
+	ErrUnsupportedFormat = errors.New("render server: unsupported image format; supported formats: png, pfm, hdr, exr")
+	ErrQueueFull         = errors.New("render server: job queue is full")
+	ErrJobNotFinished    = errors.New("render server: job has not finished yet")
+	ErrJobFinished       = errors.New("render server: job has already finished")
+	ErrJobCancelled      = errors.New("render server: job cancelled")
+	ErrServerClosed      = errors.New("render server: server closed")
+)
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/renderer"
+)
+
+type JobState string
+
+// Supported job states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// A render job submitted by a client.
+type JobRequest struct {
+	// The id of a scene uploaded to the server. If not specified, the
+	// server loads the scene from SceneFile.
+	SceneId string
+
+	// The path to a scene file (.zip or .obj) relative to the server work
+	// directory. Absolute paths and paths outside the work directory are
+	// not allowed.
+	SceneFile string
+
+	// The output image format (png, pfm, hdr or exr). Defaults to png.
+	Format string
+
+	// Render options. The server overrides the device selection options
+	// with its own settings.
+	Options renderer.Options
+}
+
+// The status of a render job.
+type JobStatus struct {
+	// The job id.
+	Id string
+
+	// The current job state. If the job failed, Error contains the
+	// reason for the failure.
+	State JobState
+	Error string `json:",omitempty"`
+
+	// The number of rendered samples per pixel and the requested total.
+	Samples       uint32
+	TargetSamples uint32
+
+	// Time elapsed since the job started running.
+	Elapsed time.Duration
+
+	// Render statistics for the last rendered pass.
+	Stats renderer.FrameStats
+}
+
+// A queued or processed render job.
+type job struct {
+	sync.Mutex
+
+	req       JobRequest
+	status    JobStatus
+	sceneFile string
+	imgFile   string
+
+	// The context for rendering the job. It is created when the job starts
+	// running and cancelled when a client cancels the running job.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Get a copy of the job status.
+func (j *job) Status() JobStatus {
+	j.Lock()
+	defer j.Unlock()
+
+	status := j.status
+	status.Stats.Tracers = append([]renderer.TracerStat(nil), j.status.Stats.Tracers...)
+	status.Stats.FailedTracers = append([]string(nil), j.status.Stats.FailedTracers...)
+	return status
+}
+
+// Request the job to be cancelled. Queued jobs are cancelled immediately
+// while running jobs stop once the tracers finish their current samples.
+func (j *job) Cancel() error {
+	j.Lock()
+	defer j.Unlock()
+
+	switch j.status.State {
+	case JobQueued:
+		j.status.State = JobCancelled
+	case JobRunning:
+		j.cancel()
+	default:
+		return ErrJobFinished
+	}
+
+	return nil
+}
+
+// Get the current job state.
+func (j *job) state() JobState {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.status.State
+}
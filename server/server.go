@@ -0,0 +1,533 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/renderer"
+)
+
+// A function that creates a renderer for processing a render job. The
+// renderer should save the rendered frame to imgFile.
+type RendererFactory func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error)
+
+type Config struct {
+	// The directory for storing uploaded scenes and rendered images.
+	WorkDir string
+
+	// The number of samples per pixel rendered by each pass. The job
+	// status is updated after each pass.
+	PassSamples uint32
+
+	// The max number of jobs that can be queued. Cancelled jobs do not
+	// count towards this limit.
+	MaxQueuedJobs int
+
+	// The max size in bytes of uploaded scenes.
+	MaxSceneSize int64
+
+	// The max frame dimensions and samples per pixel that can be
+	// requested by a job.
+	MaxFrameW          uint32
+	MaxFrameH          uint32
+	MaxSamplesPerPixel uint32
+}
+
+// A server that exposes an HTTP API for submitting render jobs. Jobs are
+// queued and processed one at a time.
+type Server struct {
+	logger log.Logger
+
+	listener    net.Listener
+	httpServer  *http.Server
+	config      Config
+	newRenderer RendererFactory
+
+	// Uploaded scenes and submitted jobs.
+	mutex       sync.Mutex
+	scenes      map[string]string
+	jobs        map[string]*job
+	jobList     []*job
+	nextSceneId int
+	nextJobId   int
+
+	// Jobs waiting to be processed. The job runner is notified via
+	// queueSignal whenever a job is queued.
+	jobQueue    []*job
+	queueSignal chan struct{}
+	closeChan   chan struct{}
+	runnerGroup sync.WaitGroup
+}
+
+// Create a new server listening for HTTP requests at addr.
+func NewServer(addr string, config Config, newRenderer RendererFactory) (*Server, error) {
+	if config.PassSamples == 0 {
+		config.PassSamples = 1
+	}
+	if config.MaxQueuedJobs <= 0 {
+		config.MaxQueuedJobs = 1
+	}
+	if config.MaxSceneSize <= 0 {
+		config.MaxSceneSize = 256 << 20
+	}
+	if config.MaxFrameW == 0 {
+		config.MaxFrameW = 8192
+	}
+	if config.MaxFrameH == 0 {
+		config.MaxFrameH = 8192
+	}
+	if config.MaxSamplesPerPixel == 0 {
+		config.MaxSamplesPerPixel = 65536
+	}
+
+	err := os.MkdirAll(config.WorkDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		logger:      log.New("render server"),
+		listener:    listener,
+		config:      config,
+		newRenderer: newRenderer,
+		scenes:      make(map[string]string),
+		jobs:        make(map[string]*job),
+		queueSignal: make(chan struct{}, 1),
+		closeChan:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scenes", s.handleScenes)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	s.httpServer = &http.Server{Handler: mux}
+
+	s.runnerGroup.Add(1)
+	go s.runJobs()
+
+	return s, nil
+}
+
+// Get the address that the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve HTTP requests until the server is closed.
+func (s *Server) Serve() error {
+	s.logger.Noticef("listening for requests at %s", s.listener.Addr())
+
+	err := s.httpServer.Serve(s.listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Stop serving requests, cancel all pending jobs and wait for the running
+// job to terminate.
+func (s *Server) Close() {
+	s.httpServer.Close()
+	s.listener.Close()
+
+	s.mutex.Lock()
+	for _, j := range s.jobList {
+		j.Cancel()
+	}
+	s.mutex.Unlock()
+
+	close(s.closeChan)
+	s.runnerGroup.Wait()
+}
+
+// Handle scene uploads. The request body should contain a compiled scene
+// whose size does not exceed the configured max scene size.
+func (s *Server) handleScenes(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("render server: method %s not allowed", req.Method))
+		return
+	}
+
+	s.mutex.Lock()
+	s.nextSceneId++
+	sceneId := fmt.Sprintf("scene-%d", s.nextSceneId)
+	s.mutex.Unlock()
+
+	sceneFile := filepath.Join(s.config.WorkDir, sceneId+".zip")
+	f, err := os.Create(sceneFile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	n, err := io.Copy(f, http.MaxBytesReader(w, req.Body, s.config.MaxSceneSize))
+	f.Close()
+	if err != nil {
+		os.Remove(sceneFile)
+		if n >= s.config.MaxSceneSize {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrSceneTooLarge)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.scenes[sceneId] = sceneFile
+	s.mutex.Unlock()
+
+	s.logger.Noticef("stored uploaded scene %q", sceneId)
+	writeJSON(w, http.StatusCreated, map[string]string{"Id": sceneId})
+}
+
+// Handle job submissions and job listing requests.
+func (s *Server) handleJobs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		s.mutex.Lock()
+		statusList := make([]JobStatus, len(s.jobList))
+		for index, j := range s.jobList {
+			statusList[index] = j.Status()
+		}
+		s.mutex.Unlock()
+
+		writeJSON(w, http.StatusOK, statusList)
+	case "POST":
+		jobReq := JobRequest{
+			Options: renderer.Options{
+				FrameW:          1024,
+				FrameH:          1024,
+				SamplesPerPixel: 16,
+				NumBounces:      5,
+				MinBouncesForRR: 3,
+				Exposure:        1.2,
+			},
+		}
+		err := json.NewDecoder(req.Body).Decode(&jobReq)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		j, err := s.submitJob(jobReq)
+		switch err {
+		case nil:
+			writeJSON(w, http.StatusAccepted, j.Status())
+		case ErrQueueFull:
+			writeError(w, http.StatusServiceUnavailable, err)
+		default:
+			writeError(w, http.StatusBadRequest, err)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("render server: method %s not allowed", req.Method))
+	}
+}
+
+// Handle job status, cancellation and image download requests.
+func (s *Server) handleJob(w http.ResponseWriter, req *http.Request) {
+	tokens := strings.Split(strings.TrimPrefix(req.URL.Path, "/jobs/"), "/")
+
+	s.mutex.Lock()
+	j, exists := s.jobs[tokens[0]]
+	s.mutex.Unlock()
+
+	if !exists || len(tokens) > 2 || (len(tokens) == 2 && tokens[1] != "image") {
+		writeError(w, http.StatusNotFound, ErrJobNotFound)
+		return
+	}
+
+	// Download rendered image
+	if len(tokens) == 2 {
+		if req.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("render server: method %s not allowed", req.Method))
+			return
+		}
+
+		if j.Status().State != JobDone {
+			writeError(w, http.StatusConflict, ErrJobNotFinished)
+			return
+		}
+
+		http.ServeFile(w, req, j.imgFile)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, j.Status())
+	case "DELETE":
+		err := j.Cancel()
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		s.logger.Noticef("cancelling job %q", j.status.Id)
+		writeJSON(w, http.StatusOK, j.Status())
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("render server: method %s not allowed", req.Method))
+	}
+}
+
+// Validate a job request and append it to the job queue.
+func (s *Server) submitJob(jobReq JobRequest) (*job, error) {
+	opts := jobReq.Options
+	if opts.FrameW == 0 || opts.FrameH == 0 {
+		return nil, ErrInvalidFrameSize
+	} else if opts.FrameW > s.config.MaxFrameW || opts.FrameH > s.config.MaxFrameH {
+		return nil, ErrFrameTooLarge
+	} else if opts.SamplesPerPixel == 0 {
+		return nil, ErrInvalidSamples
+	} else if opts.SamplesPerPixel > s.config.MaxSamplesPerPixel {
+		return nil, ErrTooManySamples
+	}
+
+	if jobReq.Format == "" {
+		jobReq.Format = "png"
+	}
+	switch jobReq.Format {
+	case "png", "pfm", "hdr", "exr":
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var sceneFile string
+	switch {
+	case jobReq.SceneId != "":
+		var exists bool
+		sceneFile, exists = s.scenes[jobReq.SceneId]
+		if !exists {
+			return nil, ErrSceneNotFound
+		}
+	case jobReq.SceneFile != "":
+		var err error
+		sceneFile, err = s.resolveSceneFile(jobReq.SceneFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrSceneNotSpecified
+	}
+
+	s.pruneJobQueue()
+	if len(s.jobQueue) >= s.config.MaxQueuedJobs {
+		return nil, ErrQueueFull
+	}
+
+	s.nextJobId++
+	jobId := fmt.Sprintf("job-%d", s.nextJobId)
+	j := &job{
+		req:       jobReq,
+		sceneFile: sceneFile,
+		imgFile:   filepath.Join(s.config.WorkDir, jobId+"."+jobReq.Format),
+		status: JobStatus{
+			Id:            jobId,
+			State:         JobQueued,
+			TargetSamples: opts.SamplesPerPixel,
+		},
+	}
+
+	s.jobQueue = append(s.jobQueue, j)
+	s.jobs[jobId] = j
+	s.jobList = append(s.jobList, j)
+	s.logger.Noticef("queued job %q", jobId)
+
+	// Wake up the job runner
+	select {
+	case s.queueSignal <- struct{}{}:
+	default:
+	}
+
+	return j, nil
+}
+
+// Resolve a scene file path relative to the server work dir. Absolute paths
+// and paths that reference a parent directory are rejected.
+func (s *Server) resolveSceneFile(sceneFile string) (string, error) {
+	if filepath.IsAbs(sceneFile) {
+		return "", ErrInvalidSceneFile
+	}
+
+	for _, elem := range strings.Split(filepath.ToSlash(sceneFile), "/") {
+		if elem == ".." {
+			return "", ErrInvalidSceneFile
+		}
+	}
+
+	return filepath.Join(s.config.WorkDir, sceneFile), nil
+}
+
+// Remove cancelled jobs from the job queue. This method must be called while
+// holding the server mutex.
+func (s *Server) pruneJobQueue() {
+	pending := s.jobQueue[:0]
+	for _, j := range s.jobQueue {
+		if j.state() == JobQueued {
+			pending = append(pending, j)
+		}
+	}
+
+	// Clear the trailing entries so pruned jobs can be garbage collected
+	for index := len(pending); index < len(s.jobQueue); index++ {
+		s.jobQueue[index] = nil
+	}
+	s.jobQueue = pending
+}
+
+// Remove the next job from the job queue skipping any cancelled jobs. Returns
+// nil if the queue is empty.
+func (s *Server) dequeueJob() *job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pruneJobQueue()
+	if len(s.jobQueue) == 0 {
+		return nil
+	}
+
+	j := s.jobQueue[0]
+	s.jobQueue[0] = nil
+	s.jobQueue = s.jobQueue[1:]
+	return j
+}
+
+// Process queued jobs one at a time until the server is closed.
+func (s *Server) runJobs() {
+	defer s.runnerGroup.Done()
+
+	for {
+		select {
+		case <-s.closeChan:
+			return
+		case <-s.queueSignal:
+		}
+
+		for j := s.dequeueJob(); j != nil; j = s.dequeueJob() {
+			s.runJob(j)
+		}
+	}
+}
+
+// Render a job and update its state once rendering completes.
+func (s *Server) runJob(j *job) {
+	j.Lock()
+	if j.status.State != JobQueued {
+		j.Unlock()
+		return
+	}
+	j.status.State = JobRunning
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	j.Unlock()
+	defer j.cancel()
+
+	s.logger.Noticef("running job %q", j.status.Id)
+	start := time.Now()
+	err := s.renderJob(j.ctx, j, start)
+
+	j.Lock()
+	defer j.Unlock()
+	j.status.Elapsed = time.Since(start)
+	switch err {
+	case nil:
+		j.status.State = JobDone
+		s.logger.Noticef("job %q completed in %s", j.status.Id, j.status.Elapsed)
+	case ErrJobCancelled:
+		j.status.State = JobCancelled
+		s.logger.Noticef("job %q cancelled", j.status.Id)
+	default:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+		s.logger.Warningf("job %q failed: %v", j.status.Id, err)
+	}
+}
+
+// Render the job scene in passes of PassSamples samples per pixel and update
+// the job progress after each pass. If ctx is cancelled, the current pass
+// is finalized using the samples rendered so far.
+func (s *Server) renderJob(ctx context.Context, j *job, start time.Time) error {
+	sc, err := reader.ReadScene(j.sceneFile)
+	if err != nil {
+		return err
+	}
+
+	opts := j.req.Options
+	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
+
+	r, err := s.newRenderer(sc, opts, j.imgFile)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var samples uint32
+	for samples < opts.SamplesPerPixel {
+		if ctx.Err() != nil {
+			return ErrJobCancelled
+		}
+
+		passSamples := s.config.PassSamples
+		if passSamples > opts.SamplesPerPixel-samples {
+			passSamples = opts.SamplesPerPixel - samples
+		}
+
+		err = r.RenderPass(ctx, samples, passSamples)
+		if err != nil && err != context.Canceled {
+			return err
+		}
+
+		// If the primary tracer changed, the pass was rendered from scratch
+		stats := r.Stats()
+		if stats.PrimaryChanged {
+			samples = 0
+		}
+		samples += stats.RenderedSamples
+
+		j.Lock()
+		j.status.Samples = samples
+		j.status.Elapsed = time.Since(start)
+		j.status.Stats = stats
+		j.status.Stats.Tracers = append([]renderer.TracerStat(nil), stats.Tracers...)
+		j.Unlock()
+
+		if err == context.Canceled {
+			return ErrJobCancelled
+		}
+	}
+
+	return nil
+}
+
+// Encode a JSON response.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Encode an error response.
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, map[string]string{"Error": err.Error()})
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/writer"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestRenderJob(t *testing.T) {
+	var mr *mockRenderer
+	srv, baseURL := startServer(t, func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error) {
+		mr = &mockRenderer{imgFile: imgFile}
+		return mr, nil
+	})
+	defer os.RemoveAll(srv.config.WorkDir)
+	defer srv.Close()
+
+	// Upload scene
+	var uploadRes map[string]string
+	res := doRequest(t, "POST", baseURL+"/scenes", readSceneFile(t), &uploadRes)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected scene upload to return status %d; got %d", http.StatusCreated, res.StatusCode)
+	}
+
+	// Submit job
+	var status JobStatus
+	jobReq := `{"SceneId": "` + uploadRes["Id"] + `", "Options": {"FrameW": 8, "FrameH": 4, "SamplesPerPixel": 10}}`
+	res = doRequest(t, "POST", baseURL+"/jobs", []byte(jobReq), &status)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected job submission to return status %d; got %d", http.StatusAccepted, res.StatusCode)
+	}
+
+	status = waitForJob(t, baseURL, status.Id)
+	if status.State != JobDone {
+		t.Fatalf("expected job state to be %q; got %q (%s)", JobDone, status.State, status.Error)
+	}
+	if status.Samples != 10 || status.TargetSamples != 10 {
+		t.Fatalf("expected job to render 10/10 samples; got %d/%d", status.Samples, status.TargetSamples)
+	}
+
+	// Passes are rendered with the configured pass size; the last pass is clipped
+	expPasses := [][2]uint32{{0, 4}, {4, 4}, {8, 2}}
+	if len(mr.passes) != len(expPasses) {
+		t.Fatalf("expected renderer to render %d passes; got %d", len(expPasses), len(mr.passes))
+	}
+	for index, pass := range mr.passes {
+		if pass != expPasses[index] {
+			t.Fatalf("expected pass %d to be %v; got %v", index, expPasses[index], pass)
+		}
+	}
+	if !mr.closed {
+		t.Fatal("expected renderer to be closed")
+	}
+
+	// Download image
+	res, err := http.Get(baseURL + "/jobs/" + status.Id + "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(data) != "image" {
+		t.Fatalf("expected to download rendered image; got status %d and body %q", res.StatusCode, string(data))
+	}
+}
+
+func TestRenderJobErrors(t *testing.T) {
+	srv, baseURL := startServer(t, func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error) {
+		return nil, errors.New("no devices available")
+	})
+	defer os.RemoveAll(srv.config.WorkDir)
+	defer srv.Close()
+
+	sceneFile := filepath.Join(srv.config.WorkDir, "test.zip")
+	err := ioutil.WriteFile(sceneFile, readSceneFile(t), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type spec struct {
+		jobReq        string
+		expStatusCode int
+		expErr        error
+	}
+	specs := []spec{
+		{`{}`, http.StatusBadRequest, ErrSceneNotSpecified},
+		{`{"SceneId": "scene-42"}`, http.StatusBadRequest, ErrSceneNotFound},
+		{`{"SceneFile": "test.zip", "Format": "jpg"}`, http.StatusBadRequest, ErrUnsupportedFormat},
+		{`{"SceneFile": "test.zip", "Options": {"FrameW": 0}}`, http.StatusBadRequest, ErrInvalidFrameSize},
+		{`{"SceneFile": "test.zip", "Options": {"FrameW": 1025}}`, http.StatusBadRequest, ErrFrameTooLarge},
+		{`{"SceneFile": "test.zip", "Options": {"FrameH": 1025}}`, http.StatusBadRequest, ErrFrameTooLarge},
+		{`{"SceneFile": "test.zip", "Options": {"SamplesPerPixel": 0}}`, http.StatusBadRequest, ErrInvalidSamples},
+		{`{"SceneFile": "test.zip", "Options": {"SamplesPerPixel": 1025}}`, http.StatusBadRequest, ErrTooManySamples},
+		// Scene files must be located inside the work dir
+		{`{"SceneFile": "` + sceneFile + `"}`, http.StatusBadRequest, ErrInvalidSceneFile},
+		{`{"SceneFile": "../test.zip"}`, http.StatusBadRequest, ErrInvalidSceneFile},
+		{`{"SceneFile": "scenes/../../test.zip"}`, http.StatusBadRequest, ErrInvalidSceneFile},
+	}
+
+	for index, s := range specs {
+		var res map[string]string
+		httpRes := doRequest(t, "POST", baseURL+"/jobs", []byte(s.jobReq), &res)
+		if httpRes.StatusCode != s.expStatusCode {
+			t.Fatalf("[spec %d] expected status %d; got %d", index, s.expStatusCode, httpRes.StatusCode)
+		}
+		if res["Error"] != s.expErr.Error() {
+			t.Fatalf("[spec %d] expected error %q; got %q", index, s.expErr.Error(), res["Error"])
+		}
+	}
+
+	// Scene uploads exceeding the size limit should be rejected
+	var uploadRes map[string]string
+	httpRes := doRequest(t, "POST", baseURL+"/scenes", make([]byte, srv.config.MaxSceneSize+1), &uploadRes)
+	if httpRes.StatusCode != http.StatusRequestEntityTooLarge || uploadRes["Error"] != ErrSceneTooLarge.Error() {
+		t.Fatalf("expected scene upload to fail with %q; got status %d and error %q", ErrSceneTooLarge, httpRes.StatusCode, uploadRes["Error"])
+	}
+	srv.mutex.Lock()
+	numScenes := len(srv.scenes)
+	srv.mutex.Unlock()
+	if numScenes != 0 {
+		t.Fatalf("expected rejected scene upload not to be stored; got %d stored scenes", numScenes)
+	}
+
+	// Jobs should fail if the renderer cannot be created
+	var status JobStatus
+	doRequest(t, "POST", baseURL+"/jobs", []byte(`{"SceneFile": "test.zip"}`), &status)
+	status = waitForJob(t, baseURL, status.Id)
+	if status.State != JobFailed || status.Error != "no devices available" {
+		t.Fatalf("expected job to fail with the renderer error; got state %q and error %q", status.State, status.Error)
+	}
+
+	// Images for failed jobs cannot be downloaded
+	var res map[string]string
+	httpRes = doRequest(t, "GET", baseURL+"/jobs/"+status.Id+"/image", nil, &res)
+	if httpRes.StatusCode != http.StatusConflict || res["Error"] != ErrJobNotFinished.Error() {
+		t.Fatalf("expected image download to fail with %q; got status %d and error %q", ErrJobNotFinished, httpRes.StatusCode, res["Error"])
+	}
+
+	httpRes = doRequest(t, "GET", baseURL+"/jobs/job-42", nil, &res)
+	if httpRes.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d for unknown job; got %d", http.StatusNotFound, httpRes.StatusCode)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	passChan := make(chan struct{})
+	srv, baseURL := startServer(t, func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error) {
+		return &mockRenderer{imgFile: imgFile, passChan: passChan}, nil
+	})
+	defer os.RemoveAll(srv.config.WorkDir)
+	defer srv.Close()
+	defer close(passChan)
+
+	sceneFile := filepath.Join(srv.config.WorkDir, "test.zip")
+	err := ioutil.WriteFile(sceneFile, readSceneFile(t), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var running, queued JobStatus
+	doRequest(t, "POST", baseURL+"/jobs", []byte(`{"SceneFile": "test.zip"}`), &running)
+	doRequest(t, "POST", baseURL+"/jobs", []byte(`{"SceneFile": "test.zip"}`), &queued)
+
+	// Let the first job complete its first pass
+	passChan <- struct{}{}
+
+	// Queued jobs are cancelled immediately
+	var status JobStatus
+	doRequest(t, "DELETE", baseURL+"/jobs/"+queued.Id, nil, &status)
+	if status.State != JobCancelled {
+		t.Fatalf("expected queued job state to be %q; got %q", JobCancelled, status.State)
+	}
+
+	// Running jobs interrupt their current pass and keep the rendered samples
+	doRequest(t, "DELETE", baseURL+"/jobs/"+running.Id, nil, &status)
+	if status.State != JobRunning {
+		t.Fatalf("expected running job state to be %q; got %q", JobRunning, status.State)
+	}
+
+	status = waitForJob(t, baseURL, running.Id)
+	if status.State != JobCancelled {
+		t.Fatalf("expected running job state to be %q; got %q", JobCancelled, status.State)
+	}
+	if expSamples := srv.config.PassSamples + 1; status.Samples != expSamples {
+		t.Fatalf("expected cancelled job to have %d samples; got %d", expSamples, status.Samples)
+	}
+
+	// Finished jobs cannot be cancelled
+	var res map[string]string
+	httpRes := doRequest(t, "DELETE", baseURL+"/jobs/"+running.Id, nil, &res)
+	if httpRes.StatusCode != http.StatusConflict || res["Error"] != ErrJobFinished.Error() {
+		t.Fatalf("expected cancel to fail with %q; got status %d and error %q", ErrJobFinished, httpRes.StatusCode, res["Error"])
+	}
+
+	var statusList []JobStatus
+	doRequest(t, "GET", baseURL+"/jobs", nil, &statusList)
+	if len(statusList) != 2 || statusList[0].Id != running.Id || statusList[1].Id != queued.Id {
+		t.Fatalf("expected job list to contain the submitted jobs; got %v", statusList)
+	}
+}
+
+func TestJobQueueLimit(t *testing.T) {
+	var mutex sync.Mutex
+	var renderedJobs []string
+	passChan := make(chan struct{})
+	srv, baseURL := startServer(t, func(sc *scene.Scene, opts renderer.Options, imgFile string) (renderer.Renderer, error) {
+		mutex.Lock()
+		renderedJobs = append(renderedJobs, strings.TrimSuffix(filepath.Base(imgFile), ".png"))
+		mutex.Unlock()
+		return &mockRenderer{imgFile: imgFile, passChan: passChan}, nil
+	})
+	defer os.RemoveAll(srv.config.WorkDir)
+	defer srv.Close()
+
+	err := ioutil.WriteFile(filepath.Join(srv.config.WorkDir, "test.zip"), readSceneFile(t), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the first job to start running and fill the queue
+	jobIds := make([]string, 0)
+	submit := func() (*http.Response, JobStatus) {
+		var status JobStatus
+		res := doRequest(t, "POST", baseURL+"/jobs", []byte(`{"SceneFile": "test.zip"}`), &status)
+		if res.StatusCode == http.StatusAccepted {
+			jobIds = append(jobIds, status.Id)
+		}
+		return res, status
+	}
+	submit()
+	passChan <- struct{}{}
+	for i := 0; i < srv.config.MaxQueuedJobs; i++ {
+		if res, _ := submit(); res.StatusCode != http.StatusAccepted {
+			t.Fatalf("expected job %d to be queued; got status %d", i, res.StatusCode)
+		}
+	}
+	if res, _ := submit(); res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected job submission to a full queue to return status %d; got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	// Cancelled jobs should not count towards the queue limit
+	var status JobStatus
+	cancelledId := jobIds[2]
+	doRequest(t, "DELETE", baseURL+"/jobs/"+cancelledId, nil, &status)
+	if res, _ := submit(); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected job to be queued after cancelling a queued job; got status %d", res.StatusCode)
+	}
+
+	close(passChan)
+	for _, jobId := range jobIds {
+		status = waitForJob(t, baseURL, jobId)
+		expState := JobDone
+		if jobId == cancelledId {
+			expState = JobCancelled
+		}
+		if status.State != expState {
+			t.Fatalf("expected job %q state to be %q; got %q", jobId, expState, status.State)
+		}
+	}
+
+	// Cancelled jobs should be dropped from the queue without being rendered
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(renderedJobs) != len(jobIds)-1 {
+		t.Fatalf("expected %d jobs to be rendered; got %v", len(jobIds)-1, renderedJobs)
+	}
+	for _, jobId := range renderedJobs {
+		if jobId == cancelledId {
+			t.Fatalf("expected cancelled job %q not to be rendered", cancelledId)
+		}
+	}
+}
+
+func startServer(t *testing.T, newRenderer RendererFactory) (*Server, string) {
+	workDir, err := ioutil.TempDir("", "polaris-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewServer("127.0.0.1:0", Config{WorkDir: workDir, PassSamples: 4, MaxQueuedJobs: 4, MaxSceneSize: 1 << 20, MaxFrameW: 1024, MaxFrameH: 1024, MaxSamplesPerPixel: 1024}, newRenderer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+
+	return srv, "http://" + srv.Addr().String()
+}
+
+func readSceneFile(t *testing.T) []byte {
+	f, err := ioutil.TempFile("", "polaris-scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	sceneFile := f.Name() + ".zip"
+	defer os.Remove(f.Name())
+	defer os.Remove(sceneFile)
+
+	err = writer.WriteScene(&scene.Scene{Camera: scene.NewCamera(45)}, sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func doRequest(t *testing.T, method, url string, body []byte, out interface{}) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func waitForJob(t *testing.T, baseURL, jobId string) JobStatus {
+	var status JobStatus
+	for i := 0; i < 100; i++ {
+		doRequest(t, "GET", baseURL+"/jobs/"+jobId, nil, &status)
+		if status.State != JobQueued && status.State != JobRunning {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timeout waiting for job %q to finish", jobId)
+	return status
+}
+
+type mockRenderer struct {
+	imgFile  string
+	passChan chan struct{}
+	passes   [][2]uint32
+	stats    renderer.FrameStats
+	closed   bool
+}
+
+func (mr *mockRenderer) Render() error {
+	return nil
+}
+
+func (mr *mockRenderer) RenderContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (mr *mockRenderer) RenderPass(ctx context.Context, accumulatedSamples, samplesPerPixel uint32) error {
+	mr.stats = renderer.FrameStats{RenderedSamples: samplesPerPixel}
+	if mr.passChan != nil {
+		select {
+		case <-mr.passChan:
+		case <-ctx.Done():
+			// Emulate a pass interrupted after its first sample
+			mr.stats = renderer.FrameStats{Cancelled: true, RenderedSamples: 1}
+		}
+	}
+
+	mr.passes = append(mr.passes, [2]uint32{accumulatedSamples, samplesPerPixel})
+	err := ioutil.WriteFile(mr.imgFile, []byte("image"), 0644)
+	if err == nil && mr.stats.Cancelled {
+		err = ctx.Err()
+	}
+	return err
+}
+
+func (mr *mockRenderer) ReadFrameAccumulator() ([]types.Vec3, error) {
+	return nil, nil
+}
+
+func (mr *mockRenderer) WriteFrameAccumulator([]types.Vec3) error {
+	return nil
+}
+
+func (mr *mockRenderer) UpdateCamera(*scene.Camera) {
+}
+
+func (mr *mockRenderer) Close() {
+	mr.closed = true
+}
+
+func (mr *mockRenderer) Stats() renderer.FrameStats {
+	return mr.stats
+}
@@ -0,0 +1,196 @@
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"github.com/achilleasa/polaris/hdr"
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrUnknownAOV = errors.New("tracer: unknown AOV type")
+)
+
+// Auxiliary output variable (AOV) types.
+type AOV uint8
+
+const (
+	// The reflectance/specularity/radiance of the surface hit by a primary ray.
+	AlbedoAOV AOV = iota
+
+	// The world-space shading normal at the primary ray hit point.
+	NormalAOV
+
+	// The distance from the camera to the primary ray hit point.
+	DepthAOV
+
+	// The index of the mesh instance hit by a primary ray.
+	MeshInstanceIdAOV
+
+	// The index of the root material node for the surface hit by a primary ray.
+	MaterialIdAOV
+)
+
+// The list of supported AOV types.
+var AOVTypes = []AOV{AlbedoAOV, NormalAOV, DepthAOV, MeshInstanceIdAOV, MaterialIdAOV}
+
+// Get the AOV name.
+func (aov AOV) String() string {
+	switch aov {
+	case AlbedoAOV:
+		return "albedo"
+	case NormalAOV:
+		return "normal"
+	case DepthAOV:
+		return "depth"
+	case MeshInstanceIdAOV:
+		return "instance-id"
+	case MaterialIdAOV:
+		return "material-id"
+	}
+
+	return fmt.Sprintf("aov(%d)", uint8(aov))
+}
+
+// Lookup an AOV type by its name.
+func ParseAOV(name string) (AOV, error) {
+	for _, aov := range AOVTypes {
+		if aov.String() == name {
+			return aov, nil
+		}
+	}
+
+	return 0, ErrUnknownAOV
+}
+
+// The AOV values captured at the first hit of primary rays for a single
+// pixel. Albedo, normal and depth values are accumulated across all traced
+// samples. Id values store the ids for the last sample that hit the scene
+// geometry or -1 if no hit was registered.
+type AOVSample struct {
+	Albedo       types.Vec3
+	Normal       types.Vec3
+	Depth        float32
+	MeshInstance int32
+	Material     int32
+}
+
+// Tracers that can export their captured AOVs to host memory implement this interface.
+type AOVReader interface {
+	// Read the AOVs captured by the last trace pass for the block specified
+	// by the block request. The returned slice contains BlockW * BlockH samples.
+	ReadAOVs(blockReq *BlockRequest) ([]AOVSample, error)
+}
+
+// Convert a set of AOV samples into linear pixel values for the given AOV
+// type. Accumulated values are scaled by sampleWeight. Depth and id values
+// are replicated to all three pixel channels.
+func AOVPixels(aov AOV, samples []AOVSample, sampleWeight float32) []types.Vec3 {
+	pixels := make([]types.Vec3, len(samples))
+	for index, sample := range samples {
+		var v float32
+		switch aov {
+		case AlbedoAOV:
+			pixels[index] = sample.Albedo.Mul(sampleWeight)
+			continue
+		case NormalAOV:
+			if sample.Normal.Len() > 0 {
+				pixels[index] = sample.Normal.Normalize()
+			}
+			continue
+		case DepthAOV:
+			v = sample.Depth * sampleWeight
+		case MeshInstanceIdAOV:
+			v = float32(sample.MeshInstance)
+		case MaterialIdAOV:
+			v = float32(sample.Material)
+		}
+		pixels[index] = types.Vec3{v, v, v}
+	}
+
+	return pixels
+}
+
+// Save an image with the contents of an AOV. If the filename extension matches
+// a HDR format then the linear AOV values are saved. Otherwise, AOV values are
+// mapped to the [0, 255] range and saved as a PNG image.
+func WriteAOV(filename string, aov AOV, frameW, frameH uint32, samples []AOVSample, sampleWeight float32) error {
+	pixels := AOVPixels(aov, samples, sampleWeight)
+	if hdr.IsHDR(filename) {
+		return hdr.WriteFile(filename, frameW, frameH, pixels)
+	}
+
+	if len(pixels) != int(frameW*frameH) {
+		return hdr.ErrInvalidImageSize
+	}
+
+	// Depth values are mapped so that closer hits appear brighter
+	var maxDepth float32
+	if aov == DepthAOV {
+		for _, pixel := range pixels {
+			if pixel[0] > maxDepth {
+				maxDepth = pixel[0]
+			}
+		}
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+	for index, pixel := range pixels {
+		var c color.RGBA
+		switch aov {
+		case AlbedoAOV:
+			c = toRGBA(pixel)
+		case NormalAOV:
+			// Map normal from [-1, 1] -> [0, 1]
+			c = toRGBA(pixel.Add(types.Vec3{1, 1, 1}).Mul(0.5))
+		case DepthAOV:
+			if pixel[0] > 0 {
+				v := 1.0 - pixel[0]/(maxDepth+1.0)
+				c = toRGBA(types.Vec3{v, v, v})
+			}
+		case MeshInstanceIdAOV, MaterialIdAOV:
+			if pixel[0] >= 0 {
+				c = idColor(uint32(pixel[0]))
+			}
+		}
+
+		c.A = 255
+		im.SetRGBA(index%int(frameW), index/int(frameW), c)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, im)
+}
+
+// Convert a color with components in the [0, 1] range to RGBA.
+func toRGBA(v types.Vec3) color.RGBA {
+	var rgb [3]uint8
+	for c := 0; c < 3; c++ {
+		switch {
+		case v[c] <= 0:
+			rgb[c] = 0
+		case v[c] >= 1:
+			rgb[c] = 255
+		default:
+			rgb[c] = uint8(v[c] * 255.0)
+		}
+	}
+
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+}
+
+// Map an id to a distinct color by hashing it.
+func idColor(id uint32) color.RGBA {
+	h := (id + 1) * 2654435761
+	return color.RGBA{uint8(h >> 24), uint8(h >> 16), uint8(h >> 8), 255}
+}
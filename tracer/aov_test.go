@@ -0,0 +1,52 @@
+package tracer
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestParseAOV(t *testing.T) {
+	for _, aov := range AOVTypes {
+		parsed, err := ParseAOV(aov.String())
+		if err != nil {
+			t.Errorf("unexpected error parsing AOV %q: %v", aov.String(), err)
+			continue
+		}
+		if parsed != aov {
+			t.Errorf("expected parsed AOV to be %d; got %d", aov, parsed)
+		}
+	}
+
+	_, err := ParseAOV("beauty")
+	if err != ErrUnknownAOV {
+		t.Fatalf("expected to get ErrUnknownAOV; got %v", err)
+	}
+}
+
+func TestAOVPixels(t *testing.T) {
+	samples := []AOVSample{
+		{Albedo: types.Vec3{1, 0.5, 2}, Normal: types.Vec3{0, 0, 4}, Depth: 6, MeshInstance: 3, Material: 7},
+		{MeshInstance: -1, Material: -1},
+	}
+
+	specs := []struct {
+		aov       AOV
+		expPixels []types.Vec3
+	}{
+		{AlbedoAOV, []types.Vec3{{0.5, 0.25, 1}, {0, 0, 0}}},
+		{NormalAOV, []types.Vec3{{0, 0, 1}, {0, 0, 0}}},
+		{DepthAOV, []types.Vec3{{3, 3, 3}, {0, 0, 0}}},
+		{MeshInstanceIdAOV, []types.Vec3{{3, 3, 3}, {-1, -1, -1}}},
+		{MaterialIdAOV, []types.Vec3{{7, 7, 7}, {-1, -1, -1}}},
+	}
+
+	for specIndex, spec := range specs {
+		pixels := AOVPixels(spec.aov, samples, 0.5)
+		for index, pixel := range pixels {
+			if pixel != spec.expPixels[index] {
+				t.Errorf("[spec %d] expected %s pixel %d to be %v; got %v", specIndex, spec.aov, index, spec.expPixels[index], pixel)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package tracer
+
+// Parameters for the bloom filter. The filter extracts the radiance of pixels
+// whose luminance exceeds a threshold, blurs it using a Gaussian pyramid and
+// adds the blurred radiance back to the frame. Each pyramid level halves the
+// resolution of the previous level so the glow around bright pixels extends to
+// roughly Radius pixels.
+type BloomParams struct {
+	// The luminance above which pixels contribute to the glow. Only the
+	// radiance in excess of the threshold is blurred.
+	Threshold float32
+
+	// The approximate radius of the glow in pixels.
+	Radius float32
+
+	// A scale factor for the blurred radiance.
+	Intensity float32
+}
+
+// Get the default bloom parameters.
+func DefaultBloomParams() BloomParams {
+	return BloomParams{
+		Threshold: 1.0,
+		Radius:    32,
+		Intensity: 0.1,
+	}
+}
+
+// Get the number of Gaussian pyramid levels that should be used for blurring a
+// blockW x blockH block. The number of levels grows with the log2 of the glow
+// radius but is limited so that the last level contains at least one pixel.
+func (p BloomParams) Levels(blockW, blockH uint32) uint32 {
+	levels := uint32(1)
+	for radius := uint32(2); float32(radius) <= p.Radius; radius <<= 1 {
+		if blockW>>levels == 0 || blockH>>levels == 0 {
+			break
+		}
+		levels++
+	}
+	return levels
+}
+
+// Get the dimensions of a bloom pyramid level for a blockW x blockH block.
+func BloomLevelSize(blockW, blockH, level uint32) (uint32, uint32) {
+	for ; level > 0; level-- {
+		blockW, blockH = (blockW+1)>>1, (blockH+1)>>1
+	}
+	return blockW, blockH
+}
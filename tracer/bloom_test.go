@@ -0,0 +1,47 @@
+package tracer
+
+import "testing"
+
+func TestBloomLevels(t *testing.T) {
+	specs := []struct {
+		radius         float32
+		blockW, blockH uint32
+		exp            uint32
+	}{
+		{0, 64, 64, 1},
+		{1, 64, 64, 1},
+		{2, 64, 64, 2},
+		{3, 64, 64, 2},
+		{32, 64, 64, 6},
+		{32, 64, 4, 3},
+		{1024, 64, 64, 7},
+		{1024, 1, 1, 1},
+	}
+
+	for specIndex, spec := range specs {
+		params := BloomParams{Radius: spec.radius}
+		if levels := params.Levels(spec.blockW, spec.blockH); levels != spec.exp {
+			t.Errorf("[spec %d] expected %d levels for a %dx%d block with radius %f; got %d", specIndex, spec.exp, spec.blockW, spec.blockH, spec.radius, levels)
+		}
+	}
+}
+
+func TestBloomLevelSize(t *testing.T) {
+	specs := []struct {
+		level      uint32
+		expW, expH uint32
+	}{
+		{0, 13, 6},
+		{1, 7, 3},
+		{2, 4, 2},
+		{3, 2, 1},
+		{4, 1, 1},
+	}
+
+	for specIndex, spec := range specs {
+		w, h := BloomLevelSize(13, 6, spec.level)
+		if w != spec.expW || h != spec.expH {
+			t.Errorf("[spec %d] expected level %d size to be %dx%d; got %dx%d", specIndex, spec.level, spec.expW, spec.expH, w, h)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestAOVCapture(t *testing.T) {
+	sc := diffuseTriangleScene()
+
+	specs := []struct {
+		// The frustrum z coordinate; rays pointing away from the triangle miss it.
+		frustrumZ float32
+		expHit    bool
+	}{
+		{-1, true},
+		{1, false},
+	}
+
+	for specIndex, spec := range specs {
+		tr, err := NewTracer("test", 2, DefaultPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Init()
+
+		camera := &scene.Camera{
+			Frustrum: scene.Frustrum{
+				{-0.1, 0.1, spec.frustrumZ, 0},
+				{0.1, 0.1, spec.frustrumZ, 0},
+				{-0.1, -0.1, spec.frustrumZ, 0},
+				{0.1, -0.1, spec.frustrumZ, 0},
+			},
+		}
+		tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{4, 4})
+		tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+
+		blockReq := &tracer.BlockRequest{
+			FrameW:          4,
+			FrameH:          4,
+			BlockW:          4,
+			BlockH:          4,
+			SamplesPerPixel: 2,
+			NumBounces:      1,
+			MinBouncesForRR: 2,
+			CaptureAOVs:     true,
+		}
+		_, err = tr.Trace(blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tr.MergeOutput(tr, blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		samples := tr.(*Tracer).buffers.FrameAOVs
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples)
+		for index, sample := range samples {
+			if !spec.expHit {
+				if sample.MeshInstance != -1 || sample.Material != -1 || sample.Depth != 0 {
+					t.Fatalf("[spec %d] expected pixel %d to have no AOV data; got %+v", specIndex, index, sample)
+				}
+				continue
+			}
+
+			if sample.MeshInstance != 0 || sample.Material != 0 {
+				t.Fatalf("[spec %d] expected pixel %d mesh instance and material ids to be 0; got %d, %d", specIndex, index, sample.MeshInstance, sample.Material)
+			}
+			if albedo := sample.Albedo.Mul(sampleWeight); albedo.Sub(types.Vec3{0.5, 0.25, 1}).Len() > 1e-4 {
+				t.Fatalf("[spec %d] expected pixel %d albedo to be [0.5, 0.25, 1]; got %v", specIndex, index, albedo)
+			}
+			if normal := sample.Normal.Normalize(); normal.Sub(types.Vec3{0, 0, 1}).Len() > 1e-4 {
+				t.Fatalf("[spec %d] expected pixel %d normal to be [0, 0, 1]; got %v", specIndex, index, normal)
+			}
+			if depth := sample.Depth * sampleWeight; depth < 1.0 || depth > 1.02 {
+				t.Fatalf("[spec %d] expected pixel %d depth to be ~1.0; got %f", specIndex, index, depth)
+			}
+		}
+	}
+}
+
+// Create a scene with a single triangle at z = -1 that uses a diffuse material.
+func diffuseTriangleScene() *scene.Scene {
+	sc := singleTriangleScene()
+	sc.NormalList = []types.Vec4{{0, 0, 1, 0}, {0, 0, 1, 0}, {0, 0, 1, 0}}
+	sc.UvList = []types.Vec2{{0, 0}, {1, 0}, {0, 1}}
+	sc.MaterialIndex = []uint32{0}
+	sc.MaterialNodeList = []scene.MaterialNode{
+		{Union1: [4]int32{int32(material.BxdfDiffuse), 0, 0, -1}, Union2: types.Vec4{0.5, 0.25, 1, 0}, Union5: [1]int32{-1}},
+	}
+	sc.SceneDiffuseMatIndex = -1
+	sc.SceneEmissiveMatIndex = -1
+
+	return sc
+}
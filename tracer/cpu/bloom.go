@@ -0,0 +1,153 @@
+package cpu
+
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The binomial approximation of a Gaussian kernel used for blurring each bloom
+// pyramid level.
+var bloomBlurKernel = [5]float32{1.0 / 16.0, 4.0 / 16.0, 6.0 / 16.0, 4.0 / 16.0, 1.0 / 16.0}
+
+// A level of the Gaussian pyramid used by the bloom filter.
+type bloomLevel struct {
+	w, h   int
+	pixels []types.Vec3
+}
+
+// Get the pixel at the specified coordinates clamped to the level bounds.
+func (l *bloomLevel) at(x, y int) types.Vec3 {
+	x = clampi(x, 0, l.w-1)
+	y = clampi(y, 0, l.h-1)
+	return l.pixels[y*l.w+x]
+}
+
+// Add a glow around bright pixels. The radiance of pixels whose luminance
+// exceeds the bloom threshold is blurred using a Gaussian pyramid and added to
+// the frame accumulator (or the output of any preceding filter stages). The
+// output is written to the filtered accumulator which is used as the input for
+// the following post-process stages.
+func Bloom(params tracer.BloomParams) PipelineStage {
+	var levels []bloomLevel
+	var scratch []types.Vec3
+
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		numLevels := int(params.Levels(blockReq.BlockW, blockReq.BlockH))
+		if len(levels) != numLevels || levels[0].w != int(blockReq.BlockW) || levels[0].h != int(blockReq.BlockH) {
+			levels = make([]bloomLevel, numLevels)
+			for index := range levels {
+				w, h := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, uint32(index))
+				levels[index] = bloomLevel{w: int(w), h: int(h), pixels: make([]types.Vec3, w*h)}
+			}
+			scratch = make([]types.Vec3, len(levels[0].pixels))
+		}
+
+		// Extract the radiance in excess of the luminance threshold
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		accumulator := tr.postProcessAccumulator()
+		tr.parallelFor(len(levels[0].pixels), func(from, to int) {
+			for blockIndex := from; blockIndex < to; blockIndex++ {
+				radiance := accumulator[blockReq.FramePixelIndex(uint32(blockIndex))].Mul(sampleWeight)
+				levels[0].pixels[blockIndex] = bloomExtract(radiance, params.Threshold)
+			}
+		})
+
+		// Blur each level and downsample it to generate the next level
+		for index := range levels {
+			if index > 0 {
+				bloomDownsample(tr, &levels[index-1], &levels[index])
+			}
+			bloomBlur(tr, &levels[index], scratch)
+		}
+
+		// Sum the blurred levels starting from the coarsest one
+		for index := len(levels) - 1; index > 0; index-- {
+			bloomUpsampleAdd(tr, &levels[index], &levels[index-1])
+		}
+
+		// Add the average of the blurred levels using the same scale as the accumulator
+		scale := params.Intensity / (float32(len(levels)) * sampleWeight)
+		tr.parallelFor(len(levels[0].pixels), func(from, to int) {
+			for blockIndex := from; blockIndex < to; blockIndex++ {
+				pixelIndex := blockReq.FramePixelIndex(uint32(blockIndex))
+				tr.buffers.FilteredAccumulator[pixelIndex] = accumulator[pixelIndex].Add(levels[0].pixels[blockIndex].Mul(scale))
+			}
+		})
+		tr.filtered = true
+
+		elapsed := time.Since(start)
+		tr.stats.KernelTimes[tracer.FilterKernel] += elapsed
+		return elapsed, nil
+	}
+}
+
+// Get the part of the radiance whose luminance exceeds the threshold.
+func bloomExtract(radiance types.Vec3, threshold float32) types.Vec3 {
+	lum := luminance(radiance)
+	if lum <= threshold {
+		return types.Vec3{}
+	}
+	return radiance.Mul((lum - threshold) / lum)
+}
+
+// Blur a pyramid level in place using a separable Gaussian kernel.
+func bloomBlur(tr *Tracer, level *bloomLevel, scratch []types.Vec3) {
+	tr.parallelFor(level.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			for x := 0; x < level.w; x++ {
+				var sum types.Vec3
+				for k, weight := range bloomBlurKernel {
+					sum = sum.Add(level.at(x+k-2, y).Mul(weight))
+				}
+				scratch[y*level.w+x] = sum
+			}
+		}
+	})
+
+	tr.parallelFor(level.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			for x := 0; x < level.w; x++ {
+				var sum types.Vec3
+				for k, weight := range bloomBlurKernel {
+					sum = sum.Add(scratch[clampi(y+k-2, 0, level.h-1)*level.w+x].Mul(weight))
+				}
+				level.pixels[y*level.w+x] = sum
+			}
+		}
+	})
+}
+
+// Downsample a pyramid level to half its resolution by averaging 2x2 pixel blocks.
+func bloomDownsample(tr *Tracer, src, dst *bloomLevel) {
+	tr.parallelFor(dst.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			for x := 0; x < dst.w; x++ {
+				sum := src.at(2*x, 2*y).Add(src.at(2*x+1, 2*y)).Add(src.at(2*x, 2*y+1)).Add(src.at(2*x+1, 2*y+1))
+				dst.pixels[y*dst.w+x] = sum.Mul(0.25)
+			}
+		}
+	})
+}
+
+// Bilinearly upsample a pyramid level and add it to the next finer level.
+func bloomUpsampleAdd(tr *Tracer, src, dst *bloomLevel) {
+	tr.parallelFor(dst.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			sy := maxf(0.5*float32(y)-0.25, 0)
+			y0 := int(sy)
+			ty := sy - float32(y0)
+			for x := 0; x < dst.w; x++ {
+				sx := maxf(0.5*float32(x)-0.25, 0)
+				x0 := int(sx)
+				tx := sx - float32(x0)
+
+				top := src.at(x0, y0).Mul(1 - tx).Add(src.at(x0+1, y0).Mul(tx))
+				bottom := src.at(x0, y0+1).Mul(1 - tx).Add(src.at(x0+1, y0+1).Mul(tx))
+				dst.pixels[y*dst.w+x] = dst.pixels[y*dst.w+x].Add(top.Mul(1 - ty).Add(bottom.Mul(ty)))
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestBloomBelowThreshold(t *testing.T) {
+	tr, blockReq := bloomTestTracer(t, 16, 16, func(x, y int) types.Vec3 {
+		return types.Vec3{0.5, float32(x) / 16, 0.25}
+	})
+
+	_, err := Bloom(tracer.BloomParams{Threshold: 1, Radius: 8, Intensity: 1})(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pixelIndex, v := range tr.buffers.FilteredAccumulator {
+		if v != tr.buffers.FrameAccumulator[pixelIndex] {
+			t.Fatalf("expected pixels below the bloom threshold to remain unchanged; got %v at pixel %d; expected %v", v, pixelIndex, tr.buffers.FrameAccumulator[pixelIndex])
+		}
+	}
+}
+
+func TestBloomGlow(t *testing.T) {
+	tr, blockReq := bloomTestTracer(t, 32, 32, func(x, y int) types.Vec3 {
+		if x == 16 && y == 16 {
+			return types.Vec3{101, 101, 101}
+		}
+		return types.Vec3{}
+	})
+
+	params := tracer.BloomParams{Threshold: 1, Radius: 8, Intensity: 0.5}
+	_, err := Bloom(params)(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.filtered {
+		t.Fatal("expected the filtered accumulator to be used as the post-process input")
+	}
+
+	// The frame accumulator should not be modified
+	for pixelIndex, v := range tr.buffers.FrameAccumulator {
+		if v != (types.Vec3{}) && pixelIndex != 16*32+16 {
+			t.Fatalf("expected frame accumulator to remain unchanged; got %v at pixel %d", v, pixelIndex)
+		}
+	}
+
+	// The glow should fall off with the distance from the bright pixel
+	prev := tr.buffers.FilteredAccumulator[16*32+17][0]
+	for x := 18; x < 24; x++ {
+		v := tr.buffers.FilteredAccumulator[16*32+x][0]
+		if v <= 0 || v > prev {
+			t.Fatalf("expected glow at pixel (%d, 16) to be positive and less than %f; got %f", x, prev, v)
+		}
+		prev = v
+	}
+
+	// The added energy should approximately equal the scaled radiance above
+	// the threshold; some energy is lost due to clamping at the block edges
+	var added float32
+	for pixelIndex, v := range tr.buffers.FilteredAccumulator {
+		added += v[0] - tr.buffers.FrameAccumulator[pixelIndex][0]
+	}
+	if exp := params.Intensity * 100 * bloomTestSpp; absf(added-exp) > 0.05*exp {
+		t.Fatalf("expected bloom to add a total radiance of %f; got %f", exp, added)
+	}
+}
+
+func TestBloomBlock(t *testing.T) {
+	tr, blockReq := bloomTestTracer(t, 16, 16, func(x, y int) types.Vec3 {
+		if x < 4 {
+			return types.Vec3{10, 10, 10}
+		}
+		return types.Vec3{}
+	})
+
+	// Filter a block that does not contain the bright pixels on the left
+	blockReq.BlockX, blockReq.BlockW = 4, 8
+	blockReq.BlockY, blockReq.BlockH = 2, 8
+	_, err := Bloom(tracer.DefaultBloomParams())(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for blockIndex := uint32(0); blockIndex < blockReq.BlockW*blockReq.BlockH; blockIndex++ {
+		if v := tr.buffers.FilteredAccumulator[blockReq.FramePixelIndex(blockIndex)]; v != (types.Vec3{}) {
+			t.Fatalf("expected filter to ignore pixels outside the block; got %v for block pixel %d", v, blockIndex)
+		}
+	}
+}
+
+const bloomTestSpp = 2
+
+// Create a tracer whose frame accumulator contains bloomTestSpp samples of
+// the per-pixel radiance values returned by pixelFn.
+func bloomTestTracer(t *testing.T, frameW, frameH int, pixelFn func(x, y int) types.Vec3) (*Tracer, *tracer.BlockRequest) {
+	tr, err := NewTracer("test", 2, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{uint32(frameW), uint32(frameH)})
+
+	buffers := tr.(*Tracer).buffers
+	for y := 0; y < frameH; y++ {
+		for x := 0; x < frameW; x++ {
+			buffers.FrameAccumulator[y*frameW+x] = pixelFn(x, y).Mul(bloomTestSpp)
+		}
+	}
+
+	return tr.(*Tracer), &tracer.BlockRequest{
+		FrameW:          uint32(frameW),
+		FrameH:          uint32(frameH),
+		BlockW:          uint32(frameW),
+		BlockH:          uint32(frameH),
+		SamplesPerPixel: bloomTestSpp,
+		Exposure:        1,
+	}
+}
@@ -0,0 +1,55 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A traced path.
+type path struct {
+	// The accumulated color along this path.
+	throughput types.Vec3
+
+	// Shaded pixel index for this path.
+	pixelIndex uint32
+
+	// Path flags.
+	flags uint32
+}
+
+// The set of host buffers used by the tracer.
+type bufferSet struct {
+	// Primary rays and paths for the current block.
+	Rays  []ray
+	Paths []path
+
+	// Accumulators for the current trace pass and the entire frame.
+	TraceAccumulator []types.Vec3
+	FrameAccumulator []types.Vec3
+
+	// The output of post-process filter stages (e.g. denoising). Filters
+	// write their output here so that the frame accumulator contents are
+	// preserved for accumulating the samples of subsequent frames.
+	FilteredAccumulator []types.Vec3
+
+	// AOVs for the current trace pass and the entire frame.
+	TraceAOVs []tracer.AOVSample
+	FrameAOVs []tracer.AOVSample
+
+	// RGBA frame buffer.
+	FrameBuffer []byte
+}
+
+// Resize buffers so they can fit a frame with the given dimensions.
+func (bs *bufferSet) Resize(frameW, frameH uint32) {
+	numPixels := int(frameW * frameH)
+
+	bs.Rays = make([]ray, numPixels)
+	bs.Paths = make([]path, numPixels)
+	bs.TraceAccumulator = make([]types.Vec3, numPixels)
+	bs.FrameAccumulator = make([]types.Vec3, numPixels)
+	bs.FilteredAccumulator = make([]types.Vec3, numPixels)
+	bs.TraceAOVs = make([]tracer.AOVSample, numPixels)
+	bs.FrameAOVs = make([]tracer.AOVSample, numPixels)
+	bs.FrameBuffer = make([]byte, numPixels*4)
+}
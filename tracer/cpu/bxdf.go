@@ -0,0 +1,334 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Returns true if the bxdf type represents an emissive surface.
+func bxdfIsEmissive(t uint32) bool {
+	return t == uint32(material.BxdfEmissive)
+}
+
+// Returns true if the bxdf type represents a singular surface (ideal mirror/dielectric).
+func bxdfIsSingular(t uint32) bool {
+	return t&uint32(material.BxdfConductor|material.BxdfDielectric) != 0
+}
+
+// Returns true if the bxdf type is supported by the tracer.
+func bxdfIsValid(t uint32) bool {
+	switch material.BxdfType(t) {
+	case material.BxdfEmissive, material.BxdfDiffuse, material.BxdfConductor,
+		material.BxdfRoughtConductor, material.BxdfDielectric, material.BxdfRoughDielectric:
+		return true
+	}
+	return false
+}
+
+// Sample BXDF for this surface and material and also generate a bounce ray
+// with a PDF that approximates the material BXDF.
+func bxdfGetSample(sc *scene.Scene, surf *surface, node *materialNode, randSample types.Vec2, inRayDir types.Vec3) (sample, outRayDir types.Vec3, pdf float32) {
+	switch material.BxdfType(node.nodeType) {
+	case material.BxdfDiffuse:
+		return diffuseSample(sc, surf, node, randSample)
+	case material.BxdfConductor:
+		return conductorSample(sc, surf, node, inRayDir)
+	case material.BxdfDielectric:
+		return dielectricSample(sc, surf, node, randSample, inRayDir)
+	case material.BxdfRoughtConductor:
+		return roughConductorSample(sc, surf, node, randSample, inRayDir)
+	case material.BxdfRoughDielectric:
+		return roughDielectricSample(sc, surf, node, randSample, inRayDir)
+	}
+
+	return types.Vec3{}, types.Vec3{}, 0.0
+}
+
+// Get PDF for selecting a pre-calculated bounce ray based on the surface BXDF.
+func bxdfGetPdf(sc *scene.Scene, surf *surface, node *materialNode, inRayDir, outRayDir types.Vec3) float32 {
+	switch material.BxdfType(node.nodeType) {
+	case material.BxdfDiffuse:
+		return surf.normal.Dot(outRayDir) * c1Pi
+	case material.BxdfConductor:
+		if isReflectedRay(surf, inRayDir, outRayDir) {
+			return 1.0
+		}
+	case material.BxdfRoughtConductor:
+		return ggxGetReflectionPdf(surfaceRoughness(sc, surf, node), outRayDir, surf.normal, inRayDir.Add(outRayDir).Normalize())
+	case material.BxdfRoughDielectric:
+		return roughDielectricPdf(sc, surf, node, inRayDir, outRayDir)
+	}
+
+	return 0.0
+}
+
+// Given a pre-calculated bounce ray evaluate the BXDF for the surface based on
+// the surface material, inRayDir and outRayDir.
+func bxdfEval(sc *scene.Scene, surf *surface, node *materialNode, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	switch material.BxdfType(node.nodeType) {
+	case material.BxdfDiffuse:
+		return matGetSample3f(sc, surf.uv, node.kval, node.tex).Mul(c1Pi)
+	case material.BxdfConductor:
+		if isReflectedRay(surf, inRayDir, outRayDir) {
+			return conductorEval(sc, surf, node, inRayDir)
+		}
+	case material.BxdfRoughtConductor:
+		return roughConductorEval(sc, surf, node, inRayDir, outRayDir)
+	case material.BxdfRoughDielectric:
+		return roughDielectricEval(sc, surf, node, inRayDir, outRayDir)
+	}
+
+	return types.Vec3{}
+}
+
+// Sample ideal diffuse (lambert) surface:
+//
+// BXDF = reflectance  / PI
+// PDF = cos(theta) / PI
+func diffuseSample(sc *scene.Scene, surf *surface, node *materialNode, randSample types.Vec2) (types.Vec3, types.Vec3, float32) {
+	outRayDir := cosWeightedHemisphereGetSample(surf.normal, randSample)
+	pdf := surf.normal.Dot(outRayDir) * c1Pi
+	kd := matGetSample3f(sc, surf.uv, node.kval, node.tex)
+	return kd.Mul(c1Pi), outRayDir, pdf
+}
+
+// Sample conductor bxdf
+//
+// BXDF = kval / cosI
+// PDF = 1
+func conductorSample(sc *scene.Scene, surf *surface, node *materialNode, inRayDir types.Vec3) (types.Vec3, types.Vec3, float32) {
+	// To generate the out ray we need to reflect the input ray around the normal
+	// inRayDir points *away* from the surface so we need to flip the sign of the
+	// reflection formula: I - 2*dot(I,N) * N
+	iDotN := inRayDir.Dot(surf.normal)
+	outRayDir := surf.normal.Mul(2.0 * iDotN).Sub(inRayDir)
+	return conductorEval(sc, surf, node, inRayDir), outRayDir, 1.0
+}
+
+// Evaluate conductor bxdf for the reflected ray.
+func conductorEval(sc *scene.Scene, surf *surface, node *materialNode, inRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(surf.normal)
+	if iDotN == 0.0 {
+		return types.Vec3{}
+	}
+
+	// Calculate fresnel unless no IOR is specified
+	var f float32 = 1.0
+	if node.intIOR != 0.0 {
+		f = fresnelForDielectric(node.extIOR, node.intIOR, iDotN)
+	}
+
+	ks := matGetSample3f(sc, surf.uv, node.kval, node.tex)
+	return ks.Mul(f / iDotN)
+}
+
+// Check whether outRayDir matches the reflection of inRayDir around the
+// surface normal allowing for a small margin of error.
+func isReflectedRay(surf *surface, inRayDir, outRayDir types.Vec3) bool {
+	iDotN := inRayDir.Dot(surf.normal)
+	expOutDir := surf.normal.Mul(2.0 * iDotN).Sub(inRayDir)
+	expDot := expOutDir.Dot(outRayDir)
+	return expDot >= 0.0 && expDot <= 0.001
+}
+
+// Ideal dielectric
+//
+// BXDF = 1 / cos(theta)
+// PDF = 1
+func dielectricSample(sc *scene.Scene, surf *surface, node *materialNode, randSample types.Vec2, inRayDir types.Vec3) (types.Vec3, types.Vec3, float32) {
+	iDotN := inRayDir.Dot(surf.normal)
+	etaI, etaT := node.extIOR, node.intIOR
+
+	// If hitting from the inside we need to swap the eta
+	if iDotN < 0.0 {
+		etaI, etaT = etaT, etaI
+	}
+
+	eta := etaI / etaT
+
+	// Calculate fresnel
+	f := fresnelForDielectric(etaI, etaT, iDotN)
+
+	var kval, outRayDir types.Vec3
+	var pdf float32
+	cosTSq := 1.0 + eta*(iDotN*iDotN-1.0)
+
+	// Based on the fresnel value randomly sample the reflection ray.
+	// In the case where the ray undergoes total internal reflection we
+	// always pick the reflection ray
+	if cosTSq <= 0.0 || randSample[0] <= f {
+		outRayDir = surf.normal.Mul(-signf(iDotN) * 2.0 * iDotN).Sub(inRayDir)
+		kval = matGetSample3f(sc, surf.uv, node.kval, node.tex)
+		pdf = f
+		if cosTSq <= 0.0 {
+			pdf = 1.0
+		}
+	} else {
+		outRayDir = surf.normal.Mul(eta*iDotN - signf(iDotN)*sqrtf(cosTSq)).Sub(inRayDir.Mul(eta))
+		kval = matGetSample3f(sc, surf.uv, node.transmittance, node.transmittanceTex).Mul(eta * eta)
+		pdf = 1.0 - f
+	}
+
+	if iDotN == 0.0 {
+		return types.Vec3{}, outRayDir, pdf
+	}
+
+	return kval.Mul(pdf / absf(iDotN)), outRayDir, pdf
+}
+
+// Get the surface roughness using Disney's remapping: a = roughness^2
+func surfaceRoughness(sc *scene.Scene, surf *surface, node *materialNode) float32 {
+	roughness := clampf(matGetSample1f(sc, surf.uv, node.roughness, node.roughnessTex), minRoughness, 1.0)
+	return roughness * roughness
+}
+
+// Evaluate a microfacet reflection using the GGX distribution (equation 20).
+func evalMicrofacetReflection(ks types.Vec3, f, roughness float32, n, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(n)
+	oDotN := outRayDir.Dot(n)
+	h := inRayDir.Add(outRayDir).Normalize()
+
+	// Calculate d and g for GGX
+	d := ggxGetD(roughness, n, h)
+	g := ggxGetG(roughness, inRayDir, outRayDir, n, h)
+
+	denom := 4.0 * iDotN * oDotN
+	if denom <= 0.0 {
+		return types.Vec3{}
+	}
+	return ks.Mul(f * d * g / denom)
+}
+
+// Evaluate a microfacet refraction using the GGX distribution (equation 21).
+func evalMicrofacetRefraction(tf types.Vec3, f, roughness, etaI, etaT float32, n, h, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(n)
+	oDotN := outRayDir.Dot(n)
+	iDotH := absf(inRayDir.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+
+	// Calc focus term (see equation 21)
+	focusTermDenom := iDotN * oDotN * (etaI*iDotH + etaT*oDotH) * (etaI*iDotH + etaT*oDotH)
+	if focusTermDenom == 0.0 {
+		return types.Vec3{}
+	}
+	focusTerm := absf(etaT * etaT * iDotH * oDotH / focusTermDenom)
+
+	// Calculate d and g for GGX
+	d := ggxGetD(roughness, n, h)
+	g := ggxGetG(roughness, inRayDir, outRayDir, n, h)
+
+	return tf.Mul((1.0 - f) * d * g * focusTerm)
+}
+
+// Sample microfacet conductor surface.
+func roughConductorSample(sc *scene.Scene, surf *surface, node *materialNode, randSample types.Vec2, inRayDir types.Vec3) (types.Vec3, types.Vec3, float32) {
+	roughness := surfaceRoughness(sc, surf, node)
+
+	// Sample GGX distribution to get halfway vector and reflect I over h to get O
+	h := ggxGetSample(roughness, surf.normal, randSample)
+	outRayDir := h.Mul(2.0 * inRayDir.Dot(h)).Sub(inRayDir)
+	pdf := ggxGetReflectionPdf(roughness, outRayDir, surf.normal, h)
+
+	return roughConductorEval(sc, surf, node, inRayDir, outRayDir), outRayDir, pdf
+}
+
+// Evaluate microfacet conductor BXDF for the selected outgoing ray.
+func roughConductorEval(sc *scene.Scene, surf *surface, node *materialNode, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	roughness := surfaceRoughness(sc, surf, node)
+	ks := matGetSample3f(sc, surf.uv, node.kval, node.tex)
+
+	// Calculate fresnel unless no IOR is specified
+	var f float32 = 1.0
+	if node.intIOR != 0.0 {
+		f = fresnelForDielectric(node.extIOR, node.intIOR, inRayDir.Dot(surf.normal))
+	}
+
+	return evalMicrofacetReflection(ks, f, roughness, surf.normal, inRayDir, outRayDir)
+}
+
+// Sample microfacet dielectric surface.
+func roughDielectricSample(sc *scene.Scene, surf *surface, node *materialNode, randSample types.Vec2, inRayDir types.Vec3) (types.Vec3, types.Vec3, float32) {
+	iDotN := inRayDir.Dot(surf.normal)
+	roughness := surfaceRoughness(sc, surf, node)
+
+	// If hitting from the inside we need to swap the eta
+	etaI, etaT := node.extIOR, node.intIOR
+	if iDotN < 0.0 {
+		etaI, etaT = etaT, etaI
+	}
+	eta := etaI / etaT
+
+	// Sample GGX distribution to get halfway vector
+	h := ggxGetSample(roughness, surf.normal, randSample)
+
+	// Calculate fresnel
+	f := fresnelForDielectric(etaI, etaT, iDotN)
+	cosTSq := 1.0 + eta*(iDotN*iDotN-1.0)
+
+	// Based on the fresnel value randomly sample the reflection ray.
+	// In the case where the ray undergoes total internal reflection we
+	// always pick the reflection ray
+	if cosTSq <= 0.0 || randSample[0] <= f {
+		// Reflect I over h to get O
+		outRayDir := h.Mul(2.0 * inRayDir.Dot(h)).Sub(inRayDir)
+		var pdf float32 = 1.0
+		if cosTSq > 0.0 {
+			pdf = ggxGetReflectionPdf(roughness, outRayDir, surf.normal, inRayDir.Add(outRayDir).Normalize())
+		}
+
+		ks := matGetSample3f(sc, surf.uv, node.kval, node.tex)
+		return evalMicrofacetReflection(ks, f, roughness, surf.normal, inRayDir, outRayDir), outRayDir, pdf
+	}
+
+	// Refract I over h to get O
+	outRayDir := h.Mul(eta*iDotN - signf(iDotN)*sqrtf(cosTSq)).Sub(inRayDir.Mul(eta))
+
+	// Recalculate halfway transmission vector (equation 16)
+	h = inRayDir.Mul(etaI).Add(outRayDir.Mul(etaT)).Mul(-1.0).Normalize()
+	pdf := ggxGetRefractionPdf(roughness, etaI, etaT, inRayDir, outRayDir, surf.normal, h)
+
+	tf := matGetSample3f(sc, surf.uv, node.transmittance, node.transmittanceTex)
+	return evalMicrofacetRefraction(tf, f, roughness, etaI, etaT, surf.normal, h, inRayDir, outRayDir), outRayDir, pdf
+}
+
+// Get PDF for a microfacet dielectric surface given an outbound ray.
+func roughDielectricPdf(sc *scene.Scene, surf *surface, node *materialNode, inRayDir, outRayDir types.Vec3) float32 {
+	iDotN := inRayDir.Dot(surf.normal)
+	roughness := surfaceRoughness(sc, surf, node)
+
+	// This is a reflected ray
+	if iDotN > 0.0 {
+		return ggxGetReflectionPdf(roughness, outRayDir, surf.normal, inRayDir.Add(outRayDir).Normalize())
+	}
+
+	// We are hitting from the inside so we need to swap the eta
+	etaI, etaT := node.intIOR, node.extIOR
+	h := inRayDir.Mul(etaI).Add(outRayDir.Mul(etaT)).Mul(-1.0).Normalize()
+	return ggxGetRefractionPdf(roughness, etaI, etaT, inRayDir, outRayDir, surf.normal, h)
+}
+
+// Evaluate microfacet dielectric BXDF for the selected outgoing ray.
+func roughDielectricEval(sc *scene.Scene, surf *surface, node *materialNode, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(surf.normal)
+	roughness := surfaceRoughness(sc, surf, node)
+
+	// If hitting from the inside we need to swap the eta
+	etaI, etaT := node.extIOR, node.intIOR
+	if iDotN < 0.0 {
+		etaI, etaT = etaT, etaI
+	}
+
+	// Calculate fresnel
+	f := fresnelForDielectric(etaI, etaT, iDotN)
+
+	// This is a reflected ray
+	if iDotN > 0.0 {
+		ks := matGetSample3f(sc, surf.uv, node.kval, node.tex)
+		return evalMicrofacetReflection(ks, f, roughness, surf.normal, inRayDir, outRayDir)
+	}
+
+	h := inRayDir.Mul(etaI).Add(outRayDir.Mul(etaT)).Mul(-1.0).Normalize()
+	tf := matGetSample3f(sc, surf.uv, node.transmittance, node.transmittanceTex)
+	return evalMicrofacetRefraction(tf, f, roughness, etaI, etaT, surf.normal, h, inRayDir, outRayDir)
+}
@@ -0,0 +1,258 @@
+package cpu
+
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A function that generates the origin and direction of a primary ray. It
+// receives the jittered frame coordinates of the sample, normalized to the
+// [0, 1] range, and the generator used for jittering the sample which can be
+// used for generating any additional random samples. If the sample does not
+// map to a valid ray, the function should return false.
+type primaryRayFn func(texel types.Vec2, rndState *rng) (origin, dir types.Vec3, ok bool)
+
+// Use the camera model described by the scene camera type for the primary ray
+// generation stage. Perspective cameras with a non-zero aperture radius use
+// a thin-lens camera. As the model is selected using the camera data supplied
+// to the tracer, tracers that are not configured by the renderer (e.g. remote
+// workers) render the scene using the same camera model.
+func SceneCamera() PipelineStage {
+	perspective := PerspectiveCamera()
+	thinLens := ThinLensCamera()
+	orthographic := OrthographicCamera()
+	equirectangular := EquirectangularCamera()
+	fisheye := FisheyeCamera()
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		switch tr.cameraType {
+		case scene.OrthographicCamera:
+			return orthographic(tr, blockReq)
+		case scene.EquirectangularCamera:
+			return equirectangular(tr, blockReq)
+		case scene.FisheyeCamera:
+			return fisheye(tr, blockReq)
+		}
+
+		if tr.cameraApertureRadius > 0 {
+			return thinLens(tr, blockReq)
+		}
+		return perspective(tr, blockReq)
+	}
+}
+
+// Use a perspective camera for the primary ray generation stage.
+func PerspectiveCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		frustrum := tr.frustrumRays()
+		return generatePrimaryRays(tr, blockReq, func(texel types.Vec2, _ *rng) (types.Vec3, types.Vec3, bool) {
+			return tr.cameraPosition, frustrumRay(frustrum, texel).Normalize(), true
+		}), nil
+	}
+}
+
+// Use an orthographic camera for the primary ray generation stage. Rays are
+// parallel to the view direction and originate from the camera plane; the
+// height of the view volume is specified by the camera.
+func OrthographicCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		forward, right, up := tr.cameraFrustrum.Axes()
+		viewH := tr.cameraOrthoHeight
+		viewW := viewH * float32(blockReq.FrameW) / float32(blockReq.FrameH)
+
+		return generatePrimaryRays(tr, blockReq, func(texel types.Vec2, _ *rng) (types.Vec3, types.Vec3, bool) {
+			origin := tr.cameraPosition.
+				Add(right.Mul((texel[0] - 0.5) * viewW)).
+				Add(up.Mul((0.5 - texel[1]) * viewH))
+			return origin, forward, true
+		}), nil
+	}
+}
+
+// Use an equirectangular camera for the primary ray generation stage. The
+// frame x axis maps to the full 360 degree range of longitudes around the
+// camera up vector and the y axis maps to the 180 degree range of latitudes.
+// The view direction is located at the frame center.
+func EquirectangularCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		forward, right, up := tr.cameraFrustrum.Axes()
+
+		return generatePrimaryRays(tr, blockReq, func(texel types.Vec2, _ *rng) (types.Vec3, types.Vec3, bool) {
+			phi := (texel[0] - 0.5) * cTwoTimesPi
+			theta := texel[1] * cPi
+			sinTheta := sinf(theta)
+			dir := forward.Mul(sinTheta * cosf(phi)).
+				Add(right.Mul(sinTheta * sinf(phi))).
+				Add(up.Mul(cosf(theta)))
+			return tr.cameraPosition, dir.Normalize(), true
+		}), nil
+	}
+}
+
+// Use an equidistant fisheye camera for the primary ray generation stage.
+// The camera field of view is mapped to the largest circle that fits inside
+// the frame; pixels outside the circle are black.
+func FisheyeCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		forward, right, up := tr.cameraFrustrum.Axes()
+		aspect := float32(blockReq.FrameW) / float32(blockReq.FrameH)
+		halfFOV := tr.cameraFisheyeFOV * cPi / 360.0
+
+		return generatePrimaryRays(tr, blockReq, func(texel types.Vec2, _ *rng) (types.Vec3, types.Vec3, bool) {
+			// Map the frame to [-aspect, aspect] x [-1, 1]
+			x := (2.0*texel[0] - 1.0) * aspect
+			y := 1.0 - 2.0*texel[1]
+			r := sqrtf(x*x + y*y)
+			if r > 1.0 {
+				return tr.cameraPosition, forward, false
+			} else if r == 0 {
+				return tr.cameraPosition, forward, true
+			}
+
+			theta := r * halfFOV
+			sinTheta := sinf(theta) / r
+			dir := forward.Mul(cosf(theta)).
+				Add(right.Mul(x * sinTheta)).
+				Add(up.Mul(y * sinTheta))
+			return tr.cameraPosition, dir.Normalize(), true
+		}), nil
+	}
+}
+
+// Use a thin-lens camera for the primary ray generation stage. Rays originate
+// from random points on the lens aperture and pass through the point where the
+// corresponding pinhole camera ray meets the focal plane. If the camera does
+// not specify a focus distance, the camera focuses on the closest surface at
+// the frame center.
+func ThinLensCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		focusDistance := tr.cameraFocusDistance
+		if focusDistance == 0 {
+			if tr.autofocusDistance == 0 {
+				tr.autofocusDistance = autofocus(tr.sceneData, tr.cameraPosition, tr.frustrumRays())
+			}
+			focusDistance = tr.autofocusDistance
+		}
+
+		// Calculate the camera axes for positioning samples on the lens
+		frustrum := tr.frustrumRays()
+		_, right, up := tr.cameraFrustrum.Axes()
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += time.Since(start)
+
+		generatePrimaryRays(tr, blockReq, func(texel types.Vec2, rndState *rng) (types.Vec3, types.Vec3, bool) {
+			// Frustrum rays have a unit length along the view direction
+			focusPoint := tr.cameraPosition.Add(frustrumRay(frustrum, texel).Mul(focusDistance))
+
+			lensSample := rndState.sample2f()
+			radius := sqrtf(lensSample[0]) * tr.cameraApertureRadius
+			phi := cTwoTimesPi * lensSample[1]
+			origin := tr.cameraPosition.Add(right.Mul(radius * cosf(phi))).Add(up.Mul(radius * sinf(phi)))
+
+			return origin, focusPoint.Sub(origin).Normalize(), true
+		})
+		return time.Since(start), nil
+	}
+}
+
+// Get the distance along the view direction to the closest surface at the frame
+// center. If the center ray does not hit any geometry, the autofocus fallback
+// distance is returned instead.
+func autofocus(sc *scene.Scene, eyePos types.Vec3, frustrum [4]types.Vec3) float32 {
+	centerDir := frustrumRay(frustrum, types.Vec2{0.5, 0.5})
+	r := ray{
+		origin:  eyePos,
+		dir:     centerDir.Normalize(),
+		maxDist: cMaxFloat,
+	}
+
+	var hit intersection
+	if sc == nil || !intersectionQuery(sc, &r, &hit) {
+		return scene.AutofocusFallbackDistance
+	}
+	return hit.t / centerDir.Len()
+}
+
+// Generate a primary ray for each block pixel using rayFn and reset the path
+// associated with each ray. Invalid rays do not intersect the scene and their
+// paths do not contribute to the frame.
+func generatePrimaryRays(tr *Tracer, blockReq *tracer.BlockRequest, rayFn primaryRayFn) time.Duration {
+	start := time.Now()
+
+	texelDims := types.Vec2{
+		1.0 / float32(blockReq.FrameW),
+		1.0 / float32(blockReq.FrameH),
+	}
+
+	tr.parallelFor(int(blockReq.BlockH), func(from, to int) {
+		for y := uint32(from); y < uint32(to); y++ {
+			for x := uint32(0); x < blockReq.BlockW; x++ {
+				index := (y * blockReq.BlockW) + x
+				frameX := x + blockReq.BlockX
+
+				// Apply stratified sampling using a tent filter. This will wrap our
+				// random numbers in the [-1, 1] range. X and Y point to the top corner
+				// of the current texel so we need to add a bit of offset to get the coords
+				// into the [-0.5, 1.5] range.
+				// The generator is seeded with the frame coordinates so that
+				// the output does not depend on how the frame is split into blocks.
+				rndState := rng{frameX + blockReq.Seed, y + blockReq.BlockY + blockReq.Seed}
+				sample0 := rndState.sample2f()
+				texel := types.Vec2{
+					(float32(frameX) + tentFilter(sample0[0])) * texelDims[0],
+					(float32(y+blockReq.BlockY) + tentFilter(sample0[1])) * texelDims[1],
+				}
+
+				origin, dir, ok := rayFn(texel, &rndState)
+				tr.buffers.Rays[index] = ray{
+					origin:    origin,
+					dir:       dir,
+					maxDist:   cMaxFloat,
+					pathIndex: index,
+				}
+				tr.buffers.Paths[index] = path{
+					throughput: types.Vec3{1, 1, 1},
+					pixelIndex: ((y + blockReq.BlockY) * blockReq.FrameW) + frameX,
+				}
+				if !ok {
+					tr.buffers.Rays[index].maxDist = 0
+					tr.buffers.Paths[index].throughput = types.Vec3{}
+				}
+			}
+		}
+	})
+
+	elapsed := time.Since(start)
+	tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+	return elapsed
+}
+
+// Get the ray directions for the camera frustrum corners.
+func (tr *Tracer) frustrumRays() [4]types.Vec3 {
+	return [4]types.Vec3{
+		tr.cameraFrustrum[0].Vec3(),
+		tr.cameraFrustrum[1].Vec3(),
+		tr.cameraFrustrum[2].Vec3(),
+		tr.cameraFrustrum[3].Vec3(),
+	}
+}
+
+// Get the (unnormalized) ray direction for a point on the frame using bilinear
+// interpolation of the frustrum corner rays.
+func frustrumRay(frustrum [4]types.Vec3, texel types.Vec2) types.Vec3 {
+	return mixVec3(
+		mixVec3(frustrum[0], frustrum[2], texel[1]),
+		mixVec3(frustrum[1], frustrum[3], texel[1]),
+		texel[0],
+	)
+}
+
+// Map a uniform random sample to the [-0.5, 1.5] range using a tent filter.
+func tentFilter(sample float32) float32 {
+	if sample < 0.5 {
+		return sqrtf(2.0*sample) - 0.5
+	}
+	return 1.5 - sqrtf(2.0-2.0*sample)
+}
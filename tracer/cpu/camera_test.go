@@ -0,0 +1,264 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestThinLensCameraWithZeroAperture(t *testing.T) {
+	tr, blockReq := cameraTestTracer(t, &scene.Camera{FocusDistance: 5}, 8, 8)
+
+	expRays := generateRays(t, tr, blockReq, PerspectiveCamera())
+	rays := generateRays(t, tr, blockReq, ThinLensCamera())
+	for index, r := range rays {
+		if r.origin != expRays[index].origin {
+			t.Fatalf("expected ray %d origin to be %v; got %v", index, expRays[index].origin, r.origin)
+		}
+		if !approxEqualVec3(r.dir, expRays[index].dir, 1e-5) {
+			t.Fatalf("expected ray %d dir to be %v; got %v", index, expRays[index].dir, r.dir)
+		}
+	}
+}
+
+func TestThinLensCameraFocusPlane(t *testing.T) {
+	var focusDistance, apertureRadius float32 = 3, 0.5
+	tr, blockReq := cameraTestTracer(t, &scene.Camera{
+		ApertureRadius: apertureRadius,
+		FocusDistance:  focusDistance,
+	}, 8, 8)
+
+	pinholeRays := generateRays(t, tr, blockReq, PerspectiveCamera())
+	rays := generateRays(t, tr, blockReq, ThinLensCamera())
+
+	var offLensCenter int
+	for index, r := range rays {
+		if r.origin[2] != 0 || r.origin.Len() > apertureRadius {
+			t.Fatalf("expected ray %d origin to lie on the lens; got %v", index, r.origin)
+		}
+		if r.origin.Len() > 1e-3 {
+			offLensCenter++
+		}
+
+		// All rays for a pixel should converge on the focal plane
+		pinholeDir := pinholeRays[index].dir
+		expPoint := pinholeDir.Mul(-focusDistance / pinholeDir[2])
+		point := r.origin.Add(r.dir.Mul(-focusDistance / r.dir[2]))
+		if !approxEqualVec3(point, expPoint, 1e-4) {
+			t.Fatalf("expected ray %d to meet the focal plane at %v; got %v", index, expPoint, point)
+		}
+	}
+
+	if offLensCenter == 0 {
+		t.Fatal("expected ray origins to be distributed over the lens")
+	}
+}
+
+func TestThinLensCameraAutofocus(t *testing.T) {
+	specs := []struct {
+		eyePos    types.Vec3
+		frustrumZ float32
+		expFocus  float32
+	}{
+		{types.Vec3{0, 0, 0}, -1, 1},
+		{types.Vec3{0, 0, 1}, -1, 2},
+		// Frustrum rays pointing away from the triangle
+		{types.Vec3{0, 0, 0}, 1, scene.AutofocusFallbackDistance},
+	}
+
+	for specIndex, spec := range specs {
+		camera := &scene.Camera{Position: spec.eyePos, ApertureRadius: 0.1}
+		camera.Frustrum = cameraTestFrustrum(spec.frustrumZ)
+		tr, blockReq := cameraTestTracer(t, camera, 8, 8)
+		_, err := tr.UpdateState(tracer.Synchronous, tracer.SceneData, diffuseTriangleScene())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		generateRays(t, tr, blockReq, ThinLensCamera())
+		if absf(tr.autofocusDistance-spec.expFocus) > 1e-4 {
+			t.Errorf("[spec %d] expected autofocus distance to be %f; got %f", specIndex, spec.expFocus, tr.autofocusDistance)
+			continue
+		}
+
+		// Camera updates should reset the autofocus distance
+		_, err = tr.UpdateState(tracer.Synchronous, tracer.CameraData, camera)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.autofocusDistance != 0 {
+			t.Errorf("[spec %d] expected camera update to reset the autofocus distance; got %f", specIndex, tr.autofocusDistance)
+		}
+	}
+}
+
+func TestSceneCamera(t *testing.T) {
+	specs := []struct {
+		camera   *scene.Camera
+		expStage PipelineStage
+	}{
+		{&scene.Camera{}, PerspectiveCamera()},
+		{&scene.Camera{ApertureRadius: 0.5, FocusDistance: 3}, ThinLensCamera()},
+		{&scene.Camera{Type: scene.OrthographicCamera, OrthoHeight: 4}, OrthographicCamera()},
+		{&scene.Camera{Type: scene.EquirectangularCamera}, EquirectangularCamera()},
+		{&scene.Camera{Type: scene.FisheyeCamera, FisheyeFOV: 180}, FisheyeCamera()},
+		// The aperture should only affect perspective cameras
+		{&scene.Camera{Type: scene.OrthographicCamera, OrthoHeight: 4, ApertureRadius: 0.5}, OrthographicCamera()},
+	}
+
+	for specIndex, spec := range specs {
+		tr, blockReq := cameraTestTracer(t, spec.camera, 8, 8)
+		expRays := generateRays(t, tr, blockReq, spec.expStage)
+		rays := generateRays(t, tr, blockReq, SceneCamera())
+		for index, r := range rays {
+			if r != expRays[index] {
+				t.Fatalf("[spec %d] expected ray %d to be %+v; got %+v", specIndex, index, expRays[index], r)
+			}
+		}
+	}
+}
+
+func TestOrthographicCamera(t *testing.T) {
+	tr, blockReq := cameraTestTracer(t, &scene.Camera{
+		Position:    types.Vec3{1, 2, 3},
+		OrthoHeight: 4,
+	}, 16, 8)
+
+	rays := generateRays(t, tr, blockReq, OrthographicCamera())
+	for index, r := range rays {
+		if r.dir != (types.Vec3{0, 0, -1}) {
+			t.Fatalf("expected ray %d dir to match the view direction; got %v", index, r.dir)
+		}
+
+		// The view volume should be 8 units wide and 4 units high. The
+		// tent filter may jitter samples up to half a pixel (0.25 units)
+		// outside the view volume.
+		offset := r.origin.Sub(tr.cameraPosition)
+		if offset[2] != 0 || absf(offset[0]) > 4.25 || absf(offset[1]) > 2.25 {
+			t.Fatalf("expected ray %d origin to lie inside the view volume; got %v", index, r.origin)
+		}
+	}
+
+	// Rays for the top-left and bottom-right pixels should originate
+	// close to the corners of the view volume
+	topLeft := rays[0].origin.Sub(tr.cameraPosition)
+	bottomRight := rays[len(rays)-1].origin.Sub(tr.cameraPosition)
+	if topLeft[0] > -3.25 || topLeft[1] < 1.25 || bottomRight[0] < 3.25 || bottomRight[1] > -1.25 {
+		t.Fatalf("expected corner ray origins to be close to the view volume corners; got %v, %v", topLeft, bottomRight)
+	}
+}
+
+func TestEquirectangularCamera(t *testing.T) {
+	tr, blockReq := cameraTestTracer(t, &scene.Camera{}, 64, 32)
+
+	rays := generateRays(t, tr, blockReq, EquirectangularCamera())
+	for index, r := range rays {
+		if absf(r.dir.Len()-1.0) > 1e-4 {
+			t.Fatalf("expected ray %d dir to be normalized; got %v", index, r.dir)
+		}
+	}
+
+	specs := []struct {
+		x, y   int
+		expDir types.Vec3
+	}{
+		// Frame center looks towards the view direction
+		{32, 16, types.Vec3{0, 0, -1}},
+		// Right quarter looks to the right
+		{48, 16, types.Vec3{1, 0, 0}},
+		// Left and right edges look behind the camera
+		{0, 16, types.Vec3{0, 0, 1}},
+		{63, 16, types.Vec3{0, 0, 1}},
+		// Top and bottom rows look up and down
+		{32, 0, types.Vec3{0, 1, 0}},
+		{32, 31, types.Vec3{0, -1, 0}},
+	}
+
+	for specIndex, spec := range specs {
+		dir := rays[spec.y*64+spec.x].dir
+		if dir.Sub(spec.expDir).Len() > 0.15 {
+			t.Errorf("[spec %d] expected ray dir for pixel (%d, %d) to be close to %v; got %v", specIndex, spec.x, spec.y, spec.expDir, dir)
+		}
+	}
+}
+
+func TestFisheyeCamera(t *testing.T) {
+	tr, blockReq := cameraTestTracer(t, &scene.Camera{FisheyeFOV: 180}, 16, 8)
+
+	rays := generateRays(t, tr, blockReq, FisheyeCamera())
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			index := y*16 + x
+			r := rays[index]
+			p := tr.buffers.Paths[index]
+
+			// Pixels at the frame sides lie outside the fisheye circle
+			if x < 4 || x >= 12 {
+				if r.maxDist != 0 || p.throughput != (types.Vec3{}) {
+					t.Fatalf("expected ray for pixel (%d, %d) to be invalid; got ray %+v, path %+v", x, y, r, p)
+				}
+				continue
+			}
+
+			// For a 180 degree FOV no ray should point behind the camera
+			if r.maxDist == 0 {
+				continue
+			}
+			if r.dir[2] > 1e-4 {
+				t.Fatalf("expected ray for pixel (%d, %d) to point in front of the camera; got %v", x, y, r.dir)
+			}
+		}
+	}
+
+	// Rays close to the frame center should point towards the view direction
+	if dir := rays[4*16+8].dir; dir.Sub(types.Vec3{0, 0, -1}).Len() > 0.3 {
+		t.Fatalf("expected center ray to point towards the view direction; got %v", dir)
+	}
+}
+
+func cameraTestTracer(t *testing.T, camera *scene.Camera, frameW, frameH uint32) (*Tracer, *tracer.BlockRequest) {
+	tr, err := NewTracer("test", 2, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+
+	if camera.Frustrum == (scene.Frustrum{}) {
+		camera.Frustrum = cameraTestFrustrum(-1)
+	}
+	tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{frameW, frameH})
+	_, err = tr.UpdateState(tracer.Synchronous, tracer.CameraData, camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr.(*Tracer), &tracer.BlockRequest{
+		FrameW: frameW,
+		FrameH: frameH,
+		BlockW: frameW,
+		BlockH: frameH,
+		Seed:   42,
+	}
+}
+
+// Get a frustrum looking along the z axis with a view-space depth of 1.
+func cameraTestFrustrum(z float32) scene.Frustrum {
+	return scene.Frustrum{
+		{-0.5, 0.5, z, 0},
+		{0.5, 0.5, z, 0},
+		{-0.5, -0.5, z, 0},
+		{0.5, -0.5, z, 0},
+	}
+}
+
+func generateRays(t *testing.T, tr *Tracer, blockReq *tracer.BlockRequest, stage PipelineStage) []ray {
+	_, err := stage(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numRays := int(blockReq.BlockW * blockReq.BlockH)
+	return append([]ray(nil), tr.buffers.Rays[:numRays]...)
+}
@@ -0,0 +1,147 @@
+package cpu
+
+import (
+	"math"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The B3 spline coefficients used by each à-trous filter iteration.
+var aTrousKernel = [5]float32{1.0 / 16.0, 1.0 / 4.0, 3.0 / 8.0, 1.0 / 4.0, 1.0 / 16.0}
+
+// The normalized first-hit values used for guiding the denoising filter.
+type denoiseGuide struct {
+	normal types.Vec3
+	depth  float32
+	albedo types.Vec3
+}
+
+// Denoise the frame accumulator using an edge-avoiding à-trous wavelet filter
+// guided by the captured normal, depth and albedo AOVs. The filtered output is
+// written to the filtered accumulator which is used as the input for the
+// following post-process stages.
+func Denoise(params tracer.DenoiseParams) PipelineStage {
+	// Buffers for storing the filter input and output of each iteration
+	var src, dst []types.Vec3
+	var guides []denoiseGuide
+
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		if !blockReq.CaptureAOVs {
+			return 0, ErrAOVsNotCaptured
+		}
+
+		start := time.Now()
+		numPixels := int(blockReq.BlockW * blockReq.BlockH)
+		if len(src) != numPixels {
+			src = make([]types.Vec3, numPixels)
+			dst = make([]types.Vec3, numPixels)
+			guides = make([]denoiseGuide, numPixels)
+		}
+
+		// Filter the average radiance of each pixel
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		accumulator := tr.postProcessAccumulator()
+		tr.parallelFor(numPixels, func(from, to int) {
+			for blockIndex := from; blockIndex < to; blockIndex++ {
+				pixelIndex := blockReq.FramePixelIndex(uint32(blockIndex))
+				src[blockIndex] = accumulator[pixelIndex].Mul(sampleWeight)
+
+				aov := tr.buffers.FrameAOVs[pixelIndex]
+				guide := denoiseGuide{
+					depth:  aov.Depth * sampleWeight,
+					albedo: aov.Albedo.Mul(sampleWeight),
+				}
+				if aov.Normal.Len() > 0 {
+					guide.normal = aov.Normal.Normalize()
+				}
+				guides[blockIndex] = guide
+			}
+		})
+
+		colorSigma := params.ColorSigma
+		for iteration := uint32(0); iteration < params.Iterations; iteration++ {
+			stepSize := 1 << iteration
+			tr.parallelFor(numPixels, func(from, to int) {
+				for blockIndex := from; blockIndex < to; blockIndex++ {
+					dst[blockIndex] = aTrousFilterPixel(src, guides, blockIndex, int(blockReq.BlockW), int(blockReq.BlockH), stepSize, colorSigma, &params)
+				}
+			})
+			src, dst = dst, src
+			colorSigma *= 0.5
+		}
+
+		// Store the filtered samples using the same scale as the accumulator
+		tr.parallelFor(numPixels, func(from, to int) {
+			for blockIndex := from; blockIndex < to; blockIndex++ {
+				tr.buffers.FilteredAccumulator[blockReq.FramePixelIndex(uint32(blockIndex))] = src[blockIndex].Mul(1.0 / sampleWeight)
+			}
+		})
+		tr.filtered = true
+
+		elapsed := time.Since(start)
+		tr.stats.KernelTimes[tracer.FilterKernel] += elapsed
+		return elapsed, nil
+	}
+}
+
+// Apply a single à-trous filter iteration to a pixel. Neighboring pixels that
+// lie outside the filtered block are ignored.
+func aTrousFilterPixel(src []types.Vec3, guides []denoiseGuide, blockIndex, blockW, blockH, stepSize int, colorSigma float32, params *tracer.DenoiseParams) types.Vec3 {
+	x, y := blockIndex%blockW, blockIndex/blockW
+	center := src[blockIndex]
+	centerGuide := &guides[blockIndex]
+
+	var sum types.Vec3
+	var weightSum float32
+	for ky := -2; ky <= 2; ky++ {
+		sy := y + ky*stepSize
+		if sy < 0 || sy >= blockH {
+			continue
+		}
+
+		for kx := -2; kx <= 2; kx++ {
+			sx := x + kx*stepSize
+			if sx < 0 || sx >= blockW {
+				continue
+			}
+
+			sampleIndex := sy*blockW + sx
+			sample := src[sampleIndex]
+			guide := &guides[sampleIndex]
+
+			weight := aTrousKernel[kx+2] * aTrousKernel[ky+2]
+			weight *= edgeStoppingWeight(lenSq(sample.Sub(center)), colorSigma)
+			weight *= edgeStoppingWeight(lenSq(guide.normal.Sub(centerGuide.normal)), params.NormalSigma)
+			weight *= edgeStoppingWeight(relativeDepthDeltaSq(centerGuide.depth, guide.depth), params.DepthSigma)
+			weight *= edgeStoppingWeight(lenSq(guide.albedo.Sub(centerGuide.albedo)), params.AlbedoSigma)
+
+			sum = sum.Add(sample.Mul(weight))
+			weightSum += weight
+		}
+	}
+
+	// The center pixel always contributes so weightSum is never zero
+	return sum.Mul(1.0 / weightSum)
+}
+
+// Calculate the edge-stopping weight for a squared difference. A zero sigma
+// disables the edge-stopping weight.
+func edgeStoppingWeight(deltaSq, sigma float32) float32 {
+	if sigma <= 0 {
+		return 1.0
+	}
+	return float32(math.Exp(float64(-deltaSq / (sigma * sigma))))
+}
+
+// Calculate the squared depth difference relative to the center pixel depth.
+func relativeDepthDeltaSq(centerDepth, depth float32) float32 {
+	delta := (depth - centerDepth) / maxf(centerDepth, 1e-4)
+	return delta * delta
+}
+
+// Calculate the squared length of a vector.
+func lenSq(v types.Vec3) float32 {
+	return v.Dot(v)
+}
@@ -0,0 +1,218 @@
+package cpu
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	denoiseTestSize = 16
+	denoiseTestSpp  = 4
+)
+
+func TestDenoiseConstantImage(t *testing.T) {
+	tr, blockReq := denoiseTestTracer(t, func(x, y int) (types.Vec3, tracer.AOVSample) {
+		return types.Vec3{0.5, 0.25, 1}, tracer.AOVSample{Normal: types.Vec3{0, 0, 1}, Depth: 1, Albedo: types.Vec3{1, 1, 1}}
+	})
+
+	filtered := averagePixels(denoise(t, tr, blockReq, tracer.DefaultDenoiseParams()))
+	for pixelIndex, v := range filtered {
+		if !approxEqualVec3(v, types.Vec3{0.5, 0.25, 1}, 1e-5) {
+			t.Fatalf("expected denoising to preserve constant radiance at pixel %d; got %v", pixelIndex, v)
+		}
+	}
+}
+
+func TestDenoiseReducesNoise(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	tr, blockReq := denoiseTestTracer(t, func(x, y int) (types.Vec3, tracer.AOVSample) {
+		v := 0.5 + 0.2*(rng.Float32()-0.5)
+		return types.Vec3{v, v, v}, tracer.AOVSample{Normal: types.Vec3{0, 0, 1}, Depth: 1, Albedo: types.Vec3{1, 1, 1}}
+	})
+
+	before := variance(averagePixels(tr.buffers.FrameAccumulator))
+	after := variance(averagePixels(denoise(t, tr, blockReq, tracer.DefaultDenoiseParams())))
+	if after >= before/4 {
+		t.Fatalf("expected denoising to reduce the radiance variance from %f to less than %f; got %f", before, before/4, after)
+	}
+}
+
+func TestDenoisePreservesEdges(t *testing.T) {
+	// Left half is a dark surface; right half is a bright surface with a
+	// different albedo, normal and depth.
+	edgeImage := func(x, y int) (types.Vec3, tracer.AOVSample) {
+		if x < denoiseTestSize/2 {
+			return types.Vec3{0, 0, 0}, tracer.AOVSample{Normal: types.Vec3{0, 0, 1}, Depth: 1, Albedo: types.Vec3{0.1, 0.1, 0.1}}
+		}
+		return types.Vec3{1, 1, 1}, tracer.AOVSample{Normal: types.Vec3{1, 0, 0}, Depth: 4, Albedo: types.Vec3{0.9, 0.9, 0.9}}
+	}
+
+	specs := []struct {
+		descr   string
+		params  tracer.DenoiseParams
+		expBlur bool
+	}{
+		{"no edge-stopping weights", tracer.DenoiseParams{Iterations: 3}, true},
+		{"normal guide", tracer.DenoiseParams{Iterations: 3, NormalSigma: 0.3}, false},
+		{"depth guide", tracer.DenoiseParams{Iterations: 3, DepthSigma: 0.1}, false},
+		{"albedo guide", tracer.DenoiseParams{Iterations: 3, AlbedoSigma: 0.1}, false},
+		{"color guide", tracer.DenoiseParams{Iterations: 3, ColorSigma: 0.1}, false},
+	}
+
+	for specIndex, spec := range specs {
+		tr, blockReq := denoiseTestTracer(t, edgeImage)
+		filtered := averagePixels(denoise(t, tr, blockReq, spec.params))
+
+		// Check the pixel next to the edge
+		edgePixel := filtered[denoiseTestSize/2-1]
+		blurred := edgePixel[0] > 0.01
+		if blurred != spec.expBlur {
+			t.Errorf("[spec %d] expected edge blurring using %s to be %t; got edge pixel value %v", specIndex, spec.descr, spec.expBlur, edgePixel)
+		}
+	}
+}
+
+func TestDenoiseBlock(t *testing.T) {
+	tr, blockReq := denoiseTestTracer(t, func(x, y int) (types.Vec3, tracer.AOVSample) {
+		if x < 4 {
+			return types.Vec3{1, 1, 1}, tracer.AOVSample{}
+		}
+		return types.Vec3{0, 0, 0}, tracer.AOVSample{}
+	})
+
+	// Filter a block that does not contain the bright pixels on the left
+	blockReq.BlockX, blockReq.BlockW = 4, 8
+	blockReq.BlockY, blockReq.BlockH = 2, 8
+	filtered := denoise(t, tr, blockReq, tracer.DenoiseParams{Iterations: 2})
+	for blockIndex := uint32(0); blockIndex < blockReq.BlockW*blockReq.BlockH; blockIndex++ {
+		if v := filtered[blockReq.FramePixelIndex(blockIndex)]; v != (types.Vec3{}) {
+			t.Fatalf("expected filter to ignore pixels outside the block; got %v for block pixel %d", v, blockIndex)
+		}
+	}
+}
+
+func TestDenoiseRequiresAOVs(t *testing.T) {
+	tr, blockReq := denoiseTestTracer(t, func(x, y int) (types.Vec3, tracer.AOVSample) {
+		return types.Vec3{}, tracer.AOVSample{}
+	})
+	blockReq.CaptureAOVs = false
+
+	_, err := Denoise(tracer.DefaultDenoiseParams())(tr, blockReq)
+	if err != ErrAOVsNotCaptured {
+		t.Fatalf("expected to get ErrAOVsNotCaptured; got %v", err)
+	}
+}
+
+func TestDenoisePostProcessInput(t *testing.T) {
+	tr, blockReq := denoiseTestTracer(t, func(x, y int) (types.Vec3, tracer.AOVSample) {
+		v := float32((x + y) % 2)
+		return types.Vec3{v, v, v}, tracer.AOVSample{}
+	})
+	tr.pipeline.PostProcess = []PipelineStage{
+		Denoise(tracer.DenoiseParams{Iterations: 1}),
+		TonemapSimpleReinhard(),
+	}
+
+	frameAccumulator := append([]types.Vec3(nil), tr.buffers.FrameAccumulator...)
+	_, err := tr.SyncFramebuffer(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frame accumulator should be preserved for accumulating further
+	// samples while the following stages process the filtered output.
+	for pixelIndex, v := range tr.buffers.FrameAccumulator {
+		if v != frameAccumulator[pixelIndex] {
+			t.Fatalf("expected frame accumulator to remain unchanged; got %v at pixel %d; expected %v", v, pixelIndex, frameAccumulator[pixelIndex])
+		}
+	}
+	if fb0, fb1 := tr.buffers.FrameBuffer[0], tr.buffers.FrameBuffer[4]; absf(float32(fb0)-float32(fb1)) > 8 {
+		t.Fatalf("expected tone-mapping to process the denoised output; got framebuffer values %d and %d for neighboring pixels", fb0, fb1)
+	}
+
+	// Filter output should not be used if no filter stage is present
+	tr.pipeline.PostProcess = []PipelineStage{TonemapSimpleReinhard()}
+	_, err = tr.SyncFramebuffer(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb0, fb1 := tr.buffers.FrameBuffer[0], tr.buffers.FrameBuffer[4]; fb0 == fb1 {
+		t.Fatalf("expected tone-mapping to process the frame accumulator; got framebuffer value %d for neighboring pixels", fb0)
+	}
+}
+
+// Create a tracer whose frame accumulator and AOVs contain denoiseTestSpp
+// samples of the per-pixel values returned by pixelFn.
+func denoiseTestTracer(t *testing.T, pixelFn func(x, y int) (types.Vec3, tracer.AOVSample)) (*Tracer, *tracer.BlockRequest) {
+	tr, err := NewTracer("test", 2, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{denoiseTestSize, denoiseTestSize})
+	tr.UpdateState(tracer.Synchronous, tracer.SceneData, diffuseTriangleScene())
+
+	buffers := tr.(*Tracer).buffers
+	for y := 0; y < denoiseTestSize; y++ {
+		for x := 0; x < denoiseTestSize; x++ {
+			radiance, aov := pixelFn(x, y)
+			pixelIndex := y*denoiseTestSize + x
+			buffers.FrameAccumulator[pixelIndex] = radiance.Mul(denoiseTestSpp)
+			buffers.FrameAOVs[pixelIndex] = tracer.AOVSample{
+				Albedo: aov.Albedo.Mul(denoiseTestSpp),
+				Normal: aov.Normal.Mul(denoiseTestSpp),
+				Depth:  aov.Depth * denoiseTestSpp,
+			}
+		}
+	}
+
+	return tr.(*Tracer), &tracer.BlockRequest{
+		FrameW:          denoiseTestSize,
+		FrameH:          denoiseTestSize,
+		BlockW:          denoiseTestSize,
+		BlockH:          denoiseTestSize,
+		SamplesPerPixel: denoiseTestSpp,
+		Exposure:        1,
+		CaptureAOVs:     true,
+	}
+}
+
+// Apply the denoise stage and return the filtered accumulator.
+func denoise(t *testing.T, tr *Tracer, blockReq *tracer.BlockRequest, params tracer.DenoiseParams) []types.Vec3 {
+	_, err := Denoise(params)(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.filtered {
+		t.Fatal("expected the filtered accumulator to be used as the post-process input")
+	}
+	return tr.buffers.FilteredAccumulator
+}
+
+// Get the average radiance of each pixel in an accumulator.
+func averagePixels(accumulator []types.Vec3) []types.Vec3 {
+	out := make([]types.Vec3, len(accumulator))
+	for pixelIndex, v := range accumulator {
+		out[pixelIndex] = v.Mul(1.0 / denoiseTestSpp)
+	}
+	return out
+}
+
+// Calculate the variance of the red channel values.
+func variance(pixels []types.Vec3) float32 {
+	var mean, meanSq float32
+	for _, v := range pixels {
+		mean += v[0]
+		meanSq += v[0] * v[0]
+	}
+	mean /= float32(len(pixels))
+	meanSq /= float32(len(pixels))
+	return meanSq - mean*mean
+}
+
+func approxEqualVec3(a, b types.Vec3, eps float32) bool {
+	return absf(a[0]-b[0]) <= eps && absf(a[1]-b[1]) <= eps && absf(a[2]-b[2]) <= eps
+}
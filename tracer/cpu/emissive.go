@@ -0,0 +1,148 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Select a random emissive surface from the set of emissive primitives.
+func emissiveSelect(numEmissives int, randSample float32) (int, float32) {
+	// Selection probability is as simple as 1/numEmissives
+	index := int(randSample * float32(numEmissives))
+	if index >= numEmissives {
+		index = numEmissives - 1
+	}
+
+	return index, 1.0 / float32(numEmissives)
+}
+
+// Generate an out ray direction towards a random point on the emissive primitive
+// and return a emission material sample from that point together with the
+// sample PDF and the distance to the emissive.
+func emissiveGetSample(sc *scene.Scene, surf *surface, emissive *scene.EmissivePrimitive, randSample types.Vec2) (sample, outRayDir types.Vec3, pdf, distToEmissive float32) {
+	switch emissive.Type {
+	case scene.AreaLight:
+		return areaLightGetSample(sc, surf, emissive, randSample)
+	case scene.EnvironmentLight:
+		return environmentLightGetSample(sc, surf, emissive, randSample)
+	}
+
+	return types.Vec3{}, types.Vec3{}, 0.0, 0.0
+}
+
+// Given a pre-calculated bounce ray, calculate a PDF for hitting this
+// emissive primitive.
+func emissiveGetPdf(sc *scene.Scene, surf *surface, emissive *scene.EmissivePrimitive, outRayDir types.Vec3) float32 {
+	switch emissive.Type {
+	case scene.AreaLight:
+		return areaLightGetPdf(sc, surf, emissive, outRayDir)
+	case scene.EnvironmentLight:
+		// We use the same formula as for lambert shading: cos(theta) / PI
+		return maxf(0.0, surf.normal.Dot(outRayDir)*c1Pi)
+	}
+
+	return 0.0
+}
+
+// Sample the environment light using a cosine weighted hemisphere direction.
+func environmentLightGetSample(sc *scene.Scene, surf *surface, emissive *scene.EmissivePrimitive, randSample types.Vec2) (types.Vec3, types.Vec3, float32, float32) {
+	outRayDir := cosWeightedHemisphereGetSample(surf.normal, randSample)
+	pdf := maxf(0.0, surf.normal.Dot(outRayDir)) * c1Pi
+
+	// Convert ray direction vector into spherical UV and use that to sample the env map
+	node := decodeMaterialNode(&sc.MaterialNodeList[emissive.MaterialNodeIndex])
+	radiance := matGetSample3f(sc, rayToLatLongUV(outRayDir), node.kval, node.tex)
+
+	return radiance.Mul(node.roughness * c1Pi), outRayDir, pdf, cMaxFloat
+}
+
+// Generate a out ray direction towards a random point on the area light
+// and return a emission material sample from that point.
+func areaLightGetSample(sc *scene.Scene, surf *surface, emissive *scene.EmissivePrimitive, randSample types.Vec2) (types.Vec3, types.Vec3, float32, float32) {
+	// Select a random point on the emissive with PDF=1/area and get its *world* xyz/normal coordinates
+	r1sqrt := sqrtf(randSample[0])
+	ru := (1.0 - randSample[1]) * r1sqrt
+	rv := randSample[1] * r1sqrt
+	wuv := types.Vec3{1.0 - ru - rv, ru, rv}
+	offset := emissive.PrimitiveIndex * 3
+
+	emissivePoint := transformPoint(
+		emissive.Transform,
+		sc.VertexList[offset].Vec3().Mul(wuv[0]).
+			Add(sc.VertexList[offset+1].Vec3().Mul(wuv[1])).
+			Add(sc.VertexList[offset+2].Vec3().Mul(wuv[2])),
+	)
+
+	emissiveNormal := transformDir(
+		emissive.Transform,
+		sc.NormalList[offset].Vec3().Mul(wuv[0]).
+			Add(sc.NormalList[offset+1].Vec3().Mul(wuv[1])).
+			Add(sc.NormalList[offset+2].Vec3().Mul(wuv[2])),
+	).Normalize()
+
+	emissiveUV := types.Vec2{
+		wuv[0]*sc.UvList[offset][0] + wuv[1]*sc.UvList[offset+1][0] + wuv[2]*sc.UvList[offset+2][0],
+		wuv[0]*sc.UvList[offset][1] + wuv[1]*sc.UvList[offset+1][1] + wuv[2]*sc.UvList[offset+2][1],
+	}
+
+	emissiveRay := emissivePoint.Sub(surf.point)
+	squaredDistToLight := emissiveRay.Dot(emissiveRay)
+	outRayDir := emissiveRay.Normalize()
+	distToEmissive := sqrtf(squaredDistToLight)
+
+	nDotOutRay := -emissiveNormal.Dot(outRayDir)
+	if nDotOutRay <= 0.0 {
+		return types.Vec3{}, outRayDir, 0.0, distToEmissive
+	}
+
+	// convert from area to solid angle using formula (25) from total compedium:
+	// ω = cos(θy) / dist^2
+	node := decodeMaterialNode(&sc.MaterialNodeList[emissive.MaterialNodeIndex])
+	ke := matGetSample3f(sc, emissiveUV, node.kval, node.tex)
+	return ke.Mul(node.roughness * nDotOutRay / squaredDistToLight), outRayDir, 1.0 / emissive.Area, distToEmissive
+}
+
+// Given a pre-calculated bounce ray, calculate a PDF for hitting this area light.
+func areaLightGetPdf(sc *scene.Scene, surf *surface, emissive *scene.EmissivePrimitive, outRayDir types.Vec3) float32 {
+	// Transform vertices to world space and check for ray/tri intersection
+	// using Moller-Trumbore algorithm
+	offset := emissive.PrimitiveIndex * 3
+	v0 := transformPoint(emissive.Transform, sc.VertexList[offset].Vec3())
+	edge01 := transformPoint(emissive.Transform, sc.VertexList[offset+1].Vec3()).Sub(v0)
+	edge02 := transformPoint(emissive.Transform, sc.VertexList[offset+2].Vec3()).Sub(v0)
+
+	pVec := outRayDir.Cross(edge02)
+	det := edge01.Dot(pVec)
+	if absf(det) < intersectionEpsilon {
+		return 0.0
+	}
+
+	invDet := 1.0 / det
+
+	// Calculate barycentric coords
+	tVec := surf.point.Sub(v0)
+	u := tVec.Dot(pVec) * invDet
+	if u < 0.0 || u > 1.0 {
+		return 0.0
+	}
+
+	qVec := tVec.Cross(edge01)
+	v := outRayDir.Dot(qVec) * invDet
+	if v < 0.0 || u+v > 1.0 {
+		return 0.0
+	}
+
+	t := edge02.Dot(qVec) * invDet
+	if t < intersectionEpsilon {
+		return 0.0
+	}
+
+	// The cos term allows us to convert from the uniform pdf 1/|A| from area measure
+	// to the solid angle measure
+	emissiveNormal := edge01.Cross(edge02).Normalize()
+	denominator := emissive.Area * absf(emissiveNormal.Dot(outRayDir))
+	if denominator <= 0.0 {
+		return 0.0
+	}
+	return (t * t) / denominator
+}
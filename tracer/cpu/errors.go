@@ -0,0 +1,11 @@
+package cpu
+
+import "errors"
+
+var (
+	ErrUnsupportedChangeType = errors.New("cpu tracer: unsupported change type")
+	ErrInvalidChangeData     = errors.New("cpu tracer: invalid data type for change")
+	ErrNoSceneData           = errors.New("cpu tracer: no scene data uploaded")
+	ErrBuffersNotAllocated   = errors.New("cpu tracer: frame buffers not allocated")
+	ErrAOVsNotCaptured       = errors.New("cpu tracer: AOV capture is not enabled")
+)
@@ -0,0 +1,179 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Calculate MIS weights using the power heuristic.
+func powerHeuristic(a, b float32) float32 {
+	denom := a*a + b*b
+	if denom == 0.0 {
+		return 0.0
+	}
+	return (a * a) / denom
+}
+
+// Convert a color value to luminance.
+func luminance(v types.Vec3) float32 {
+	return 0.2126*v[0] + 0.7152*v[1] + 0.0722*v[2]
+}
+
+// The number of indirect and occlusion rays traced by an integrator worker.
+type rayCounts struct {
+	indirect  uint64
+	occlusion uint64
+}
+
+// Trace a primary ray through the scene using a monte-carlo path tracer and
+// return the accumulated radiance along its path. At each bounce we calculate
+// an outgoing indirect ray based on the surface BXDF and also perform direct
+// light sampling by emitting occlusion rays towards a randomly selected emissive.
+//
+// If aov is not nil, it is populated with the AOVs for the first hit. The
+// number of traced indirect and occlusion rays is added to counts.
+func tracePath(sc *scene.Scene, blockReq *tracer.BlockRequest, r ray, p *path, aov *tracer.AOVSample, counts *rayCounts) types.Vec3 {
+	var accumulator types.Vec3
+	var hit intersection
+	var bounce uint32
+
+	rndState := rng{blockReq.Seed, p.pixelIndex + 1}
+	numEmissives := len(sc.EmissivePrimitives)
+
+	for bounce = 0; bounce < blockReq.NumBounces; bounce++ {
+		if bounce > 0 {
+			counts.indirect++
+		}
+
+		if !intersectionQuery(sc, &r, &hit) {
+			// Shade misses by sampling the global env map or the scene bg color
+			if sc.SceneDiffuseMatIndex != -1 {
+				node := decodeMaterialNode(&sc.MaterialNodeList[sc.SceneDiffuseMatIndex])
+				kd := matGetSample3f(sc, rayToLatLongUV(r.dir), node.kval, node.tex)
+				accumulator = accumulator.Add(mulVec3(p.throughput, kd))
+			}
+			break
+		}
+
+		sample0 := rndState.sample2f()
+		sample1 := rndState.sample2f()
+		sample2 := rndState.sample2f()
+
+		// All BxDF formulas use in/out rays that are going outwards from the surface.
+		inRayDir := r.dir.Mul(-1.0)
+
+		surf := newSurface(sc, &r, &hit)
+		node, tint := selectMaterialNode(sc, p, &surf, &rndState)
+
+		if bounce == 0 && aov != nil {
+			aov.Albedo = mulVec3(tint, matGetSample3f(sc, surf.uv, node.kval, node.tex))
+			aov.Normal = surf.normal
+			aov.Depth = hit.t
+			aov.MeshInstance = int32(hit.meshInstance)
+			aov.Material = int32(surf.matNodeIndex)
+		}
+
+		// If we hit an emissive node we need to accumulate implicit
+		// light and terminate the path.
+		if bxdfIsEmissive(node.nodeType) {
+			// Make sure that the incoming ray is facing the emissive.
+			if inRayDir.Dot(surf.normal) > 0.0 {
+				radiance := matGetSample3f(sc, surf.uv, node.kval, node.tex).Mul(node.roughness)
+				accumulator = accumulator.Add(mulVec3(p.throughput, radiance))
+			}
+			break
+		}
+
+		if !bxdfIsValid(node.nodeType) {
+			break
+		}
+
+		// Implement RR to terminate paths with no significant contribution
+		// killing paths with a probability less than sample2.x while also
+		// boosting surving paths by the same probablility.
+		if bounce >= blockReq.MinBouncesForRR {
+			rrProbability := maxf(minf(0.5, luminance(p.throughput)), 0.01)
+			if rrProbability < sample2[0] {
+				break
+			}
+			p.throughput = p.throughput.Mul(1.0 / rrProbability)
+		}
+
+		// Get BXDF sample and generate outgoing ray based on surface BXDF
+		bxdfSample, bxdfOutRayDir, bxdfPdf := bxdfGetSample(sc, &surf, &node, sample0, inRayDir)
+		var bxdfWeight float32 = 1.0
+
+		// To calculate the origin for occlusion/indirect rays we displace the
+		// surface hit point by a small epsilon along the normal to ensure that
+		// we don't register an intersection with the same surface.  If this
+		// material is refractive and we are hitting it from the outside we
+		// need to ensure that the outgoing ray starts inside the surface.
+		outBxdfRayOrigin := surf.point.Add(surf.normal.Mul(signf(surf.normal.Dot(bxdfOutRayDir)) * intersectionEpsilon))
+
+		// The emissive ray always starts away from the surface. This allows us to shade BTDFs
+		outEmissiveRayOrigin := surf.point.Add(surf.normal.Mul(intersectionEpsilon))
+
+		// Select and sample emissive source
+		if numEmissives > 0 {
+			emissiveIndex, emissiveSelectionPdf := emissiveSelect(numEmissives, sample1[0])
+			emissive := &sc.EmissivePrimitives[emissiveIndex]
+			emissiveSample, emissiveOutRayDir, emissivePdf, distToEmissive := emissiveGetSample(sc, &surf, emissive, sample1)
+
+			// MIS: we already have a PDF for generating emissiveOutRayDir.
+			// Calculate a PDF for the BXDF sampler generating the same ray
+			// and generate sampling weights using the power heuristic.
+			bxdfEmissivePdf := bxdfGetPdf(sc, &surf, &node, inRayDir, emissiveOutRayDir)
+			emissiveWeight := powerHeuristic(emissivePdf, bxdfEmissivePdf)
+
+			// We use the same approach to calculate a weight for the BXDF sample by
+			// calculating the PDF for the emissive sampler generating bxdfOutRayDir
+			emissiveBxdfPdf := emissiveGetPdf(sc, &surf, emissive, bxdfOutRayDir)
+			bxdfWeight = powerHeuristic(bxdfPdf, emissiveBxdfPdf)
+
+			// If we have a valid emissive sample trace an occlusion ray and
+			// accumulate the sample if the emissive is visible.
+			nDotEmissiveOutRay := maxf(0.0, surf.normal.Dot(emissiveOutRayDir))
+			if emissiveSample.MaxComponent() > 0.0 && emissivePdf > 0.0 && nDotEmissiveOutRay > 0.0 {
+				bxdfEmissiveSample := bxdfEval(sc, &surf, &node, inRayDir, emissiveOutRayDir)
+				emissiveSample = mulVec3(mulVec3(emissiveSample, bxdfEmissiveSample), p.throughput).
+					Mul(emissiveWeight * nDotEmissiveOutRay / (emissivePdf * emissiveSelectionPdf))
+
+				if emissiveSample.MaxComponent() > 0.0 {
+					occlusionRay := ray{
+						origin:  outEmissiveRayOrigin,
+						dir:     emissiveOutRayDir,
+						maxDist: distToEmissive - intersectionWithLightEpsilon,
+					}
+					counts.occlusion++
+					if !intersectionTest(sc, &occlusionRay) {
+						accumulator = accumulator.Add(emissiveSample)
+					}
+				}
+			}
+		}
+
+		// Disable bxdfWeight for singular surfaces (ideal mirror/dielectric)
+		if bxdfIsSingular(node.nodeType) {
+			bxdfWeight = 1.0
+		}
+
+		// If we got a valid bxdf sample update the path throughput
+		// Note: we are using the abs value of the dot product as
+		// it will be negative for rays entering into refractive surfaces
+		throughput := mulVec3(bxdfSample, tint).Mul(bxdfWeight * absf(surf.normal.Dot(bxdfOutRayDir)))
+		if throughput.MaxComponent() <= 0.0 || bxdfPdf <= 0.0 {
+			break
+		}
+		p.throughput = mulVec3(p.throughput, throughput).Mul(1.0 / bxdfPdf)
+
+		r = ray{
+			origin:    outBxdfRayOrigin,
+			dir:       bxdfOutRayDir,
+			maxDist:   cMaxFloat,
+			pathIndex: r.pathIndex,
+		}
+	}
+
+	return accumulator
+}
@@ -0,0 +1,215 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+const bvhMaxStackSize = 64
+
+// A ray used for intersection queries.
+type ray struct {
+	origin types.Vec3
+	dir    types.Vec3
+
+	// The max allowed distance for intersection queries.
+	maxDist float32
+
+	// The path index associated with this ray.
+	pathIndex uint32
+}
+
+// The details of a ray intersection with the scene geometry.
+type intersection struct {
+	// Barycentric coords of hit.
+	wuv types.Vec3
+
+	// Distance from ray origin to hit.
+	t float32
+
+	// Mesh instance that registered the hit.
+	meshInstance uint32
+
+	// Index to the triangle that was intersected.
+	triIndex uint32
+}
+
+// Test for ray intersections with scene geometry. This method returns
+// as soon as any intersection is found and does not calculate any intersection
+// details so its cheaper to use for general intersection queries (e.g light occlusion).
+func intersectionTest(sc *scene.Scene, r *ray) bool {
+	return traverseBvh(sc, r, true, nil)
+}
+
+// Find the closest ray intersection with scene geometry and fill in the
+// intersection details. Returns true if an intersection was found.
+func intersectionQuery(sc *scene.Scene, r *ray, hit *intersection) bool {
+	return traverseBvh(sc, r, false, hit)
+}
+
+// Traverse the two-level scene BVH looking for ray intersections. Top-level
+// BVH leaves point to mesh instances; when we reach one of them we transform
+// the ray into mesh space and continue traversal using the mesh BVH tree.
+func traverseBvh(sc *scene.Scene, r *ray, anyHit bool, hit *intersection) bool {
+	if len(sc.BvhNodeList) == 0 {
+		return false
+	}
+
+	var nodeStack [bvhMaxStackSize]uint32
+	var meshInstanceId uint32
+	var leftDist, rightDist float32
+	var wantLeft, wantRight bool
+
+	stackIndex := 0
+	meshBvhStackStartIndex := -1
+	curNode := &sc.BvhNodeList[0]
+
+	rayOrigin := r.origin
+	rayDir := r.dir
+	invDir := invVec3(rayDir)
+
+	closestHit := r.maxDist
+	gotHit := false
+
+	for stackIndex > -1 {
+		if curNode.LData <= 0 {
+			if curNode.RData == 0 {
+				// This is a top BVH leaf; load the mesh instance and
+				// transform the ray into mesh space.
+				meshInstanceId = uint32(-curNode.LData)
+				meshInstance := &sc.MeshInstanceList[meshInstanceId]
+
+				// Push bottom BVH root to the stack and keep a record
+				// of the current stack so that we know when we exit the
+				// bottom BVH
+				meshBvhStackStartIndex = stackIndex
+				nodeStack[stackIndex] = meshInstance.BvhRoot
+				stackIndex++
+
+				rayOrigin = transformPoint(meshInstance.Transform, r.origin)
+				rayDir = transformDir(meshInstance.Transform, r.dir)
+				invDir = invVec3(rayDir)
+			} else {
+				// Intersect with all leaf triangles using the Moller-Trumbore algorithm
+				firstTriIndex, numTriangles := curNode.GetPrimitives()
+				for triIndex := firstTriIndex; triIndex < firstTriIndex+numTriangles; triIndex++ {
+					u, v, t, ok := intersectTriangle(sc.VertexList, triIndex, rayOrigin, rayDir)
+					if !ok || t >= closestHit {
+						continue
+					}
+
+					if anyHit {
+						return true
+					}
+
+					gotHit = true
+					closestHit = t
+					hit.wuv = types.Vec3{1.0 - (u + v), u, v}
+					hit.t = t
+					hit.triIndex = triIndex
+					hit.meshInstance = meshInstanceId
+				}
+			}
+
+			wantLeft = false
+			wantRight = false
+		} else {
+			leftDist, wantLeft = intersectBBox(&sc.BvhNodeList[curNode.LData], rayOrigin, invDir, closestHit)
+			rightDist, wantRight = intersectBBox(&sc.BvhNodeList[curNode.RData], rayOrigin, invDir, closestHit)
+		}
+
+		if wantLeft && wantRight {
+			// Visit the closest node first
+			if leftDist <= rightDist {
+				nodeStack[stackIndex] = uint32(curNode.RData)
+				curNode = &sc.BvhNodeList[curNode.LData]
+			} else {
+				nodeStack[stackIndex] = uint32(curNode.LData)
+				curNode = &sc.BvhNodeList[curNode.RData]
+			}
+			stackIndex++
+		} else if wantLeft {
+			curNode = &sc.BvhNodeList[curNode.LData]
+		} else if wantRight {
+			curNode = &sc.BvhNodeList[curNode.RData]
+		} else {
+			if stackIndex == meshBvhStackStartIndex {
+				// If we exited from a bottom bvh tree we need to restore our ray
+				rayOrigin = r.origin
+				rayDir = r.dir
+				invDir = invVec3(rayDir)
+				meshBvhStackStartIndex = -1
+			}
+
+			// Pop the next node off the stack
+			stackIndex--
+			if stackIndex >= 0 {
+				curNode = &sc.BvhNodeList[nodeStack[stackIndex]]
+			}
+		}
+	}
+
+	return gotHit
+}
+
+// Check for a ray intersection with the node bounding box. Returns the
+// distance to the box and a flag indicating whether the box was hit.
+func intersectBBox(node *scene.BvhNode, rayOrigin, invDir types.Vec3, maxDist float32) (float32, bool) {
+	var tmin, tmax, rmin, rmax types.Vec3
+	for i := 0; i < 3; i++ {
+		tmin[i] = (node.Min[i] - rayOrigin[i]) * invDir[i]
+		tmax[i] = (node.Max[i] - rayOrigin[i]) * invDir[i]
+		rmin[i] = minf(tmin[i], tmax[i])
+		rmax[i] = maxf(tmin[i], tmax[i])
+	}
+
+	minmax := minf(minf(rmax[0], rmax[1]), rmax[2])
+	maxmin := maxf(maxf(rmin[0], rmin[1]), rmin[2])
+	if minmax < 0 || maxmin > minmax || maxmin >= maxDist {
+		return cMaxFloat, false
+	}
+
+	return maxmin, true
+}
+
+// Intersect a ray with a triangle using the Moller-Trumbore algorithm. Returns
+// the u, v barycentric coordinates of the hit and the distance from the ray origin.
+func intersectTriangle(vertices []types.Vec4, triIndex uint32, rayOrigin, rayDir types.Vec3) (u, v, t float32, hit bool) {
+	vIndex := triIndex * 3
+	v0 := vertices[vIndex].Vec3()
+	edge01 := vertices[vIndex+1].Vec3().Sub(v0)
+	edge02 := vertices[vIndex+2].Vec3().Sub(v0)
+
+	pVec := rayDir.Cross(edge02)
+	det := edge01.Dot(pVec)
+	if absf(det) < intersectionEpsilon {
+		return 0, 0, 0, false
+	}
+
+	invDet := 1.0 / det
+
+	// Calculate barycentric coords
+	tVec := rayOrigin.Sub(v0)
+	u = tVec.Dot(pVec) * invDet
+	if u < 0.0 || u > 1.0 {
+		return 0, 0, 0, false
+	}
+
+	qVec := tVec.Cross(edge01)
+	v = rayDir.Dot(qVec) * invDet
+	if v < 0.0 || u+v > 1.0 {
+		return 0, 0, 0, false
+	}
+
+	t = edge02.Dot(qVec) * invDet
+	if t <= intersectionEpsilon {
+		return 0, 0, 0, false
+	}
+
+	return u, v, t, true
+}
+
+// Calculate the reciprocal of each vector component.
+func invVec3(v types.Vec3) types.Vec3 {
+	return types.Vec3{1.0 / v[0], 1.0 / v[1], 1.0 / v[2]}
+}
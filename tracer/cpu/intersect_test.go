@@ -0,0 +1,93 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestIntersectionQuery(t *testing.T) {
+	sc := singleTriangleScene()
+
+	specs := []struct {
+		r      ray
+		expHit bool
+		expT   float32
+	}{
+		// Hit triangle center
+		{ray{origin: types.Vec3{0, 0, 1}, dir: types.Vec3{0, 0, -1}, maxDist: cMaxFloat}, true, 2.0},
+		// Ray pointing away from triangle
+		{ray{origin: types.Vec3{0, 0, 1}, dir: types.Vec3{0, 0, 1}, maxDist: cMaxFloat}, false, 0},
+		// Ray passing next to triangle
+		{ray{origin: types.Vec3{5, 0, 1}, dir: types.Vec3{0, 0, -1}, maxDist: cMaxFloat}, false, 0},
+		// Triangle is further than the max ray distance
+		{ray{origin: types.Vec3{0, 0, 1}, dir: types.Vec3{0, 0, -1}, maxDist: 1.0}, false, 0},
+	}
+
+	for specIndex, spec := range specs {
+		var hit intersection
+		gotHit := intersectionQuery(sc, &spec.r, &hit)
+		if gotHit != spec.expHit {
+			t.Errorf("[spec %d] expected intersection query to return %t; got %t", specIndex, spec.expHit, gotHit)
+			continue
+		}
+
+		if gotHit != intersectionTest(sc, &spec.r) {
+			t.Errorf("[spec %d] expected intersection test to return %t; got %t", specIndex, gotHit, !gotHit)
+		}
+
+		if !gotHit {
+			continue
+		}
+
+		if absf(hit.t-spec.expT) > intersectionEpsilon {
+			t.Errorf("[spec %d] expected hit distance to be %f; got %f", specIndex, spec.expT, hit.t)
+		}
+
+		wuvSum := hit.wuv[0] + hit.wuv[1] + hit.wuv[2]
+		if absf(wuvSum-1.0) > intersectionEpsilon {
+			t.Errorf("[spec %d] expected barycentric coords to sum to 1; got %f", specIndex, wuvSum)
+		}
+	}
+}
+
+func TestIntersectionQueryWithTransformedInstance(t *testing.T) {
+	sc := singleTriangleScene()
+
+	// Move the mesh instance 2 units away from the camera. The instance
+	// transform maps world coordinates to mesh coordinates.
+	sc.MeshInstanceList[0].Transform = types.Translate4(types.Vec3{0, 0, 2}).Mul4(sc.MeshInstanceList[0].Transform)
+
+	var hit intersection
+	r := ray{origin: types.Vec3{0, 0, 1}, dir: types.Vec3{0, 0, -1}, maxDist: cMaxFloat}
+	if !intersectionQuery(sc, &r, &hit) {
+		t.Fatal("expected ray to intersect transformed mesh instance")
+	}
+
+	var expT float32 = 4.0
+	if absf(hit.t-expT) > intersectionEpsilon {
+		t.Fatalf("expected hit distance to be %f; got %f", expT, hit.t)
+	}
+}
+
+// Generate a scene with a single mesh instance containing a triangle
+// lying on the z = -1 plane.
+func singleTriangleScene() *scene.Scene {
+	return &scene.Scene{
+		BvhNodeList: []scene.BvhNode{
+			// Top-level BVH leaf pointing to mesh instance 0
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 0},
+			// Mesh BVH leaf containing triangle 0
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 1},
+		},
+		MeshInstanceList: []scene.MeshInstance{
+			{MeshIndex: 0, BvhRoot: 1, Transform: types.Ident4()},
+		},
+		VertexList: []types.Vec4{
+			{-1, -1, -1, 1},
+			{1, -1, -1, 1},
+			{0, 1, -1, 1},
+		},
+	}
+}
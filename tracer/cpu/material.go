@@ -0,0 +1,310 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Path flags for tracking the selected channel when rendering dispersive materials.
+const (
+	pathFlagDisperseR uint32 = 1 << iota
+	pathFlagDisperseG
+	pathFlagDisperseB
+)
+
+// The surface parameters at a ray intersection point.
+type surface struct {
+	// Intersection point and normal in world space.
+	point  types.Vec3
+	normal types.Vec3
+
+	// Texture uv coords at intersection point.
+	uv types.Vec2
+
+	// Material node index.
+	matNodeIndex uint32
+}
+
+// Initialize surface parameters using the intersection details.
+func newSurface(sc *scene.Scene, r *ray, hit *intersection) surface {
+	offset := hit.triIndex * 3
+	wuv := hit.wuv
+
+	// Lerp barycentric coords to get normal and uv coords. Mesh normals
+	// are specified in mesh space so we need to transform them to world space.
+	normal := sc.NormalList[offset].Vec3().Mul(wuv[0]).
+		Add(sc.NormalList[offset+1].Vec3().Mul(wuv[1])).
+		Add(sc.NormalList[offset+2].Vec3().Mul(wuv[2]))
+
+	return surface{
+		point:  r.origin.Add(r.dir.Mul(hit.t)),
+		normal: transformNormal(sc.MeshInstanceList[hit.meshInstance].Transform, normal),
+		uv: types.Vec2{
+			wuv[0]*sc.UvList[offset][0] + wuv[1]*sc.UvList[offset+1][0] + wuv[2]*sc.UvList[offset+2][0],
+			wuv[0]*sc.UvList[offset][1] + wuv[1]*sc.UvList[offset+1][1] + wuv[2]*sc.UvList[offset+2][1],
+		},
+		matNodeIndex: sc.MaterialIndex[hit.triIndex],
+	}
+}
+
+// A decoded material node. The scene stores material nodes using a union-like
+// layout; this type gives a name to each one of the union fields.
+type materialNode struct {
+	nodeType   uint32
+	leftChild  uint32
+	rightChild uint32
+
+	// Transmittance texture or right child for op nodes.
+	transmittanceTex int32
+
+	// Bump map, mix weight, reflectance, specularity or radiance texture.
+	tex int32
+
+	// Reflectance, specularity, radiance or internal dispersion IORs. For mix
+	// nodes the first component stores the mix weight.
+	kval types.Vec3
+
+	// Transmittance or external dispersion IORs.
+	transmittance types.Vec3
+
+	intIOR float32
+	extIOR float32
+
+	// Roughness or radiance scaler.
+	roughness    float32
+	roughnessTex int32
+}
+
+// Decode a scene material node.
+func decodeMaterialNode(node *scene.MaterialNode) materialNode {
+	return materialNode{
+		nodeType:         uint32(node.Union1[0]),
+		leftChild:        uint32(node.Union1[1]),
+		rightChild:       uint32(node.Union1[2]),
+		transmittanceTex: node.Union1[2],
+		tex:              node.Union1[3],
+		kval:             node.Union2.Vec3(),
+		transmittance:    node.Union3.Vec3(),
+		intIOR:           node.Union4[0],
+		extIOR:           node.Union4[1],
+		roughness:        node.Union4[2],
+		roughnessTex:     node.Union5[0],
+	}
+}
+
+// Traverse the layered material tree for this surface and select a leaf node.
+// Returns the selected node as well as a tint value that should be applied
+// to the bxdf sample.
+func selectMaterialNode(sc *scene.Scene, p *path, surf *surface, rndState *rng) (materialNode, types.Vec3) {
+	var forceIntIOR, forceExtIOR float32
+	tint := types.Vec3{1, 1, 1}
+	node := decodeMaterialNode(&sc.MaterialNodeList[surf.matNodeIndex])
+
+	for material.IsOpType(node.nodeType) {
+		next := node.leftChild
+		switch material.OpType(node.nodeType) {
+		case material.OpMix:
+			// Depending on the sample, follow left or right
+			sample := rndState.sample2f()
+			if sample[0] >= node.kval[0] {
+				next = node.rightChild
+			}
+		case material.OpMixMap:
+			// Sample weight from texture
+			sample := rndState.sample2f()
+			if sample[0] >= texGetSample1f(sc, surf.uv, node.tex) {
+				next = node.rightChild
+			}
+		case material.OpBumpMap:
+			surf.normal = matGetBumpSample3f(sc, surf.normal, surf.uv, node.tex)
+		case material.OpNormalMap:
+			surf.normal = matGetNormalSample3f(sc, surf.normal, surf.uv, node.tex)
+		case material.OpDisperse:
+			// If the path already has a disperse flag set use it to select
+			// the IOR values and use the tint value as a primary color filter.
+			// Otherwise, we randomly select a channel and set the proper
+			// dispersion flag so it can be reused when exiting the material.
+			channel := -1
+			switch {
+			case p.flags&pathFlagDisperseR != 0:
+				channel = 0
+			case p.flags&pathFlagDisperseG != 0:
+				channel = 1
+			case p.flags&pathFlagDisperseB != 0:
+				channel = 2
+			default:
+				sample := rndState.sample2f()
+				switch {
+				case sample[0] < 0.333:
+					channel = 0
+				case sample[0] < 0.666:
+					channel = 1
+				default:
+					channel = 2
+				}
+				p.flags |= pathFlagDisperseR << uint32(channel)
+			}
+
+			tint = types.Vec3{}
+			tint[channel] = 1.0
+			forceIntIOR = node.kval[channel]
+			forceExtIOR = node.transmittance[channel]
+		}
+
+		node = decodeMaterialNode(&sc.MaterialNodeList[next])
+	}
+
+	// Apply dispersion IORs
+	node.intIOR = maxf(node.intIOR, forceIntIOR)
+	node.extIOR = maxf(node.extIOR, forceExtIOR)
+
+	return node, tint
+}
+
+// Sample texture using the supplied uv coordinates and return a Vec3.
+// If texIndex is -1 then fall-back to the supplied default value.
+func matGetSample3f(sc *scene.Scene, uv types.Vec2, defaultValue types.Vec3, texIndex int32) types.Vec3 {
+	if texIndex == -1 {
+		return defaultValue
+	}
+
+	return texGetSample3f(sc, uv, texIndex)
+}
+
+// Sample texture using the supplied uv coordinates and return a float value.
+// If texIndex is -1 then fall-back to the supplied default value.
+func matGetSample1f(sc *scene.Scene, uv types.Vec2, defaultValue float32, texIndex int32) float32 {
+	if texIndex == -1 {
+		return defaultValue
+	}
+
+	return texGetSample1f(sc, uv, texIndex)
+}
+
+// Apply normal map to intersection normal.
+func matGetNormalSample3f(sc *scene.Scene, normal types.Vec3, uv types.Vec2, texIndex int32) types.Vec3 {
+	u, v := tangentVectors(normal)
+
+	// Sample normal map and convert it into the [-1, 1] range.
+	sample := texGetSample3f(sc, uv, texIndex)
+	for i := 0; i < 3; i++ {
+		sample[i] = sample[i]*2.0 - 1.0
+	}
+	return u.Mul(sample[0]).Add(v.Mul(sample[1])).Add(normal.Mul(0.5 * sample[2])).Normalize()
+}
+
+// Apply bump map to intersection normal.
+func matGetBumpSample3f(sc *scene.Scene, normal types.Vec3, uv types.Vec2, texIndex int32) types.Vec3 {
+	u, v := tangentVectors(normal)
+
+	sample := texGetBumpSample3f(sc, uv, texIndex)
+	for i := 0; i < 3; i++ {
+		sample[i] = sample[i]*2.0 - 1.0
+	}
+	return u.Mul(sample[0]).Add(v.Mul(sample[1])).Add(normal.Mul(sample[2])).Normalize()
+}
+
+// The coordinates of the texels used for applying bilinear filtering.
+type texelCoords struct {
+	tx, ty, bx, by uint32
+	coeffX, coeffY float32
+}
+
+// Calculate the texel coordinates for the supplied uv coordinates.
+func getTexelCoords(meta *scene.TextureMetadata, uv types.Vec2) texelCoords {
+	// Handle repeating textures by keeping the fractional part of uv and
+	// scale to [0, texDims) range
+	scaledU := (uv[0] - float32(math.Floor(float64(uv[0])))) * float32(meta.Width)
+	scaledV := (uv[1] - float32(math.Floor(float64(uv[1])))) * float32(meta.Height)
+
+	tc := texelCoords{
+		tx: clampu(uint32(scaledU), meta.Width-1),
+		ty: clampu(uint32(scaledV), meta.Height-1),
+	}
+	tc.bx = clampu(tc.tx+1, meta.Width-1)
+	tc.by = clampu(tc.ty+1, meta.Height-1)
+	tc.coeffX = scaledU - float32(tc.tx)
+	tc.coeffY = scaledV - float32(tc.ty)
+	return tc
+}
+
+// Read a texel channel as a float value.
+func readTexel(sc *scene.Scene, meta *scene.TextureMetadata, x, y uint32, channel uint32) float32 {
+	texelIndex := y*meta.Width + x
+	switch meta.Format {
+	case texture.Luminance8:
+		return float32(sc.TextureData[meta.DataOffset+texelIndex]) / 255.0
+	case texture.Luminance32F:
+		return readFloat32(sc.TextureData, meta.DataOffset+texelIndex<<2)
+	case texture.Rgba8:
+		return float32(sc.TextureData[meta.DataOffset+texelIndex<<2+channel]) / 255.0
+	case texture.Rgba32F:
+		return readFloat32(sc.TextureData, meta.DataOffset+(texelIndex<<2+channel)<<2)
+	}
+	return 0.0
+}
+
+// Sample a texture channel using bilinear filtering.
+func sampleTexelChannel(sc *scene.Scene, meta *scene.TextureMetadata, tc texelCoords, channel uint32) float32 {
+	return mixf(
+		mixf(readTexel(sc, meta, tc.tx, tc.ty, channel), readTexel(sc, meta, tc.tx, tc.by, channel), tc.coeffY),
+		mixf(readTexel(sc, meta, tc.bx, tc.ty, channel), readTexel(sc, meta, tc.bx, tc.by, channel), tc.coeffY),
+		tc.coeffX,
+	)
+}
+
+// Sample texture at given uv coordinates returning back a Vec3.
+func texGetSample3f(sc *scene.Scene, uv types.Vec2, texIndex int32) types.Vec3 {
+	meta := &sc.TextureMetadata[texIndex]
+	tc := getTexelCoords(meta, uv)
+
+	switch meta.Format {
+	case texture.Rgba8, texture.Rgba32F:
+		return types.Vec3{
+			sampleTexelChannel(sc, meta, tc, 0),
+			sampleTexelChannel(sc, meta, tc, 1),
+			sampleTexelChannel(sc, meta, tc, 2),
+		}
+	}
+
+	r := sampleTexelChannel(sc, meta, tc, 0)
+	return types.Vec3{r, r, r}
+}
+
+// Sample texture at given uv coordinates returning back a float. For
+// multi-channel textures we only read from the red channel.
+func texGetSample1f(sc *scene.Scene, uv types.Vec2, texIndex int32) float32 {
+	meta := &sc.TextureMetadata[texIndex]
+	return sampleTexelChannel(sc, meta, getTexelCoords(meta, uv), 0)
+}
+
+// Sample bump map texture at given uv coordinates returning back a Vec3.
+func texGetBumpSample3f(sc *scene.Scene, uv types.Vec2, texIndex int32) types.Vec3 {
+	meta := &sc.TextureMetadata[texIndex]
+	tc := getTexelCoords(meta, uv)
+
+	// We need 3 samples to recreate the normal
+	// s0(tx, ty), s1(tx+1, ty), s2(tx, ty+1)
+	s0 := readTexel(sc, meta, tc.tx, tc.ty, 0)
+	s1 := readTexel(sc, meta, tc.bx, tc.ty, 0)
+	s2 := readTexel(sc, meta, tc.tx, tc.by, 0)
+
+	n := types.Vec3{s1 - s0, s2 - s0, 1.0}.Normalize()
+	return types.Vec3{0.5 + 0.5*n[0], 0.5 + 0.5*n[1], 0.5 + 0.5*n[2]}
+}
+
+func clampu(v, max uint32) uint32 {
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func readFloat32(data []byte, offset uint32) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+}
@@ -0,0 +1,151 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Re-define useful constants as float32 values.
+const (
+	cPi         float32 = math.Pi
+	cTwoTimesPi float32 = 2.0 * math.Pi
+	c1Pi        float32 = 1.0 / math.Pi
+	cMaxFloat   float32 = math.MaxFloat32
+)
+
+// Intersection constants.
+const (
+	intersectionEpsilon          float32 = 0.00001
+	intersectionWithLightEpsilon float32 = intersectionEpsilon * 1e3
+)
+
+// GGX distribution explodes if roughness is set to 0 (microfacet bxdf).
+const minRoughness float32 = 0.1
+
+func sqrtf(v float32) float32 {
+	return float32(math.Sqrt(float64(v)))
+}
+
+func cosf(v float32) float32 {
+	return float32(math.Cos(float64(v)))
+}
+
+func sinf(v float32) float32 {
+	return float32(math.Sin(float64(v)))
+}
+
+func absf(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func minf(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxf(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampf(v, min, max float32) float32 {
+	return minf(maxf(v, min), max)
+}
+
+func clampi(v, min, max int) int {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+	return v
+}
+
+func signf(v float32) float32 {
+	switch {
+	case v > 0:
+		return 1.0
+	case v < 0:
+		return -1.0
+	}
+	return 0.0
+}
+
+// Linearly interpolate between two values.
+func mixf(a, b, t float32) float32 {
+	return a + (b-a)*t
+}
+
+// Multiply two vectors component-wise.
+func mulVec3(v1, v2 types.Vec3) types.Vec3 {
+	return types.Vec3{v1[0] * v2[0], v1[1] * v2[1], v1[2] * v2[2]}
+}
+
+// Linearly interpolate between two vectors.
+func mixVec3(v1, v2 types.Vec3, t float32) types.Vec3 {
+	return v1.Add(v2.Sub(v1).Mul(t))
+}
+
+// Transform a point using a 4x4 matrix.
+func transformPoint(m types.Mat4, v types.Vec3) types.Vec3 {
+	return m.Mul4x1(v.Vec4(1.0)).Vec3()
+}
+
+// Transform a direction vector using the 3x3 rotation part of a 4x4 matrix.
+func transformDir(m types.Mat4, v types.Vec3) types.Vec3 {
+	return m.Mul4x1(v.Vec4(0.0)).Vec3()
+}
+
+// Transform a normal vector using the transpose of the supplied matrix. This
+// allows us to convert mesh space normals to world space using the inverted
+// mesh instance transformation matrix.
+func transformNormal(m types.Mat4, n types.Vec3) types.Vec3 {
+	return types.Vec3{
+		m[0]*n[0] + m[1]*n[1] + m[2]*n[2],
+		m[4]*n[0] + m[5]*n[1] + m[6]*n[2],
+		m[8]*n[0] + m[9]*n[1] + m[10]*n[2],
+	}.Normalize()
+}
+
+// Generate tangent and bi-tangent vectors for the supplied normal.
+func tangentVectors(n types.Vec3) (u, v types.Vec3) {
+	if absf(n[2]) < 0.999 {
+		u = types.Vec3{0, 0, 1}.Cross(n).Normalize()
+	} else {
+		u = types.Vec3{1, 0, 0}.Cross(n).Normalize()
+	}
+	v = n.Cross(u)
+	return u, v
+}
+
+// Convert ray direction vector to normalized spherical UV coords.
+func rayToLatLongUV(v types.Vec3) types.Vec2 {
+	at2 := float32(math.Atan2(float64(v[0]), float64(v[2])))
+	if at2 < 0 {
+		at2 += cTwoTimesPi
+	}
+
+	return types.Vec2{
+		at2 / cTwoTimesPi,
+		float32(math.Acos(float64(v[1]/v.Len()))) / cPi,
+	}
+}
+
+// Calculate fresnel given the eta and cosTheta using Schlick's approximation.
+func fresnelForDielectric(etaI, etaT, iDotN float32) float32 {
+	eta := etaI / etaT
+
+	// Calculate r0 from eta
+	r0 := ((1.0 - eta) * (1.0 - eta)) / ((1.0 + eta) * (1.0 + eta))
+	c := 1.0 - absf(iDotN)
+	c1 := c * c
+	return r0 + (1.0-r0)*c1*c1*c
+}
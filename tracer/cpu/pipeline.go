@@ -0,0 +1,225 @@
+package cpu
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/achilleasa/polaris/hdr"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// An alias for functions that can be used as part of the rendering pipeline.
+type PipelineStage func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error)
+
+// The list of pluggable of stages that are used to render the scene.
+type Pipeline struct {
+	// This stage is executed whenever the tracer generates a new set
+	// of primary rays. Depending on the samples per pixel this stage
+	// may be invoked more than once.
+	PrimaryRayGenerator PipelineStage
+
+	// This stage implements an integrator function to trace the primary
+	// rays and add their contribution into the accumulation buffer.
+	Integrator PipelineStage
+
+	// A set of post-processing stages that are executed prior to
+	// rendering the final frame.
+	PostProcess []PipelineStage
+}
+
+func DefaultPipeline() *Pipeline {
+	return &Pipeline{
+		PrimaryRayGenerator: SceneCamera(),
+		Integrator:          MonteCarloIntegrator(),
+		PostProcess: []PipelineStage{
+			TonemapSimpleReinhard(),
+		},
+	}
+}
+
+// Use a montecarlo pathtracer implementation.
+func MonteCarloIntegrator() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		numRays := int(blockReq.BlockW * blockReq.BlockH)
+		if blockReq.CountRays {
+			tr.stats.PrimaryRays += uint64(numRays)
+		}
+
+		tr.parallelFor(numRays, func(from, to int) {
+			var aov *tracer.AOVSample
+			if blockReq.CaptureAOVs {
+				aov = &tracer.AOVSample{}
+			}
+
+			var counts rayCounts
+			if blockReq.CountRays {
+				defer func() {
+					atomic.AddUint64(&tr.stats.IndirectRays, counts.indirect)
+					atomic.AddUint64(&tr.stats.OcclusionRays, counts.occlusion)
+				}()
+			}
+
+			for rayIndex := from; rayIndex < to; rayIndex++ {
+				p := &tr.buffers.Paths[rayIndex]
+				if aov != nil {
+					aov.MeshInstance = -1
+				}
+
+				sample := tracePath(tr.sceneData, blockReq, tr.buffers.Rays[rayIndex], p, aov, &counts)
+				tr.buffers.TraceAccumulator[p.pixelIndex] = tr.buffers.TraceAccumulator[p.pixelIndex].Add(sample)
+
+				// Only accumulate AOVs for primary rays that hit the scene geometry
+				if aov != nil && aov.MeshInstance != -1 {
+					accumulateAOV(&tr.buffers.TraceAOVs[p.pixelIndex], aov)
+				}
+			}
+		})
+
+		return time.Since(start), nil
+	}
+}
+
+// Add the AOVs captured by a traced sample to an AOV accumulator.
+func accumulateAOV(dst, src *tracer.AOVSample) {
+	dst.Albedo = dst.Albedo.Add(src.Albedo)
+	dst.Normal = dst.Normal.Add(src.Normal)
+	dst.Depth += src.Depth
+	dst.MeshInstance = src.MeshInstance
+	dst.Material = src.Material
+}
+
+// Apply simple Reinhard tone-mapping.
+func TonemapSimpleReinhard() PipelineStage {
+	return Tonemap(tracer.TonemapParams{Operator: tracer.ReinhardTonemap})
+}
+
+// Apply tone-mapping using the specified operator and parameters.
+func Tonemap(params tracer.TonemapParams) PipelineStage {
+	params = params.WithDefaults()
+	mapFn := tonemapFunc(params.Operator, params.WhitePoint)
+	invGamma := 1.0 / float64(params.Gamma)
+
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		scale := sampleWeight * blockReq.Exposure
+		accumulator := tr.postProcessAccumulator()
+
+		tr.parallelFor(int(blockReq.BlockW*blockReq.BlockH), func(from, to int) {
+			for blockIndex := from; blockIndex < to; blockIndex++ {
+				pixelIndex := int(blockReq.FramePixelIndex(uint32(blockIndex)))
+				hdrColor := accumulator[pixelIndex].Mul(scale)
+
+				fbOffset := pixelIndex << 2
+				for c := 0; c < 3; c++ {
+					// Apply tone-mapping, gamma correction and scale
+					mapped := mapFn(hdrColor[c])
+					tr.buffers.FrameBuffer[fbOffset+c] = uint8(clampf(gammaCorrect(mapped, invGamma), 0.0, 1.0) * 255.0)
+				}
+				tr.buffers.FrameBuffer[fbOffset+3] = 255
+			}
+		})
+
+		elapsed := time.Since(start)
+		tr.stats.KernelTimes[tracer.TonemapKernel] += elapsed
+		return elapsed, nil
+	}
+}
+
+// Apply gamma correction to a color value.
+func gammaCorrect(v float32, invGamma float64) float32 {
+	if v <= 0.0 {
+		return 0.0
+	}
+	return float32(math.Pow(float64(v), invGamma))
+}
+
+// Save a copy of the RGBA framebuffer.
+func SaveFrameBuffer(imgFile string) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		f, err := os.Create(imgFile)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+
+		// Copy the framebuffer rows that overlap the block
+		im := image.NewRGBA(image.Rect(0, 0, int(blockReq.BlockW), int(blockReq.BlockH)))
+		for y := uint32(0); y < blockReq.BlockH; y++ {
+			offset := blockReq.FramePixelIndex(y*blockReq.BlockW) << 2
+			copy(im.Pix[y*uint32(im.Stride):], tr.buffers.FrameBuffer[offset:offset+blockReq.BlockW<<2])
+		}
+
+		return time.Since(start), png.Encode(f, im)
+	}
+}
+
+// Save a copy of the RGBA framebuffer each time this stage is executed. The
+// output filename is generated by formatting imgFilePattern (e.g. frame-%04d.png)
+// with a frame counter that starts at 0.
+func SaveFrameBufferSequence(imgFilePattern string) PipelineStage {
+	var frame int
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		stage := SaveFrameBuffer(fmt.Sprintf(imgFilePattern, frame))
+		frame++
+		return stage(tr, blockReq)
+	}
+}
+
+// Save a linear copy of the frame accumulator, or the output of any preceding
+// filter stages, normalized by the number of accumulated samples. The HDR image
+// format (pfm, hdr or exr) is selected based on the imgFile extension.
+func SaveHDRFrameBuffer(imgFile string) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		accumulator := tr.postProcessAccumulator()
+		pixels := make([]types.Vec3, blockReq.BlockW*blockReq.BlockH)
+		for blockIndex := range pixels {
+			pixels[blockIndex] = accumulator[blockReq.FramePixelIndex(uint32(blockIndex))].Mul(sampleWeight)
+		}
+
+		return time.Since(start), hdr.WriteFile(imgFile, blockReq.BlockW, blockReq.BlockH, pixels)
+	}
+}
+
+// Save a linear copy of the frame accumulator each time this stage is executed.
+// The output filename is generated by formatting imgFilePattern (e.g. frame-%04d.exr)
+// with a frame counter that starts at 0.
+func SaveHDRFrameBufferSequence(imgFilePattern string) PipelineStage {
+	var frame int
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		stage := SaveHDRFrameBuffer(fmt.Sprintf(imgFilePattern, frame))
+		frame++
+		return stage(tr, blockReq)
+	}
+}
+
+// Save an image with the contents of the frame AOV buffer for the given AOV
+// type. The image format is selected based on the imgFile extension.
+func SaveAOV(aov tracer.AOV, imgFile string) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		if !blockReq.CaptureAOVs {
+			return 0, ErrAOVsNotCaptured
+		}
+
+		start := time.Now()
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		samples := make([]tracer.AOVSample, blockReq.BlockW*blockReq.BlockH)
+		for blockIndex := range samples {
+			samples[blockIndex] = tr.buffers.FrameAOVs[blockReq.FramePixelIndex(uint32(blockIndex))]
+		}
+		err := tracer.WriteAOV(imgFile, aov, blockReq.BlockW, blockReq.BlockH, samples, sampleWeight)
+		return time.Since(start), err
+	}
+}
@@ -0,0 +1,137 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+const invMaxInt float32 = 1.0 / 4294967296.0
+
+// A fast pseudo-random number generator. This is a port of the generator
+// used by the opencl kernels.
+type rng [2]uint32
+
+// Generate 2 random numbers in the [0, 1) range and update RNG state.
+func (r *rng) sample2f() types.Vec2 {
+	x := r[0]*17 + r[1]*13123
+	r[0] = (x << 13) ^ x
+	r[1] ^= (x << 7)
+
+	return types.Vec2{
+		float32(x*(x*x*15731+74323)+871483) * invMaxInt,
+		float32(x*(x*x*13734+37828)+234234) * invMaxInt,
+	}
+}
+
+// See https://www.cs.cornell.edu/~srm/publications/EGSR07-btdf.pdf
+// for GGX distribution formulas
+
+// G1(v, m) = 2 / 1 + sqrt( 1 + a^2 * tanv^2 )  (formula 34)
+func ggxGetG1(roughness float32, v, n, m types.Vec3) float32 {
+	nDotV := n.Dot(v)
+	mDotV := m.Dot(v)
+	if nDotV*mDotV <= 0.0 {
+		return 0.0
+	}
+	nDotVSq := nDotV * nDotV
+
+	// Calc tanV^2
+	var tanSq float32
+	if nDotVSq > 0.0 {
+		tanSq = (1.0 - nDotVSq) / nDotVSq
+	}
+
+	aSq := roughness * roughness
+	return 2.0 / (1.0 + sqrtf(1.0+aSq*tanSq))
+}
+
+// Use smith approximation for G:
+// G(l, v, h) = G1(l,h) * G1(v,h)
+func ggxGetG(roughness float32, inRayDir, outRayDir, n, m types.Vec3) float32 {
+	return ggxGetG1(roughness, inRayDir, n, m) * ggxGetG1(roughness, outRayDir, n, m)
+}
+
+// D(m) = a^2 / PI * cosT^4 * (a^2 + tanT^2)^2  (formula 33)
+func ggxGetD(roughness float32, n, m types.Vec3) float32 {
+	nDotM := n.Dot(m)
+	if nDotM <= 0.0 {
+		return 0.0
+	}
+	nDotMSq := nDotM * nDotM
+
+	// Calc tanT^2
+	tanSq := (1.0 - nDotMSq) / nDotMSq
+
+	// Calc denominator
+	aSq := roughness * roughness
+	denom := cPi * nDotMSq * nDotMSq * (aSq + tanSq) * (aSq + tanSq)
+	if denom <= 0.0 {
+		return 0.0
+	}
+	return aSq / denom
+}
+
+// Sample GGX distribution to generate a normal that will be used for microfacet calculations.
+func ggxGetSample(roughness float32, n types.Vec3, randSample types.Vec2) types.Vec3 {
+	// Generate tangent, bi-tangent vectors
+	u, v := tangentVectors(n)
+
+	// According to equations (35, 36) for sampling GGX:
+	// theta = atan( a * sqrt(randSample.x / 1 - randSample.x) )
+	// phi = 2 * pi * randSample.y
+	theta := float32(math.Atan(float64(roughness * sqrtf(randSample[0]/(1.0-randSample[0])))))
+	if theta < 0.0 {
+		theta += cTwoTimesPi
+	}
+
+	cosTheta := cosf(theta)
+	sinTheta := sqrtf(1.0 - cosTheta*cosTheta)
+
+	cosPhi := cosf(cTwoTimesPi * randSample[1])
+	sinPhi := sqrtf(1.0 - cosPhi*cosPhi)
+
+	// Project and rotate to get the halfway vector
+	return u.Mul(sinTheta * cosPhi).Add(v.Mul(sinTheta * sinPhi)).Add(n.Mul(cosTheta)).Normalize()
+}
+
+// pdf = D * hDotN / 4 * oDotH
+func ggxGetReflectionPdf(roughness float32, outRayDir, n, h types.Vec3) float32 {
+	nDotH := absf(n.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+
+	denom := 4.0 * oDotH
+	if denom == 0.0 {
+		return 0.0
+	}
+	return ggxGetD(roughness, n, h) * nDotH / denom
+}
+
+// pdf = D * hDotN * focusTerm where
+// focusTerm = etaT * etaT * oDotH / (etaI * iDotH + etaT * oDotH)^2
+func ggxGetRefractionPdf(roughness, etaI, etaT float32, inRayDir, outRayDir, n, h types.Vec3) float32 {
+	iDotH := absf(inRayDir.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+	hDotN := absf(h.Dot(n))
+
+	denom := (etaI*iDotH + etaT*oDotH) * (etaI*iDotH + etaT*oDotH)
+	if denom <= 0.0 {
+		return 0.0
+	}
+	return ggxGetD(roughness, n, h) * hDotN * oDotH * etaT * etaT / denom
+}
+
+// Sample hemisphere direction using a cosine weighted distribution
+//
+// PDF = cos(theta) / pi
+func cosWeightedHemisphereGetSample(normal types.Vec3, randSample types.Vec2) types.Vec3 {
+	// Generate point on disk
+	rd := sqrtf(randSample[0])
+	phi := cTwoTimesPi * randSample[1]
+
+	// Generate tangent, bi-tangent vectors
+	u, v := tangentVectors(normal)
+
+	// Project disk point to unit hemisphere and rotate so that the normal points up
+	return u.Mul(rd * cosf(phi)).Add(v.Mul(rd * sinf(phi))).Add(normal.Mul(sqrtf(1.0 - randSample[0]))).Normalize()
+}
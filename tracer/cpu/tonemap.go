@@ -0,0 +1,63 @@
+package cpu
+
+import "github.com/achilleasa/polaris/tracer"
+
+// Coefficients for the Uncharted 2 filmic curve.
+const (
+	uncharted2ShoulderStrength float32 = 0.15
+	uncharted2LinearStrength   float32 = 0.50
+	uncharted2LinearAngle      float32 = 0.10
+	uncharted2ToeStrength      float32 = 0.20
+	uncharted2ToeNumerator     float32 = 0.02
+	uncharted2ToeDenominator   float32 = 0.30
+)
+
+// Get a function that maps a HDR color channel value to the [0, 1] range
+// using the specified tone-mapping operator.
+func tonemapFunc(op tracer.TonemapOperator, whitePoint float32) func(float32) float32 {
+	switch op {
+	case tracer.ACESTonemap:
+		return tonemapACES
+	case tracer.Uncharted2Tonemap:
+		whiteScale := 1.0 / uncharted2Curve(whitePoint)
+		return func(v float32) float32 {
+			return uncharted2Curve(v) * whiteScale
+		}
+	case tracer.LinearTonemap:
+		return func(v float32) float32 {
+			return clampf(v/whitePoint, 0.0, 1.0)
+		}
+	}
+
+	if whitePoint <= 0.0 {
+		return tonemapReinhard
+	}
+
+	invWhitePointSq := 1.0 / (whitePoint * whitePoint)
+	return func(v float32) float32 {
+		return v * (1.0 + v*invWhitePointSq) / (1.0 + v)
+	}
+}
+
+// Apply simple Reinhard tone-mapping.
+func tonemapReinhard(v float32) float32 {
+	return v / (v + 1.0)
+}
+
+// Apply the ACES filmic curve approximation by Krzysztof Narkowicz.
+func tonemapACES(v float32) float32 {
+	return clampf((v*(2.51*v+0.03))/(v*(2.43*v+0.59)+0.14), 0.0, 1.0)
+}
+
+// Evaluate the Uncharted 2 filmic curve by John Hable.
+func uncharted2Curve(v float32) float32 {
+	const (
+		a = uncharted2ShoulderStrength
+		b = uncharted2LinearStrength
+		c = uncharted2LinearAngle
+		d = uncharted2ToeStrength
+		e = uncharted2ToeNumerator
+		f = uncharted2ToeDenominator
+	)
+	return ((v*(a*v+c*b) + d*e) / (v*(a*v+b) + d*f)) - e/f
+}
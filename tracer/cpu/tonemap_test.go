@@ -0,0 +1,77 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestTonemapFunc(t *testing.T) {
+	specs := []struct {
+		op         tracer.TonemapOperator
+		whitePoint float32
+		in         float32
+		exp        float32
+	}{
+		{tracer.ReinhardTonemap, 0, 1, 0.5},
+		{tracer.ReinhardTonemap, 0, 3, 0.75},
+		{tracer.ReinhardTonemap, 4, 4, 1},
+		{tracer.ReinhardTonemap, 4, 0, 0},
+		{tracer.ACESTonemap, 0, 0, 0},
+		{tracer.ACESTonemap, 0, 1000, 1},
+		{tracer.Uncharted2Tonemap, 11.2, 11.2, 1},
+		{tracer.Uncharted2Tonemap, 11.2, 0, 0},
+		{tracer.LinearTonemap, 1, 0.25, 0.25},
+		{tracer.LinearTonemap, 1, 2, 1},
+		{tracer.LinearTonemap, 4, 2, 0.5},
+	}
+
+	for specIndex, spec := range specs {
+		mapped := tonemapFunc(spec.op, spec.whitePoint)(spec.in)
+		if absf(mapped-spec.exp) > 1e-5 {
+			t.Errorf("[spec %d] expected %s operator to map %f to %f; got %f", specIndex, spec.op, spec.in, spec.exp, mapped)
+		}
+	}
+
+	// All operators should map increasing radiance values below their default
+	// white point to non-decreasing outputs
+	for _, op := range tracer.TonemapOperators {
+		mapFn := tonemapFunc(op, op.DefaultWhitePoint())
+		prev := mapFn(0)
+		for v := float32(0.1); v < 10; v += 0.1 {
+			mapped := mapFn(v)
+			if mapped < prev || mapped > 1.00001 {
+				t.Fatalf("expected %s operator output to be monotonic and <= 1; got %f for %f (previous value %f)", op, mapped, v, prev)
+			}
+			prev = mapped
+		}
+	}
+}
+
+func TestTonemapGamma(t *testing.T) {
+	tr, err := NewTracer("test", 1, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{1, 1})
+	tr.(*Tracer).buffers.FrameAccumulator[0] = [3]float32{0.25, 0.25, 0.25}
+
+	blockReq := &tracer.BlockRequest{FrameW: 1, FrameH: 1, BlockW: 1, BlockH: 1, SamplesPerPixel: 1, Exposure: 1}
+	specs := []struct {
+		gamma float32
+		exp   uint8
+	}{
+		{1, 63},
+		{2, 127},
+	}
+	for specIndex, spec := range specs {
+		_, err = Tonemap(tracer.TonemapParams{Operator: tracer.LinearTonemap, Gamma: spec.gamma})(tr.(*Tracer), blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.(*Tracer).buffers.FrameBuffer[0]; got != spec.exp {
+			t.Errorf("[spec %d] expected tone-mapped value with gamma %f to be %d; got %d", specIndex, spec.gamma, spec.exp, got)
+		}
+	}
+}
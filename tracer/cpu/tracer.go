@@ -0,0 +1,462 @@
+package cpu
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+type Tracer struct {
+	logger log.Logger
+
+	sync.Mutex
+
+	// The number of goroutines used for tracing.
+	numWorkers int
+
+	// The allocated host buffers.
+	buffers *bufferSet
+
+	// The tracer id.
+	id string
+
+	// A buffer for asynchronous updates. Updates are grouped by type and
+	// latest updates always overwrite the previous ones.
+	changeBuffer map[tracer.ChangeType]interface{}
+
+	// Statistics for last rendered frame.
+	stats *tracer.Stats
+
+	// The tracer rendering pipeline.
+	pipeline *Pipeline
+
+	// Set by post-process filter stages once they have written their output
+	// to the filtered accumulator.
+	filtered bool
+
+	// The scene data.
+	sceneData *scene.Scene
+
+	// Camera attributes
+	cameraPosition       types.Vec3
+	cameraFrustrum       scene.Frustrum
+	cameraType           scene.CameraType
+	cameraApertureRadius float32
+	cameraFocusDistance  float32
+	cameraOrthoHeight    float32
+	cameraFisheyeFOV     float32
+
+	// The focus distance calculated by thin-lens cameras when autofocus
+	// is enabled; it is reset whenever the camera or scene changes.
+	autofocusDistance float32
+}
+
+// Create a new CPU tracer that splits work between numWorkers goroutines. If
+// numWorkers is <= 0 then the tracer will use one worker per available CPU.
+func NewTracer(id string, numWorkers int, pipeline *Pipeline) (tracer.Tracer, error) {
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+
+	tr := &Tracer{
+		logger:       log.New(fmt.Sprintf("cpu tracer (%s)", id)),
+		numWorkers:   numWorkers,
+		id:           id,
+		changeBuffer: make(map[tracer.ChangeType]interface{}, 0),
+		stats:        &tracer.Stats{},
+		pipeline:     pipeline,
+	}
+
+	return tr, nil
+}
+
+// Get tracer id.
+func (tr *Tracer) Id() string {
+	return tr.id
+}
+
+// Get tracer flags.
+func (tr *Tracer) Flags() tracer.Flag {
+	return tracer.Local | tracer.CpuDevice
+}
+
+// Get the computation speed estimate (in GFlops). We use a very rough
+// estimate of 1 GFlop per worker.
+func (tr *Tracer) Speed() uint32 {
+	return uint32(tr.numWorkers)
+}
+
+// Initialize tracer
+func (tr *Tracer) Init() error {
+	tr.Lock()
+	defer tr.Unlock()
+
+	tr.buffers = &bufferSet{}
+	return nil
+}
+
+// Shutdown and cleanup tracer.
+func (tr *Tracer) Close() {
+	tr.Lock()
+	defer tr.Unlock()
+
+	tr.buffers = nil
+	tr.sceneData = nil
+}
+
+// Retrieve last frame statistics.
+func (tr *Tracer) Stats() *tracer.Stats {
+	return tr.stats
+}
+
+// Get the RGBA frame buffer that is populated by the post-process stages.
+func (tr *Tracer) FrameBuffer() []byte {
+	if tr.buffers == nil {
+		return nil
+	}
+	return tr.buffers.FrameBuffer
+}
+
+// Update tracer state
+func (tr *Tracer) UpdateState(mode tracer.UpdateMode, changeType tracer.ChangeType, data interface{}) (time.Duration, error) {
+	tr.changeBuffer[changeType] = data
+
+	if mode == tracer.Synchronous {
+		return tr.commitChanges()
+	}
+
+	return time.Duration(0), nil
+}
+
+// Commit queued state changes.
+func (tr *Tracer) commitChanges() (time.Duration, error) {
+	if len(tr.changeBuffer) == 0 {
+		return 0, nil
+	}
+
+	if tr.buffers == nil {
+		return 0, ErrBuffersNotAllocated
+	}
+
+	start := time.Now()
+	for changeType, data := range tr.changeBuffer {
+		switch changeType {
+		case tracer.FrameDimensions:
+			dims, ok := data.([2]uint32)
+			if !ok {
+				return time.Since(start), ErrInvalidChangeData
+			}
+			tr.buffers.Resize(dims[0], dims[1])
+		case tracer.SceneData:
+			sc, ok := data.(*scene.Scene)
+			if !ok {
+				return time.Since(start), ErrInvalidChangeData
+			}
+			tr.sceneData = sc
+			tr.autofocusDistance = 0
+		case tracer.CameraData:
+			camera, ok := data.(*scene.Camera)
+			if !ok {
+				return time.Since(start), ErrInvalidChangeData
+			}
+			tr.cameraPosition = camera.Position
+			tr.cameraFrustrum = camera.Frustrum
+			tr.cameraType = camera.Type
+			tr.cameraApertureRadius = camera.ApertureRadius
+			tr.cameraFocusDistance = camera.FocusDistance
+			tr.cameraOrthoHeight = camera.OrthoHeight
+			tr.cameraFisheyeFOV = camera.FisheyeFOV
+			tr.autofocusDistance = 0
+		default:
+			return time.Since(start), ErrUnsupportedChangeType
+		}
+	}
+
+	tr.changeBuffer = make(map[tracer.ChangeType]interface{}, 0)
+	return time.Since(start), nil
+}
+
+// Process block request.
+func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	var err error
+	start := time.Now()
+
+	tr.stats.UpdateTime, err = tr.commitChanges()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if tr.sceneData == nil {
+		return time.Since(start), ErrNoSceneData
+	}
+
+	// Clear the trace accumulator for the block pixels
+	numPixels := blockReq.BlockW * blockReq.BlockH
+	for blockIndex := uint32(0); blockIndex < numPixels; blockIndex++ {
+		pixelIndex := blockReq.FramePixelIndex(blockIndex)
+		tr.buffers.TraceAccumulator[pixelIndex] = types.Vec3{}
+		if blockReq.CaptureAOVs {
+			tr.buffers.TraceAOVs[pixelIndex] = tracer.AOVSample{MeshInstance: -1, Material: -1}
+		}
+	}
+
+	// Derive the seed for each sample from the frame seed and the sample
+	// index so the output does not depend on the tracer that renders the block.
+	frameSeed := blockReq.Seed
+
+	// Reset the per-block stage timings and ray counters
+	tr.stats.PrimaryRayTime, tr.stats.IntegratorTime = 0, 0
+	tr.stats.PrimaryRays, tr.stats.IndirectRays, tr.stats.OcclusionRays = 0, 0, 0
+	tr.stats.KernelTimes = tracer.KernelTimes{}
+
+	var sample uint32
+	var elapsed time.Duration
+	for sample = 0; sample < blockReq.SamplesPerPixel; sample++ {
+		blockReq.Seed = tracer.DeriveSeed(frameSeed, blockReq.AccumulatedSamples)
+
+		// Generate primary rays
+		if tr.pipeline.PrimaryRayGenerator != nil {
+			elapsed, err = tr.pipeline.PrimaryRayGenerator(tr, blockReq)
+			tr.stats.PrimaryRayTime += elapsed
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
+		// Run integrator
+		if tr.pipeline.Integrator != nil {
+			elapsed, err = tr.pipeline.Integrator(tr, blockReq)
+			tr.stats.IntegratorTime += elapsed
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
+		blockReq.AccumulatedSamples++
+
+		// If the request was cancelled, stop after the current sample.
+		if sample+1 < blockReq.SamplesPerPixel && blockReq.Cancelled() {
+			sample++
+			break
+		}
+	}
+
+	tr.stats.RenderedSamples = sample
+	tr.stats.BlockW = blockReq.BlockW
+	tr.stats.BlockH = blockReq.BlockH
+	tr.stats.RenderTime = time.Since(start)
+	return tr.stats.RenderTime, nil
+}
+
+// Run post-process filters and update the framebuffer with the processed output.
+func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	var err error
+	start := time.Now()
+
+	if tr.sceneData == nil {
+		return time.Since(start), ErrNoSceneData
+	}
+
+	if tr.pipeline.PostProcess == nil {
+		return time.Since(start), nil
+	}
+
+	tr.filtered = false
+	for _, stage := range tr.pipeline.PostProcess {
+		_, err = stage(tr, blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Get the accumulator that should be used as the input for post-process
+// stages. This is the filtered accumulator if a filter stage has been applied
+// while syncing the frame buffer; otherwise it is the frame accumulator.
+func (tr *Tracer) postProcessAccumulator() []types.Vec3 {
+	if tr.filtered {
+		return tr.buffers.FilteredAccumulator
+	}
+	return tr.buffers.FrameAccumulator
+}
+
+// Merge accumulator output from another tracer into this tracer's buffer.
+//
+// Each tracer merges a non-overlapping frame block so there is no need to
+// synchronize access to the frame accumulator. If the merged block contains
+// the first samples for a frame then the accumulator contents are replaced
+// instead of being added to the existing values.
+func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	elapsed, err := tr.mergeOutput(other, blockReq)
+	other.Stats().KernelTimes[tracer.MergeKernel] += elapsed
+	return elapsed, err
+}
+
+// Merge the accumulator and AOV output from another tracer into the frame buffers.
+func (tr *Tracer) mergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	src, isReader := other.(tracer.AccumulatorReader)
+	if !isReader {
+		return 0, fmt.Errorf("merge failed: unsupported tracer instance")
+	}
+
+	start := time.Now()
+	srcAccumulator, err := src.ReadAccumulator(blockReq)
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(srcAccumulator) != numPixels {
+		return time.Since(start), fmt.Errorf("merge failed: expected %d accumulator samples; got %d", numPixels, len(srcAccumulator))
+	}
+
+	firstSamples := blockReq.AccumulatedSamples == other.Stats().RenderedSamples
+	for blockIndex, sample := range srcAccumulator {
+		pixelIndex := blockReq.FramePixelIndex(uint32(blockIndex))
+		if firstSamples {
+			tr.buffers.FrameAccumulator[pixelIndex] = sample
+		} else {
+			tr.buffers.FrameAccumulator[pixelIndex] = tr.buffers.FrameAccumulator[pixelIndex].Add(sample)
+		}
+	}
+
+	if !blockReq.CaptureAOVs {
+		return time.Since(start), nil
+	}
+
+	aovReader, isReader := other.(tracer.AOVReader)
+	if !isReader {
+		return time.Since(start), fmt.Errorf("merge failed: tracer does not support AOV capture")
+	}
+
+	srcAOVs, err := aovReader.ReadAOVs(blockReq)
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if len(srcAOVs) != numPixels {
+		return time.Since(start), fmt.Errorf("merge failed: expected %d AOV samples; got %d", numPixels, len(srcAOVs))
+	}
+
+	for blockIndex := range srcAOVs {
+		pixelIndex := blockReq.FramePixelIndex(uint32(blockIndex))
+		if firstSamples {
+			tr.buffers.FrameAOVs[pixelIndex] = srcAOVs[blockIndex]
+		} else if srcAOVs[blockIndex].MeshInstance != -1 {
+			accumulateAOV(&tr.buffers.FrameAOVs[pixelIndex], &srcAOVs[blockIndex])
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Scale the frame accumulator and AOV contents for the block specified by the block request.
+func (tr *Tracer) ScaleFrameOutput(blockReq *tracer.BlockRequest, scale float32) (time.Duration, error) {
+	if tr.buffers == nil {
+		return 0, ErrBuffersNotAllocated
+	}
+
+	start := time.Now()
+	numPixels := blockReq.BlockW * blockReq.BlockH
+	for blockIndex := uint32(0); blockIndex < numPixels; blockIndex++ {
+		pixelIndex := blockReq.FramePixelIndex(blockIndex)
+		tr.buffers.FrameAccumulator[pixelIndex] = tr.buffers.FrameAccumulator[pixelIndex].Mul(scale)
+		if blockReq.CaptureAOVs {
+			aov := &tr.buffers.FrameAOVs[pixelIndex]
+			aov.Albedo = aov.Albedo.Mul(scale)
+			aov.Normal = aov.Normal.Mul(scale)
+			aov.Depth *= scale
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the trace accumulator contents for the block specified by the block request.
+func (tr *Tracer) ReadAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	if tr.buffers == nil {
+		return nil, ErrBuffersNotAllocated
+	}
+
+	samples := make([]types.Vec3, blockReq.BlockW*blockReq.BlockH)
+	for blockIndex := range samples {
+		samples[blockIndex] = tr.buffers.TraceAccumulator[blockReq.FramePixelIndex(uint32(blockIndex))]
+	}
+	return samples, nil
+}
+
+// Read the AOVs captured by the last trace pass for the block specified by the block request.
+func (tr *Tracer) ReadAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	if tr.buffers == nil {
+		return nil, ErrBuffersNotAllocated
+	}
+
+	samples := make([]tracer.AOVSample, blockReq.BlockW*blockReq.BlockH)
+	for blockIndex := range samples {
+		samples[blockIndex] = tr.buffers.TraceAOVs[blockReq.FramePixelIndex(uint32(blockIndex))]
+	}
+	return samples, nil
+}
+
+// Read the frame accumulator contents for the block specified by the block request.
+func (tr *Tracer) ReadFrameAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	if tr.buffers == nil {
+		return nil, ErrBuffersNotAllocated
+	}
+
+	samples := make([]types.Vec3, blockReq.BlockW*blockReq.BlockH)
+	for blockIndex := range samples {
+		samples[blockIndex] = tr.buffers.FrameAccumulator[blockReq.FramePixelIndex(uint32(blockIndex))]
+	}
+	return samples, nil
+}
+
+// Replace the frame accumulator contents for the block specified by the block request.
+func (tr *Tracer) WriteFrameAccumulator(blockReq *tracer.BlockRequest, samples []types.Vec3) error {
+	if tr.buffers == nil {
+		return ErrBuffersNotAllocated
+	}
+
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(samples) != numPixels {
+		return fmt.Errorf("cpu tracer: expected %d accumulator samples; got %d", numPixels, len(samples))
+	}
+
+	for blockIndex, sample := range samples {
+		tr.buffers.FrameAccumulator[blockReq.FramePixelIndex(uint32(blockIndex))] = sample
+	}
+	return nil
+}
+
+// Split the [0, count) range into equal chunks and process them in parallel
+// using the tracer's workers.
+func (tr *Tracer) parallelFor(count int, fn func(from, to int)) {
+	if count <= 0 {
+		return
+	}
+
+	chunkSize := (count + tr.numWorkers - 1) / tr.numWorkers
+
+	var wg sync.WaitGroup
+	for from := 0; from < count; from += chunkSize {
+		to := from + chunkSize
+		if to > count {
+			to = count
+		}
+
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			fn(from, to)
+		}(from, to)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,423 @@
+package cpu
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestTraceTiles(t *testing.T) {
+	sc := diffuseTriangleScene()
+	camera := &scene.Camera{
+		Frustrum: scene.Frustrum{
+			{-0.1, 0.1, -1, 0},
+			{0.1, 0.1, -1, 0},
+			{-0.1, -0.1, -1, 0},
+			{0.1, -0.1, -1, 0},
+		},
+	}
+
+	tiles := []tracer.Tile{
+		{X: 0, Y: 0, W: 2, H: 2},
+		{X: 2, Y: 0, W: 4, H: 1},
+		{X: 2, Y: 1, W: 4, H: 1},
+	}
+
+	tracers := make([]tracer.Tracer, len(tiles))
+	for trIndex := range tracers {
+		tr, err := NewTracer("test", 1, DefaultPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Init()
+		tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{6, 2})
+		tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+		tracers[trIndex] = tr
+	}
+
+	primary := tracers[0].(*Tracer)
+	for trIndex, tile := range tiles {
+		blockReq := &tracer.BlockRequest{
+			FrameW:          6,
+			FrameH:          2,
+			BlockX:          tile.X,
+			BlockY:          tile.Y,
+			BlockW:          tile.W,
+			BlockH:          tile.H,
+			SamplesPerPixel: 1,
+			NumBounces:      1,
+			MinBouncesForRR: 2,
+			CaptureAOVs:     true,
+		}
+		_, err := tracers[trIndex].Trace(blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the pixels inside the tile should be traced
+		traceAOVs := tracers[trIndex].(*Tracer).buffers.TraceAOVs
+		for pixelIndex, sample := range traceAOVs {
+			x, y := uint32(pixelIndex)%6, uint32(pixelIndex)/6
+			inTile := x >= tile.X && x < tile.X+tile.W && y >= tile.Y && y < tile.Y+tile.H
+			if inTile != (sample.Depth != 0) {
+				t.Fatalf("[tile %d] expected traced state for pixel (%d, %d) to be %t", trIndex, x, y, inTile)
+			}
+		}
+
+		samples, err := tracers[trIndex].(tracer.AccumulatorReader).ReadAccumulator(blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != int(tile.W*tile.H) {
+			t.Fatalf("[tile %d] expected %d accumulator samples; got %d", trIndex, tile.W*tile.H, len(samples))
+		}
+
+		_, err = primary.MergeOutput(tracers[trIndex], blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for pixelIndex, sample := range primary.buffers.FrameAOVs {
+		if sample.MeshInstance != 0 || sample.Depth == 0 {
+			t.Fatalf("expected pixel %d to be merged into the frame AOVs; got %+v", pixelIndex, sample)
+		}
+	}
+}
+
+func TestTraceIsDeterministic(t *testing.T) {
+	// Use a wide frustrum so that some pixels straddle the triangle edges
+	// and add an environment light so that the traced paths collect radiance.
+	sc := diffuseTriangleScene()
+	sc.MaterialNodeList = append(sc.MaterialNodeList, scene.MaterialNode{
+		Union1: [4]int32{int32(material.BxdfEmissive), 0, 0, -1}, Union2: types.Vec4{1, 1, 1, 0}, Union4: types.Vec3{0, 0, 1}, Union5: [1]int32{-1},
+	})
+	sc.EmissivePrimitives = []scene.EmissivePrimitive{{Type: scene.EnvironmentLight, MaterialNodeIndex: 1}}
+	sc.SceneDiffuseMatIndex = 1
+	sc.SceneEmissiveMatIndex = 1
+	camera := &scene.Camera{
+		Frustrum: scene.Frustrum{
+			{-1, 1, -1, 0},
+			{1, 1, -1, 0},
+			{-1, -1, -1, 0},
+			{1, -1, -1, 0},
+		},
+	}
+
+	var frameW, frameH uint32 = 8, 8
+	trace := func(seed uint32, tiles []tracer.Tile) []types.Vec3 {
+		tr, err := NewTracer("test", 2, DefaultPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Init()
+		tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{frameW, frameH})
+		tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+
+		for _, tile := range tiles {
+			blockReq := &tracer.BlockRequest{
+				FrameW:          frameW,
+				FrameH:          frameH,
+				BlockX:          tile.X,
+				BlockY:          tile.Y,
+				BlockW:          tile.W,
+				BlockH:          tile.H,
+				SamplesPerPixel: 4,
+				NumBounces:      3,
+				MinBouncesForRR: 4,
+				Seed:            seed,
+			}
+			_, err = tr.Trace(blockReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return append([]types.Vec3(nil), tr.(*Tracer).buffers.TraceAccumulator...)
+	}
+
+	frame := []tracer.Tile{{W: frameW, H: frameH}}
+	expSamples := trace(42, frame)
+
+	specs := []struct {
+		seed     uint32
+		tiles    []tracer.Tile
+		expEqual bool
+	}{
+		{42, frame, true},
+		{42, []tracer.Tile{{W: 8, H: 3}, {Y: 3, W: 3, H: 5}, {X: 3, Y: 3, W: 5, H: 5}}, true},
+		{43, frame, false},
+	}
+
+	for specIndex, spec := range specs {
+		samples := trace(spec.seed, spec.tiles)
+
+		equal := true
+		for pixelIndex := range samples {
+			if samples[pixelIndex] != expSamples[pixelIndex] {
+				equal = false
+				break
+			}
+		}
+
+		if equal != spec.expEqual {
+			t.Errorf("[spec %d] expected traced samples to match the reference frame: %t; got %t", specIndex, spec.expEqual, equal)
+		}
+	}
+}
+
+func TestTonemapBlock(t *testing.T) {
+	tr, err := NewTracer("test", 2, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{6, 4})
+
+	buffers := tr.(*Tracer).buffers
+	for pixelIndex := range buffers.FrameAccumulator {
+		buffers.FrameAccumulator[pixelIndex] = types.Vec3{1, 1, 1}
+	}
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          6,
+		FrameH:          4,
+		BlockX:          2,
+		BlockY:          1,
+		BlockW:          3,
+		BlockH:          2,
+		SamplesPerPixel: 1,
+		Exposure:        1,
+	}
+	_, err = TonemapSimpleReinhard()(tr.(*Tracer), blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the pixels inside the block should be tone-mapped
+	for pixelIndex := 0; pixelIndex < len(buffers.FrameBuffer)>>2; pixelIndex++ {
+		x, y := uint32(pixelIndex)%6, uint32(pixelIndex)/6
+		inBlock := x >= 2 && x < 5 && y >= 1 && y < 3
+		if inBlock != (buffers.FrameBuffer[pixelIndex<<2+3] == 255) {
+			t.Fatalf("expected tone-mapped state for pixel (%d, %d) to be %t", x, y, inBlock)
+		}
+	}
+}
+
+func TestKernelTimes(t *testing.T) {
+	sc := diffuseTriangleScene()
+	camera := &scene.Camera{
+		Frustrum: scene.Frustrum{
+			{-0.1, 0.1, -1, 0},
+			{0.1, 0.1, -1, 0},
+			{-0.1, -0.1, -1, 0},
+			{0.1, -0.1, -1, 0},
+		},
+	}
+
+	tr, err := NewTracer("test", 1, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{4, 4})
+	tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+	tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          4,
+		FrameH:          4,
+		BlockW:          4,
+		BlockH:          4,
+		SamplesPerPixel: 1,
+		NumBounces:      1,
+		MinBouncesForRR: 2,
+		Exposure:        1,
+	}
+	_, err = tr.Trace(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tr.MergeOutput(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tr.SyncFramebuffer(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kernelTimes := tr.Stats().KernelTimes
+	for _, kernel := range []tracer.Kernel{tracer.PrimaryRayKernel, tracer.MergeKernel, tracer.TonemapKernel} {
+		if kernelTimes[kernel] == 0 {
+			t.Errorf("expected %s kernel time to be recorded", kernel)
+		}
+	}
+
+	// Tracing the next block should reset the kernel times
+	_, err = tr.Trace(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kernelTimes = tr.Stats().KernelTimes; kernelTimes[tracer.MergeKernel] != 0 || kernelTimes[tracer.TonemapKernel] != 0 {
+		t.Fatalf("expected merge and tonemap kernel times to be reset; got %v", kernelTimes)
+	}
+}
+
+func TestTraceCancelled(t *testing.T) {
+	sc := diffuseTriangleScene()
+	camera := &scene.Camera{
+		Frustrum: scene.Frustrum{
+			{-0.1, 0.1, -1, 0},
+			{0.1, 0.1, -1, 0},
+			{-0.1, -0.1, -1, 0},
+			{0.1, -0.1, -1, 0},
+		},
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+
+	specs := []struct {
+		samplesPerPixel uint32
+		cancel          <-chan struct{}
+		expSamples      uint32
+	}{
+		{1, nil, 1},
+		{4, cancel, 1},
+	}
+
+	var expAccumulator []types.Vec3
+	for specIndex, spec := range specs {
+		tr, err := NewTracer("test", 1, DefaultPipeline())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Init()
+		tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{4, 4})
+		tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+
+		blockReq := &tracer.BlockRequest{
+			FrameW:          4,
+			FrameH:          4,
+			BlockW:          4,
+			BlockH:          4,
+			SamplesPerPixel: spec.samplesPerPixel,
+			NumBounces:      1,
+			MinBouncesForRR: 2,
+			Seed:            7,
+			Cancel:          spec.cancel,
+		}
+		_, err = tr.Trace(blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if samples := tr.Stats().RenderedSamples; samples != spec.expSamples {
+			t.Fatalf("[spec %d] expected tracer to render %d samples; got %d", specIndex, spec.expSamples, samples)
+		}
+		if blockReq.AccumulatedSamples != spec.expSamples {
+			t.Fatalf("[spec %d] expected accumulated samples to be %d; got %d", specIndex, spec.expSamples, blockReq.AccumulatedSamples)
+		}
+
+		// The output of a cancelled request should only contain the rendered samples
+		accumulator := tr.(*Tracer).buffers.TraceAccumulator
+		if specIndex == 0 {
+			expAccumulator = append([]types.Vec3(nil), accumulator...)
+			continue
+		}
+		for pixelIndex, sample := range accumulator {
+			if exp := expAccumulator[pixelIndex]; sample != exp {
+				t.Fatalf("[spec %d] expected pixel %d accumulator value to be %v; got %v", specIndex, pixelIndex, exp, sample)
+			}
+		}
+	}
+}
+
+func TestFrameAccumulatorReadWrite(t *testing.T) {
+	tr, err := NewTracer("test", 1, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{4, 4})
+
+	blockReq := &tracer.BlockRequest{FrameW: 4, FrameH: 4, BlockX: 1, BlockY: 2, BlockW: 2, BlockH: 2}
+	samples := []types.Vec3{{1, 1, 1}, {2, 2, 2}, {3, 3, 3}, {4, 4, 4}}
+	err = tr.(tracer.FrameAccumulatorReadWriter).WriteFrameAccumulator(blockReq, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the block pixels should be written
+	expAccumulator := make([]types.Vec3, 16)
+	expAccumulator[9], expAccumulator[10] = samples[0], samples[1]
+	expAccumulator[13], expAccumulator[14] = samples[2], samples[3]
+	if accumulator := tr.(*Tracer).buffers.FrameAccumulator; !reflect.DeepEqual(accumulator, expAccumulator) {
+		t.Fatalf("expected frame accumulator to be:\n%v\ngot:\n%v", expAccumulator, accumulator)
+	}
+
+	readSamples, err := tr.(tracer.FrameAccumulatorReadWriter).ReadFrameAccumulator(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readSamples, samples) {
+		t.Fatalf("expected to read samples:\n%v\ngot:\n%v", samples, readSamples)
+	}
+
+	err = tr.(tracer.FrameAccumulatorReadWriter).WriteFrameAccumulator(blockReq, samples[1:])
+	if err == nil {
+		t.Fatal("expected writing a partial block to fail")
+	}
+}
+
+func TestScaleFrameOutput(t *testing.T) {
+	tr, err := NewTracer("test", 1, DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Init()
+	tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{4, 4})
+
+	buffers := tr.(*Tracer).buffers
+	for pixelIndex := range buffers.FrameAccumulator {
+		buffers.FrameAccumulator[pixelIndex] = types.Vec3{2, 4, 8}
+		buffers.FrameAOVs[pixelIndex] = tracer.AOVSample{
+			Albedo:       types.Vec3{2, 2, 2},
+			Normal:       types.Vec3{0, 0, 2},
+			Depth:        8,
+			MeshInstance: 1,
+		}
+	}
+
+	blockReq := &tracer.BlockRequest{FrameW: 4, FrameH: 4, BlockX: 1, BlockY: 2, BlockW: 2, BlockH: 2, CaptureAOVs: true}
+	_, err = tr.(tracer.FrameOutputScaler).ScaleFrameOutput(blockReq, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the block pixels should be scaled
+	for pixelIndex := range buffers.FrameAccumulator {
+		expSample := types.Vec3{2, 4, 8}
+		expAOV := tracer.AOVSample{Albedo: types.Vec3{2, 2, 2}, Normal: types.Vec3{0, 0, 2}, Depth: 8, MeshInstance: 1}
+		switch pixelIndex {
+		case 9, 10, 13, 14:
+			expSample = types.Vec3{1, 2, 4}
+			expAOV = tracer.AOVSample{Albedo: types.Vec3{1, 1, 1}, Normal: types.Vec3{0, 0, 1}, Depth: 4, MeshInstance: 1}
+		}
+
+		if sample := buffers.FrameAccumulator[pixelIndex]; sample != expSample {
+			t.Fatalf("expected pixel %d accumulator value to be %v; got %v", pixelIndex, expSample, sample)
+		}
+		if aov := buffers.FrameAOVs[pixelIndex]; aov != expAOV {
+			t.Fatalf("expected pixel %d AOV to be %+v; got %+v", pixelIndex, expAOV, aov)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tracer
+
+// Parameters for the edge-avoiding à-trous wavelet denoising filter. The
+// filter smooths the frame radiance using a 5x5 B3 spline kernel whose
+// footprint doubles with each iteration. The contribution of each neighboring
+// pixel is scaled by edge-stopping weights exp(-d^2 / sigma^2) where d is the
+// difference between the pixel's radiance, normal, depth or albedo values and
+// the center pixel's values. The normal, depth and albedo values are obtained
+// from the AOVs captured for the first hit of each primary ray. Setting a
+// sigma to zero disables the corresponding edge-stopping weight.
+type DenoiseParams struct {
+	// The number of filter iterations.
+	Iterations uint32
+
+	// The edge-stopping sigma for radiance differences. The sigma is halved
+	// after each iteration so that later iterations, which combine pixels
+	// that are further apart, preserve more detail.
+	ColorSigma float32
+
+	// The edge-stopping sigma for differences between shading normals.
+	NormalSigma float32
+
+	// The edge-stopping sigma for depth differences relative to the depth
+	// of the center pixel.
+	DepthSigma float32
+
+	// The edge-stopping sigma for albedo differences.
+	AlbedoSigma float32
+}
+
+// Get the default denoising parameters.
+func DefaultDenoiseParams() DenoiseParams {
+	return DenoiseParams{
+		Iterations:  5,
+		ColorSigma:  0.8,
+		NormalSigma: 0.3,
+		DepthSigma:  0.1,
+		AlbedoSigma: 0.1,
+	}
+}
@@ -0,0 +1,91 @@
+package tracer
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tracer kernels with individually tracked execution times.
+type Kernel uint8
+
+const (
+	// Primary ray generation.
+	PrimaryRayKernel Kernel = iota
+
+	// Closest hit intersection queries for primary and indirect rays.
+	IntersectionQueryKernel
+
+	// Closest hit intersection queries for primary ray packets.
+	PacketQueryKernel
+
+	// Shading of ray hits.
+	ShadeHitsKernel
+
+	// Shading of rays that do not hit any scene geometry.
+	ShadeMissesKernel
+
+	// Any hit intersection tests for occlusion rays.
+	OcclusionTestKernel
+
+	// Accumulation of emissive samples and AOVs.
+	AccumulateKernel
+
+	// Post-process filtering (e.g. denoising) of the frame accumulator.
+	FilterKernel
+
+	// Tone-mapping of the frame accumulator.
+	TonemapKernel
+
+	// Merging of the trace accumulator into the frame accumulator.
+	MergeKernel
+
+	//
+	NumKernels
+)
+
+// Execution times for each tracer kernel.
+type KernelTimes [NumKernels]time.Duration
+
+// Get the kernel name.
+func (k Kernel) String() string {
+	switch k {
+	case PrimaryRayKernel:
+		return "primary rays"
+	case IntersectionQueryKernel:
+		return "intersection query"
+	case PacketQueryKernel:
+		return "packet query"
+	case ShadeHitsKernel:
+		return "shade hits"
+	case ShadeMissesKernel:
+		return "shade misses"
+	case OcclusionTestKernel:
+		return "occlusion test"
+	case AccumulateKernel:
+		return "accumulate"
+	case FilterKernel:
+		return "filter"
+	case TonemapKernel:
+		return "tonemap"
+	case MergeKernel:
+		return "merge"
+	}
+
+	return fmt.Sprintf("kernel(%d)", uint8(k))
+}
+
+// Add the execution times from another set of kernel times.
+func (kt *KernelTimes) Add(other KernelTimes) {
+	for kernel := range kt {
+		kt[kernel] += other[kernel]
+	}
+}
+
+// Get the total execution time for all kernels.
+func (kt *KernelTimes) Total() time.Duration {
+	var total time.Duration
+	for _, elapsed := range kt {
+		total += elapsed
+	}
+	return total
+}
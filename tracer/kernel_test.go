@@ -0,0 +1,36 @@
+package tracer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKernelTimes(t *testing.T) {
+	var kt KernelTimes
+	kt.Add(KernelTimes{PrimaryRayKernel: time.Second, MergeKernel: 2 * time.Second})
+	kt.Add(KernelTimes{PrimaryRayKernel: time.Second, TonemapKernel: 3 * time.Second})
+
+	expTimes := KernelTimes{PrimaryRayKernel: 2 * time.Second, TonemapKernel: 3 * time.Second, MergeKernel: 2 * time.Second}
+	if kt != expTimes {
+		t.Fatalf("expected kernel times to be %v; got %v", expTimes, kt)
+	}
+
+	if total := kt.Total(); total != 7*time.Second {
+		t.Fatalf("expected total kernel time to be %s; got %s", 7*time.Second, total)
+	}
+}
+
+func TestKernelString(t *testing.T) {
+	seen := make(map[string]bool)
+	for kernel := Kernel(0); kernel < NumKernels; kernel++ {
+		name := kernel.String()
+		if seen[name] {
+			t.Fatalf("duplicate name %q for kernel %d", name, kernel)
+		}
+		seen[name] = true
+	}
+
+	if name := NumKernels.String(); name != "kernel(10)" {
+		t.Fatalf("expected unknown kernel name to be %q; got %q", "kernel(10)", name)
+	}
+}
@@ -8,14 +8,44 @@ __kernel void clearAccumulator(
 	accumulator[get_global_id(0)] = (float3)(0.0f, 0.0f, 0.0f);
 }
 
+// Clear a block of the accumulation buffer. This kernel is executed as a 2D
+// kernel with its work offset set to the block origin.
+__kernel void clearAccumulatorBlock(
+		__global float3 *accumulator,
+		const uint frameW
+		){
+	uint pixelIndex = (get_global_id(1) * frameW) + get_global_id(0);
+	accumulator[pixelIndex] = (float3)(0.0f, 0.0f, 0.0f);
+}
 
-// Aggregate trace accumulator to the primary tracer's frame accumulator 
+// Aggregate trace accumulator to the primary tracer's frame accumulator. This
+// kernel is executed as a 2D kernel with its work offset set to the block origin.
+// If replace is set, the block contents are replaced by the trace accumulator
+// contents instead of being added to them.
 __kernel void aggregateAccumulator(
 		__global float3 *srcAccumulator,
-		__global float3 *dstAccumulator
+		__global float3 *dstAccumulator,
+		const uint frameW,
+		const uint replace
+		){
+	uint pixelIndex = (get_global_id(1) * frameW) + get_global_id(0);
+	if(replace){
+		dstAccumulator[pixelIndex] = srcAccumulator[pixelIndex];
+		return;
+	}
+
+	dstAccumulator[pixelIndex] += srcAccumulator[pixelIndex];
+}
+
+// Scale the accumulator contents. This kernel is executed as a 2D kernel
+// with its work offset set to the block origin.
+__kernel void scaleAccumulator(
+		__global float3 *accumulator,
+		const uint frameW,
+		const float scale
 		){
-	int globalId = get_global_id(0);
-	dstAccumulator[globalId] += srcAccumulator[globalId];
+	uint pixelIndex = (get_global_id(1) * frameW) + get_global_id(0);
+	accumulator[pixelIndex] *= scale;
 }
 
 #endif
@@ -0,0 +1,107 @@
+#ifndef AOV_KERNELS_CL
+#define AOV_KERNELS_CL
+
+// Clear AOV buffer
+__kernel void clearAOVs(
+		__global AOVSample *aovs
+		){
+	int globalId = get_global_id(0);
+	aovs[globalId].albedo = (float3)(0.0f, 0.0f, 0.0f);
+	aovs[globalId].normal = (float3)(0.0f, 0.0f, 0.0f);
+	aovs[globalId].depth = 0.0f;
+	aovs[globalId].meshInstance = -1;
+	aovs[globalId].material = -1;
+}
+
+// Accumulate albedo, shading normal, depth and ids for primary ray hits.
+__kernel void accumulateAOVs(
+		__global Ray *rays,
+		__global const int *numRays,
+		__global Path *paths,
+		__global uint *hitFlags,
+		__global Intersection *intersections,
+		__global float4 *vertices,
+		__global float4 *normals,
+		__global float2 *uv,
+		__global uint *materialIndices,
+		__global MaterialNode *materialNodes,
+		// texture data
+		__global TextureMetadata *texMeta,
+		__global uchar *texData,
+		const uint randSeed,
+		// output
+		__global AOVSample *aovs
+		){
+
+	int globalId = get_global_id(0);
+	if(globalId >= *numRays){
+		return;
+	}
+
+	float hitDist = intersections[globalId].wuvt.w;
+
+	// No hit
+	if(!hitFlags[globalId] || hitDist == FLT_MAX) {
+		return;
+	}
+
+	uint pixelIndex = paths[globalId].pixelIndex;
+
+	Surface surface;
+	surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+
+	float3 inRayDir = -rays[globalId].dir.xyz;
+
+	MaterialNode materialNode;
+	uint2 rndState = (uint2)(randSeed, pixelIndex + 1);
+	float3 bxdfTint = (float3)(1.0f, 1.0f, 1.0f);
+	matSelectNode(paths + globalId, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &rndState, texMeta, texData);
+
+	aovs[pixelIndex].albedo += bxdfTint * matGetSample3f(surface.uv, materialNode.reflectance, materialNode.reflectanceTex, texMeta, texData);
+	aovs[pixelIndex].normal += surface.normal;
+	aovs[pixelIndex].depth += hitDist;
+	aovs[pixelIndex].meshInstance = intersections[globalId].meshInstance;
+	aovs[pixelIndex].material = materialIndices[intersections[globalId].triIndex];
+}
+
+// Aggregate trace AOVs to the primary tracer's frame AOVs. If replace is set
+// the destination AOVs are overwritten instead of being accumulated. This
+// kernel is executed as a 2D kernel with its work offset set to the block origin.
+__kernel void aggregateAOVs(
+		__global AOVSample *srcAOVs,
+		__global AOVSample *dstAOVs,
+		const uint frameW,
+		const uint replace
+		){
+	uint pixelIndex = (get_global_id(1) * frameW) + get_global_id(0);
+	if(replace){
+		dstAOVs[pixelIndex] = srcAOVs[pixelIndex];
+		return;
+	}
+
+	// Skip pixels without any primary ray hits
+	if(srcAOVs[pixelIndex].meshInstance == -1){
+		return;
+	}
+
+	dstAOVs[pixelIndex].albedo += srcAOVs[pixelIndex].albedo;
+	dstAOVs[pixelIndex].normal += srcAOVs[pixelIndex].normal;
+	dstAOVs[pixelIndex].depth += srcAOVs[pixelIndex].depth;
+	dstAOVs[pixelIndex].meshInstance = srcAOVs[pixelIndex].meshInstance;
+	dstAOVs[pixelIndex].material = srcAOVs[pixelIndex].material;
+}
+
+// Scale the accumulated albedo, normal and depth values. This kernel is
+// executed as a 2D kernel with its work offset set to the block origin.
+__kernel void scaleAOVs(
+		__global AOVSample *aovs,
+		const uint frameW,
+		const float scale
+		){
+	uint pixelIndex = (get_global_id(1) * frameW) + get_global_id(0);
+	aovs[pixelIndex].albedo *= scale;
+	aovs[pixelIndex].normal *= scale;
+	aovs[pixelIndex].depth *= scale;
+}
+
+#endif
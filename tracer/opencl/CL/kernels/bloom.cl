@@ -0,0 +1,125 @@
+#ifndef BLOOM_KERNEL_CL
+#define BLOOM_KERNEL_CL
+
+// The binomial approximation of a Gaussian kernel used for blurring each bloom
+// pyramid level.
+__constant float bloomBlurKernel[5] = {1.0f / 16.0f, 4.0f / 16.0f, 6.0f / 16.0f, 4.0f / 16.0f, 1.0f / 16.0f};
+
+float3 bloomLevelPixel(__global float3 *level, int x, int y, const int levelW, const int levelH);
+
+// Get a bloom pyramid level pixel clamping its coordinates to the level bounds.
+inline float3 bloomLevelPixel(__global float3 *level, int x, int y, const int levelW, const int levelH){
+	x = clamp(x, 0, levelW - 1);
+	y = clamp(y, 0, levelH - 1);
+	return level[y * levelW + x];
+}
+
+// Extract the radiance of the block pixels in excess of the luminance threshold
+// into the first bloom pyramid level.
+__kernel void bloomExtract(
+		__global float3 *accumulator,
+		__global float3 *pyramid,
+		const uint frameW,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const float sampleWeight,
+		const float threshold
+		){
+	int globalId = get_global_id(0);
+	uint pixelIndex = (blockY + globalId / blockW) * frameW + blockX + (globalId % blockW);
+
+	float3 radiance = accumulator[pixelIndex] * sampleWeight;
+	float lum = 0.2126f * radiance.x + 0.7152f * radiance.y + 0.0722f * radiance.z;
+	pyramid[globalId] = lum > threshold ? radiance * ((lum - threshold) / lum) : (float3)(0.0f, 0.0f, 0.0f);
+}
+
+// Blur a bloom pyramid level along the (dx, dy) direction.
+__kernel void bloomBlur(
+		__global float3 *src,
+		const uint srcOffset,
+		__global float3 *dst,
+		const uint dstOffset,
+		const int levelW,
+		const int levelH,
+		const int dx,
+		const int dy
+		){
+	int globalId = get_global_id(0);
+	int x = globalId % levelW;
+	int y = globalId / levelW;
+
+	float3 sum = (float3)(0.0f, 0.0f, 0.0f);
+	for(int k = -2; k <= 2; k++){
+		sum += bloomLevelPixel(src + srcOffset, x + k * dx, y + k * dy, levelW, levelH) * bloomBlurKernel[k + 2];
+	}
+
+	dst[dstOffset + globalId] = sum;
+}
+
+// Downsample a bloom pyramid level to half its resolution by averaging 2x2
+// pixel blocks.
+__kernel void bloomDownsample(
+		__global float3 *pyramid,
+		const uint srcOffset,
+		const int srcW,
+		const int srcH,
+		const uint dstOffset,
+		const int dstW
+		){
+	int globalId = get_global_id(0);
+	int x = 2 * (globalId % dstW);
+	int y = 2 * (globalId / dstW);
+
+	__global float3 *src = pyramid + srcOffset;
+	float3 sum = bloomLevelPixel(src, x, y, srcW, srcH) +
+		bloomLevelPixel(src, x + 1, y, srcW, srcH) +
+		bloomLevelPixel(src, x, y + 1, srcW, srcH) +
+		bloomLevelPixel(src, x + 1, y + 1, srcW, srcH);
+
+	pyramid[dstOffset + globalId] = sum * 0.25f;
+}
+
+// Bilinearly upsample a bloom pyramid level and add it to the next finer level.
+__kernel void bloomUpsampleAdd(
+		__global float3 *pyramid,
+		const uint srcOffset,
+		const int srcW,
+		const int srcH,
+		const uint dstOffset,
+		const int dstW
+		){
+	int globalId = get_global_id(0);
+	float sx = fmax(0.5f * (float)(globalId % dstW) - 0.25f, 0.0f);
+	float sy = fmax(0.5f * (float)(globalId / dstW) - 0.25f, 0.0f);
+	int x0 = (int)sx;
+	int y0 = (int)sy;
+	float tx = sx - (float)x0;
+	float ty = sy - (float)y0;
+
+	__global float3 *src = pyramid + srcOffset;
+	float3 top = mix(bloomLevelPixel(src, x0, y0, srcW, srcH), bloomLevelPixel(src, x0 + 1, y0, srcW, srcH), tx);
+	float3 bottom = mix(bloomLevelPixel(src, x0, y0 + 1, srcW, srcH), bloomLevelPixel(src, x0 + 1, y0 + 1, srcW, srcH), tx);
+
+	pyramid[dstOffset + globalId] += mix(top, bottom, ty);
+}
+
+// Add the blurred radiance stored in the first bloom pyramid level to the
+// accumulator samples of the block and store the result in the output buffer.
+__kernel void bloomComposite(
+		__global float3 *accumulator,
+		__global float3 *output,
+		__global float3 *pyramid,
+		const uint frameW,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const float scale
+		){
+	int globalId = get_global_id(0);
+	uint pixelIndex = (blockY + globalId / blockW) * frameW + blockX + (globalId % blockW);
+
+	output[pixelIndex] = accumulator[pixelIndex] + pyramid[globalId] * scale;
+}
+
+#endif
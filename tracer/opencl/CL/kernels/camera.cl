@@ -1,6 +1,19 @@
 #ifndef CAMERA_KERNEL_CL
 #define CAMERA_KERNEL_CL
 
+// Get the normalized frame coordinates for a sample within a pixel. Samples
+// are stratified using a tent filter. This will wrap our random numbers in the
+// [-1, 1] range. The pixel coordinates point to the top corner of the texel so
+// we need to add a bit of offset to get the coords into the [-0.5, 1.5] range.
+inline float2 cameraSampleTexel(uint2 pixel, float2 texelDims, uint2 *rndState){
+	float2 sample0 = randomGetSample2f(rndState);
+	float2 offset = (float2)(
+			sample0.x < 0.5f ? native_sqrt(2.0f * sample0.x) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.x),
+			sample0.y < 0.5f ? native_sqrt(2.0f * sample0.y) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.y)
+	);
+	return ((float2)(pixel.x, pixel.y) + offset) * texelDims;
+}
+
 // Generate primary rays.
 __kernel void generatePrimaryRays(
 		__global Ray *rays, 
@@ -12,7 +25,9 @@ __kernel void generatePrimaryRays(
 		const float4 frustrumBR,
 		const float3 eyePos,
 		const float2 texelDims,
+		const uint blockX,
 		const uint blockY,
+		const uint blockW,
 		const uint blockH,
 		const uint frameW,
 		const uint frameH,
@@ -24,24 +39,17 @@ __kernel void generatePrimaryRays(
 	globalId.y = get_global_id(1);
 
 	if(globalId.x == 0 && globalId.y == 0){
-		*numRays = frameW * blockH;
-	}
-
-	if( globalId.x < frameW && globalId.y < blockH ){
-		uint index = (globalId.y * frameW) + globalId.x;
-		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x;
-
-		// Apply stratified sampling using a tent filter. This will wrap our
-		// random numbers in the [-1, 1] range. X and Y point to the top corner
-		// of the current texel so we need to add a bit of offset to get the coords
-		// into the [-0.5, 1.5] range.
-		uint2 rndState = globalId + randSeed;
-		float2 sample0 = randomGetSample2f(&rndState);
-		float2 offset = (float2)(
-				sample0.x < 0.5f ? native_sqrt(2.0f * sample0.x) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.x),
-				sample0.y < 0.5f ? native_sqrt(2.0f * sample0.y) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.y)
-		);
-		float2 texel = ((float2)(globalId.x, globalId.y + blockY) + offset) * texelDims;
+		*numRays = blockW * blockH;
+	}
+
+	if( globalId.x < blockW && globalId.y < blockH ){
+		uint index = (globalId.y * blockW) + globalId.x;
+		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x + blockX;
+
+		// Seed the generator with the frame coordinates so that the output
+		// does not depend on how the frame is split into blocks.
+		uint2 rndState = (uint2)(globalId.x + blockX, globalId.y + blockY) + randSeed;
+		float2 texel = cameraSampleTexel((uint2)(globalId.x + blockX, globalId.y + blockY), texelDims, &rndState);
 
 		// Get ray direction using trilinear interpolation
 		float4 dir = normalize(
@@ -57,4 +65,222 @@ __kernel void generatePrimaryRays(
 	}
 }
 
+// Generate primary rays for a thin-lens camera. Rays originate from random
+// points on the lens aperture and pass through the point where the
+// corresponding pinhole camera ray meets the focal plane.
+__kernel void generateThinLensPrimaryRays(
+		__global Ray *rays, 
+		__global int *numRays,
+		__global Path *paths,
+		const float4 frustrumTL,
+		const float4 frustrumTR,
+		const float4 frustrumBL,
+		const float4 frustrumBR,
+		const float3 eyePos,
+		const float3 cameraRight,
+		const float3 cameraUp,
+		const float apertureRadius,
+		const float focusDistance,
+		const float2 texelDims,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const uint blockH,
+		const uint frameW,
+		const uint frameH,
+		const uint randSeed
+		){
+
+	uint2 globalId;
+	globalId.x = get_global_id(0);
+	globalId.y = get_global_id(1);
+
+	if(globalId.x == 0 && globalId.y == 0){
+		*numRays = blockW * blockH;
+	}
+
+	if( globalId.x < blockW && globalId.y < blockH ){
+		uint index = (globalId.y * blockW) + globalId.x;
+		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x + blockX;
+
+		uint2 rndState = (uint2)(globalId.x + blockX, globalId.y + blockY) + randSeed;
+		float2 texel = cameraSampleTexel((uint2)(globalId.x + blockX, globalId.y + blockY), texelDims, &rndState);
+
+		// Frustrum rays have a unit length along the view direction so
+		// we can scale them by the focus distance to get the point where
+		// the pinhole ray meets the focal plane.
+		float3 pinholeDir = mix(
+			mix(frustrumTL, frustrumBL, texel.y),
+			mix(frustrumTR, frustrumBR, texel.y),
+			texel.x
+		).xyz;
+		float3 focusPoint = eyePos + pinholeDir * focusDistance;
+
+		// Sample a point on the lens disk
+		float2 lensSample = randomGetSample2f(&rndState);
+		float radius = native_sqrt(lensSample.x) * apertureRadius;
+		float phi = C_TWO_TIMES_PI * lensSample.y;
+		float3 origin = eyePos + cameraRight * (radius * native_cos(phi)) + cameraUp * (radius * native_sin(phi));
+
+		rayNew(rays + index, origin, normalize(focusPoint - origin), FLT_MAX, index);
+		pathNew(paths + index, pixelIndex);
+	}
+}
+
+// Generate primary rays for an orthographic camera. Rays are parallel to the
+// view direction and originate from the camera plane.
+__kernel void generateOrthographicPrimaryRays(
+		__global Ray *rays, 
+		__global int *numRays,
+		__global Path *paths,
+		const float3 eyePos,
+		const float3 cameraForward,
+		const float3 cameraRight,
+		const float3 cameraUp,
+		const float2 viewDims,
+		const float2 texelDims,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const uint blockH,
+		const uint frameW,
+		const uint frameH,
+		const uint randSeed
+		){
+
+	uint2 globalId;
+	globalId.x = get_global_id(0);
+	globalId.y = get_global_id(1);
+
+	if(globalId.x == 0 && globalId.y == 0){
+		*numRays = blockW * blockH;
+	}
+
+	if( globalId.x < blockW && globalId.y < blockH ){
+		uint index = (globalId.y * blockW) + globalId.x;
+		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x + blockX;
+
+		uint2 rndState = (uint2)(globalId.x + blockX, globalId.y + blockY) + randSeed;
+		float2 texel = cameraSampleTexel((uint2)(globalId.x + blockX, globalId.y + blockY), texelDims, &rndState);
+
+		float3 origin = eyePos +
+			cameraRight * ((texel.x - 0.5f) * viewDims.x) +
+			cameraUp * ((0.5f - texel.y) * viewDims.y);
+
+		rayNew(rays + index, origin, cameraForward, FLT_MAX, index);
+		pathNew(paths + index, pixelIndex);
+	}
+}
+
+// Generate primary rays for an equirectangular camera. The frame x axis maps
+// to the full range of longitudes around the camera up vector and the y axis
+// maps to the range of latitudes.
+__kernel void generateEquirectangularPrimaryRays(
+		__global Ray *rays, 
+		__global int *numRays,
+		__global Path *paths,
+		const float3 eyePos,
+		const float3 cameraForward,
+		const float3 cameraRight,
+		const float3 cameraUp,
+		const float2 texelDims,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const uint blockH,
+		const uint frameW,
+		const uint frameH,
+		const uint randSeed
+		){
+
+	uint2 globalId;
+	globalId.x = get_global_id(0);
+	globalId.y = get_global_id(1);
+
+	if(globalId.x == 0 && globalId.y == 0){
+		*numRays = blockW * blockH;
+	}
+
+	if( globalId.x < blockW && globalId.y < blockH ){
+		uint index = (globalId.y * blockW) + globalId.x;
+		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x + blockX;
+
+		uint2 rndState = (uint2)(globalId.x + blockX, globalId.y + blockY) + randSeed;
+		float2 texel = cameraSampleTexel((uint2)(globalId.x + blockX, globalId.y + blockY), texelDims, &rndState);
+
+		float phi = (texel.x - 0.5f) * C_TWO_TIMES_PI;
+		float theta = texel.y * C_PI;
+		float sinTheta = native_sin(theta);
+		float3 dir = cameraForward * (sinTheta * native_cos(phi)) +
+			cameraRight * (sinTheta * native_sin(phi)) +
+			cameraUp * native_cos(theta);
+
+		rayNew(rays + index, eyePos, normalize(dir), FLT_MAX, index);
+		pathNew(paths + index, pixelIndex);
+	}
+}
+
+// Generate primary rays for an equidistant fisheye camera. The field of view
+// is mapped to the largest circle that fits inside the frame. Rays for pixels
+// outside the circle do not intersect the scene and do not contribute to the
+// frame.
+__kernel void generateFisheyePrimaryRays(
+		__global Ray *rays, 
+		__global int *numRays,
+		__global Path *paths,
+		const float3 eyePos,
+		const float3 cameraForward,
+		const float3 cameraRight,
+		const float3 cameraUp,
+		const float halfFOV,
+		const float aspect,
+		const float2 texelDims,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const uint blockH,
+		const uint frameW,
+		const uint frameH,
+		const uint randSeed
+		){
+
+	uint2 globalId;
+	globalId.x = get_global_id(0);
+	globalId.y = get_global_id(1);
+
+	if(globalId.x == 0 && globalId.y == 0){
+		*numRays = blockW * blockH;
+	}
+
+	if( globalId.x < blockW && globalId.y < blockH ){
+		uint index = (globalId.y * blockW) + globalId.x;
+		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x + blockX;
+
+		uint2 rndState = (uint2)(globalId.x + blockX, globalId.y + blockY) + randSeed;
+		float2 texel = cameraSampleTexel((uint2)(globalId.x + blockX, globalId.y + blockY), texelDims, &rndState);
+
+		// Map the frame to [-aspect, aspect] x [-1, 1]
+		float2 p = (float2)((2.0f * texel.x - 1.0f) * aspect, 1.0f - 2.0f * texel.y);
+		float r = length(p);
+		if( r > 1.0f ){
+			rayNew(rays + index, eyePos, cameraForward, 0.0f, index);
+			pathNew(paths + index, pixelIndex);
+			pathSetThroughput(paths + index, (float3)(0.0f, 0.0f, 0.0f));
+			return;
+		}
+
+		float3 dir = cameraForward;
+		if( r > 0.0f ){
+			float theta = r * halfFOV;
+			float sinTheta = native_sin(theta) / r;
+			dir = cameraForward * native_cos(theta) +
+				cameraRight * (p.x * sinTheta) +
+				cameraUp * (p.y * sinTheta);
+		}
+
+		rayNew(rays + index, eyePos, normalize(dir), FLT_MAX, index);
+		pathNew(paths + index, pixelIndex);
+	}
+}
+
 #endif
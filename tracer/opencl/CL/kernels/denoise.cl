@@ -0,0 +1,87 @@
+#ifndef DENOISE_KERNEL_CL
+#define DENOISE_KERNEL_CL
+
+// The B3 spline coefficients used by each a-trous filter iteration
+__constant float aTrousKernel[5] = {1.0f / 16.0f, 1.0f / 4.0f, 3.0f / 8.0f, 1.0f / 4.0f, 1.0f / 16.0f};
+
+float edgeStoppingWeight(float deltaSq, const float sigma);
+float3 aovNormal(__global AOVSample *aov);
+
+// Calculate the edge-stopping weight for a squared difference. A zero sigma
+// disables the edge-stopping weight.
+inline float edgeStoppingWeight(float deltaSq, const float sigma){
+	return sigma > 0.0f ? native_exp(-deltaSq / (sigma * sigma)) : 1.0f;
+}
+
+// Get the normalized accumulated normal for an AOV sample.
+inline float3 aovNormal(__global AOVSample *aov){
+	return length(aov->normal) > 0.0f ? normalize(aov->normal) : (float3)(0.0f, 0.0f, 0.0f);
+}
+
+// Apply a single iteration of the edge-avoiding a-trous wavelet filter to the
+// accumulator samples of a block. Neighboring pixels are weighted based on
+// their radiance, normal, depth and albedo differences to the center pixel.
+// Neighboring pixels outside the block are ignored. This kernel is executed
+// as a 2D kernel with its work offset set to the block origin.
+__kernel void denoiseATrous(
+		__global float3 *src,
+		__global float3 *dst,
+		__global AOVSample *aovs,
+		const uint frameW,
+		const uint blockX,
+		const uint blockY,
+		const uint blockW,
+		const uint blockH,
+		const int stepSize,
+		const float sampleWeight,
+		const float colorSigma,
+		const float normalSigma,
+		const float depthSigma,
+		const float albedoSigma
+		){
+	int x = get_global_id(0);
+	int y = get_global_id(1);
+	uint pixelIndex = (y * frameW) + x;
+
+	float3 center = src[pixelIndex] * sampleWeight;
+	float3 centerNormal = aovNormal(aovs + pixelIndex);
+	float centerDepth = aovs[pixelIndex].depth * sampleWeight;
+	float3 centerAlbedo = aovs[pixelIndex].albedo * sampleWeight;
+
+	float3 sum = (float3)(0.0f, 0.0f, 0.0f);
+	float weightSum = 0.0f;
+	for(int ky = -2; ky <= 2; ky++){
+		int sy = y + ky * stepSize;
+		if(sy < (int)blockY || sy >= (int)(blockY + blockH)){
+			continue;
+		}
+
+		for(int kx = -2; kx <= 2; kx++){
+			int sx = x + kx * stepSize;
+			if(sx < (int)blockX || sx >= (int)(blockX + blockW)){
+				continue;
+			}
+
+			uint sampleIndex = (sy * frameW) + sx;
+			float3 sample = src[sampleIndex] * sampleWeight;
+			float3 colorDelta = sample - center;
+			float3 normalDelta = aovNormal(aovs + sampleIndex) - centerNormal;
+			float depthDelta = (aovs[sampleIndex].depth * sampleWeight - centerDepth) / fmax(centerDepth, 1e-4f);
+			float3 albedoDelta = aovs[sampleIndex].albedo * sampleWeight - centerAlbedo;
+
+			float weight = aTrousKernel[kx + 2] * aTrousKernel[ky + 2] *
+				edgeStoppingWeight(dot(colorDelta, colorDelta), colorSigma) *
+				edgeStoppingWeight(dot(normalDelta, normalDelta), normalSigma) *
+				edgeStoppingWeight(depthDelta * depthDelta, depthSigma) *
+				edgeStoppingWeight(dot(albedoDelta, albedoDelta), albedoSigma);
+
+			sum += src[sampleIndex] * weight;
+			weightSum += weight;
+		}
+	}
+
+	// The center pixel always contributes so weightSum is never zero
+	dst[pixelIndex] = sum / weightSum;
+}
+
+#endif
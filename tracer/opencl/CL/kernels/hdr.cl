@@ -27,4 +27,108 @@ __kernel void tonemapSimpleReinhard(
 					);
 		}
 
+// Coefficients for the Uncharted 2 filmic curve
+#define UNCHARTED2_A 0.15f
+#define UNCHARTED2_B 0.50f
+#define UNCHARTED2_C 0.10f
+#define UNCHARTED2_D 0.20f
+#define UNCHARTED2_E 0.02f
+#define UNCHARTED2_F 0.30f
+
+void storeTonemappedColor(__global uchar4 *frameBuffer, int globalId, float3 mapped, const float invGamma);
+float3 uncharted2Curve(float3 x);
+
+// Apply gamma correction to a tone-mapped color and write it to the frame buffer
+inline void storeTonemappedColor(__global uchar4 *frameBuffer, int globalId, float3 mapped, const float invGamma){
+	float3 normalizedOutput = clamp(pow(max(mapped, 0.0f), invGamma), 0.0f, 1.0f) * 255.0f;
+
+	frameBuffer[globalId] = (uchar4)(
+			(uchar)normalizedOutput.r,
+			(uchar)normalizedOutput.g,
+			(uchar)normalizedOutput.b,
+			255 // alpha
+			);
+}
+
+// Evaluate the Uncharted 2 filmic curve by John Hable
+inline float3 uncharted2Curve(float3 x){
+	return ((x * (UNCHARTED2_A * x + UNCHARTED2_C * UNCHARTED2_B) + UNCHARTED2_D * UNCHARTED2_E) /
+			(x * (UNCHARTED2_A * x + UNCHARTED2_B) + UNCHARTED2_D * UNCHARTED2_F)) - UNCHARTED2_E / UNCHARTED2_F;
+}
+
+// Reinhard tone-mapping with a white point. If the white point is not
+// positive, the simple Reinhard operator is applied instead.
+__kernel void tonemapReinhard(
+	__global float3 *accumulator,
+	__global uchar4 *frameBuffer,
+	const float sampleWeight,
+	const float exposure,
+	const float whitePoint,
+	const float invGamma
+		){
+
+			int globalId = get_global_id(0);
+
+			float3 hdrColor = accumulator[globalId] * sampleWeight * exposure;
+			float3 mapped = whitePoint > 0.0f
+				? hdrColor * (1.0f + hdrColor / (whitePoint * whitePoint)) / (1.0f + hdrColor)
+				: hdrColor / (hdrColor + 1.0f);
+
+			storeTonemappedColor(frameBuffer, globalId, mapped, invGamma);
+		}
+
+// ACES filmic tone-mapping using the curve approximation by Krzysztof Narkowicz
+__kernel void tonemapACES(
+	__global float3 *accumulator,
+	__global uchar4 *frameBuffer,
+	const float sampleWeight,
+	const float exposure,
+	const float whitePoint,
+	const float invGamma
+		){
+
+			int globalId = get_global_id(0);
+
+			float3 hdrColor = accumulator[globalId] * sampleWeight * exposure;
+			float3 mapped = (hdrColor * (2.51f * hdrColor + 0.03f)) / (hdrColor * (2.43f * hdrColor + 0.59f) + 0.14f);
+
+			storeTonemappedColor(frameBuffer, globalId, mapped, invGamma);
+		}
+
+// Uncharted 2 filmic tone-mapping
+__kernel void tonemapUncharted2(
+	__global float3 *accumulator,
+	__global uchar4 *frameBuffer,
+	const float sampleWeight,
+	const float exposure,
+	const float whitePoint,
+	const float invGamma
+		){
+
+			int globalId = get_global_id(0);
+
+			float3 hdrColor = accumulator[globalId] * sampleWeight * exposure;
+			float3 mapped = uncharted2Curve(hdrColor) / uncharted2Curve((float3)(whitePoint));
+
+			storeTonemappedColor(frameBuffer, globalId, mapped, invGamma);
+		}
+
+// Linear tone-mapping that maps [0, whitePoint] to [0, 1] and clamps values outside this range
+__kernel void tonemapLinear(
+	__global float3 *accumulator,
+	__global uchar4 *frameBuffer,
+	const float sampleWeight,
+	const float exposure,
+	const float whitePoint,
+	const float invGamma
+		){
+
+			int globalId = get_global_id(0);
+
+			float3 hdrColor = accumulator[globalId] * sampleWeight * exposure;
+			float3 mapped = hdrColor / whitePoint;
+
+			storeTonemappedColor(frameBuffer, globalId, mapped, invGamma);
+		}
+
 #endif
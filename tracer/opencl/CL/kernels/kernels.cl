@@ -3,9 +3,12 @@
 
 #include "camera.cl"
 #include "hdr.cl"
+#include "denoise.cl"
+#include "bloom.cl"
 #include "intersect.cl"
 #include "pt_integrator.cl"
 #include "accumulator.cl"
+#include "aov.cl"
 #include "debug.cl"
 
 #endif
@@ -77,18 +77,20 @@ __kernel void shadeHits(
 			bxdfPdf = 1.0f;
 			bxdfWeight = 1.0f;
 
-			// Init PRNG and generate required samples
-			uint2 rndState = (uint2)(randSeed, globalId);
-			float2 sample0 = randomGetSample2f(&rndState);
-			float2 sample1 = randomGetSample2f(&rndState);
-			float2 sample2 = randomGetSample2f(&rndState);
-
 			// Load incoming ray direction and invert it so it points away
 			// from the surface. All BxDF formulas use in/out rays that 
 			// are going outwards from the surface.
 			float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
 			curPathThroughput = paths[rayPathIndex].throughput;
 
+			// Init PRNG and generate required samples. The generator is seeded
+			// with the frame pixel index of the path as the ray index depends
+			// on the block dimensions and on the rays that survived.
+			uint2 rndState = (uint2)(randSeed, paths[rayPathIndex].pixelIndex + 1);
+			float2 sample0 = randomGetSample2f(&rndState);
+			float2 sample1 = randomGetSample2f(&rndState);
+			float2 sample2 = randomGetSample2f(&rndState);
+
 			// Fill surface data and calculate cos(n, inRay)
 			surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
 
@@ -186,4 +186,20 @@ typedef struct {
 	uint type;
 } Emissive;
 
+typedef struct {
+	// Accumulated albedo and shading normal for first hits
+	float3 albedo;
+	float3 normal;
+
+	// Accumulated distance to first hit
+	float depth;
+
+	// Mesh instance and root material node index for the last first hit or -1
+	int meshInstance;
+	int material;
+
+	// padding
+	uint _reserved1;
+} AOVSample;
+
 #endif
@@ -16,6 +16,7 @@ const (
 	sizeofIntersection      = 32
 	sizeofEmissiveSample    = 16 // float3 but takes same space as float4
 	sizeofAccumulatorSample = 16 // float3
+	sizeofAOVSample         = 48
 )
 
 type bufferSet struct {
@@ -58,10 +59,34 @@ type bufferSet struct {
 
 	// A buffer that aggregates the trace accumulator content between
 	// multiple frames. All post-processing pipeline stages operate on
-	// this buffer. The buffer is cleared when the pipeline Reset stage
-	// is executed.
+	// this buffer. Blocks are cleared when the pipeline Reset stage is
+	// executed and replaced when merging the first samples of a frame.
 	FrameAccumulator *device.Buffer
 
+	// Buffers for the output of post-process filter stages (e.g. denoising).
+	// Filters write their output to the filtered accumulator so that the
+	// frame accumulator contents are preserved for accumulating the samples
+	// of subsequent frames. The scratch buffer stores intermediate results.
+	FilteredAccumulator *device.Buffer
+	FilterScratch       *device.Buffer
+
+	// The levels of the Gaussian pyramid used by the bloom filter. The
+	// levels are stored back to back starting with the full resolution level.
+	BloomPyramid *device.Buffer
+
+	// A buffer for uploading trace accumulator data from tracers that
+	// do not share our opencl context (e.g. remote tracers).
+	HostAccumulator *device.Buffer
+
+	// AOV buffers for a single trace request and the entire frame. They
+	// work in the same way as the trace and frame accumulators.
+	TraceAOVs *device.Buffer
+	FrameAOVs *device.Buffer
+
+	// A buffer for uploading trace AOV data from tracers that do not
+	// share our opencl context.
+	HostAOVs *device.Buffer
+
 	EmissiveSamples *device.Buffer
 	DebugOutput     *device.Buffer
 
@@ -91,13 +116,20 @@ func newBufferSet(dev *device.Device) *bufferSet {
 			dev.Buffer("rays1"),
 			dev.Buffer("rays2"),
 		},
-		Paths:            dev.Buffer("paths"),
-		HitFlags:         dev.Buffer("hitFlags"),
-		Intersections:    dev.Buffer("intersections"),
-		EmissiveSamples:  dev.Buffer("emissiveSamples"),
-		TraceAccumulator: dev.Buffer("traceAccumulator"),
-		FrameAccumulator: dev.Buffer("frameAccumulator"),
-		DebugOutput:      dev.Buffer("debugOutput"),
+		Paths:               dev.Buffer("paths"),
+		HitFlags:            dev.Buffer("hitFlags"),
+		Intersections:       dev.Buffer("intersections"),
+		EmissiveSamples:     dev.Buffer("emissiveSamples"),
+		TraceAccumulator:    dev.Buffer("traceAccumulator"),
+		FrameAccumulator:    dev.Buffer("frameAccumulator"),
+		FilteredAccumulator: dev.Buffer("filteredAccumulator"),
+		FilterScratch:       dev.Buffer("filterScratch"),
+		BloomPyramid:        dev.Buffer("bloomPyramid"),
+		HostAccumulator:     dev.Buffer("hostAccumulator"),
+		TraceAOVs:           dev.Buffer("traceAOVs"),
+		FrameAOVs:           dev.Buffer("frameAOVs"),
+		HostAOVs:            dev.Buffer("hostAOVs"),
+		DebugOutput:         dev.Buffer("debugOutput"),
 		RayCounters: [3]*device.Buffer{
 			dev.Buffer("numRays0"),
 			dev.Buffer("numRays1"),
@@ -162,6 +194,34 @@ func (bs *bufferSet) Resize(frameW, frameH uint32) error {
 	if err != nil {
 		return err
 	}
+	err = bs.HostAccumulator.Allocate(int(pixels*sizeofAccumulatorSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.FilteredAccumulator.Allocate(int(pixels*sizeofAccumulatorSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.FilterScratch.Allocate(int(pixels*sizeofAccumulatorSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.BloomPyramid.Allocate(int(bloomPyramidSize(frameW, frameH)*sizeofAccumulatorSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.TraceAOVs.Allocate(int(pixels*sizeofAOVSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.FrameAOVs.Allocate(int(pixels*sizeofAOVSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.HostAOVs.Allocate(int(pixels*sizeofAOVSample), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
 	err = bs.EmissiveSamples.Allocate(int(pixels*sizeofEmissiveSample), cl.MEM_READ_WRITE)
 	if err != nil {
 		return err
@@ -144,6 +144,38 @@ func (b *Buffer) WriteData(data interface{}, offset int) error {
 	return nil
 }
 
+// Write data to the device buffer starting at the specified byte offset. The
+// behavior of this method is undefined if a non-slice argument is passed or
+// the argument does not use contiguous memory.
+func (b *Buffer) WriteDataAtOffset(data interface{}, dstOffset int) error {
+	dataPtr, dataLen := getSliceData(data)
+	if dataLen == 0 {
+		return nil
+	}
+
+	if dstOffset+dataLen > b.size {
+		return fmt.Errorf("opencl device(%s): insufficient buffer space (%d) in %s for copying data of length %d at offset %d", b.device.Name, b.size, b.name, dataLen, dstOffset)
+	}
+
+	errCode := cl.EnqueueWriteBuffer(
+		b.device.cmdQueue,
+		b.bufHandle,
+		cl.TRUE,
+		uint64(dstOffset),
+		uint64(dataLen),
+		dataPtr,
+		0,
+		nil,
+		nil,
+	)
+
+	if errCode != cl.SUCCESS {
+		return fmt.Errorf("opencl device(%s): error copying host data to device buffer %s (errCode %d)", b.device.Name, b.name, errCode)
+	}
+
+	return nil
+}
+
 // Read data from device buffer into the supplied host buffer. The behavior of
 // this method is undefined if a non-slice argument is passed or if the argument
 // does not use contiguous memory.
@@ -214,3 +214,44 @@ func (k *Kernel) Exec2D(offsetX, offsetY, globalWorkSizeX, globalWorkSizeY, loca
 
 	return time.Since(tick), nil
 }
+
+// Execute 2D kernel. If both localWorkSizeX and localWorkSizeY are 0 then the opencl implementation
+// will pick the optimal local worksize split for the underlying hardware. This method
+// will not wait for the kernel to finish. The client must manually invoke
+// WaitForKernels() on the target device.
+func (k *Kernel) Exec2DNoWait(offsetX, offsetY, globalWorkSizeX, globalWorkSizeY, localWorkSizeX, localWorkSizeY int) (time.Duration, error) {
+	var errCode cl.ErrorCode
+	var offsetPtr *uint64 = nil
+	var localSizePtr *uint64 = nil
+
+	// Setup work params
+	if offsetX > 0 || offsetY > 0 {
+		k.offsets[0] = uint64(offsetX)
+		k.offsets[1] = uint64(offsetY)
+		offsetPtr = (*uint64)(unsafe.Pointer(&k.offsets[0]))
+	}
+	k.globalWorkSizes[0], k.globalWorkSizes[1] = uint64(globalWorkSizeX), uint64(globalWorkSizeY)
+	if localWorkSizeX != 0 && localWorkSizeY != 0 {
+		k.localWorkSizes[0], k.localWorkSizes[1] = uint64(localWorkSizeX), uint64(localWorkSizeY)
+		localSizePtr = (*uint64)(unsafe.Pointer(&k.localWorkSizes[0]))
+	}
+
+	// Run kernel
+	tick := time.Now()
+	errCode = cl.EnqueueNDRangeKernel(
+		k.device.cmdQueue,
+		k.kernelHandle,
+		2,
+		offsetPtr,
+		(*uint64)(unsafe.Pointer(&k.globalWorkSizes[0])),
+		localSizePtr,
+		0,
+		nil,
+		nil,
+	)
+	if errCode != cl.SUCCESS {
+		return time.Duration(0), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+	}
+
+	return time.Since(tick), nil
+}
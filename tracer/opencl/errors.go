@@ -19,4 +19,5 @@ var (
 	ErrInvalidChangeData      = errors.New("opencl tracer: invalid data type for change")
 	ErrInvalidOption          = errors.New("opencl tracer: invalid tracer option")
 	ErrNoSceneData            = errors.New("opencl tracer: no scene data uploaded")
+	ErrAOVsNotCaptured        = errors.New("opencl tracer: AOV capture is not enabled")
 )
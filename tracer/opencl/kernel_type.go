@@ -8,6 +8,10 @@ type kernelType uint8
 const (
 	// camera kernels
 	generatePrimaryRays kernelType = iota
+	generateThinLensPrimaryRays
+	generateOrthographicPrimaryRays
+	generateEquirectangularPrimaryRays
+	generateFisheyePrimaryRays
 	// intersection kernels
 	rayIntersectionTest
 	rayIntersectionQuery
@@ -19,9 +23,27 @@ const (
 	accumulateEmissiveSamples
 	// hdr kernels
 	tonemapSimpleReinhard
+	tonemapReinhard
+	tonemapACES
+	tonemapUncharted2
+	tonemapLinear
+	// post-process filters
+	denoiseATrous
+	bloomExtract
+	bloomBlur
+	bloomDownsample
+	bloomUpsampleAdd
+	bloomComposite
 	// accumulator
 	clearAccumulator
+	clearAccumulatorBlock
 	aggregateAccumulator
+	scaleAccumulator
+	// aov
+	clearAOVs
+	accumulateAOVs
+	aggregateAOVs
+	scaleAOVs
 	// debugging
 	debugClearBuffer
 	debugRayIntersectionDepth
@@ -38,6 +60,14 @@ func (kt kernelType) String() string {
 	switch kt {
 	case generatePrimaryRays:
 		return "generatePrimaryRays"
+	case generateThinLensPrimaryRays:
+		return "generateThinLensPrimaryRays"
+	case generateOrthographicPrimaryRays:
+		return "generateOrthographicPrimaryRays"
+	case generateEquirectangularPrimaryRays:
+		return "generateEquirectangularPrimaryRays"
+	case generateFisheyePrimaryRays:
+		return "generateFisheyePrimaryRays"
 	case rayIntersectionTest:
 		return "rayIntersectionTest"
 	case rayIntersectionQuery:
@@ -54,10 +84,42 @@ func (kt kernelType) String() string {
 		return "accumulateEmissiveSamples"
 	case tonemapSimpleReinhard:
 		return "tonemapSimpleReinhard"
+	case tonemapReinhard:
+		return "tonemapReinhard"
+	case tonemapACES:
+		return "tonemapACES"
+	case tonemapUncharted2:
+		return "tonemapUncharted2"
+	case tonemapLinear:
+		return "tonemapLinear"
+	case denoiseATrous:
+		return "denoiseATrous"
+	case bloomExtract:
+		return "bloomExtract"
+	case bloomBlur:
+		return "bloomBlur"
+	case bloomDownsample:
+		return "bloomDownsample"
+	case bloomUpsampleAdd:
+		return "bloomUpsampleAdd"
+	case bloomComposite:
+		return "bloomComposite"
 	case clearAccumulator:
 		return "clearAccumulator"
+	case clearAccumulatorBlock:
+		return "clearAccumulatorBlock"
 	case aggregateAccumulator:
 		return "aggregateAccumulator"
+	case scaleAccumulator:
+		return "scaleAccumulator"
+	case clearAOVs:
+		return "clearAOVs"
+	case accumulateAOVs:
+		return "accumulateAOVs"
+	case aggregateAOVs:
+		return "aggregateAOVs"
+	case scaleAOVs:
+		return "scaleAOVs"
 	case debugClearBuffer:
 		return "debugClearBuffer"
 	case debugRayIntersectionDepth:
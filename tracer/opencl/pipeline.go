@@ -4,11 +4,12 @@ import (
 	"fmt"
 	"image"
 	"image/png"
-	"math/rand"
 	"os"
 	"time"
 	"unsafe"
 
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/hdr"
 	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
 	"github.com/go-gl/gl/v2.1/gl"
@@ -55,7 +56,7 @@ type Pipeline struct {
 func DefaultPipeline(debugFlags DebugFlag) *Pipeline {
 	pipeline := &Pipeline{
 		Reset:               ClearAccumulator(),
-		PrimaryRayGenerator: PerspectiveCamera(),
+		PrimaryRayGenerator: SceneCamera(),
 		Integrator:          MonteCarloIntegrator(debugFlags),
 		PostProcess: []PipelineStage{
 			TonemapSimpleReinhard(),
@@ -69,24 +70,185 @@ func DefaultPipeline(debugFlags DebugFlag) *Pipeline {
 	return pipeline
 }
 
-// Clear the frame accumulator buffer.
+// Clear the frame accumulator contents for the rendered block.
 func ClearAccumulator() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 		return tr.resources.ClearFrameAccumulator(blockReq)
 	}
 }
 
+// Use the camera model described by the scene camera type for the primary ray
+// generation stage. Perspective cameras with a non-zero aperture radius use
+// a thin-lens camera. As the model is selected using the camera data supplied
+// to the tracer, tracers that are not configured by the renderer (e.g. remote
+// workers) render the scene using the same camera model.
+func SceneCamera() PipelineStage {
+	perspective := PerspectiveCamera()
+	thinLens := ThinLensCamera()
+	orthographic := OrthographicCamera()
+	equirectangular := EquirectangularCamera()
+	fisheye := FisheyeCamera()
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		switch tr.cameraType {
+		case scene.OrthographicCamera:
+			return orthographic(tr, blockReq)
+		case scene.EquirectangularCamera:
+			return equirectangular(tr, blockReq)
+		case scene.FisheyeCamera:
+			return fisheye(tr, blockReq)
+		}
+
+		if tr.cameraApertureRadius > 0 {
+			return thinLens(tr, blockReq)
+		}
+		return perspective(tr, blockReq)
+	}
+}
+
 // Use a perspective camera for the primary ray generation stage.
 func PerspectiveCamera() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-		return tr.resources.GeneratePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum)
+		elapsed, err := tr.resources.GeneratePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum)
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Use an orthographic camera for the primary ray generation stage. Rays are
+// parallel to the view direction and originate from the camera plane; the
+// height of the view volume is specified by the camera.
+func OrthographicCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		elapsed, err := tr.resources.GenerateOrthographicPrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum, tr.cameraOrthoHeight)
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Use an equirectangular camera for the primary ray generation stage. The
+// frame x axis maps to the full 360 degree range of longitudes around the
+// camera up vector and the y axis maps to the 180 degree range of latitudes.
+// The view direction is located at the frame center.
+func EquirectangularCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		elapsed, err := tr.resources.GenerateEquirectangularPrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum)
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Use an equidistant fisheye camera for the primary ray generation stage.
+// The camera field of view is mapped to the largest circle that fits inside
+// the frame; pixels outside the circle are black.
+func FisheyeCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		elapsed, err := tr.resources.GenerateFisheyePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum, tr.cameraFisheyeFOV)
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+		return elapsed, err
 	}
 }
 
+// Use a thin-lens camera for the primary ray generation stage. Rays originate
+// from random points on the lens aperture and pass through the point where the
+// corresponding pinhole camera ray meets the focal plane. If the camera does
+// not specify a focus distance, the camera focuses on the closest surface at
+// the frame center.
+func ThinLensCamera() PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		var elapsed time.Duration
+		focusDistance := tr.cameraFocusDistance
+		if focusDistance == 0 {
+			if tr.autofocusDistance == 0 {
+				dist, afElapsed, err := tr.autofocus()
+				elapsed += afElapsed
+				if err != nil {
+					tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+					return elapsed, err
+				}
+				tr.autofocusDistance = dist
+			}
+			focusDistance = tr.autofocusDistance
+		}
+
+		genElapsed, err := tr.resources.GenerateThinLensPrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum, tr.cameraApertureRadius, focusDistance)
+		elapsed += genElapsed
+		tr.stats.KernelTimes[tracer.PrimaryRayKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Get the distance along the view direction to the closest surface at the frame
+// center. If the center ray does not hit any geometry, the autofocus fallback
+// distance is returned instead.
+func (tr *Tracer) autofocus() (float32, time.Duration, error) {
+	centerDir := tr.cameraFrustrum[0].Vec3().
+		Add(tr.cameraFrustrum[1].Vec3()).
+		Add(tr.cameraFrustrum[2].Vec3()).
+		Add(tr.cameraFrustrum[3].Vec3()).
+		Mul(0.25)
+
+	dist, elapsed, err := tr.resources.ClosestHitDistance(tr.cameraPosition, centerDir.Normalize())
+	if err != nil || dist == 0 {
+		return scene.AutofocusFallbackDistance, elapsed, err
+	}
+	return dist / centerDir.Len(), elapsed, nil
+}
+
 // Apply simple Reinhard tone-mapping.
 func TonemapSimpleReinhard() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-		return tr.resources.TonemapSimpleReinhard(blockReq)
+		elapsed, err := tr.resources.TonemapSimpleReinhard(tr.postProcessAccumulator(), blockReq)
+		tr.stats.KernelTimes[tracer.TonemapKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Apply tone-mapping using the specified operator and parameters.
+func Tonemap(params tracer.TonemapParams) PipelineStage {
+	params = params.WithDefaults()
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		elapsed, err := tr.resources.Tonemap(tr.postProcessAccumulator(), blockReq, params)
+		tr.stats.KernelTimes[tracer.TonemapKernel] += elapsed
+		return elapsed, err
+	}
+}
+
+// Denoise the frame accumulator using an edge-avoiding à-trous wavelet filter
+// guided by the captured normal, depth and albedo AOVs. The filtered output is
+// written to the filtered accumulator which is used as the input for the
+// following post-process stages.
+func Denoise(params tracer.DenoiseParams) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		if !blockReq.CaptureAOVs {
+			return 0, ErrAOVsNotCaptured
+		}
+
+		elapsed, err := tr.resources.Denoise(tr.postProcessAccumulator(), blockReq, params)
+		tr.stats.KernelTimes[tracer.FilterKernel] += elapsed
+		if err != nil {
+			return elapsed, err
+		}
+
+		tr.filtered = true
+		return elapsed, nil
+	}
+}
+
+// Add a glow around bright pixels. The radiance of pixels whose luminance
+// exceeds the bloom threshold is blurred using a Gaussian pyramid and added to
+// the frame accumulator (or the output of any preceding filter stages). The
+// output is written to the filtered accumulator which is used as the input for
+// the following post-process stages.
+func Bloom(params tracer.BloomParams) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		elapsed, err := tr.resources.Bloom(tr.postProcessAccumulator(), blockReq, params)
+		tr.stats.KernelTimes[tracer.FilterKernel] += elapsed
+		if err != nil {
+			return elapsed, err
+		}
+
+		tr.filtered = true
+		return elapsed, nil
 	}
 }
 
@@ -94,25 +256,42 @@ func TonemapSimpleReinhard() PipelineStage {
 func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 		var err error
+		var elapsed time.Duration
 
 		start := time.Now()
-		numPixels := int(blockReq.FrameW * blockReq.BlockH)
+		kernelTimes := &tr.stats.KernelTimes
+		numPixels := int(blockReq.BlockW * blockReq.BlockH)
 		numEmissives := uint32(len(tr.sceneData.EmissivePrimitives))
 
 		var activeRayBuf uint32 = 0
+		if blockReq.CountRays {
+			tr.stats.PrimaryRays += uint64(readCounter(tr.resources, activeRayBuf))
+		}
 
 		// Intersect primary rays outside of the loop
 		// Use packet query intersector for GPUs as opencl forces CPU
 		// to use a local workgroup size equal to 1
 		if tr.device.Type == device.GpuDevice {
-			_, err = tr.resources.RayPacketIntersectionQuery(activeRayBuf, numPixels)
+			elapsed, err = tr.resources.RayPacketIntersectionQuery(activeRayBuf, numPixels)
+			kernelTimes[tracer.PacketQueryKernel] += elapsed
 		} else {
-			_, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
+			elapsed, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
+			kernelTimes[tracer.IntersectionQueryKernel] += elapsed
 		}
 		if err != nil {
 			return time.Since(start), err
 		}
 
+		// Derive the AOV seed using an index past the last bounce so that
+		// the AOV samples are not correlated with the shaded paths.
+		if blockReq.CaptureAOVs {
+			elapsed, err = tr.resources.AccumulateAOVs(tracer.DeriveSeed(blockReq.Seed, blockReq.NumBounces), activeRayBuf, numPixels)
+			kernelTimes[tracer.AccumulateKernel] += elapsed
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
 		if debugFlags&PrimaryRayIntersectionDepth == PrimaryRayIntersectionDepth {
 			_, err = tr.resources.DebugRayIntersectionDepth(blockReq, activeRayBuf)
 			err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, "debug-primary-intersection-depth.png")
@@ -133,17 +312,19 @@ func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 			// Shade misses
 			if tr.sceneData.SceneDiffuseMatIndex != -1 {
 				if bounce == 0 {
-					_, err = tr.resources.ShadePrimaryRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
+					elapsed, err = tr.resources.ShadePrimaryRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
 				} else {
-					_, err = tr.resources.ShadeIndirectRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
+					elapsed, err = tr.resources.ShadeIndirectRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
 				}
+				kernelTimes[tracer.ShadeMissesKernel] += elapsed
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			// Shade hits
-			_, err = tr.resources.ShadeHits(bounce, blockReq.MinBouncesForRR, rand.Uint32(), numEmissives, activeRayBuf, numPixels)
+			elapsed, err = tr.resources.ShadeHits(bounce, blockReq.MinBouncesForRR, tracer.DeriveSeed(blockReq.Seed, bounce), numEmissives, activeRayBuf, numPixels)
+			kernelTimes[tracer.ShadeHitsKernel] += elapsed
 			if err != nil {
 				return time.Since(start), err
 			}
@@ -156,13 +337,24 @@ func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 				}
 			}
 
+			// Read the number of occlusion and indirect rays generated for
+			// this bounce. Indirect rays are only traced by the next bounce.
+			if blockReq.CountRays {
+				tr.stats.OcclusionRays += uint64(readCounter(tr.resources, 2))
+				if bounce+1 < blockReq.NumBounces {
+					tr.stats.IndirectRays += uint64(readCounter(tr.resources, 1-activeRayBuf))
+				}
+			}
+
 			// Process intersections for occlusion rays and accumulate emissive samples for non occluded paths
-			_, err := tr.resources.RayIntersectionTest(2, numPixels)
+			elapsed, err = tr.resources.RayIntersectionTest(2, numPixels)
+			kernelTimes[tracer.OcclusionTestKernel] += elapsed
 			if err != nil {
 				return time.Since(start), err
 			}
 
-			_, err = tr.resources.AccumulateEmissiveSamples(2, numPixels)
+			elapsed, err = tr.resources.AccumulateEmissiveSamples(2, numPixels)
+			kernelTimes[tracer.AccumulateKernel] += elapsed
 			if err != nil {
 				return time.Since(start), err
 			}
@@ -202,7 +394,8 @@ func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 			// Process intersections for indirect rays
 			if bounce+1 < blockReq.NumBounces {
 				activeRayBuf = 1 - activeRayBuf
-				_, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
+				elapsed, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
+				kernelTimes[tracer.IntersectionQueryKernel] += elapsed
 				if err != nil {
 					return time.Since(start), err
 				}
@@ -223,13 +416,83 @@ func SaveFrameBuffer(imgFile string) PipelineStage {
 		}
 		defer f.Close()
 
-		im := image.NewRGBA(image.Rect(0, 0, int(blockReq.FrameW), int(blockReq.FrameH)))
-		err = tr.resources.buffers.FrameBuffer.ReadData(0, 0, tr.resources.buffers.FrameBuffer.Size(), im.Pix)
+		// Read the framebuffer rows that overlap the block
+		im := image.NewRGBA(image.Rect(0, 0, int(blockReq.BlockW), int(blockReq.BlockH)))
+		rowSize := int(blockReq.BlockW) << 2
+		for y := 0; y < int(blockReq.BlockH); y++ {
+			offset := int(blockReq.FramePixelIndex(uint32(y)*blockReq.BlockW)) << 2
+			err = tr.resources.buffers.FrameBuffer.ReadData(offset, y*im.Stride, rowSize, im.Pix)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		return time.Since(start), png.Encode(f, im)
+	}
+}
+
+// Save a copy of the RGBA framebuffer each time this stage is executed. The
+// output filename is generated by formatting imgFilePattern (e.g. frame-%04d.png)
+// with a frame counter that starts at 0.
+func SaveFrameBufferSequence(imgFilePattern string) PipelineStage {
+	var frame int
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		stage := SaveFrameBuffer(fmt.Sprintf(imgFilePattern, frame))
+		frame++
+		return stage(tr, blockReq)
+	}
+}
+
+// Save a linear copy of the frame accumulator, or the output of any preceding
+// filter stages, normalized by the number of accumulated samples. The HDR image
+// format (pfm, hdr or exr) is selected based on the imgFile extension.
+func SaveHDRFrameBuffer(imgFile string) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		pixels, err := readAccumulatorBlock(tr.postProcessAccumulator(), blockReq)
 		if err != nil {
 			return 0, err
 		}
 
-		return time.Since(start), png.Encode(f, im)
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		for index := range pixels {
+			pixels[index] = pixels[index].Mul(sampleWeight)
+		}
+
+		return time.Since(start), hdr.WriteFile(imgFile, blockReq.BlockW, blockReq.BlockH, pixels)
+	}
+}
+
+// Save a linear copy of the frame accumulator each time this stage is executed.
+// The output filename is generated by formatting imgFilePattern (e.g. frame-%04d.exr)
+// with a frame counter that starts at 0.
+func SaveHDRFrameBufferSequence(imgFilePattern string) PipelineStage {
+	var frame int
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		stage := SaveHDRFrameBuffer(fmt.Sprintf(imgFilePattern, frame))
+		frame++
+		return stage(tr, blockReq)
+	}
+}
+
+// Save an image with the contents of the frame AOV buffer for the given AOV
+// type. The image format is selected based on the imgFile extension.
+func SaveAOV(aov tracer.AOV, imgFile string) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		if !blockReq.CaptureAOVs {
+			return 0, ErrAOVsNotCaptured
+		}
+
+		start := time.Now()
+		samples, err := tr.resources.ReadFrameAOVs(blockReq)
+		if err != nil {
+			return 0, err
+		}
+
+		sampleWeight := 1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel)
+		err = tracer.WriteAOV(imgFile, aov, blockReq.BlockW, blockReq.BlockH, samples, sampleWeight)
+		return time.Since(start), err
 	}
 }
 
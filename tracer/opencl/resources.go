@@ -5,6 +5,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
 	"github.com/achilleasa/polaris/types"
@@ -77,17 +78,27 @@ func (dr *deviceResources) Close() {
 	}
 }
 
-// Clear the frame accumulator.
+// Clear the frame accumulator contents for the block specified by blockReq.
+// Blocks rendered by other tracers are left untouched so that their merged
+// output is preserved.
 func (dr *deviceResources) ClearFrameAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error) {
-	kernel := dr.kernels[clearAccumulator]
+	kernel := dr.kernels[clearAccumulatorBlock]
 	err := kernel.SetArgs(
 		dr.buffers.FrameAccumulator,
+		blockReq.FrameW,
 	)
 	if err != nil {
 		return 0, err
 	}
 
-	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+	return kernel.Exec2D(
+		int(blockReq.BlockX),
+		int(blockReq.BlockY),
+		int(blockReq.BlockW),
+		int(blockReq.BlockH),
+		0,
+		0,
+	)
 }
 
 // Clear the trace accumulator.
@@ -104,23 +115,309 @@ func (dr *deviceResources) ClearTraceAccumulator(blockReq *tracer.BlockRequest)
 }
 
 // Aggregate the trace accumulator contents from another tracer into
-// this tracer's frame accumulator.
-func (dr *deviceResources) AggregateAccumulator(srcAccumulator *device.Buffer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+// this tracer's frame accumulator. If firstSamples is set, the aggregated
+// block replaces the frame accumulator contents.
+func (dr *deviceResources) AggregateAccumulator(srcAccumulator *device.Buffer, blockReq *tracer.BlockRequest, firstSamples bool) (time.Duration, error) {
+	var replace uint32
+	if firstSamples {
+		replace = 1
+	}
+
 	kernel := dr.kernels[aggregateAccumulator]
 	err := kernel.SetArgs(
 		srcAccumulator,
 		dr.buffers.FrameAccumulator,
+		blockReq.FrameW,
+		replace,
 	)
 	if err != nil {
 		return 0, err
 	}
 
 	// Add the contents of block specified by blockReq
-	return kernel.Exec1DNoWait(
-		int(blockReq.FrameW*blockReq.BlockY),
-		int(blockReq.BlockW*blockReq.BlockH),
+	return kernel.Exec2DNoWait(
+		int(blockReq.BlockX),
+		int(blockReq.BlockY),
+		int(blockReq.BlockW),
+		int(blockReq.BlockH),
+		0,
+		0,
+	)
+}
+
+// Scale the frame accumulator and, if AOV capture is enabled, the frame AOV
+// contents for the block specified by blockReq.
+func (dr *deviceResources) ScaleFrameOutput(blockReq *tracer.BlockRequest, scale float32) (time.Duration, error) {
+	kernel := dr.kernels[scaleAccumulator]
+	err := kernel.SetArgs(
+		dr.buffers.FrameAccumulator,
+		blockReq.FrameW,
+		scale,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed, err := kernel.Exec2D(
+		int(blockReq.BlockX),
+		int(blockReq.BlockY),
+		int(blockReq.BlockW),
+		int(blockReq.BlockH),
 		0,
+		0,
+	)
+	if err != nil || !blockReq.CaptureAOVs {
+		return elapsed, err
+	}
+
+	kernel = dr.kernels[scaleAOVs]
+	err = kernel.SetArgs(
+		dr.buffers.FrameAOVs,
+		blockReq.FrameW,
+		scale,
 	)
+	if err != nil {
+		return elapsed, err
+	}
+
+	aovElapsed, err := kernel.Exec2D(
+		int(blockReq.BlockX),
+		int(blockReq.BlockY),
+		int(blockReq.BlockW),
+		int(blockReq.BlockH),
+		0,
+		0,
+	)
+	return elapsed + aovElapsed, err
+}
+
+// Upload a block of trace accumulator samples from host memory and aggregate
+// them into this tracer's frame accumulator.
+func (dr *deviceResources) AggregateHostAccumulator(samples []types.Vec3, blockReq *tracer.BlockRequest, firstSamples bool) (time.Duration, error) {
+	err := writeAccumulatorBlock(dr.buffers.HostAccumulator, samples, blockReq)
+	if err != nil {
+		return 0, err
+	}
+
+	return dr.AggregateAccumulator(dr.buffers.HostAccumulator, blockReq, firstSamples)
+}
+
+// Replace the frame accumulator contents for the block specified by blockReq
+// with a block of samples from host memory.
+func (dr *deviceResources) WriteFrameAccumulator(samples []types.Vec3, blockReq *tracer.BlockRequest) error {
+	return writeAccumulatorBlock(dr.buffers.FrameAccumulator, samples, blockReq)
+}
+
+// Upload a block of accumulator samples from host memory to the block
+// location specified by blockReq.
+func writeAccumulatorBlock(buf *device.Buffer, samples []types.Vec3, blockReq *tracer.BlockRequest) error {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(samples) != numPixels {
+		return fmt.Errorf("device_resources: expected %d accumulator samples; got %d", numPixels, len(samples))
+	}
+
+	// Accumulator samples are stored as float3 which takes the same space as a float4
+	paddedSamples := make([]types.Vec4, numPixels)
+	for index, sample := range samples {
+		paddedSamples[index] = sample.Vec4(0)
+	}
+
+	// Upload each block row to its location in the frame
+	blockW := int(blockReq.BlockW)
+	for y := 0; y < int(blockReq.BlockH); y++ {
+		offset := int(blockReq.FramePixelIndex(uint32(y*blockW))) * sizeofAccumulatorSample
+		err := buf.WriteDataAtOffset(paddedSamples[y*blockW:(y+1)*blockW], offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read the trace accumulator contents for the block specified by blockReq into host memory.
+func (dr *deviceResources) ReadTraceAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	return readAccumulatorBlock(dr.buffers.TraceAccumulator, blockReq)
+}
+
+// Read the frame accumulator contents for the block specified by blockReq into host memory.
+func (dr *deviceResources) ReadFrameAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	return readAccumulatorBlock(dr.buffers.FrameAccumulator, blockReq)
+}
+
+// Read the accumulator buffer contents for the block specified by blockReq
+// into host memory and strip the float4 padding.
+func readAccumulatorBlock(buf *device.Buffer, blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	paddedSamples := make([]types.Vec4, numPixels)
+
+	// Read each block row from its location in the frame
+	blockW := int(blockReq.BlockW)
+	for y := 0; y < int(blockReq.BlockH); y++ {
+		offset := int(blockReq.FramePixelIndex(uint32(y*blockW))) * sizeofAccumulatorSample
+		err := buf.ReadData(offset, y*blockW*sizeofAccumulatorSample, blockW*sizeofAccumulatorSample, paddedSamples)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([]types.Vec3, numPixels)
+	for index, sample := range paddedSamples {
+		samples[index] = sample.Vec3()
+	}
+
+	return samples, nil
+}
+
+// The device layout of an AOV sample.
+type deviceAOVSample struct {
+	albedo       types.Vec4
+	normal       types.Vec4
+	depth        float32
+	meshInstance int32
+	material     int32
+	_padding1    uint32
+}
+
+// Clear the trace AOVs.
+func (dr *deviceResources) ClearTraceAOVs(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	kernel := dr.kernels[clearAOVs]
+	err := kernel.SetArgs(
+		dr.buffers.TraceAOVs,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+}
+
+// Accumulate AOVs for the primary ray intersections.
+func (dr *deviceResources) AccumulateAOVs(randSeed, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[accumulateAOVs]
+	err := kernel.SetArgs(
+		dr.buffers.Rays[rayBufferIndex],
+		dr.buffers.RayCounters[rayBufferIndex],
+		dr.buffers.Paths,
+		dr.buffers.HitFlags,
+		dr.buffers.Intersections,
+		dr.buffers.Vertices,
+		dr.buffers.Normals,
+		dr.buffers.UV,
+		dr.buffers.MaterialIndices,
+		dr.buffers.MaterialNodes,
+		dr.buffers.TextureMetadata,
+		dr.buffers.Textures,
+		randSeed,
+		dr.buffers.TraceAOVs,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Aggregate the trace AOV contents from another tracer into this tracer's
+// frame AOVs. If the block contains the first samples for the frame then
+// the frame AOVs are replaced instead.
+func (dr *deviceResources) AggregateAOVs(srcAOVs *device.Buffer, blockReq *tracer.BlockRequest, firstSamples bool) (time.Duration, error) {
+	var replace uint32
+	if firstSamples {
+		replace = 1
+	}
+
+	kernel := dr.kernels[aggregateAOVs]
+	err := kernel.SetArgs(
+		srcAOVs,
+		dr.buffers.FrameAOVs,
+		blockReq.FrameW,
+		replace,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec2DNoWait(
+		int(blockReq.BlockX),
+		int(blockReq.BlockY),
+		int(blockReq.BlockW),
+		int(blockReq.BlockH),
+		0,
+		0,
+	)
+}
+
+// Upload a block of trace AOVs from host memory and aggregate them into
+// this tracer's frame AOVs.
+func (dr *deviceResources) AggregateHostAOVs(samples []tracer.AOVSample, blockReq *tracer.BlockRequest, firstSamples bool) (time.Duration, error) {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(samples) != numPixels {
+		return 0, fmt.Errorf("device_resources: expected %d AOV samples; got %d", numPixels, len(samples))
+	}
+
+	deviceSamples := make([]deviceAOVSample, numPixels)
+	for index, sample := range samples {
+		deviceSamples[index] = deviceAOVSample{
+			albedo:       sample.Albedo.Vec4(0),
+			normal:       sample.Normal.Vec4(0),
+			depth:        sample.Depth,
+			meshInstance: sample.MeshInstance,
+			material:     sample.Material,
+		}
+	}
+
+	// Upload each block row to its location in the frame
+	blockW := int(blockReq.BlockW)
+	for y := 0; y < int(blockReq.BlockH); y++ {
+		offset := int(blockReq.FramePixelIndex(uint32(y*blockW))) * sizeofAOVSample
+		err := dr.buffers.HostAOVs.WriteDataAtOffset(deviceSamples[y*blockW:(y+1)*blockW], offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return dr.AggregateAOVs(dr.buffers.HostAOVs, blockReq, firstSamples)
+}
+
+// Read the trace AOV contents for the block specified by blockReq into host memory.
+func (dr *deviceResources) ReadTraceAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	return readAOVBlock(dr.buffers.TraceAOVs, blockReq)
+}
+
+// Read the frame AOV contents for the block specified by blockReq into host memory.
+func (dr *deviceResources) ReadFrameAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	return readAOVBlock(dr.buffers.FrameAOVs, blockReq)
+}
+
+// Read the AOV buffer contents for the block specified by blockReq into
+// host memory and convert them from the device layout.
+func readAOVBlock(buf *device.Buffer, blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	deviceSamples := make([]deviceAOVSample, numPixels)
+
+	// Read each block row from its location in the frame
+	blockW := int(blockReq.BlockW)
+	for y := 0; y < int(blockReq.BlockH); y++ {
+		offset := int(blockReq.FramePixelIndex(uint32(y*blockW))) * sizeofAOVSample
+		err := buf.ReadData(offset, y*blockW*sizeofAOVSample, blockW*sizeofAOVSample, deviceSamples)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([]tracer.AOVSample, numPixels)
+	for index, sample := range deviceSamples {
+		samples[index] = tracer.AOVSample{
+			Albedo:       sample.albedo.Vec3(),
+			Normal:       sample.normal.Vec3(),
+			Depth:        sample.depth,
+			MeshInstance: sample.meshInstance,
+			Material:     sample.material,
+		}
+	}
+
+	return samples, nil
 }
 
 // Generate primary rays.
@@ -142,7 +439,51 @@ func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, ca
 		cameraFrustrum[3],
 		cameraEyePos,
 		texelDims,
+		blockReq.BlockX,
+		blockReq.BlockY,
+		blockReq.BlockW,
+		blockReq.BlockH,
+		blockReq.FrameW,
+		blockReq.FrameH,
+		blockReq.Seed,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec2D(0, 0, int(blockReq.BlockW), int(blockReq.BlockH), 0, 0)
+}
+
+// Generate primary rays for a thin-lens camera with the specified aperture
+// radius and focus distance.
+func (dr *deviceResources) GenerateThinLensPrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum scene.Frustrum, apertureRadius, focusDistance float32) (time.Duration, error) {
+	kernel := dr.kernels[generateThinLensPrimaryRays]
+
+	texelDims := types.Vec2{
+		1.0 / float32(blockReq.FrameW),
+		1.0 / float32(blockReq.FrameH),
+	}
+
+	// Calculate the camera axes for positioning samples on the lens
+	_, cameraRight, cameraUp := cameraFrustrum.Axes()
+
+	err := kernel.SetArgs(
+		dr.buffers.Rays[0],
+		dr.buffers.RayCounters[0],
+		dr.buffers.Paths,
+		cameraFrustrum[0],
+		cameraFrustrum[1],
+		cameraFrustrum[2],
+		cameraFrustrum[3],
+		cameraEyePos,
+		cameraRight,
+		cameraUp,
+		apertureRadius,
+		focusDistance,
+		texelDims,
+		blockReq.BlockX,
 		blockReq.BlockY,
+		blockReq.BlockW,
 		blockReq.BlockH,
 		blockReq.FrameW,
 		blockReq.FrameH,
@@ -152,7 +493,106 @@ func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, ca
 		return 0, err
 	}
 
-	return kernel.Exec2D(0, 0, int(blockReq.FrameW), int(blockReq.BlockH), 0, 0)
+	return kernel.Exec2D(0, 0, int(blockReq.BlockW), int(blockReq.BlockH), 0, 0)
+}
+
+// Generate primary rays for an orthographic camera whose view volume has the
+// specified height.
+func (dr *deviceResources) GenerateOrthographicPrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum scene.Frustrum, viewH float32) (time.Duration, error) {
+	viewDims := types.Vec2{
+		viewH * float32(blockReq.FrameW) / float32(blockReq.FrameH),
+		viewH,
+	}
+	return dr.generateCameraPrimaryRays(generateOrthographicPrimaryRays, blockReq, cameraEyePos, cameraFrustrum, viewDims)
+}
+
+// Generate primary rays for an equirectangular camera.
+func (dr *deviceResources) GenerateEquirectangularPrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum scene.Frustrum) (time.Duration, error) {
+	return dr.generateCameraPrimaryRays(generateEquirectangularPrimaryRays, blockReq, cameraEyePos, cameraFrustrum)
+}
+
+// Generate primary rays for a fisheye camera with the specified field of view
+// in degrees.
+func (dr *deviceResources) GenerateFisheyePrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum scene.Frustrum, fov float32) (time.Duration, error) {
+	halfFOV := fov * math.Pi / 360.0
+	aspect := float32(blockReq.FrameW) / float32(blockReq.FrameH)
+	return dr.generateCameraPrimaryRays(generateFisheyePrimaryRays, blockReq, cameraEyePos, cameraFrustrum, halfFOV, aspect)
+}
+
+// Invoke a primary ray generation kernel that expects the camera axes instead
+// of the frustrum corner rays. Any camera-specific kernel arguments are passed
+// after the camera axes.
+func (dr *deviceResources) generateCameraPrimaryRays(kt kernelType, blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum scene.Frustrum, cameraArgs ...interface{}) (time.Duration, error) {
+	kernel := dr.kernels[kt]
+
+	texelDims := types.Vec2{
+		1.0 / float32(blockReq.FrameW),
+		1.0 / float32(blockReq.FrameH),
+	}
+
+	cameraForward, cameraRight, cameraUp := cameraFrustrum.Axes()
+	args := []interface{}{
+		dr.buffers.Rays[0],
+		dr.buffers.RayCounters[0],
+		dr.buffers.Paths,
+		cameraEyePos,
+		cameraForward,
+		cameraRight,
+		cameraUp,
+	}
+	args = append(args, cameraArgs...)
+	args = append(args,
+		texelDims,
+		blockReq.BlockX,
+		blockReq.BlockY,
+		blockReq.BlockW,
+		blockReq.BlockH,
+		blockReq.FrameW,
+		blockReq.FrameH,
+		blockReq.Seed,
+	)
+
+	err := kernel.SetArgs(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec2D(0, 0, int(blockReq.BlockW), int(blockReq.BlockH), 0, 0)
+}
+
+// Trace a single ray from the camera eye position along the given direction
+// and return the distance to the closest intersection. If the ray does not
+// intersect the scene geometry, the returned distance will be 0. This method
+// overwrites the first entry of the primary ray buffer and should be invoked
+// before generating the primary rays for a block.
+func (dr *deviceResources) ClosestHitDistance(eyePos, dir types.Vec3) (float32, time.Duration, error) {
+	err := dr.buffers.Rays[0].WriteDataAtOffset([]types.Vec4{eyePos.Vec4(math.MaxFloat32), dir.Vec4(0)}, 0)
+	if err == nil {
+		err = dr.buffers.RayCounters[0].WriteDataAtOffset([]int32{1}, 0)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	elapsed, err := dr.RayIntersectionQuery(0, 1)
+	if err != nil {
+		return 0, elapsed, err
+	}
+
+	hitFlag := make([]uint32, 1)
+	err = dr.buffers.HitFlags.ReadData(0, 0, sizeofHitFlag, hitFlag)
+	if err != nil || hitFlag[0] == 0 {
+		return 0, elapsed, err
+	}
+
+	// The hit distance is stored in the w component of the first intersection field
+	wuvt := make([]types.Vec4, 1)
+	err = dr.buffers.Intersections.ReadData(0, 0, 16, wuvt)
+	if err != nil {
+		return 0, elapsed, err
+	}
+
+	return wuvt[0][3], elapsed, nil
 }
 
 // Test for ray intersection. This method will update the hit buffer to indicate
@@ -340,13 +780,12 @@ func (dr *deviceResources) AccumulateEmissiveSamples(rayBufferIndex uint32, numP
 	return kernel.Exec1D(0, numPixels, 0)
 }
 
-// Perform tone-mapping using a simple version of Reinhard.
-func (dr *deviceResources) TonemapSimpleReinhard(blockReq *tracer.BlockRequest) (time.Duration, error) {
+// Perform tone-mapping of the accumulator contents using a simple version of Reinhard.
+func (dr *deviceResources) TonemapSimpleReinhard(accumulator *device.Buffer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 	kernel := dr.kernels[tonemapSimpleReinhard]
-	numPixels := int(blockReq.FrameW * blockReq.BlockH)
 	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
 	err := kernel.SetArgs(
-		dr.buffers.FrameAccumulator,
+		accumulator,
 		dr.buffers.Paths,
 		dr.buffers.FrameBuffer,
 		sampleWeight,
@@ -356,7 +795,249 @@ func (dr *deviceResources) TonemapSimpleReinhard(blockReq *tracer.BlockRequest)
 		return 0, err
 	}
 
-	return kernel.Exec1D(0, numPixels, 0)
+	return execBlockKernel(kernel, blockReq)
+}
+
+// Perform tone-mapping of the accumulator contents using the specified operator
+// and parameters.
+func (dr *deviceResources) Tonemap(accumulator *device.Buffer, blockReq *tracer.BlockRequest, params tracer.TonemapParams) (time.Duration, error) {
+	var kernel *device.Kernel
+	switch params.Operator {
+	case tracer.ReinhardTonemap:
+		kernel = dr.kernels[tonemapReinhard]
+	case tracer.ACESTonemap:
+		kernel = dr.kernels[tonemapACES]
+	case tracer.Uncharted2Tonemap:
+		kernel = dr.kernels[tonemapUncharted2]
+	case tracer.LinearTonemap:
+		kernel = dr.kernels[tonemapLinear]
+	default:
+		return 0, tracer.ErrUnknownTonemapOperator
+	}
+
+	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
+	err := kernel.SetArgs(
+		accumulator,
+		dr.buffers.FrameBuffer,
+		sampleWeight,
+		blockReq.Exposure,
+		params.WhitePoint,
+		1.0/params.Gamma,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return execBlockKernel(kernel, blockReq)
+}
+
+// Denoise the accumulator contents for the block specified by blockReq using
+// the edge-avoiding à-trous wavelet filter and store the output in the filtered
+// accumulator. The filter iterations alternate between the filtered accumulator
+// and the filter scratch buffer so that the last iteration writes its output
+// to the filtered accumulator.
+func (dr *deviceResources) Denoise(accumulator *device.Buffer, blockReq *tracer.BlockRequest, params tracer.DenoiseParams) (time.Duration, error) {
+	var total time.Duration
+	filtered, scratch := dr.buffers.FilteredAccumulator, dr.buffers.FilterScratch
+	accumulatorSize := int(blockReq.FrameW*blockReq.FrameH) * sizeofAccumulatorSample
+
+	// Make sure that the input is not overwritten by the first iteration
+	if params.Iterations%2 == 1 && accumulator == filtered {
+		err := scratch.CopyDataFrom(filtered, 0, 0, accumulatorSize)
+		if err != nil {
+			return 0, err
+		}
+		accumulator = scratch
+	} else if params.Iterations == 0 && accumulator != filtered {
+		return 0, filtered.CopyDataFrom(accumulator, 0, 0, accumulatorSize)
+	}
+
+	kernel := dr.kernels[denoiseATrous]
+	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
+	colorSigma := params.ColorSigma
+	src := accumulator
+	for iteration := uint32(0); iteration < params.Iterations; iteration++ {
+		dst := filtered
+		if (params.Iterations-iteration)%2 == 0 {
+			dst = scratch
+		}
+
+		err := kernel.SetArgs(
+			src,
+			dst,
+			dr.buffers.FrameAOVs,
+			blockReq.FrameW,
+			blockReq.BlockX,
+			blockReq.BlockY,
+			blockReq.BlockW,
+			blockReq.BlockH,
+			int32(1<<iteration),
+			sampleWeight,
+			colorSigma,
+			params.NormalSigma,
+			params.DepthSigma,
+			params.AlbedoSigma,
+		)
+		if err != nil {
+			return total, err
+		}
+
+		elapsed, err := kernel.Exec2D(
+			int(blockReq.BlockX),
+			int(blockReq.BlockY),
+			int(blockReq.BlockW),
+			int(blockReq.BlockH),
+			0,
+			0,
+		)
+		total += elapsed
+		if err != nil {
+			return total, err
+		}
+
+		src = dst
+		colorSigma *= 0.5
+	}
+
+	return total, nil
+}
+
+// Add a glow around the bright pixels of the accumulator block specified by
+// blockReq and store the output in the filtered accumulator. The bloom pyramid
+// levels are blurred using the filter scratch buffer.
+func (dr *deviceResources) Bloom(accumulator *device.Buffer, blockReq *tracer.BlockRequest, params tracer.BloomParams) (time.Duration, error) {
+	var total time.Duration
+	pyramid, scratch := dr.buffers.BloomPyramid, dr.buffers.FilterScratch
+	numLevels := params.Levels(blockReq.BlockW, blockReq.BlockH)
+	levelOffsets := make([]uint32, numLevels)
+	for level := uint32(1); level < numLevels; level++ {
+		w, h := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, level-1)
+		levelOffsets[level] = levelOffsets[level-1] + w*h
+	}
+
+	// Extract the radiance in excess of the luminance threshold
+	kernel := dr.kernels[bloomExtract]
+	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
+	err := kernel.SetArgs(
+		accumulator,
+		pyramid,
+		blockReq.FrameW,
+		blockReq.BlockX,
+		blockReq.BlockY,
+		blockReq.BlockW,
+		sampleWeight,
+		params.Threshold,
+	)
+	if err != nil {
+		return 0, err
+	}
+	elapsed, err := kernel.Exec1D(0, int(blockReq.BlockW*blockReq.BlockH), 0)
+	total += elapsed
+	if err != nil {
+		return total, err
+	}
+
+	// Blur each level and downsample it to generate the next level
+	for level := uint32(0); level < numLevels; level++ {
+		w, h := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, level)
+		if level > 0 {
+			srcW, srcH := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, level-1)
+			kernel = dr.kernels[bloomDownsample]
+			err = kernel.SetArgs(pyramid, levelOffsets[level-1], int32(srcW), int32(srcH), levelOffsets[level], int32(w))
+			if err != nil {
+				return total, err
+			}
+			elapsed, err = kernel.Exec1D(0, int(w*h), 0)
+			total += elapsed
+			if err != nil {
+				return total, err
+			}
+		}
+
+		kernel = dr.kernels[bloomBlur]
+		for _, pass := range []struct {
+			src, dst             *device.Buffer
+			srcOffset, dstOffset uint32
+			dx, dy               int32
+		}{
+			{pyramid, scratch, levelOffsets[level], 0, 1, 0},
+			{scratch, pyramid, 0, levelOffsets[level], 0, 1},
+		} {
+			err = kernel.SetArgs(pass.src, pass.srcOffset, pass.dst, pass.dstOffset, int32(w), int32(h), pass.dx, pass.dy)
+			if err != nil {
+				return total, err
+			}
+			elapsed, err = kernel.Exec1D(0, int(w*h), 0)
+			total += elapsed
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+
+	// Sum the blurred levels starting from the coarsest one
+	kernel = dr.kernels[bloomUpsampleAdd]
+	for level := numLevels - 1; level > 0; level-- {
+		srcW, srcH := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, level)
+		w, h := tracer.BloomLevelSize(blockReq.BlockW, blockReq.BlockH, level-1)
+		err = kernel.SetArgs(pyramid, levelOffsets[level], int32(srcW), int32(srcH), levelOffsets[level-1], int32(w))
+		if err != nil {
+			return total, err
+		}
+		elapsed, err = kernel.Exec1D(0, int(w*h), 0)
+		total += elapsed
+		if err != nil {
+			return total, err
+		}
+	}
+
+	// Add the average of the blurred levels using the same scale as the accumulator
+	kernel = dr.kernels[bloomComposite]
+	err = kernel.SetArgs(
+		accumulator,
+		dr.buffers.FilteredAccumulator,
+		pyramid,
+		blockReq.FrameW,
+		blockReq.BlockX,
+		blockReq.BlockY,
+		blockReq.BlockW,
+		params.Intensity/(float32(numLevels)*sampleWeight),
+	)
+	if err != nil {
+		return total, err
+	}
+	elapsed, err = kernel.Exec1D(0, int(blockReq.BlockW*blockReq.BlockH), 0)
+	return total + elapsed, err
+}
+
+// Get the number of pixels in a bloom pyramid with levels down to 1x1 pixel.
+func bloomPyramidSize(w, h uint32) uint32 {
+	size := w * h
+	for w > 1 || h > 1 {
+		w, h = (w+1)>>1, (h+1)>>1
+		size += w * h
+	}
+	return size
+}
+
+// Execute a kernel that processes each frame pixel covered by a block request.
+func execBlockKernel(kernel *device.Kernel, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	// Blocks spanning the full frame width can be processed with a
+	// single kernel invocation; otherwise process each block row.
+	if blockReq.BlockW == blockReq.FrameW {
+		return kernel.Exec1D(int(blockReq.FramePixelIndex(0)), int(blockReq.FrameW*blockReq.BlockH), 0)
+	}
+
+	var total time.Duration
+	for y := uint32(0); y < blockReq.BlockH; y++ {
+		elapsed, err := kernel.Exec1D(int(blockReq.FramePixelIndex(y*blockReq.BlockW)), int(blockReq.BlockW), 0)
+		total += elapsed
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
 }
 
 // Clear debug buffer
@@ -401,7 +1082,7 @@ func (dr *deviceResources) DebugRayIntersectionDepth(blockReq *tracer.BlockReque
 	}
 
 	kernel := dr.kernels[debugRayIntersectionDepth]
-	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
 
 	err = kernel.SetArgs(
 		dr.buffers.RayCounters[activeRayBuf],
@@ -426,7 +1107,7 @@ func (dr *deviceResources) DebugRayIntersectionNormals(blockReq *tracer.BlockReq
 	}
 
 	kernel := dr.kernels[debugRayIntersectionNormals]
-	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
 
 	err = kernel.SetArgs(
 		dr.buffers.Rays[activeRayBuf],
@@ -458,7 +1139,7 @@ func (dr *deviceResources) DebugEmissiveSamples(blockReq *tracer.BlockRequest, m
 	}
 
 	kernel := dr.kernels[debugEmissiveSamples]
-	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
 
 	err = kernel.SetArgs(
 		dr.buffers.Rays[2],
@@ -485,7 +1166,7 @@ func (dr *deviceResources) DebugThroughput(blockReq *tracer.BlockRequest) (time.
 	}
 
 	kernel := dr.kernels[debugThroughput]
-	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
 
 	err = kernel.SetArgs(
 		dr.buffers.Paths,
@@ -2,7 +2,6 @@ package opencl
 
 import (
 	"fmt"
-	"math/rand"
 	"path"
 	"runtime"
 	"sync"
@@ -46,12 +45,25 @@ type Tracer struct {
 	// The tracer rendering pipeline.
 	pipeline *Pipeline
 
+	// Set by post-process filter stages once they have written their output
+	// to the filtered accumulator.
+	filtered bool
+
 	// The uploaded optimized scene data.
 	sceneData *scene.Scene
 
 	// Camera attributes
-	cameraPosition types.Vec3
-	cameraFrustrum scene.Frustrum
+	cameraPosition       types.Vec3
+	cameraFrustrum       scene.Frustrum
+	cameraType           scene.CameraType
+	cameraApertureRadius float32
+	cameraFocusDistance  float32
+	cameraOrthoHeight    float32
+	cameraFisheyeFOV     float32
+
+	// The focus distance calculated by thin-lens cameras when autofocus
+	// is enabled; it is reset whenever the camera or scene changes.
+	autofocusDistance float32
 }
 
 // Create a new opencl tracer.
@@ -173,10 +185,17 @@ func (tr *Tracer) commitChanges() (time.Duration, error) {
 		case tracer.SceneData:
 			tr.sceneData = data.(*scene.Scene)
 			err = tr.resources.buffers.UploadSceneData(tr.sceneData)
+			tr.autofocusDistance = 0
 		case tracer.CameraData:
 			camera := data.(*scene.Camera)
 			tr.cameraPosition = camera.Position
 			tr.cameraFrustrum = camera.Frustrum
+			tr.cameraType = camera.Type
+			tr.cameraApertureRadius = camera.ApertureRadius
+			tr.cameraFocusDistance = camera.FocusDistance
+			tr.cameraOrthoHeight = camera.OrthoHeight
+			tr.cameraFisheyeFOV = camera.FisheyeFOV
+			tr.autofocusDistance = 0
 		default:
 			err = fmt.Errorf("unsupported change type %d", changeType)
 		}
@@ -195,7 +214,7 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 	var err error
 	start := time.Now()
 
-	_, err = tr.commitChanges()
+	tr.stats.UpdateTime, err = tr.commitChanges()
 	if err != nil {
 		return time.Since(start), err
 	}
@@ -217,13 +236,31 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 		return time.Since(start), err
 	}
 
+	if blockReq.CaptureAOVs {
+		_, err = tr.resources.ClearTraceAOVs(blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	// Derive the seed for each sample from the frame seed and the sample
+	// index so the output does not depend on the tracer that renders the block.
+	frameSeed := blockReq.Seed
+
+	// Reset the per-block stage timings and ray counters
+	tr.stats.PrimaryRayTime, tr.stats.IntegratorTime = 0, 0
+	tr.stats.PrimaryRays, tr.stats.IndirectRays, tr.stats.OcclusionRays = 0, 0, 0
+	tr.stats.KernelTimes = tracer.KernelTimes{}
+
 	var sample uint32
+	var elapsed time.Duration
 	for sample = 0; sample < blockReq.SamplesPerPixel; sample++ {
-		blockReq.Seed = rand.Uint32()
+		blockReq.Seed = tracer.DeriveSeed(frameSeed, blockReq.AccumulatedSamples)
 
 		// Generate primary rays
 		if tr.pipeline.PrimaryRayGenerator != nil {
-			_, err = tr.pipeline.PrimaryRayGenerator(tr, blockReq)
+			elapsed, err = tr.pipeline.PrimaryRayGenerator(tr, blockReq)
+			tr.stats.PrimaryRayTime += elapsed
 			if err != nil {
 				return time.Since(start), err
 			}
@@ -231,15 +268,23 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 
 		// Run integrator
 		if tr.pipeline.Integrator != nil {
-			_, err = tr.pipeline.Integrator(tr, blockReq)
+			elapsed, err = tr.pipeline.Integrator(tr, blockReq)
+			tr.stats.IntegratorTime += elapsed
 			if err != nil {
 				return time.Since(start), err
 			}
 		}
 
 		blockReq.AccumulatedSamples++
+
+		// If the request was cancelled, stop after the current sample.
+		if sample+1 < blockReq.SamplesPerPixel && blockReq.Cancelled() {
+			sample++
+			break
+		}
 	}
 
+	tr.stats.RenderedSamples = sample
 	tr.stats.BlockW = blockReq.BlockW
 	tr.stats.BlockH = blockReq.BlockH
 	tr.stats.RenderTime = time.Since(start)
@@ -265,6 +310,7 @@ func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration,
 		return time.Since(start), nil
 	}
 
+	tr.filtered = false
 	for _, stage := range tr.pipeline.PostProcess {
 		_, err = stage(tr, blockReq)
 		if err != nil {
@@ -275,12 +321,87 @@ func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration,
 	return time.Since(start), nil
 }
 
+// Get the accumulator that should be used as the input for post-process
+// stages. This is the filtered accumulator if a filter stage has been applied
+// while syncing the frame buffer; otherwise it is the frame accumulator.
+func (tr *Tracer) postProcessAccumulator() *device.Buffer {
+	if tr.filtered {
+		return tr.resources.buffers.FilteredAccumulator
+	}
+	return tr.resources.buffers.FrameAccumulator
+}
+
 // Merge accumulator output from another tracer into this tracer's buffer.
 func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-	src, isClTracer := other.(*Tracer)
-	if !isClTracer {
-		return 0, fmt.Errorf("merge failed: unsupported tracer instance")
+	elapsed, err := tr.mergeOutput(other, blockReq)
+	other.Stats().KernelTimes[tracer.MergeKernel] += elapsed
+	return elapsed, err
+}
+
+// Aggregate the accumulator and AOV output from another tracer. Tracers that
+// share our context are merged asynchronously so the returned time only
+// includes the time for enqueuing the aggregation kernels.
+func (tr *Tracer) mergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+	firstSamples := blockReq.AccumulatedSamples == other.Stats().RenderedSamples
+	switch src := other.(type) {
+	case *Tracer:
+		_, err := tr.resources.AggregateAccumulator(src.resources.buffers.TraceAccumulator, blockReq, firstSamples)
+		if err == nil && blockReq.CaptureAOVs {
+			_, err = tr.resources.AggregateAOVs(src.resources.buffers.TraceAOVs, blockReq, firstSamples)
+		}
+		return time.Since(start), err
+	case tracer.AccumulatorReader:
+		// Tracers that do not share our context need to export their
+		// output to host memory so we can upload it to the device.
+		samples, err := src.ReadAccumulator(blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = tr.resources.AggregateHostAccumulator(samples, blockReq, firstSamples)
+		if err != nil || !blockReq.CaptureAOVs {
+			return time.Since(start), err
+		}
+
+		aovReader, isReader := other.(tracer.AOVReader)
+		if !isReader {
+			return time.Since(start), fmt.Errorf("merge failed: tracer does not support AOV capture")
+		}
+
+		aovs, err := aovReader.ReadAOVs(blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = tr.resources.AggregateHostAOVs(aovs, blockReq, firstSamples)
+		return time.Since(start), err
 	}
 
-	return tr.resources.AggregateAccumulator(src.resources.buffers.TraceAccumulator, blockReq)
+	return 0, fmt.Errorf("merge failed: unsupported tracer instance")
+}
+
+// Read the trace accumulator contents for the block specified by the block request.
+func (tr *Tracer) ReadAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	return tr.resources.ReadTraceAccumulator(blockReq)
+}
+
+// Read the trace AOV contents for the block specified by the block request.
+func (tr *Tracer) ReadAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	return tr.resources.ReadTraceAOVs(blockReq)
+}
+
+// Read the frame accumulator contents for the block specified by the block request.
+func (tr *Tracer) ReadFrameAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	return tr.resources.ReadFrameAccumulator(blockReq)
+}
+
+// Replace the frame accumulator contents for the block specified by the block request.
+func (tr *Tracer) WriteFrameAccumulator(blockReq *tracer.BlockRequest, samples []types.Vec3) error {
+	return tr.resources.WriteFrameAccumulator(samples, blockReq)
+}
+
+// Scale the frame accumulator and AOV contents for the block specified by the block request.
+func (tr *Tracer) ScaleFrameOutput(blockReq *tracer.BlockRequest, scale float32) (time.Duration, error) {
+	return tr.resources.ScaleFrameOutput(blockReq, scale)
 }
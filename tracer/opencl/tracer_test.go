@@ -0,0 +1,222 @@
+package opencl
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestMergeTilesIntoFrameAccumulator(t *testing.T) {
+	primary := openclTestTracer(t)
+	defer primary.Close()
+
+	other, err := cpu.NewTracer("cpu", 1, cpu.DefaultPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	var frameW, frameH uint32 = 8, 8
+	sc := environmentLitScene()
+	for _, tr := range []tracer.Tracer{primary, other} {
+		tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{frameW, frameH})
+		tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, sc.Camera)
+	}
+
+	// Each tracer renders more than one tile per frame; tiles rendered
+	// later must not wipe the tiles that were already merged.
+	tiles := []struct {
+		tr   tracer.Tracer
+		tile tracer.Tile
+	}{
+		{primary, tracer.Tile{X: 0, Y: 0, W: 4, H: 4}},
+		{other, tracer.Tile{X: 4, Y: 0, W: 4, H: 4}},
+		{primary, tracer.Tile{X: 0, Y: 4, W: 4, H: 4}},
+		{other, tracer.Tile{X: 4, Y: 4, W: 4, H: 4}},
+	}
+
+	var frames [2][]types.Vec3
+	for frameIndex := range frames {
+		for _, spec := range tiles {
+			blockReq := &tracer.BlockRequest{
+				FrameW:          frameW,
+				FrameH:          frameH,
+				BlockX:          spec.tile.X,
+				BlockY:          spec.tile.Y,
+				BlockW:          spec.tile.W,
+				BlockH:          spec.tile.H,
+				SamplesPerPixel: 2,
+				NumBounces:      2,
+				MinBouncesForRR: 3,
+				Seed:            42,
+			}
+			_, err = spec.tr.Trace(blockReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = primary.MergeOutput(spec.tr, blockReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		frames[frameIndex], err = primary.(*Tracer).resources.ReadFrameAccumulator(&tracer.BlockRequest{FrameW: frameW, FrameH: frameH, BlockW: frameW, BlockH: frameH})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for pixelIndex, sample := range frames[0] {
+		if sample.MaxComponent() <= 0 {
+			t.Fatalf("expected pixel (%d, %d) to contain merged samples; got %v", uint32(pixelIndex)%frameW, uint32(pixelIndex)/frameW, sample)
+		}
+	}
+
+	// Restarting the frame should replace rather than add to the previous samples
+	for pixelIndex := range frames[1] {
+		if !types.ApproxEqual(frames[1][pixelIndex], frames[0][pixelIndex], 1e-4) {
+			t.Fatalf("expected pixel (%d, %d) to be %v after restarting the frame; got %v", uint32(pixelIndex)%frameW, uint32(pixelIndex)/frameW, frames[0][pixelIndex], frames[1][pixelIndex])
+		}
+	}
+}
+
+func TestTraceIsDeterministic(t *testing.T) {
+	tr := openclTestTracer(t)
+	defer tr.Close()
+
+	var frameW, frameH uint32 = 8, 8
+	sc := environmentLitScene()
+	tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{frameW, frameH})
+	tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+	tr.UpdateState(tracer.Asynchronous, tracer.CameraData, sc.Camera)
+
+	trace := func(seed uint32, tiles []tracer.Tile) []types.Vec3 {
+		for _, tile := range tiles {
+			blockReq := &tracer.BlockRequest{
+				FrameW:          frameW,
+				FrameH:          frameH,
+				BlockX:          tile.X,
+				BlockY:          tile.Y,
+				BlockW:          tile.W,
+				BlockH:          tile.H,
+				SamplesPerPixel: 4,
+				NumBounces:      3,
+				MinBouncesForRR: 1,
+				Seed:            seed,
+				CaptureAOVs:     true,
+			}
+			_, err := tr.Trace(blockReq)
+			if err == nil {
+				_, err = tr.MergeOutput(tr, blockReq)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		samples, err := tr.(*Tracer).resources.ReadFrameAccumulator(&tracer.BlockRequest{FrameW: frameW, FrameH: frameH, BlockW: frameW, BlockH: frameH})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return samples
+	}
+
+	frame := []tracer.Tile{{W: frameW, H: frameH}}
+	expSamples := trace(42, frame)
+
+	specs := []struct {
+		seed     uint32
+		tiles    []tracer.Tile
+		expEqual bool
+	}{
+		{42, frame, true},
+		{42, []tracer.Tile{{W: 8, H: 3}, {Y: 3, W: 3, H: 5}, {X: 3, Y: 3, W: 5, H: 5}}, true},
+		{42, []tracer.Tile{{W: 4, H: 4}, {X: 4, W: 4, H: 4}, {Y: 4, W: 4, H: 4}, {X: 4, Y: 4, W: 4, H: 4}}, true},
+		{43, frame, false},
+	}
+
+	for specIndex, spec := range specs {
+		samples := trace(spec.seed, spec.tiles)
+
+		equal := true
+		for pixelIndex := range samples {
+			if samples[pixelIndex] != expSamples[pixelIndex] {
+				equal = false
+				break
+			}
+		}
+
+		if equal != spec.expEqual {
+			t.Errorf("[spec %d] expected traced samples to match the reference frame: %t; got %t", specIndex, spec.expEqual, equal)
+		}
+	}
+}
+
+// Create and initialize an opencl tracer using the CPU device.
+func openclTestTracer(t *testing.T) tracer.Tracer {
+	devList, err := device.SelectDevices(device.CpuDevice, "CPU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devList) != 1 {
+		t.Fatalf("expected to get 1 CPU opencl device; got %d; check that openCL drivers are installed", len(devList))
+	}
+
+	ctx, err := device.NewSharedContext(devList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTracer("opencl", devList[0], ctx, DefaultPipeline(NoDebug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tr.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// A diffuse triangle lit by an environment light so that every traced path
+// collects some radiance.
+func environmentLitScene() *scene.Scene {
+	return &scene.Scene{
+		BvhNodeList: []scene.BvhNode{
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 0},
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 1},
+		},
+		MeshInstanceList: []scene.MeshInstance{
+			{MeshIndex: 0, BvhRoot: 1, Transform: types.Ident4()},
+		},
+		VertexList:    []types.Vec4{{-1, -1, -1, 1}, {1, -1, -1, 1}, {0, 1, -1, 1}},
+		NormalList:    []types.Vec4{{0, 0, 1, 0}, {0, 0, 1, 0}, {0, 0, 1, 0}},
+		UvList:        []types.Vec2{{0, 0}, {1, 0}, {0, 1}},
+		MaterialIndex: []uint32{0},
+		MaterialNodeList: []scene.MaterialNode{
+			{Union1: [4]int32{int32(material.BxdfDiffuse), 0, 0, -1}, Union2: types.Vec4{0.5, 0.25, 1, 0}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.BxdfEmissive), 0, 0, -1}, Union2: types.Vec4{1, 1, 1, 0}, Union4: types.Vec3{0, 0, 1}, Union5: [1]int32{-1}},
+		},
+		EmissivePrimitives:    []scene.EmissivePrimitive{{Type: scene.EnvironmentLight, MaterialNodeIndex: 1}},
+		SceneDiffuseMatIndex:  1,
+		SceneEmissiveMatIndex: 1,
+		Camera: &scene.Camera{
+			Frustrum: scene.Frustrum{
+				{-1, 1, -1, 0},
+				{1, 1, -1, 0},
+				{-1, -1, -1, 0},
+				{1, -1, -1, 0},
+			},
+		},
+	}
+}
@@ -0,0 +1,15 @@
+package remote
+
+import "errors"
+
+var (
+	ErrNotConnected          = errors.New("remote tracer: not connected to worker")
+	ErrTimeout               = errors.New("remote tracer: timed out waiting for worker response")
+	ErrUnsupportedChangeType = errors.New("remote tracer: unsupported change type")
+	ErrInvalidChangeData     = errors.New("remote tracer: invalid data type for change")
+	ErrUnsupportedRequest    = errors.New("remote tracer: unsupported request type")
+	ErrMergeNotSupported     = errors.New("remote tracer: merging output from other tracers is not supported")
+	ErrSyncNotSupported      = errors.New("remote tracer: remote tracers cannot sync the frame buffer")
+	ErrNoAccumulatorReader   = errors.New("remote tracer: worker tracer cannot export its accumulator contents")
+	ErrNoAOVReader           = errors.New("remote tracer: worker tracer cannot export its AOVs")
+)
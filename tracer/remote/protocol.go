@@ -0,0 +1,71 @@
+package remote
+
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The protocol used for communicating with remote workers. Messages are
+// gob-encoded and exchanged over a TCP connection. Once a connection is
+// established, the worker sends a handshake message describing the tracer
+// that serves the connection. After that, the client sends requests and the
+// worker replies to each one with a single response message.
+
+type requestType uint8
+
+// Supported request types.
+const (
+	updateStateRequest requestType = iota
+	traceRequest
+)
+
+// The handshake message sent by the worker when a client connects.
+type handshake struct {
+	// The worker tracer id, flags and estimated speed.
+	Id    string
+	Flags tracer.Flag
+	Speed uint32
+
+	// An error that occurred while initializing the worker tracer.
+	Error string
+}
+
+// A request sent to the worker.
+type request struct {
+	Type requestType
+
+	// State change payload. Depending on the change type only one of
+	// the following fields is populated. The scene data is only sent
+	// when the scene changes.
+	ChangeType tracer.ChangeType
+	FrameDims  [2]uint32
+	Scene      *scene.Scene
+	Camera     *scene.Camera
+
+	// Trace request payload.
+	BlockReq tracer.BlockRequest
+}
+
+// A response sent by the worker.
+type response struct {
+	// An error that occurred while processing the request.
+	Error string
+
+	// The time it took the worker to process the request.
+	Duration time.Duration
+
+	// The updated block request and the trace accumulator contents
+	// for the block after processing a trace request.
+	BlockReq    tracer.BlockRequest
+	Accumulator []types.Vec3
+
+	// The trace AOVs for the block. Only populated if the block request
+	// enables AOV capture.
+	AOVs []tracer.AOVSample
+
+	// The worker tracer statistics after processing a trace request.
+	Stats tracer.Stats
+}
@@ -0,0 +1,171 @@
+package remote
+
+import (
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// A function that creates and initializes a local tracer for serving a
+// worker connection.
+type TracerFactory func() (tracer.Tracer, error)
+
+// A server that exposes local tracers to remote clients. Each client
+// connection is served by a separate tracer instance.
+type Server struct {
+	logger log.Logger
+
+	listener  net.Listener
+	newTracer TracerFactory
+
+	// A wait group for tracking active connections.
+	connGroup sync.WaitGroup
+}
+
+// Create a new server listening for worker connections at addr.
+func NewServer(addr string, newTracer TracerFactory) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		logger:    log.New("remote worker"),
+		listener:  listener,
+		newTracer: newTracer,
+	}, nil
+}
+
+// Get the address that the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Accept and serve client connections until the server is closed.
+func (s *Server) Serve() error {
+	s.logger.Noticef("listening for connections at %s", s.listener.Addr())
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		s.connGroup.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Stop accepting new connections and wait for active connections to terminate.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.connGroup.Wait()
+}
+
+// Serve requests received from a client connection using a new tracer instance.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.connGroup.Done()
+	}()
+
+	remoteAddr := conn.RemoteAddr().String()
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+
+	tr, err := s.newTracer()
+	if err != nil {
+		s.logger.Warningf("could not create tracer for client %s: %v", remoteAddr, err)
+		enc.Encode(&handshake{Error: err.Error()})
+		return
+	}
+	defer tr.Close()
+
+	err = enc.Encode(&handshake{
+		Id:    tr.Id(),
+		Flags: tr.Flags(),
+		Speed: tr.Speed(),
+	})
+	if err != nil {
+		s.logger.Warningf("could not send handshake to client %s: %v", remoteAddr, err)
+		return
+	}
+	s.logger.Noticef("serving client %s using tracer %q", remoteAddr, tr.Id())
+
+	for {
+		// Decoding into a reused value would retain fields omitted by
+		// gob so we always decode into a new request.
+		req := &request{}
+		err = dec.Decode(req)
+		if err != nil {
+			s.logger.Noticef("client %s disconnected", remoteAddr)
+			return
+		}
+
+		res := s.process(tr, req)
+		err = enc.Encode(res)
+		if err != nil {
+			s.logger.Warningf("could not send response to client %s: %v", remoteAddr, err)
+			return
+		}
+	}
+}
+
+// Process a client request using the supplied tracer.
+func (s *Server) process(tr tracer.Tracer, req *request) *response {
+	var err error
+	start := time.Now()
+	res := &response{}
+
+	switch req.Type {
+	case updateStateRequest:
+		var data interface{}
+		switch req.ChangeType {
+		case tracer.FrameDimensions:
+			data = req.FrameDims
+		case tracer.SceneData:
+			data = req.Scene
+		case tracer.CameraData:
+			data = req.Camera
+		default:
+			err = ErrUnsupportedChangeType
+		}
+
+		if err == nil {
+			_, err = tr.UpdateState(tracer.Synchronous, req.ChangeType, data)
+		}
+	case traceRequest:
+		reader, isReader := tr.(tracer.AccumulatorReader)
+		if !isReader {
+			err = ErrNoAccumulatorReader
+			break
+		}
+
+		_, err = tr.Trace(&req.BlockReq)
+		if err == nil {
+			res.BlockReq = req.BlockReq
+			res.Stats = *tr.Stats()
+			res.Accumulator, err = reader.ReadAccumulator(&req.BlockReq)
+		}
+		if err == nil && req.BlockReq.CaptureAOVs {
+			aovReader, isAOVReader := tr.(tracer.AOVReader)
+			if !isAOVReader {
+				err = ErrNoAOVReader
+				break
+			}
+			res.AOVs, err = aovReader.ReadAOVs(&req.BlockReq)
+		}
+	default:
+		err = ErrUnsupportedRequest
+	}
+
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Duration = time.Since(start)
+	return res
+}
@@ -0,0 +1,334 @@
+package remote
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	// The timeout for establishing a connection to a remote worker.
+	dialTimeout = 10 * time.Second
+
+	// The default timeout for receiving a response to a worker request.
+	DefaultRequestTimeout = 1 * time.Minute
+
+	// The additional time allowed to the worker for each sample (block
+	// pixels * samples per pixel) that it needs to trace.
+	traceTimeoutPerSample = 10 * time.Microsecond
+)
+
+// A tracer that forwards requests to a remote worker.
+type Tracer struct {
+	logger log.Logger
+
+	sync.Mutex
+
+	// The tracer id.
+	id string
+
+	// The worker address.
+	addr string
+
+	// The base timeout for worker requests. Trace requests are allowed
+	// additional time depending on the requested number of samples.
+	timeout time.Duration
+
+	// The connection to the worker and the gob encoder/decoder pair
+	// used for exchanging messages.
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+
+	// The flags and speed estimate reported by the worker.
+	remoteFlags tracer.Flag
+	speed       uint32
+
+	// A buffer for asynchronous updates. Updates are grouped by type and
+	// latest updates always overwrite the previous ones.
+	changeBuffer map[tracer.ChangeType]interface{}
+
+	// Statistics for last rendered frame.
+	stats *tracer.Stats
+
+	// The trace accumulator contents for the last processed block.
+	accumulator []types.Vec3
+
+	// The trace AOVs for the last processed block.
+	aovs []tracer.AOVSample
+}
+
+// Create a new tracer that connects to the worker listening at addr. If
+// timeout is zero, DefaultRequestTimeout will be used as the base timeout
+// for worker requests.
+func NewTracer(id string, addr string, timeout time.Duration) (tracer.Tracer, error) {
+	if timeout == 0 {
+		timeout = DefaultRequestTimeout
+	}
+
+	tr := &Tracer{
+		logger:       log.New(fmt.Sprintf("remote tracer (%s)", addr)),
+		id:           id,
+		addr:         addr,
+		timeout:      timeout,
+		changeBuffer: make(map[tracer.ChangeType]interface{}, 0),
+		stats:        &tracer.Stats{},
+	}
+
+	return tr, nil
+}
+
+// Get tracer id.
+func (tr *Tracer) Id() string {
+	return tr.id
+}
+
+// Get tracer flags.
+func (tr *Tracer) Flags() tracer.Flag {
+	return tracer.Remote | (tr.remoteFlags & tracer.CpuDevice)
+}
+
+// Get the computation speed estimate (in GFlops) reported by the worker.
+func (tr *Tracer) Speed() uint32 {
+	return tr.speed
+}
+
+// Connect to the remote worker and wait for its handshake.
+func (tr *Tracer) Init() error {
+	tr.Lock()
+	defer tr.Unlock()
+
+	conn, err := net.DialTimeout("tcp", tr.addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	tr.conn = conn
+	tr.enc = gob.NewEncoder(conn)
+	tr.dec = gob.NewDecoder(conn)
+
+	var hs handshake
+	err = conn.SetDeadline(time.Now().Add(tr.timeout))
+	if err == nil {
+		err = tr.dec.Decode(&hs)
+	}
+	if isTimeout(err) {
+		err = ErrTimeout
+	}
+	if err == nil && hs.Error != "" {
+		err = errors.New(hs.Error)
+	}
+	if err != nil {
+		tr.closeConn()
+		return err
+	}
+
+	tr.remoteFlags = hs.Flags
+	tr.speed = hs.Speed
+	tr.logger.Infof("connected to worker tracer %q", hs.Id)
+
+	return nil
+}
+
+// Shutdown and cleanup tracer.
+func (tr *Tracer) Close() {
+	tr.Lock()
+	defer tr.Unlock()
+
+	tr.closeConn()
+}
+
+// Close the worker connection.
+func (tr *Tracer) closeConn() {
+	if tr.conn != nil {
+		tr.conn.Close()
+		tr.conn = nil
+		tr.enc = nil
+		tr.dec = nil
+	}
+}
+
+// Retrieve last frame statistics.
+func (tr *Tracer) Stats() *tracer.Stats {
+	return tr.stats
+}
+
+// Update tracer state
+func (tr *Tracer) UpdateState(mode tracer.UpdateMode, changeType tracer.ChangeType, data interface{}) (time.Duration, error) {
+	tr.changeBuffer[changeType] = data
+
+	if mode == tracer.Synchronous {
+		return tr.commitChanges()
+	}
+
+	return time.Duration(0), nil
+}
+
+// Forward queued state changes to the worker.
+func (tr *Tracer) commitChanges() (time.Duration, error) {
+	if len(tr.changeBuffer) == 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	for changeType, data := range tr.changeBuffer {
+		req := &request{
+			Type:       updateStateRequest,
+			ChangeType: changeType,
+		}
+
+		var ok bool
+		switch changeType {
+		case tracer.FrameDimensions:
+			req.FrameDims, ok = data.([2]uint32)
+		case tracer.SceneData:
+			req.Scene, ok = data.(*scene.Scene)
+		case tracer.CameraData:
+			req.Camera, ok = data.(*scene.Camera)
+		default:
+			return time.Since(start), ErrUnsupportedChangeType
+		}
+
+		if !ok {
+			return time.Since(start), ErrInvalidChangeData
+		}
+
+		_, err := tr.roundTrip(req)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	tr.changeBuffer = make(map[tracer.ChangeType]interface{}, 0)
+	return time.Since(start), nil
+}
+
+// Process block request.
+func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+
+	var err error
+	tr.stats.UpdateTime, err = tr.commitChanges()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	res, err := tr.roundTrip(&request{
+		Type:     traceRequest,
+		BlockReq: *blockReq,
+	})
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	// Apply any block request changes made by the worker tracer (e.g.
+	// accumulated sample count) so we behave exactly like a local tracer.
+	*blockReq = res.BlockReq
+	tr.accumulator = res.Accumulator
+	tr.aovs = res.AOVs
+
+	// Report the stage and kernel timings and ray counters collected by the worker
+	tr.stats.PrimaryRayTime = res.Stats.PrimaryRayTime
+	tr.stats.IntegratorTime = res.Stats.IntegratorTime
+	tr.stats.PrimaryRays = res.Stats.PrimaryRays
+	tr.stats.IndirectRays = res.Stats.IndirectRays
+	tr.stats.OcclusionRays = res.Stats.OcclusionRays
+	tr.stats.KernelTimes = res.Stats.KernelTimes
+	tr.stats.RenderedSamples = res.Stats.RenderedSamples
+
+	tr.stats.BlockW = blockReq.BlockW
+	tr.stats.BlockH = blockReq.BlockH
+	tr.stats.RenderTime = time.Since(start)
+	return tr.stats.RenderTime, nil
+}
+
+// Remote tracers cannot be used as primary tracers so this method always
+// returns an error.
+func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, ErrSyncNotSupported
+}
+
+// Remote tracers cannot be used as primary tracers so this method always
+// returns an error.
+func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, ErrMergeNotSupported
+}
+
+// Get the trace accumulator contents for the block processed by the last
+// trace request.
+func (tr *Tracer) ReadAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(tr.accumulator) != numPixels {
+		return nil, fmt.Errorf("remote tracer: expected %d accumulator samples; got %d", numPixels, len(tr.accumulator))
+	}
+
+	return tr.accumulator, nil
+}
+
+// Get the trace AOVs for the block processed by the last trace request.
+func (tr *Tracer) ReadAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	numPixels := int(blockReq.BlockW * blockReq.BlockH)
+	if len(tr.aovs) != numPixels {
+		return nil, fmt.Errorf("remote tracer: expected %d AOV samples; got %d", numPixels, len(tr.aovs))
+	}
+
+	return tr.aovs, nil
+}
+
+// Send a request to the worker and wait for its response. If the worker
+// does not respond in time, the connection is closed and ErrTimeout is
+// returned.
+func (tr *Tracer) roundTrip(req *request) (*response, error) {
+	if tr.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	err := tr.conn.SetDeadline(time.Now().Add(tr.requestTimeout(req)))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &response{}
+	err = tr.enc.Encode(req)
+	if err == nil {
+		err = tr.dec.Decode(res)
+	}
+	if isTimeout(err) {
+		tr.closeConn()
+		return nil, ErrTimeout
+	} else if err != nil {
+		return nil, err
+	}
+
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+
+	return res, nil
+}
+
+// Get the timeout for a worker request. Trace requests are allowed additional
+// time proportional to the number of samples that need to be traced.
+func (tr *Tracer) requestTimeout(req *request) time.Duration {
+	if req.Type != traceRequest {
+		return tr.timeout
+	}
+
+	blockReq := &req.BlockReq
+	numSamples := time.Duration(blockReq.BlockW) * time.Duration(blockReq.BlockH) * time.Duration(blockReq.SamplesPerPixel)
+	return tr.timeout + numSamples*traceTimeoutPerSample
+}
+
+// Check if err is a network timeout error.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,412 @@
+package remote
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestRemoteTracer(t *testing.T) {
+	local := &mockTracer{}
+	srv, err := NewServer("127.0.0.1:0", func() (tracer.Tracer, error) {
+		return local, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	defer srv.Close()
+
+	tr, err := NewTracer("remote", srv.Addr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tr.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	expFlags := tracer.Remote | tracer.CpuDevice
+	if tr.Flags() != expFlags {
+		t.Fatalf("expected tracer flags to be %d; got %d", expFlags, tr.Flags())
+	}
+	if tr.Speed() != 42 {
+		t.Fatalf("expected tracer speed to be 42; got %d", tr.Speed())
+	}
+
+	// Queue async changes; they should be sent to the worker when tracing
+	sc := &scene.Scene{
+		VertexList: []types.Vec4{{1, 2, 3, 4}},
+		Camera:     scene.NewCamera(45),
+	}
+	tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{4, 2})
+	tr.UpdateState(tracer.Asynchronous, tracer.SceneData, sc)
+	tr.UpdateState(tracer.Asynchronous, tracer.CameraData, sc.Camera)
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          4,
+		FrameH:          2,
+		BlockW:          4,
+		BlockY:          1,
+		BlockH:          1,
+		SamplesPerPixel: 2,
+	}
+	_, err = tr.Trace(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if local.frameDims != [2]uint32{4, 2} {
+		t.Fatalf("expected worker frame dims to be [4 2]; got %v", local.frameDims)
+	}
+	if local.sc == nil || len(local.sc.VertexList) != 1 || local.sc.VertexList[0] != sc.VertexList[0] {
+		t.Fatal("expected scene data to be sent to the worker")
+	}
+	if local.camera == nil || local.camera.FOV != sc.Camera.FOV {
+		t.Fatal("expected camera data to be sent to the worker")
+	}
+
+	if blockReq.AccumulatedSamples != 2 {
+		t.Fatalf("expected block request accumulated samples to be 2; got %d", blockReq.AccumulatedSamples)
+	}
+
+	samples, err := tr.(tracer.AccumulatorReader).ReadAccumulator(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 accumulator samples; got %d", len(samples))
+	}
+	for index, sample := range samples {
+		expSample := types.Vec3{float32(4 + index), 0, 0}
+		if sample != expSample {
+			t.Fatalf("expected accumulator sample %d to be %v; got %v", index, expSample, sample)
+		}
+	}
+
+	// AOVs should only be transferred when the block request enables them
+	_, err = tr.(tracer.AOVReader).ReadAOVs(blockReq)
+	if err == nil {
+		t.Fatal("expected to get an error when reading AOVs that were not captured")
+	}
+
+	blockReq.CaptureAOVs = true
+	_, err = tr.Trace(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aovs, err := tr.(tracer.AOVReader).ReadAOVs(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aovs) != 4 {
+		t.Fatalf("expected 4 AOV samples; got %d", len(aovs))
+	}
+	for index, aov := range aovs {
+		if expId := int32(4 + index); aov.MeshInstance != expId {
+			t.Fatalf("expected AOV sample %d mesh instance to be %d; got %d", index, expId, aov.MeshInstance)
+		}
+	}
+
+	// Worker errors should be propagated to the client
+	local.traceErr = errors.New("trace failed")
+	_, err = tr.Trace(blockReq)
+	if err == nil || err.Error() != "trace failed" {
+		t.Fatalf("expected to get worker error; got %v", err)
+	}
+}
+
+func TestRemoteTracerWorkerInitError(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0", func() (tracer.Tracer, error) {
+		return nil, errors.New("no devices available")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	defer srv.Close()
+
+	tr, _ := NewTracer("remote", srv.Addr().String(), 0)
+	err = tr.Init()
+	if err == nil || err.Error() != "no devices available" {
+		t.Fatalf("expected to get worker init error; got %v", err)
+	}
+}
+
+func TestRemoteTracerTimeout(t *testing.T) {
+	// Accept connections and send a handshake but never respond to requests
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		gob.NewEncoder(conn).Encode(&handshake{Id: "hung worker"})
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	timeout := 50 * time.Millisecond
+	tr, _ := NewTracer("remote", l.Addr().String(), timeout)
+	err = tr.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          4,
+		FrameH:          2,
+		BlockW:          4,
+		BlockH:          2,
+		SamplesPerPixel: 2,
+	}
+
+	expTimeout := timeout + 16*traceTimeoutPerSample
+	if reqTimeout := tr.(*Tracer).requestTimeout(&request{Type: traceRequest, BlockReq: *blockReq}); reqTimeout != expTimeout {
+		t.Fatalf("expected trace request timeout to be %v; got %v", expTimeout, reqTimeout)
+	}
+
+	start := time.Now()
+	_, err = tr.Trace(blockReq)
+	if err != ErrTimeout {
+		t.Fatalf("expected to get ErrTimeout; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Fatalf("expected trace request to time out after %v; took %v", expTimeout, elapsed)
+	}
+
+	// The connection should be closed after a timeout
+	_, err = tr.Trace(blockReq)
+	if err != ErrNotConnected {
+		t.Fatalf("expected to get ErrNotConnected; got %v", err)
+	}
+}
+
+func TestRemoteTracerSceneCamera(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0", func() (tracer.Tracer, error) {
+		tr, err := cpu.NewTracer("worker", 1, cpu.DefaultPipeline())
+		if err == nil {
+			err = tr.Init()
+		}
+		return tr, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	defer srv.Close()
+
+	remoteTr, err := NewTracer("remote", srv.Addr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := cameraTestScene()
+	sc.Camera.Type = scene.OrthographicCamera
+	sc.Camera.OrthoHeight = 4
+
+	// Workers should select the primary ray generator using the scene camera
+	expSamples := traceFrame(t, localTracer(t, cpu.OrthographicCamera()), sc)
+	samples := traceFrame(t, remoteTr, sc)
+	for index, sample := range samples {
+		if sample != expSamples[index] {
+			t.Fatalf("expected remote sample %d to be %v; got %v", index, expSamples[index], sample)
+		}
+	}
+
+	var diffSamples int
+	for index, sample := range traceFrame(t, localTracer(t, cpu.PerspectiveCamera()), sc) {
+		if sample != expSamples[index] {
+			diffSamples++
+		}
+	}
+	if diffSamples == 0 {
+		t.Fatal("expected orthographic and perspective camera output to differ")
+	}
+}
+
+// Create a scene with a small diffuse triangle lit by a uniform environment light.
+func cameraTestScene() *scene.Scene {
+	camera := &scene.Camera{
+		Frustrum: scene.Frustrum{
+			{-0.5, 0.5, -1, 0},
+			{0.5, 0.5, -1, 0},
+			{-0.5, -0.5, -1, 0},
+			{0.5, -0.5, -1, 0},
+		},
+	}
+
+	return &scene.Scene{
+		BvhNodeList: []scene.BvhNode{
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 0},
+			{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, -1}, LData: 0, RData: 1},
+		},
+		MeshInstanceList: []scene.MeshInstance{
+			{MeshIndex: 0, BvhRoot: 1, Transform: types.Ident4()},
+		},
+		VertexList:    []types.Vec4{{-1, -1, -1, 1}, {1, -1, -1, 1}, {0, 1, -1, 1}},
+		NormalList:    []types.Vec4{{0, 0, 1, 0}, {0, 0, 1, 0}, {0, 0, 1, 0}},
+		UvList:        []types.Vec2{{0, 0}, {1, 0}, {0, 1}},
+		MaterialIndex: []uint32{0},
+		MaterialNodeList: []scene.MaterialNode{
+			{Union1: [4]int32{int32(material.BxdfDiffuse), 0, 0, -1}, Union2: types.Vec4{0.5, 0.25, 1, 0}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.BxdfEmissive), 0, 0, -1}, Union2: types.Vec4{1, 1, 1, 0}, Union4: types.Vec3{0, 0, 1}, Union5: [1]int32{-1}},
+		},
+		EmissivePrimitives:    []scene.EmissivePrimitive{{Type: scene.EnvironmentLight, MaterialNodeIndex: 1}},
+		SceneDiffuseMatIndex:  1,
+		SceneEmissiveMatIndex: 1,
+		Camera:                camera,
+	}
+}
+
+// Create a local cpu tracer that uses the specified primary ray generator.
+func localTracer(t *testing.T, camera cpu.PipelineStage) tracer.Tracer {
+	pipeline := cpu.DefaultPipeline()
+	pipeline.PrimaryRayGenerator = camera
+	tr, err := cpu.NewTracer("local", 1, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// Render an 8x8 frame using the supplied tracer and return its accumulator contents.
+func traceFrame(t *testing.T, tr tracer.Tracer, sc *scene.Scene) []types.Vec3 {
+	err := tr.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	changes := []struct {
+		changeType tracer.ChangeType
+		data       interface{}
+	}{
+		{tracer.FrameDimensions, [2]uint32{8, 8}},
+		{tracer.SceneData, sc},
+		{tracer.CameraData, sc.Camera},
+	}
+	for _, change := range changes {
+		_, err = tr.UpdateState(tracer.Synchronous, change.changeType, change.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          8,
+		FrameH:          8,
+		BlockW:          8,
+		BlockH:          8,
+		SamplesPerPixel: 4,
+		NumBounces:      2,
+		MinBouncesForRR: 3,
+		Exposure:        1,
+		Seed:            42,
+	}
+	_, err = tr.Trace(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := tr.(tracer.AccumulatorReader).ReadAccumulator(blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+type mockTracer struct {
+	frameDims [2]uint32
+	sc        *scene.Scene
+	camera    *scene.Camera
+	traceErr  error
+}
+
+func (mt *mockTracer) Id() string {
+	return "mock"
+}
+
+func (mt *mockTracer) Flags() tracer.Flag {
+	return tracer.Local | tracer.CpuDevice
+}
+
+func (mt *mockTracer) Speed() uint32 {
+	return 42
+}
+
+func (mt *mockTracer) Init() error {
+	return nil
+}
+
+func (mt *mockTracer) Close() {
+}
+
+func (mt *mockTracer) Stats() *tracer.Stats {
+	return &tracer.Stats{}
+}
+
+func (mt *mockTracer) UpdateState(_ tracer.UpdateMode, changeType tracer.ChangeType, data interface{}) (time.Duration, error) {
+	switch changeType {
+	case tracer.FrameDimensions:
+		mt.frameDims = data.([2]uint32)
+	case tracer.SceneData:
+		mt.sc = data.(*scene.Scene)
+	case tracer.CameraData:
+		mt.camera = data.(*scene.Camera)
+	}
+	return 0, nil
+}
+
+func (mt *mockTracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	if mt.traceErr != nil {
+		return 0, mt.traceErr
+	}
+	blockReq.AccumulatedSamples += blockReq.SamplesPerPixel
+	return 0, nil
+}
+
+func (mt *mockTracer) MergeOutput(_ tracer.Tracer, _ *tracer.BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) SyncFramebuffer(_ *tracer.BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+// Return the pixel index for each block pixel as its red component.
+func (mt *mockTracer) ReadAccumulator(blockReq *tracer.BlockRequest) ([]types.Vec3, error) {
+	samples := make([]types.Vec3, blockReq.BlockW*blockReq.BlockH)
+	offset := blockReq.FrameW * blockReq.BlockY
+	for index := range samples {
+		samples[index] = types.Vec3{float32(offset + uint32(index)), 0, 0}
+	}
+	return samples, nil
+}
+
+// Return the pixel index for each block pixel as its mesh instance id.
+func (mt *mockTracer) ReadAOVs(blockReq *tracer.BlockRequest) ([]tracer.AOVSample, error) {
+	samples := make([]tracer.AOVSample, blockReq.BlockW*blockReq.BlockH)
+	offset := blockReq.FrameW * blockReq.BlockY
+	for index := range samples {
+		samples[index] = tracer.AOVSample{MeshInstance: int32(offset + uint32(index)), Material: -1}
+	}
+	return samples, nil
+}
@@ -2,17 +2,37 @@ package tracer
 
 import "math"
 
+// A rectangular frame region assigned to a tracer.
+type Tile struct {
+	X uint32
+	Y uint32
+	W uint32
+	H uint32
+}
+
 // The BlockScheduler interface is implemented by all block scheduling algorithms.
 type BlockScheduler interface {
-	// Split frame into row blocks of variable height and assign to the pool
-	// of tracers using feedback collected from previous frames.
-	Schedule(tracers []Tracer, frameH uint32) []uint32
+	// Split frame into rectangular tiles and assign them to the pool of
+	// tracers using feedback collected from previous frames. The returned
+	// slice contains one tile for each tracer.
+	Schedule(tracers []Tracer, frameW, frameH uint32) []Tile
+}
+
+// Block schedulers that implement this interface place the scheduled tiles in
+// a queue which is shared by all tracers. Instead of being assigned a single
+// tile, each tracer pulls the next tile from the queue as soon as it becomes idle.
+type SharedQueueScheduler interface {
+	BlockScheduler
+
+	// Get the dimensions of the queued tiles.
+	TileSize() (uint32, uint32)
 }
 
 // The naive scheduler distributes blocks to available renderers based on their
 // reported speed estimate.
 type naiveScheduler struct {
-	blockAssignment []uint32
+	frameW, frameH uint32
+	tileAssignment []Tile
 }
 
 // Create a new naive scheduler
@@ -20,19 +40,20 @@ func NaiveScheduler() BlockScheduler {
 	return &naiveScheduler{}
 }
 
-// Split frame into blocks and assign blocks bases on reported tracer speeds.
-func (sch *naiveScheduler) Schedule(tracers []Tracer, frameH uint32) []uint32 {
-	if len(sch.blockAssignment) != len(tracers) {
-		sch.blockAssignment = assignBlocksBasedOnSpeed(tracers, frameH)
+// Split frame into tiles and assign tiles based on reported tracer speeds.
+func (sch *naiveScheduler) Schedule(tracers []Tracer, frameW, frameH uint32) []Tile {
+	if len(sch.tileAssignment) != len(tracers) || sch.frameW != frameW || sch.frameH != frameH {
+		sch.frameW, sch.frameH = frameW, frameH
+		sch.tileAssignment = assignTilesBasedOnSpeed(tracers, frameW, frameH)
 	}
 
-	return sch.blockAssignment
+	return sch.tileAssignment
 }
 
 // The perfect scheduler assumes that the volume of tracing work between two
 // subsequent frames is approximately the same.
 type perfectScheduler struct {
-	blockAssignment []uint32
+	tileAssignment []Tile
 }
 
 // Create a new perfect scheduler instance
@@ -40,67 +61,190 @@ func PerfectScheduler() BlockScheduler {
 	return &perfectScheduler{}
 }
 
-// Split frame into blocks of variable height and assign to the pool
+// Split frame into tiles of variable size and assign to the pool
 // of tracers using feedback collected from previous frames.
 //
-// This function returns the block height assignment for each tracer in the
+// This function returns the tile assignment for each tracer in the
 // input list. When previous frame information is available the scheduler
 // uses the following formula for estimating the workload for tracer w and frame i+1:
-// w_i, f_i+1 = (blockH,w_i / time,w_i) / Σ(blockH_i-1 / time,i-1)
-func (sch *perfectScheduler) Schedule(tracers []Tracer, frameH uint32) []uint32 {
+// w_i, f_i+1 = (area,w_i / time,w_i) / Σ(area_i-1 / time,i-1)
+func (sch *perfectScheduler) Schedule(tracers []Tracer, frameW, frameH uint32) []Tile {
 	// Use a naive distribution for the first assignment
-	if len(sch.blockAssignment) != len(tracers) {
-		sch.blockAssignment = assignBlocksBasedOnSpeed(tracers, frameH)
-		return sch.blockAssignment
+	if len(sch.tileAssignment) != len(tracers) {
+		sch.tileAssignment = assignTilesBasedOnSpeed(tracers, frameW, frameH)
+		return sch.tileAssignment
 	}
 
-	// Use last frame statistics to build our scaler
+	// Use the pixel throughput for the last frame as the tracer weight.
+	// Tracers that did not report a tile or a render time (e.g. tracers
+	// that were assigned an empty tile) are given the average weight.
 	var stats *Stats
-	var total, scaler float64
-	for _, tr := range tracers {
+	var total float64
+	var numValid int
+	weights := make([]float64, len(tracers))
+	for idx, tr := range tracers {
 		stats = tr.Stats()
-		total += float64(stats.BlockH) / float64(stats.RenderTime.Nanoseconds())
+		area := float64(stats.BlockW) * float64(stats.BlockH)
+		if area == 0 || stats.RenderTime <= 0 {
+			weights[idx] = -1
+			continue
+		}
+		weights[idx] = area / float64(stats.RenderTime.Nanoseconds())
+		total += weights[idx]
+		numValid++
 	}
-	scaler = float64(frameH) / total
 
-	var assignedRows, blockH uint32 = 0, 0
-	for idx, tr := range tracers {
-		stats = tr.Stats()
-		blockH = uint32(math.Max(1.0, math.Floor(float64(stats.BlockH)/float64(stats.RenderTime.Nanoseconds())*scaler)))
-		assignedRows += blockH
-		sch.blockAssignment[idx] = blockH
+	// Keep the previous assignment if there is no usable feedback
+	if numValid == 0 {
+		return sch.tileAssignment
+	}
+
+	for idx, weight := range weights {
+		if weight < 0 {
+			weights[idx] = total / float64(numValid)
+		}
 	}
 
-	// If the assigned rows don't match our frame height add them to the first tracer
-	if assignedRows < frameH {
-		sch.blockAssignment[0] += frameH - assignedRows
+	sch.tileAssignment = splitFrame(weights, frameW, frameH)
+	return sch.tileAssignment
+}
+
+// The work-stealing scheduler splits the frame into a queue of small tiles
+// which is shared by all tracers. Fast tracers end up processing more tiles
+// so a slow tracer working on an expensive frame region does not stall the
+// entire frame.
+type workStealingScheduler struct {
+	tileW, tileH   uint32
+	frameW, frameH uint32
+	tiles          []Tile
+}
+
+// Create a new work-stealing scheduler that splits the frame into tiles with
+// the given dimensions.
+func WorkStealingScheduler(tileW, tileH uint32) BlockScheduler {
+	if tileW == 0 {
+		tileW = 1
+	}
+	if tileH == 0 {
+		tileH = 1
 	}
 
-	return sch.blockAssignment
+	return &workStealingScheduler{
+		tileW: tileW,
+		tileH: tileH,
+	}
 }
 
-// Assign blocks to tracers based on reported speed.
-func assignBlocksBasedOnSpeed(tracers []Tracer, frameH uint32) []uint32 {
-	blockAssignment := make([]uint32, len(tracers))
+// Split frame into a list of tiles in scanline order. Tiles at the right and
+// bottom frame edges are clipped to the frame dimensions.
+func (sch *workStealingScheduler) Schedule(_ []Tracer, frameW, frameH uint32) []Tile {
+	if sch.tiles != nil && sch.frameW == frameW && sch.frameH == frameH {
+		return sch.tiles
+	}
 
-	var speedSum uint32 = 0
-	for _, tr := range tracers {
-		speedSum += tr.Speed()
+	sch.frameW, sch.frameH = frameW, frameH
+	sch.tiles = make([]Tile, 0)
+	for y := uint32(0); y < frameH; y += sch.tileH {
+		for x := uint32(0); x < frameW; x += sch.tileW {
+			sch.tiles = append(sch.tiles, Tile{
+				X: x,
+				Y: y,
+				W: minUint32(sch.tileW, frameW-x),
+				H: minUint32(sch.tileH, frameH-y),
+			})
+		}
 	}
 
-	scaler := float64(frameH) / float64(speedSum)
+	return sch.tiles
+}
+
+// Get the dimensions of the queued tiles.
+func (sch *workStealingScheduler) TileSize() (uint32, uint32) {
+	return sch.tileW, sch.tileH
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
 
-	var assignedRows, blockH uint32 = 0, 0
+// Assign tiles to tracers based on reported speed.
+func assignTilesBasedOnSpeed(tracers []Tracer, frameW, frameH uint32) []Tile {
+	weights := make([]float64, len(tracers))
 	for idx, tr := range tracers {
-		blockH = uint32(math.Max(1.0, float64(tr.Speed())*scaler))
-		assignedRows += blockH
-		blockAssignment[idx] = blockH
+		weights[idx] = float64(tr.Speed())
+	}
+
+	return splitFrame(weights, frameW, frameH)
+}
+
+// Split the frame into a list of tiles whose area is proportional to the
+// supplied weights. The frame is recursively partitioned: at each step the
+// weights are divided into two groups and the region is cut along its longest
+// dimension so that each side receives an area proportional to the total
+// weight of its group. Invalid (negative, NaN or infinite) weights are treated
+// as zero; if all weights in a group are zero, the region is split evenly.
+func splitFrame(weights []float64, frameW, frameH uint32) []Tile {
+	tiles := make([]Tile, len(weights))
+	if len(weights) != 0 {
+		splitRegion(tiles, weights, Tile{W: frameW, H: frameH})
+	}
+
+	return tiles
+}
+
+// Split region into one tile per weight and store them into tiles.
+func splitRegion(tiles []Tile, weights []float64, region Tile) {
+	if len(weights) == 1 {
+		tiles[0] = region
+		return
+	}
+
+	mid := len(weights) / 2
+	var total, totalB float64
+	for idx, weight := range weights {
+		if math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0 {
+			continue
+		}
+		total += weight
+		if idx >= mid {
+			totalB += weight
+		}
+	}
+
+	fracB := float64(len(weights)-mid) / float64(len(weights))
+	if total > 0 {
+		fracB = totalB / total
+	}
+
+	splitSize := region.H
+	if region.W > region.H {
+		splitSize = region.W
+	}
+
+	// Round the size for the second group down and assign the remainder
+	// to the first group. Unless the region is too small, each side
+	// receives at least one row or column.
+	sizeB := uint32(math.Floor(float64(splitSize) * fracB))
+	if sizeB == 0 && splitSize > 1 {
+		sizeB = 1
+	}
+	sizeA := splitSize - sizeB
+	if sizeA == 0 && splitSize > 1 {
+		sizeA, sizeB = 1, splitSize-1
 	}
 
-	// If the assigned rows don't match our frame height add them to the first tracer
-	if assignedRows < frameH {
-		blockAssignment[0] += frameH - assignedRows
+	regionA, regionB := region, region
+	if region.W > region.H {
+		regionA.W = sizeA
+		regionB.X, regionB.W = region.X+sizeA, sizeB
+	} else {
+		regionA.H = sizeA
+		regionB.Y, regionB.H = region.Y+sizeA, sizeB
 	}
 
-	return blockAssignment
+	splitRegion(tiles[:mid], weights[:mid], regionA)
+	splitRegion(tiles[mid:], weights[mid:], regionB)
 }
@@ -1,6 +1,7 @@
 package tracer
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -9,14 +10,17 @@ func TestNaiveScheduler(t *testing.T) {
 	type spec struct {
 		speed1   uint32
 		speed2   uint32
+		frameW   uint32
 		frameH   uint32
-		expRows1 uint32
-		expRows2 uint32
+		expTile1 Tile
+		expTile2 Tile
 	}
 	specs := []spec{
-		spec{1, 2, 10, 4, 6},
-		spec{2, 1, 10, 7, 3},
-		spec{1, 1000, 10, 1, 9},
+		spec{1, 2, 4, 10, Tile{0, 0, 4, 4}, Tile{0, 4, 4, 6}},
+		spec{2, 1, 4, 10, Tile{0, 0, 4, 7}, Tile{0, 7, 4, 3}},
+		spec{1, 1000, 4, 10, Tile{0, 0, 4, 1}, Tile{0, 1, 4, 9}},
+		// Wide frames are split into columns
+		spec{1, 2, 10, 4, Tile{0, 0, 4, 4}, Tile{4, 0, 6, 4}},
 	}
 
 	for index, s := range specs {
@@ -25,33 +29,84 @@ func TestNaiveScheduler(t *testing.T) {
 		tracers := []Tracer{tr1, tr2}
 
 		sch := NaiveScheduler()
-		blockAssignment := sch.Schedule(tracers, s.frameH)
+		tileAssignment := sch.Schedule(tracers, s.frameW, s.frameH)
 
-		if blockAssignment[0] != s.expRows1 {
-			t.Fatalf("[spec %d] expected tracer 0 to be assigned %d rows; got %d", index, s.expRows1, blockAssignment[0])
+		if tileAssignment[0] != s.expTile1 {
+			t.Fatalf("[spec %d] expected tracer 0 to be assigned tile %v; got %v", index, s.expTile1, tileAssignment[0])
 		}
 
-		if blockAssignment[1] != s.expRows2 {
-			t.Fatalf("[spec %d] expected tracer 1 to be assigned %d rows; got %d", index, s.expRows2, blockAssignment[1])
+		if tileAssignment[1] != s.expTile2 {
+			t.Fatalf("[spec %d] expected tracer 1 to be assigned tile %v; got %v", index, s.expTile2, tileAssignment[1])
+		}
+	}
+}
+
+func TestNaiveSchedulerFrameResize(t *testing.T) {
+	tracers := []Tracer{makeMockTracer("mock-1", 1), makeMockTracer("mock-2", 1)}
+
+	sch := NaiveScheduler()
+	sch.Schedule(tracers, 4, 10)
+
+	// Scheduling a different region should not reuse the cached assignment
+	tileAssignment := sch.Schedule(tracers, 2, 4)
+	expTiles := []Tile{{0, 0, 2, 2}, {0, 2, 2, 2}}
+	for index, tile := range tileAssignment {
+		if tile != expTiles[index] {
+			t.Fatalf("expected tracer %d to be assigned tile %v; got %v", index, expTiles[index], tile)
+		}
+	}
+}
+
+func TestSplitFrame(t *testing.T) {
+	type spec struct {
+		weights  []float64
+		frameW   uint32
+		frameH   uint32
+		expTiles []Tile
+	}
+	specs := []spec{
+		spec{[]float64{1, 1, 1}, 4, 6, []Tile{{0, 0, 4, 2}, {0, 2, 4, 2}, {0, 4, 4, 2}}},
+		// Each side of a cut receives at least one row or column
+		spec{[]float64{100, 1, 1}, 4, 2, []Tile{{0, 0, 3, 2}, {3, 0, 1, 1}, {3, 1, 1, 1}}},
+		// Regions that are too small to split produce empty tiles
+		spec{[]float64{1, 1, 1}, 2, 1, []Tile{{0, 0, 1, 1}, {1, 0, 1, 1}, {1, 1, 1, 0}}},
+		// Frames are split in both dimensions
+		spec{[]float64{1, 1, 1, 1}, 8, 8, []Tile{{0, 0, 4, 4}, {4, 0, 4, 4}, {0, 4, 4, 4}, {4, 4, 4, 4}}},
+		spec{[]float64{2, 1, 1}, 8, 4, []Tile{{0, 0, 4, 4}, {4, 0, 4, 2}, {4, 2, 4, 2}}},
+		// Zero or invalid weights fall back to an even split
+		spec{[]float64{0, 0}, 4, 2, []Tile{{0, 0, 2, 2}, {2, 0, 2, 2}}},
+		spec{[]float64{math.NaN(), math.Inf(1)}, 4, 2, []Tile{{0, 0, 2, 2}, {2, 0, 2, 2}}},
+	}
+
+	for index, s := range specs {
+		tiles := splitFrame(s.weights, s.frameW, s.frameH)
+		if len(tiles) != len(s.expTiles) {
+			t.Fatalf("[spec %d] expected %d tiles; got %d", index, len(s.expTiles), len(tiles))
+		}
+		for tileIndex, tile := range tiles {
+			if tile != s.expTiles[tileIndex] {
+				t.Fatalf("[spec %d] expected tile %d to be %v; got %v", index, tileIndex, s.expTiles[tileIndex], tile)
+			}
 		}
 	}
 }
 
 func TestPerfectScheduler(t *testing.T) {
 	type spec struct {
+		frameW   uint32
 		frameH   uint32
 		rTime1   time.Duration
 		rTime2   time.Duration
-		expRows1 uint32
-		expRows2 uint32
+		expTile1 Tile
+		expTile2 Tile
 	}
 	specs := []spec{
 		// First call always behaves like the naive scheduler
-		spec{10, time.Duration(1), time.Duration(5), 5, 5},
+		spec{4, 10, time.Duration(1), time.Duration(5), Tile{0, 0, 4, 5}, Tile{0, 5, 4, 5}},
 		// Second call should use the render times to assign rows
-		spec{10, time.Duration(1), time.Duration(5), 9, 1},
+		spec{4, 10, time.Duration(1), time.Duration(5), Tile{0, 0, 4, 9}, Tile{0, 9, 4, 1}},
 		// This time tracer 2 performed much better
-		spec{10, time.Duration(5), time.Duration(1), 7, 3},
+		spec{4, 10, time.Duration(5), time.Duration(1), Tile{0, 0, 4, 7}, Tile{0, 7, 4, 3}},
 	}
 
 	// Tracers have same speed
@@ -64,18 +119,105 @@ func TestPerfectScheduler(t *testing.T) {
 		tr1.stats.RenderTime = s.rTime1
 		tr2.stats.RenderTime = s.rTime2
 
-		blockAssignment := sch.Schedule(tracers, s.frameH)
+		tileAssignment := sch.Schedule(tracers, s.frameW, s.frameH)
+
+		if tileAssignment[0] != s.expTile1 {
+			t.Fatalf("[spec %d] expected tracer 0 to be assigned tile %v; got %v", index, s.expTile1, tileAssignment[0])
+		}
+
+		if tileAssignment[1] != s.expTile2 {
+			t.Fatalf("[spec %d] expected tracer 1 to be assigned tile %v; got %v", index, s.expTile2, tileAssignment[1])
+		}
+
+		tr1.stats.BlockW, tr1.stats.BlockH = tileAssignment[0].W, tileAssignment[0].H
+		tr2.stats.BlockW, tr2.stats.BlockH = tileAssignment[1].W, tileAssignment[1].H
+	}
+}
+
+func TestPerfectSchedulerWithMissingStats(t *testing.T) {
+	tr1 := makeMockTracer("mock-1", 1)
+	tr2 := makeMockTracer("mock-2", 3)
+	tracers := []Tracer{tr1, tr2}
+
+	sch := PerfectScheduler()
+	sch.Schedule(tracers, 4, 8)
+
+	// Without any usable stats the previous assignment should be kept
+	tileAssignment := sch.Schedule(tracers, 4, 8)
+	expTiles := []Tile{{0, 0, 4, 2}, {0, 2, 4, 6}}
+	for index, tile := range tileAssignment {
+		if tile != expTiles[index] {
+			t.Fatalf("expected tracer %d to keep tile %v; got %v", index, expTiles[index], tile)
+		}
+	}
 
-		if blockAssignment[0] != s.expRows1 {
-			t.Fatalf("[spec %d] expected tracer 0 to be assigned %d rows; got %d", index, s.expRows1, blockAssignment[0])
+	// Tracers without stats should get the average weight
+	tr1.stats.BlockW, tr1.stats.BlockH, tr1.stats.RenderTime = 4, 2, time.Duration(1)
+	tileAssignment = sch.Schedule(tracers, 4, 8)
+	expTiles = []Tile{{0, 0, 4, 4}, {0, 4, 4, 4}}
+	for index, tile := range tileAssignment {
+		if tile != expTiles[index] {
+			t.Fatalf("expected tracer %d to be assigned tile %v; got %v", index, expTiles[index], tile)
 		}
+	}
+}
+
+func TestWorkStealingScheduler(t *testing.T) {
+	sch := WorkStealingScheduler(4, 3)
+	if _, isShared := sch.(SharedQueueScheduler); !isShared {
+		t.Fatal("expected work-stealing scheduler to use a shared queue")
+	}
+
+	// Tiles at the frame edges should be clipped
+	expTiles := []Tile{
+		{0, 0, 4, 3}, {4, 0, 4, 3}, {8, 0, 2, 3},
+		{0, 3, 4, 2}, {4, 3, 4, 2}, {8, 3, 2, 2},
+	}
+
+	tiles := sch.Schedule(nil, 10, 5)
+	if len(tiles) != len(expTiles) {
+		t.Fatalf("expected %d tiles; got %d", len(expTiles), len(tiles))
+	}
+	for index, tile := range tiles {
+		if tile != expTiles[index] {
+			t.Fatalf("expected tile %d to be %v; got %v", index, expTiles[index], tile)
+		}
+	}
+}
+
+func TestFramePixelIndex(t *testing.T) {
+	blockReq := &BlockRequest{
+		FrameW: 10,
+		FrameH: 4,
+		BlockX: 4,
+		BlockY: 1,
+		BlockW: 3,
+		BlockH: 2,
+	}
 
-		if blockAssignment[1] != s.expRows2 {
-			t.Fatalf("[spec %d] expected tracer 1 to be assigned %d rows; got %d", index, s.expRows2, blockAssignment[1])
+	expIndices := []uint32{14, 15, 16, 24, 25, 26}
+	for blockIndex, expIndex := range expIndices {
+		if pixelIndex := blockReq.FramePixelIndex(uint32(blockIndex)); pixelIndex != expIndex {
+			t.Fatalf("expected block pixel %d to map to frame pixel %d; got %d", blockIndex, expIndex, pixelIndex)
 		}
+	}
+}
+
+func TestBlockRequestCancelled(t *testing.T) {
+	blockReq := &BlockRequest{}
+	if blockReq.Cancelled() {
+		t.Fatal("expected block request without a cancel channel not to be cancelled")
+	}
+
+	cancel := make(chan struct{})
+	blockReq.Cancel = cancel
+	if blockReq.Cancelled() {
+		t.Fatal("expected block request not to be cancelled")
+	}
 
-		tr1.stats.BlockH = blockAssignment[0]
-		tr2.stats.BlockH = blockAssignment[1]
+	close(cancel)
+	if !blockReq.Cancelled() {
+		t.Fatal("expected block request to be cancelled")
 	}
 }
 
@@ -112,12 +254,22 @@ func (mt *mockTracer) Init() error {
 func (mt *mockTracer) Close() {
 }
 
-func (mt *mockTracer) Enqueue(_ BlockRequest) {
+func (mt *mockTracer) Stats() *Stats {
+	return mt.stats
 }
 
-func (mt *mockTracer) Update(_ UpdateType, _ interface{}) {
+func (mt *mockTracer) UpdateState(_ UpdateMode, _ ChangeType, _ interface{}) (time.Duration, error) {
+	return 0, nil
 }
 
-func (mt *mockTracer) Stats() *Stats {
-	return mt.stats
+func (mt *mockTracer) Trace(_ *BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) MergeOutput(_ Tracer, _ *BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) SyncFramebuffer(_ *BlockRequest) (time.Duration, error) {
+	return 0, nil
 }
@@ -0,0 +1,22 @@
+package tracer
+
+// Derive a new seed by hashing a seed value together with a list of values
+// such as a frame, sample or bounce index. The same inputs always produce the
+// same seed which makes the rendered output reproducible.
+func DeriveSeed(seed uint32, values ...uint32) uint32 {
+	hash := mixSeed(seed ^ 0x9e3779b9)
+	for _, value := range values {
+		hash = mixSeed(hash ^ (value + 0x9e3779b9 + (hash << 6) + (hash >> 2)))
+	}
+	return hash
+}
+
+// Scramble the bits of a 32-bit value using the murmur3 finalizer.
+func mixSeed(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
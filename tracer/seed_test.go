@@ -0,0 +1,29 @@
+package tracer
+
+import "testing"
+
+func TestDeriveSeed(t *testing.T) {
+	if DeriveSeed(42, 1, 2) != DeriveSeed(42, 1, 2) {
+		t.Fatal("expected DeriveSeed to return the same seed for the same inputs")
+	}
+
+	specs := [][]uint32{
+		{41},
+		{42},
+		{42, 0},
+		{42, 1},
+		{42, 0, 1},
+		{42, 1, 0},
+		{43, 0},
+	}
+
+	seen := make(map[uint32][]uint32)
+	for _, spec := range specs {
+		seed := DeriveSeed(spec[0], spec[1:]...)
+		if other, exists := seen[seed]; exists {
+			t.Errorf("expected inputs %v and %v to produce different seeds; got %d for both", spec, other, seed)
+			continue
+		}
+		seen[seed] = spec
+	}
+}
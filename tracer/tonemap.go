@@ -0,0 +1,101 @@
+package tracer
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownTonemapOperator = errors.New("tracer: unknown tone-mapping operator")
+)
+
+// The default gamma value used for encoding tone-mapped frames.
+const DefaultGamma float32 = 2.2
+
+// Tone-mapping operators for converting HDR radiance values to LDR colors.
+type TonemapOperator uint8
+
+const (
+	// Reinhard operator. If a white point is specified, the extended
+	// Reinhard operator is used which maps the white point to 1.
+	ReinhardTonemap TonemapOperator = iota
+
+	// Filmic curve fitted to the ACES reference rendering transform.
+	ACESTonemap
+
+	// Filmic curve by John Hable used in Uncharted 2.
+	Uncharted2Tonemap
+
+	// Linear mapping of [0, white point] to [0, 1]; values outside this
+	// range are clamped.
+	LinearTonemap
+)
+
+// The list of supported tone-mapping operators.
+var TonemapOperators = []TonemapOperator{ReinhardTonemap, ACESTonemap, Uncharted2Tonemap, LinearTonemap}
+
+// Get the tone-mapping operator name.
+func (op TonemapOperator) String() string {
+	switch op {
+	case ReinhardTonemap:
+		return "reinhard"
+	case ACESTonemap:
+		return "aces"
+	case Uncharted2Tonemap:
+		return "uncharted2"
+	case LinearTonemap:
+		return "linear"
+	}
+
+	return fmt.Sprintf("tonemap(%d)", uint8(op))
+}
+
+// Get the white point used by the operator if no white point is specified.
+// The Reinhard operator does not use a white point by default and the ACES
+// operator ignores the white point.
+func (op TonemapOperator) DefaultWhitePoint() float32 {
+	switch op {
+	case Uncharted2Tonemap:
+		return 11.2
+	case LinearTonemap:
+		return 1.0
+	}
+
+	return 0
+}
+
+// Lookup a tone-mapping operator by its name.
+func ParseTonemapOperator(name string) (TonemapOperator, error) {
+	for _, op := range TonemapOperators {
+		if op.String() == name {
+			return op, nil
+		}
+	}
+
+	return 0, ErrUnknownTonemapOperator
+}
+
+// The tone-mapping operator and parameters used by tone-mapping pipeline stages.
+type TonemapParams struct {
+	Operator TonemapOperator
+
+	// The radiance value that is mapped to white. If zero, the operator's
+	// default white point is used.
+	WhitePoint float32
+
+	// The gamma value for encoding the tone-mapped colors. If zero, the
+	// default gamma value is used.
+	Gamma float32
+}
+
+// Get a copy of the tone-mapping params where unset values are replaced by
+// the operator defaults.
+func (p TonemapParams) WithDefaults() TonemapParams {
+	if p.WhitePoint == 0 {
+		p.WhitePoint = p.Operator.DefaultWhitePoint()
+	}
+	if p.Gamma == 0 {
+		p.Gamma = DefaultGamma
+	}
+	return p
+}
@@ -0,0 +1,47 @@
+package tracer
+
+import "testing"
+
+func TestParseTonemapOperator(t *testing.T) {
+	for _, op := range TonemapOperators {
+		parsed, err := ParseTonemapOperator(op.String())
+		if err != nil {
+			t.Errorf("unexpected error parsing tone-mapping operator %q: %v", op.String(), err)
+			continue
+		}
+		if parsed != op {
+			t.Errorf("expected parsed tone-mapping operator to be %d; got %d", op, parsed)
+		}
+	}
+
+	_, err := ParseTonemapOperator("filmic")
+	if err != ErrUnknownTonemapOperator {
+		t.Fatalf("expected to get ErrUnknownTonemapOperator; got %v", err)
+	}
+}
+
+func TestTonemapParamsWithDefaults(t *testing.T) {
+	specs := []struct {
+		params    TonemapParams
+		expParams TonemapParams
+	}{
+		{
+			TonemapParams{Operator: ReinhardTonemap},
+			TonemapParams{Operator: ReinhardTonemap, Gamma: DefaultGamma},
+		},
+		{
+			TonemapParams{Operator: Uncharted2Tonemap, Gamma: 1},
+			TonemapParams{Operator: Uncharted2Tonemap, WhitePoint: 11.2, Gamma: 1},
+		},
+		{
+			TonemapParams{Operator: LinearTonemap, WhitePoint: 4},
+			TonemapParams{Operator: LinearTonemap, WhitePoint: 4, Gamma: DefaultGamma},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		if params := spec.params.WithDefaults(); params != spec.expParams {
+			t.Errorf("[spec %d] expected params to be %+v; got %+v", specIndex, spec.expParams, params)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package tracer
 
-import "time"
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/types"
+)
 
 // A unit of work that is processed by a tracer.
 type BlockRequest struct {
@@ -31,6 +35,34 @@ type BlockRequest struct {
 
 	// Number of sequential rendered frames from current camera position.
 	AccumulatedSamples uint32
+
+	// If set, tracers capture AOVs for the first hit of each primary ray.
+	CaptureAOVs bool
+
+	// If set, tracers count the number of traced rays. Counting rays may
+	// require additional synchronization with the device.
+	CountRays bool
+
+	// An optional channel that is closed to request local tracers to stop
+	// rendering the block after the current sample. Tracers that stop early
+	// only accumulate the rendered samples and report their number in
+	// Stats.RenderedSamples. This field is not sent to remote workers.
+	Cancel <-chan struct{} `json:"-"`
+}
+
+// Check whether the block request has been cancelled.
+func (br *BlockRequest) Cancelled() bool {
+	select {
+	case <-br.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Map the index of a pixel within the requested block to its index within the frame.
+func (br *BlockRequest) FramePixelIndex(blockIndex uint32) uint32 {
+	return (br.BlockY+blockIndex/br.BlockW)*br.FrameW + br.BlockX + blockIndex%br.BlockW
 }
 
 // Tracer statistics.
@@ -44,6 +76,27 @@ type Stats struct {
 
 	// The time for rendering this block
 	RenderTime time.Duration
+
+	// The number of samples rendered for this block. If the block request
+	// was cancelled this may be less than the requested samples per pixel.
+	RenderedSamples uint32
+
+	// The time spent in the primary ray generation and integrator
+	// pipeline stages while rendering this block.
+	PrimaryRayTime time.Duration
+	IntegratorTime time.Duration
+
+	// The execution time for each tracer kernel. Trace resets and populates
+	// the kernels used for rendering this block. The filter and tonemap times
+	// are added by the post-processing stages and the merge time is added
+	// when another tracer merges the output of this tracer.
+	KernelTimes KernelTimes
+
+	// The number of primary, indirect and occlusion rays traced for this
+	// block. Rays are only counted if the block request enables CountRays.
+	PrimaryRays   uint64
+	IndirectRays  uint64
+	OcclusionRays uint64
 }
 
 type Flag uint8
@@ -103,9 +156,40 @@ type Tracer interface {
 	Trace(*BlockRequest) (time.Duration, error)
 
 	// Merge accumulator output from another tracer into this tracer's buffer.
+	// The merge kernel time is added to the stats of the other tracer.
 	MergeOutput(Tracer, *BlockRequest) (time.Duration, error)
 
 	// Run post-process filters to the accumulated trace data and
 	// update the output frame buffer.
 	SyncFramebuffer(*BlockRequest) (time.Duration, error)
 }
+
+// Tracers that can export the contents of their trace accumulator to host
+// memory implement this interface. It allows tracers that do not share the
+// same device context (e.g. remote tracers) to merge their output.
+type AccumulatorReader interface {
+	// Read the trace accumulator contents for the block specified by the
+	// block request. The returned slice contains BlockW * BlockH samples.
+	ReadAccumulator(*BlockRequest) ([]types.Vec3, error)
+}
+
+// Tracers that can export and restore the contents of their frame accumulator
+// implement this interface. It allows renders to be checkpointed and resumed.
+type FrameAccumulatorReadWriter interface {
+	// Read the frame accumulator contents for the block specified by the
+	// block request. The returned slice contains BlockW * BlockH samples.
+	ReadFrameAccumulator(*BlockRequest) ([]types.Vec3, error)
+
+	// Replace the frame accumulator contents for the block specified by
+	// the block request with BlockW * BlockH samples.
+	WriteFrameAccumulator(*BlockRequest, []types.Vec3) error
+}
+
+// Tracers that can scale the contents of their frame accumulator and frame
+// AOVs implement this interface. It allows the renderer to equalize the sample
+// weights of blocks that rendered fewer samples because the frame was cancelled.
+type FrameOutputScaler interface {
+	// Scale the frame accumulator and AOV contents for the block specified
+	// by the block request.
+	ScaleFrameOutput(*BlockRequest, float32) (time.Duration, error)
+}
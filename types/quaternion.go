@@ -94,3 +94,126 @@ func (q1 Quat) Mat4() Mat4 {
 		0, 0, 0, 1,
 	}
 }
+
+// Adds two quaternions. It's no more complicated than
+// adding their W and V components.
+func (q1 Quat) Add(q2 Quat) Quat {
+	return Quat{q1.V.Add(q2.V), q1.W + q2.W}
+}
+
+// Subtracts two quaternions. It's no more complicated than
+// subtracting their W and V components.
+func (q1 Quat) Sub(q2 Quat) Quat {
+	return Quat{q1.V.Sub(q2.V), q1.W - q2.W}
+}
+
+// Scales every element of the quaternion by some constant factor.
+func (q1 Quat) Scale(c float32) Quat {
+	return Quat{q1.V.Mul(c), q1.W * c}
+}
+
+// The dot product between two quaternions, equivalent to if this was a Vec4.
+func (q1 Quat) Dot(q2 Quat) float32 {
+	return q1.W*q2.W + q1.V.Dot(q2.V)
+}
+
+// Normalized Linear Interpolation between two quaternions. Useful as a cheaper
+// alternative to slerp when the two quaternions are very close to each other.
+func QuatNlerp(q1, q2 Quat, amount float32) Quat {
+	return q1.Add(q2.Sub(q1).Scale(amount)).Normalize()
+}
+
+// Spherical Linear Interpolation between two quaternions. The interpolation
+// always follows the shortest path between the two orientations.
+func QuatSlerp(q1, q2 Quat, amount float32) Quat {
+	q1, q2 = q1.Normalize(), q2.Normalize()
+	dot := q1.Dot(q2)
+
+	// q and -q represent the same orientation; flip one of the
+	// quaternions so we interpolate along the shortest arc.
+	if dot < 0 {
+		q2 = q2.Scale(-1)
+		dot = -dot
+	}
+
+	// If the inputs are too close for comfort, linearly interpolate and normalize the result.
+	if dot > 0.9995 {
+		return QuatNlerp(q1, q2, amount)
+	}
+
+	// Guard against precision errors that would make Acos return NaN
+	if dot > 1 {
+		dot = 1
+	}
+
+	theta := float32(math.Acos(float64(dot))) * amount
+	c, s := float32(math.Cos(float64(theta))), float32(math.Sin(float64(theta)))
+	rel := q2.Sub(q1.Scale(dot)).Normalize()
+
+	return q1.Scale(c).Add(rel.Scale(s))
+}
+
+// Create a quaternion from the upper 3x3 rotation part of a homogeneous matrix.
+func Mat4ToQuat(m Mat4) Quat {
+	// http://www.euclideanspace.com/maths/geometry/rotations/conversions/matrixToQuaternion/index.htm
+	if tr := m[0] + m[5] + m[10]; tr > 0 {
+		s := float32(0.5 / math.Sqrt(float64(tr+1.0)))
+		return Quat{
+			V: Vec3{
+				(m[6] - m[9]) * s,
+				(m[8] - m[2]) * s,
+				(m[1] - m[4]) * s,
+			},
+			W: 0.25 / s,
+		}
+	}
+
+	if (m[0] > m[5]) && (m[0] > m[10]) {
+		s := float32(2.0 * math.Sqrt(float64(1.0+m[0]-m[5]-m[10])))
+		return Quat{
+			V: Vec3{
+				0.25 * s,
+				(m[4] + m[1]) / s,
+				(m[8] + m[2]) / s,
+			},
+			W: (m[6] - m[9]) / s,
+		}
+	}
+
+	if m[5] > m[10] {
+		s := float32(2.0 * math.Sqrt(float64(1.0+m[5]-m[0]-m[10])))
+		return Quat{
+			V: Vec3{
+				(m[4] + m[1]) / s,
+				0.25 * s,
+				(m[9] + m[6]) / s,
+			},
+			W: (m[8] - m[2]) / s,
+		}
+	}
+
+	s := float32(2.0 * math.Sqrt(float64(1.0+m[10]-m[0]-m[5])))
+	return Quat{
+		V: Vec3{
+			(m[8] + m[2]) / s,
+			(m[9] + m[6]) / s,
+			0.25 * s,
+		},
+		W: (m[1] - m[4]) / s,
+	}
+}
+
+// Create a quaternion that rotates the default view direction (0, 0, -1) and
+// up vector (0, 1, 0) so they match the supplied direction and up vectors.
+func QuatLookAtV(dir, up Vec3) Quat {
+	dir = dir.Normalize()
+	right := dir.Cross(up).Normalize()
+	up = right.Cross(dir)
+
+	return Mat4ToQuat(Mat4{
+		right[0], right[1], right[2], 0,
+		up[0], up[1], up[2], 0,
+		-dir[0], -dir[1], -dir[2], 0,
+		0, 0, 0, 1,
+	})
+}